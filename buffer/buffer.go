@@ -1,21 +1,28 @@
 package buffer
 
 import (
+	"sync"
+
 	"simpledb/file"
 	"simpledb/log"
 )
 
 type Buffer struct {
-	fileManager *file.Manager
+	// latch protects the contents and the modification state against
+	// concurrent access by transactions that lock different records of the
+	// block. It is only held for the duration of a single access.
+	latch       sync.Mutex
+	fileManager file.Storage
 	logManager  *log.Manager
 	contents    *file.Page
 	block       *file.Block
 	pins        int32
-	modifiedBy  int32 // transaction number that made the change
-	lsn         int32 // LSN of the most recent log record
+	modifiedBy  int64              // transaction number that made the latest change
+	modifiers   map[int64]struct{} // transactions whose changes are not flushed yet
+	lsn         int32              // LSN of the most recent log record
 }
 
-func NewBuffer(fileManager *file.Manager, logManager *log.Manager) *Buffer {
+func NewBuffer(fileManager file.Storage, logManager *log.Manager) *Buffer {
 	return &Buffer{
 		fileManager: fileManager,
 		logManager:  logManager,
@@ -23,6 +30,7 @@ func NewBuffer(fileManager *file.Manager, logManager *log.Manager) *Buffer {
 		block:       nil,
 		pins:        0,
 		modifiedBy:  -1,
+		modifiers:   make(map[int64]struct{}),
 		lsn:         -1,
 	}
 }
@@ -35,8 +43,23 @@ func (b *Buffer) Block() *file.Block {
 	return b.block
 }
 
-func (b *Buffer) SetModified(txNum, lsn int32) {
+// Latch acquires the short-term latch of the buffer. Readers and writers
+// of the contents must hold it while they access them.
+func (b *Buffer) Latch() {
+	b.latch.Lock()
+}
+
+// Unlatch releases the latch acquired by Latch.
+func (b *Buffer) Unlatch() {
+	b.latch.Unlock()
+}
+
+// SetModified records that the transaction changed the contents. Since
+// transactions can lock different records of the same block, several
+// transactions may have unflushed changes in the buffer at once.
+func (b *Buffer) SetModified(txNum int64, lsn int32) {
 	b.modifiedBy = txNum
+	b.modifiers[txNum] = struct{}{}
 	if lsn >= 0 {
 		b.lsn = lsn
 	}
@@ -46,10 +69,19 @@ func (b *Buffer) IsPinned() bool {
 	return b.pins > 0
 }
 
-func (b *Buffer) ModifyingTx() int32 {
+// ModifyingTx returns the number of the transaction that made the latest
+// unflushed change, or -1 if the buffer is clean.
+func (b *Buffer) ModifyingTx() int64 {
 	return b.modifiedBy
 }
 
+// IsModifiedBy reports whether the transaction has unflushed changes in the
+// buffer.
+func (b *Buffer) IsModifiedBy(txNum int64) bool {
+	_, ok := b.modifiers[txNum]
+	return ok
+}
+
 func (b *Buffer) assignToBlock(block *file.Block) error {
 	// Flush the buffer, so that any modifications to the previous block are preserved.
 	if err := b.flush(); err != nil {
@@ -57,7 +89,12 @@ func (b *Buffer) assignToBlock(block *file.Block) error {
 	}
 
 	b.block = block
-	b.fileManager.Read(block, b.contents)
+	if err := b.fileManager.Read(block, b.contents); err != nil {
+		// The block is beyond the end of the file, as when recovery
+		// redoes changes to blocks a restored backup does not have yet.
+		// It reads as zeros, like a newly appended block.
+		clear(b.contents.Buf())
+	}
 	b.pins = 0
 	return nil
 }
@@ -70,11 +107,25 @@ func (b *Buffer) flush() error {
 		if err := b.fileManager.Write(b.block, b.contents); err != nil {
 			return err
 		}
+		if err := b.fileManager.Sync(b.block.Filename()); err != nil {
+			return err
+		}
 		b.modifiedBy = -1
+		clear(b.modifiers)
 	}
 	return nil
 }
 
+// discard unassigns the buffer from its block and drops its changes.
+func (b *Buffer) discard() {
+	b.Latch()
+	defer b.Unlatch()
+
+	b.block = nil
+	b.modifiedBy = -1
+	clear(b.modifiers)
+}
+
 func (b *Buffer) pin() {
 	b.pins++
 }
@@ -16,7 +16,7 @@ type Manager struct {
 	cond       *sync.Cond // used to wait for a buffer to become available.
 }
 
-func NewManager(fileManager *file.Manager, logManager *log.Manager, numBufs int32) *Manager {
+func NewManager(fileManager file.Storage, logManager *log.Manager, numBufs int32) *Manager {
 	m := &Manager{
 		bufferPool: make([]*Buffer, numBufs),
 		available:  numBufs,
@@ -36,20 +36,53 @@ func (m *Manager) Available() int32 {
 }
 
 // FlushAll flushes all dirty buffers modified by the specified transaction.
-func (m *Manager) FlushAll(txNum int32) error {
+func (m *Manager) FlushAll(txNum int64) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
 	for _, buffer := range m.bufferPool {
-		if buffer.ModifyingTx() == txNum {
-			if err := buffer.flush(); err != nil {
-				return err
-			}
+		if err := flushIfModifiedBy(buffer, txNum); err != nil {
+			return err
 		}
 	}
 	return nil
 }
 
+// flushIfModifiedBy flushes the buffer under its latch, since it may be in
+// use by other transactions, if the transaction has changes in it.
+func flushIfModifiedBy(buf *Buffer, txNum int64) error {
+	buf.Latch()
+	defer buf.Unlatch()
+
+	if !buf.IsModifiedBy(txNum) {
+		return nil
+	}
+	return buf.flush()
+}
+
+// ErrBufferPinned is returned when discarding a block that is pinned.
+var ErrBufferPinned = errors.New("buffer manager: block is pinned")
+
+// Discard forgets the buffers of the blocks of the file from the specified
+// block number on, without flushing them, so that the file can be truncated
+// to that size. It fails with ErrBufferPinned if one of them is pinned.
+func (m *Manager) Discard(filename string, size int32) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, buf := range m.bufferPool {
+		b := buf.Block()
+		if b == nil || b.Filename() != filename || b.Number() < size {
+			continue
+		}
+		if buf.IsPinned() {
+			return ErrBufferPinned
+		}
+		buf.discard()
+	}
+	return nil
+}
+
 func (m *Manager) Unpin(buf *Buffer) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
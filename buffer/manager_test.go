@@ -9,17 +9,13 @@ import (
 	"simpledb/log"
 )
 
-// setup creates a temporary directory and initializes file and log managers for testing.
-func setup(t *testing.T) (*file.Manager, *log.Manager) {
+// setup initializes in-memory file and log managers for testing.
+func setup(t *testing.T) (*file.MemoryStorage, *log.Manager) {
 	t.Helper()
-	dir := t.TempDir()
 	const blockSize = 400
 	const logFile = "testlogfile"
 
-	fm, err := file.NewManager(dir, blockSize)
-	if err != nil {
-		t.Fatalf("failed to create file manager: %v", err)
-	}
+	fm := file.NewMemoryStorage(blockSize)
 
 	lm, err := log.NewManager(fm, logFile)
 	if err != nil {
@@ -236,3 +232,42 @@ func TestManager_Pin(t *testing.T) {
 		}
 	})
 }
+
+func TestManager_Discard(t *testing.T) {
+	fm, lm := setup(t)
+	bm := NewManager(fm, lm, 3)
+
+	const txNum = 10
+	var bufs []*Buffer
+	for blockNum := range int32(3) {
+		buf, err := bm.Pin(file.NewBlock("testfile", blockNum))
+		if err != nil {
+			t.Fatalf("failed to pin block %d: %v", blockNum, err)
+		}
+		buf.Contents().WriteInt32At(0, blockNum+1)
+		buf.SetModified(txNum, -1)
+		bufs = append(bufs, buf)
+	}
+
+	if err := bm.Discard("testfile", 1); !errors.Is(err, ErrBufferPinned) {
+		t.Errorf("Discard of pinned blocks: got error %v, want %v", err, ErrBufferPinned)
+	}
+	for _, buf := range bufs {
+		bm.Unpin(buf)
+	}
+	if err := bm.Discard("testfile", 1); err != nil {
+		t.Fatalf("failed to discard blocks: %v", err)
+	}
+
+	// Only the block that was kept is written to the file.
+	if err := bm.FlushAll(txNum); err != nil {
+		t.Fatalf("failed to flush: %v", err)
+	}
+	size, err := fm.Size("testfile")
+	if err != nil {
+		t.Fatalf("failed to get file size: %v", err)
+	}
+	if size != 1 {
+		t.Errorf("file has %d blocks, want %d", size, 1)
+	}
+}
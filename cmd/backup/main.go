@@ -0,0 +1,164 @@
+// Command backup backs up and restores SimpleDB database directories.
+//
+// Usage:
+//
+//	backup backup -server ADDR -to DIR
+//	backup restore -from DIR -db DIR [-archive DIR [-lsn N | -time T]] [-blocksize N]
+//	backup commits -db DIR [-blocksize N]
+//
+// The backup subcommand asks the database serving backups at the TCP
+// address -server, with server.SimpleDB.ServeBackups, to copy itself to the
+// empty directory -to on its host while transactions keep running. The
+// restore subcommand replaces the database in -db with the backup
+// in -from, and recovers it to a consistent state. With -archive, the
+// database is recovered to a point in time instead: the log in the archive
+// directory, such as the database directory the backup was taken of, is
+// replayed up to the log record with LSN -lsn, or up to the last commit at
+// or before the RFC 3339 time -time, or else to its end. The commits
+// subcommand lists the commits in the log of the database in -db, most
+// recent first, to choose where to stop.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"simpledb/file"
+	"simpledb/server"
+	"simpledb/transaction"
+)
+
+const bufferSize = 8
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "backup":
+		err = backup(os.Args[2:])
+	case "restore":
+		err = restore(os.Args[2:])
+	case "commits":
+		err = commits(os.Args[2:])
+	default:
+		usage()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "backup: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: backup backup -server ADDR -to DIR")
+	fmt.Fprintln(os.Stderr, "       backup restore -from DIR -db DIR [-archive DIR [-lsn N | -time T]] [-blocksize N]")
+	fmt.Fprintln(os.Stderr, "       backup commits -db DIR [-blocksize N]")
+	os.Exit(2)
+}
+
+func backup(args []string) error {
+	flags := flag.NewFlagSet("backup", flag.ExitOnError)
+	addr := flags.String("server", "", "address the database serves backups at")
+	toDir := flags.String("to", "", "backup directory")
+	flags.Parse(args)
+	if *addr == "" || *toDir == "" {
+		usage()
+	}
+
+	// The directory is opened by the database, whose working directory may
+	// differ.
+	dir, err := filepath.Abs(*toDir)
+	if err != nil {
+		return err
+	}
+
+	conn, err := net.Dial("tcp", *addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return server.RequestBackup(conn, dir)
+}
+
+func restore(args []string) error {
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	fromDir := flags.String("from", "", "backup directory")
+	dbDir := flags.String("db", "", "database directory")
+	archiveDir := flags.String("archive", "", "directory of the log to recover to a point in time")
+	lsn := flags.Int("lsn", 0, "LSN of the last log record to recover")
+	targetTime := flags.String("time", "", "time of the last commit to recover, in RFC 3339 format")
+	blockSize := flags.Int("blocksize", 400, "block size of the database")
+	flags.Parse(args)
+	if *fromDir == "" || *dbDir == "" {
+		usage()
+	}
+
+	target := transaction.RecoveryTarget{LSN: int32(*lsn)}
+	if *targetTime != "" {
+		t, err := time.Parse(time.RFC3339Nano, *targetTime)
+		if err != nil {
+			return err
+		}
+		target.Time = t
+	}
+
+	src, err := file.NewManager(*fromDir, int32(*blockSize))
+	if err != nil {
+		return err
+	}
+	dst, err := file.NewManager(*dbDir, int32(*blockSize))
+	if err != nil {
+		return err
+	}
+	if err := transaction.Restore(src, dst); err != nil {
+		return err
+	}
+
+	if *archiveDir != "" {
+		archive, err := file.NewManager(*archiveDir, int32(*blockSize))
+		if err != nil {
+			return err
+		}
+		if err := transaction.RollForward(archive, dst, target); err != nil {
+			return err
+		}
+	}
+
+	db := server.NewSimpleDB(*dbDir, int32(*blockSize), bufferSize)
+	tx := db.NewTx()
+	if err := tx.Recover(); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func commits(args []string) error {
+	flags := flag.NewFlagSet("commits", flag.ExitOnError)
+	dbDir := flags.String("db", "", "database directory")
+	blockSize := flags.Int("blocksize", 400, "block size of the database")
+	flags.Parse(args)
+	if *dbDir == "" {
+		usage()
+	}
+
+	storage, err := file.NewManager(*dbDir, int32(*blockSize))
+	if err != nil {
+		return err
+	}
+	commits, err := transaction.LoggedCommits(storage, server.LogFile)
+	if err != nil {
+		return err
+	}
+
+	for _, commit := range commits {
+		fmt.Printf("LSN %d\ttransaction %d\t%s\n", commit.LSN, commit.TxNum, commit.Time.Format(time.RFC3339Nano))
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package decimal
+
+import (
+	"errors"
+	"math/big"
+	"strings"
+)
+
+var (
+	// ErrSyntax is returned when parsing a string that is not a decimal
+	// number.
+	ErrSyntax = errors.New("decimal: invalid syntax")
+	// ErrRange is returned when a decimal does not fit in the requested
+	// representation.
+	ErrRange = errors.New("decimal: value out of range")
+)
+
+// Decimal is an exact decimal number, the value of the coefficient times
+// ten to the power of minus the scale. Arithmetic on decimals is exact, so
+// that amounts of money can be added and multiplied without the rounding
+// errors of binary floating point.
+//
+// Decimals are immutable values. The zero value is 0.
+type Decimal struct {
+	coef  *big.Int
+	scale int32
+}
+
+var ten = big.NewInt(10)
+
+// New returns the decimal unscaled * 10^-scale. For example, New(1999, 2)
+// is 19.99. The scale must not be negative.
+func New(unscaled int64, scale int32) Decimal {
+	return Decimal{coef: big.NewInt(unscaled), scale: scale}
+}
+
+// Parse parses a decimal written as an optional sign, digits, and an
+// optional fractional part after a point, such as "-12.50". The scale of
+// the result is the number of fractional digits written.
+func Parse(s string) (Decimal, error) {
+	digits := strings.TrimLeft(s, "+-")
+	if len(s)-len(digits) > 1 {
+		return Decimal{}, ErrSyntax
+	}
+
+	intPart, fracPart, _ := strings.Cut(digits, ".")
+	if intPart == "" && fracPart == "" {
+		return Decimal{}, ErrSyntax
+	}
+	for _, c := range intPart + fracPart {
+		if c < '0' || c > '9' {
+			return Decimal{}, ErrSyntax
+		}
+	}
+
+	coef, ok := new(big.Int).SetString(intPart+fracPart, 10)
+	if !ok {
+		return Decimal{}, ErrSyntax
+	}
+	if strings.HasPrefix(s, "-") {
+		coef.Neg(coef)
+	}
+	return Decimal{coef: coef, scale: int32(len(fracPart))}, nil
+}
+
+// String formats the decimal with exactly Scale fractional digits.
+func (d Decimal) String() string {
+	digits := new(big.Int).Abs(d.coefficient()).String()
+	if d.scale > 0 {
+		if pad := int(d.scale) + 1 - len(digits); pad > 0 {
+			digits = strings.Repeat("0", pad) + digits
+		}
+		digits = digits[:len(digits)-int(d.scale)] + "." + digits[len(digits)-int(d.scale):]
+	}
+	if d.Sign() < 0 {
+		return "-" + digits
+	}
+	return digits
+}
+
+// Scale returns the number of fractional digits of the decimal.
+func (d Decimal) Scale() int32 {
+	return d.scale
+}
+
+// Precision returns the number of digits of the coefficient, which is at
+// least 1.
+func (d Decimal) Precision() int32 {
+	return int32(len(new(big.Int).Abs(d.coefficient()).String()))
+}
+
+// Sign returns -1, 0 or +1 as the decimal is negative, zero or positive.
+func (d Decimal) Sign() int {
+	return d.coefficient().Sign()
+}
+
+// Unscaled returns the coefficient of the decimal, or ErrRange if it does
+// not fit in an int64.
+func (d Decimal) Unscaled() (int64, error) {
+	coef := d.coefficient()
+	if !coef.IsInt64() {
+		return 0, ErrRange
+	}
+	return coef.Int64(), nil
+}
+
+// Float64 returns the float64 value nearest to the decimal.
+func (d Decimal) Float64() float64 {
+	f, _ := new(big.Rat).SetFrac(d.coefficient(), pow10(d.scale)).Float64()
+	return f
+}
+
+// Rescale returns the decimal with the specified number of fractional
+// digits. Digits that are dropped are rounded half away from zero.
+func (d Decimal) Rescale(scale int32) Decimal {
+	if scale >= d.scale {
+		coef := new(big.Int).Mul(d.coefficient(), pow10(scale-d.scale))
+		return Decimal{coef: coef, scale: scale}
+	}
+
+	divisor := pow10(d.scale - scale)
+	quo, rem := new(big.Int).QuoRem(d.coefficient(), divisor, new(big.Int))
+	// Round away from zero if the dropped digits are at least one half.
+	if rem.Abs(rem).Lsh(rem, 1).Cmp(divisor) >= 0 {
+		quo.Add(quo, big.NewInt(int64(d.Sign())))
+	}
+	return Decimal{coef: quo, scale: scale}
+}
+
+// Add returns d + other, with the larger scale of the two.
+func (d Decimal) Add(other Decimal) Decimal {
+	a, b, scale := align(d, other)
+	return Decimal{coef: a.Add(a, b), scale: scale}
+}
+
+// Sub returns d - other, with the larger scale of the two.
+func (d Decimal) Sub(other Decimal) Decimal {
+	a, b, scale := align(d, other)
+	return Decimal{coef: a.Sub(a, b), scale: scale}
+}
+
+// Mul returns d * other, whose scale is the sum of the two scales.
+func (d Decimal) Mul(other Decimal) Decimal {
+	coef := new(big.Int).Mul(d.coefficient(), other.coefficient())
+	return Decimal{coef: coef, scale: d.scale + other.scale}
+}
+
+// Neg returns -d.
+func (d Decimal) Neg() Decimal {
+	return Decimal{coef: new(big.Int).Neg(d.coefficient()), scale: d.scale}
+}
+
+// Cmp compares the values of the decimals regardless of their scales, and
+// returns -1, 0 or +1 as d is less than, equal to or greater than other.
+func (d Decimal) Cmp(other Decimal) int {
+	a, b, _ := align(d, other)
+	return a.Cmp(b)
+}
+
+// coefficient returns the coefficient, which is 0 for the nil one of the
+// zero value.
+func (d Decimal) coefficient() *big.Int {
+	if d.coef == nil {
+		return new(big.Int)
+	}
+	return d.coef
+}
+
+// align returns copies of the coefficients of the decimals scaled to the
+// larger of their scales, and that scale.
+func align(a Decimal, b Decimal) (*big.Int, *big.Int, int32) {
+	scale := max(a.scale, b.scale)
+	return a.Rescale(scale).coefficient(), b.Rescale(scale).coefficient(), scale
+}
+
+func pow10(n int32) *big.Int {
+	return new(big.Int).Exp(ten, big.NewInt(int64(n)), nil)
+}
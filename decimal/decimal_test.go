@@ -0,0 +1,129 @@
+package decimal
+
+import (
+	"errors"
+	"testing"
+)
+
+func mustParse(t *testing.T, s string) Decimal {
+	t.Helper()
+	d, err := Parse(s)
+	if err != nil {
+		t.Fatalf("Parse(%q): %v", s, err)
+	}
+	return d
+}
+
+func TestParse(t *testing.T) {
+	testCases := []struct {
+		in      string
+		want    string
+		scale   int32
+		wantErr error
+	}{
+		{"0", "0", 0, nil},
+		{"12.50", "12.50", 2, nil},
+		{"-0.05", "-0.05", 2, nil},
+		{"+7", "7", 0, nil},
+		{".5", "0.5", 1, nil},
+		{"5.", "5", 0, nil},
+		{"123456789012345678901234567890.1", "123456789012345678901234567890.1", 1, nil},
+		{"", "", 0, ErrSyntax},
+		{"-", "", 0, ErrSyntax},
+		{".", "", 0, ErrSyntax},
+		{"--1", "", 0, ErrSyntax},
+		{"1.2.3", "", 0, ErrSyntax},
+		{"1e5", "", 0, ErrSyntax},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.in, func(t *testing.T) {
+			d, err := Parse(tc.in)
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got := d.String(); got != tc.want {
+				t.Errorf("String() = %q, want %q", got, tc.want)
+			}
+			if got := d.Scale(); got != tc.scale {
+				t.Errorf("Scale() = %d, want %d", got, tc.scale)
+			}
+		})
+	}
+}
+
+func TestDecimal_Arithmetic(t *testing.T) {
+	a := mustParse(t, "21474836.47")
+	b := mustParse(t, "0.1")
+
+	// Amounts beyond the range of int32 cents are exact.
+	if got, want := a.Add(a).String(), "42949672.94"; got != want {
+		t.Errorf("Add() = %s, want %s", got, want)
+	}
+	// 0.1 + 0.2 is exactly 0.3, unlike with floating point.
+	if got := b.Add(mustParse(t, "0.2")); got.Cmp(mustParse(t, "0.3")) != 0 {
+		t.Errorf("0.1 + 0.2 = %s, want 0.3", got)
+	}
+	if got, want := b.Sub(a).String(), "-21474836.37"; got != want {
+		t.Errorf("Sub() = %s, want %s", got, want)
+	}
+	if got, want := a.Mul(mustParse(t, "-1.5")).String(), "-32212254.705"; got != want {
+		t.Errorf("Mul() = %s, want %s", got, want)
+	}
+	if got, want := New(5, 0).Neg().String(), "-5"; got != want {
+		t.Errorf("Neg() = %s, want %s", got, want)
+	}
+	if got, want := (Decimal{}).Add(b).String(), "0.1"; got != want {
+		t.Errorf("zero value Add() = %s, want %s", got, want)
+	}
+}
+
+func TestDecimal_Rescale(t *testing.T) {
+	testCases := []struct {
+		in    string
+		scale int32
+		want  string
+	}{
+		{"1.5", 3, "1.500"},
+		{"1.25", 1, "1.3"},
+		{"1.24", 1, "1.2"},
+		{"-1.25", 1, "-1.3"},
+		{"-0.05", 1, "-0.1"},
+		{"0.04", 1, "0.0"},
+		{"9.99", 0, "10"},
+	}
+
+	for _, tc := range testCases {
+		if got := mustParse(t, tc.in).Rescale(tc.scale).String(); got != tc.want {
+			t.Errorf("Rescale(%s, %d) = %s, want %s", tc.in, tc.scale, got, tc.want)
+		}
+	}
+}
+
+func TestDecimal_Cmp(t *testing.T) {
+	if got := mustParse(t, "1.10").Cmp(mustParse(t, "1.1")); got != 0 {
+		t.Errorf("Cmp(1.10, 1.1) = %d, want 0", got)
+	}
+	if got := mustParse(t, "-2").Cmp(mustParse(t, "1.99")); got != -1 {
+		t.Errorf("Cmp(-2, 1.99) = %d, want -1", got)
+	}
+}
+
+func TestDecimal_Unscaled(t *testing.T) {
+	n, err := mustParse(t, "-92233720368547758.08").Unscaled()
+	if err != nil || n != -9223372036854775808 {
+		t.Errorf("Unscaled() = %d, %v, want %d", n, err, int64(-9223372036854775808))
+	}
+	if _, err := mustParse(t, "92233720368547758.08").Unscaled(); !errors.Is(err, ErrRange) {
+		t.Errorf("Unscaled() error = %v, want %v", err, ErrRange)
+	}
+	if got, want := mustParse(t, "123").Precision(), int32(3); got != want {
+		t.Errorf("Precision() = %d, want %d", got, want)
+	}
+	if got := mustParse(t, "-0.25").Float64(); got != -0.25 {
+		t.Errorf("Float64() = %v, want -0.25", got)
+	}
+}
@@ -0,0 +1,329 @@
+package file
+
+import (
+	"errors"
+	"maps"
+	"math/rand/v2"
+	"slices"
+	"sync"
+)
+
+// ErrCrashed is returned by every operation of a FaultyStorage between a
+// simulated crash and the following Restart.
+var ErrCrashed = errors.New("file: storage crashed")
+
+// FaultyStorage wraps a Storage, which plays the role of the disk, to test
+// recovery against crashes. Writes and appends are cached until the file is
+// synced, as by the page cache of an operating system, and a crash loses
+// whatever was not synced. A crash happens when Crash is called, or when the
+// write chosen with FailNthWrite is attempted; that write can be made to
+// reach the disk only partially, as a torn page.
+//
+// It is safe for concurrent use.
+type FaultyStorage struct {
+	mu      sync.Mutex
+	disk    Storage
+	pending map[string]map[int32][]byte // unsynced blocks, by file and number
+	sizes   map[string]int32            // file sizes including unsynced appends
+	writes  int                         // writes and appends until the failing one
+	tear    func(block *Block) bool
+	rand    *rand.Rand
+	crashed bool
+}
+
+// NewFaultyStorage wraps the disk. The seed makes the torn writes
+// reproducible.
+func NewFaultyStorage(disk Storage, seed uint64) *FaultyStorage {
+	return &FaultyStorage{
+		disk:    disk,
+		pending: make(map[string]map[int32][]byte),
+		sizes:   make(map[string]int32),
+		rand:    rand.New(rand.NewPCG(seed, seed)),
+	}
+}
+
+// FailNthWrite makes the nth write or append from now on, counting from 1,
+// fail and crash the storage. A value of 0 disables the fault.
+func (s *FaultyStorage) FailNthWrite(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.writes = n
+}
+
+// TearWrites sets which blocks are torn when their write fails: a random
+// prefix of the new contents reaches the disk, and the rest of the block
+// keeps its old contents. A nil function disables tearing.
+func (s *FaultyStorage) TearWrites(tear func(block *Block) bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tear = tear
+}
+
+// Crash simulates a crash: the unsynced writes are lost, and every operation
+// fails with ErrCrashed until Restart is called.
+func (s *FaultyStorage) Crash() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.crash()
+}
+
+// Restart makes the storage usable again after a crash, with the contents
+// that were synced before it.
+func (s *FaultyStorage) Restart() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.crashed = false
+	s.writes = 0
+}
+
+// Crashed reports whether the storage has crashed and not been restarted.
+func (s *FaultyStorage) Crashed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.crashed
+}
+
+func (s *FaultyStorage) BlockSize() int32 {
+	return s.disk.BlockSize()
+}
+
+func (s *FaultyStorage) Read(block *Block, page *Page) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.crashed {
+		return ErrCrashed
+	}
+
+	if contents, ok := s.pending[block.Filename()][block.Number()]; ok {
+		copy(page.Buf(), contents)
+		return nil
+	}
+	return s.readDisk(block, page)
+}
+
+func (s *FaultyStorage) Write(block *Block, page *Page) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.countWrite(); err != nil {
+		if s.tear != nil && s.tear(block) {
+			s.tearWrite(block, page)
+		}
+		return err
+	}
+
+	size, err := s.size(block.Filename())
+	if err != nil {
+		return err
+	}
+	if block.Number() >= size {
+		s.sizes[block.Filename()] = block.Number() + 1
+	}
+	s.setPending(block, page.Buf())
+	return nil
+}
+
+func (s *FaultyStorage) Append(filename string) (*Block, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.countWrite(); err != nil {
+		return nil, err
+	}
+
+	size, err := s.size(filename)
+	if err != nil {
+		return nil, err
+	}
+	block := NewBlock(filename, size)
+	s.sizes[filename] = size + 1
+	s.setPending(block, make([]byte, s.disk.BlockSize()))
+	return block, nil
+}
+
+func (s *FaultyStorage) Size(filename string) (int32, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.crashed {
+		return 0, ErrCrashed
+	}
+	return s.size(filename)
+}
+
+// Sync writes the cached blocks of the file to the disk.
+func (s *FaultyStorage) Sync(filename string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.crashed {
+		return ErrCrashed
+	}
+
+	size, err := s.size(filename)
+	if err != nil {
+		return err
+	}
+	diskSize, err := s.disk.Size(filename)
+	if err != nil {
+		return err
+	}
+	for ; diskSize < size; diskSize++ {
+		if _, err := s.disk.Append(filename); err != nil {
+			return err
+		}
+	}
+
+	for blockNum, contents := range s.pending[filename] {
+		if err := s.disk.Write(NewBlock(filename, blockNum), NewPageFromBuf(contents)); err != nil {
+			return err
+		}
+	}
+	delete(s.pending, filename)
+	return s.disk.Sync(filename)
+}
+
+// Truncate shortens the file on the disk right away, as if it were synced.
+func (s *FaultyStorage) Truncate(filename string, size int32) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.crashed {
+		return ErrCrashed
+	}
+
+	for blockNum := range s.pending[filename] {
+		if blockNum >= size {
+			delete(s.pending[filename], blockNum)
+		}
+	}
+	if current, ok := s.sizes[filename]; ok && current > size {
+		s.sizes[filename] = size
+	}
+	return s.disk.Truncate(filename, size)
+}
+
+// List returns the files of the disk, together with the files created since
+// the last sync.
+func (s *FaultyStorage) List() ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.crashed {
+		return nil, ErrCrashed
+	}
+
+	filenames, err := s.disk.List()
+	if err != nil {
+		return nil, err
+	}
+	filenames = append(filenames, slices.Collect(maps.Keys(s.sizes))...)
+	slices.Sort(filenames)
+	return slices.Compact(filenames), nil
+}
+
+// Remove deletes the file from the disk right away, as if the directory
+// were synced with it.
+func (s *FaultyStorage) Remove(filename string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.crashed {
+		return ErrCrashed
+	}
+
+	delete(s.pending, filename)
+	delete(s.sizes, filename)
+	return s.disk.Remove(filename)
+}
+
+// countWrite counts a write or an append, and crashes the storage if it is
+// the one that must fail.
+// This method must be called with the mutex lock already held.
+func (s *FaultyStorage) countWrite() error {
+	if s.crashed {
+		return ErrCrashed
+	}
+	if s.writes > 0 {
+		s.writes--
+		if s.writes == 0 {
+			s.crash()
+			return ErrCrashed
+		}
+	}
+	return nil
+}
+
+// crash drops the unsynced writes.
+// This method must be called with the mutex lock already held.
+func (s *FaultyStorage) crash() {
+	s.crashed = true
+	clear(s.pending)
+	clear(s.sizes)
+}
+
+// tearWrite writes a random prefix of the page to the disk, over the synced
+// contents of the block.
+// This method must be called with the mutex lock already held.
+func (s *FaultyStorage) tearWrite(block *Block, page *Page) {
+	diskSize, err := s.disk.Size(block.Filename())
+	if err != nil || block.Number() >= diskSize {
+		// The block was never synced, so there is nothing to tear.
+		return
+	}
+
+	torn := NewPage(s.disk.BlockSize())
+	if err := s.disk.Read(block, torn); err != nil {
+		return
+	}
+	n := s.rand.IntN(len(page.Buf()))
+	copy(torn.Buf(), page.Buf()[:n])
+	s.disk.Write(block, torn)
+	s.disk.Sync(block.Filename())
+}
+
+// readDisk reads a block from the disk. Blocks beyond the end of the file
+// read as zeros.
+// This method must be called with the mutex lock already held.
+func (s *FaultyStorage) readDisk(block *Block, page *Page) error {
+	size, err := s.disk.Size(block.Filename())
+	if err != nil {
+		return err
+	}
+	if block.Number() >= size {
+		clear(page.Buf())
+		return nil
+	}
+	return s.disk.Read(block, page)
+}
+
+// size returns the size of the file, including the unsynced appends.
+// This method must be called with the mutex lock already held.
+func (s *FaultyStorage) size(filename string) (int32, error) {
+	if size, ok := s.sizes[filename]; ok {
+		return size, nil
+	}
+	size, err := s.disk.Size(filename)
+	if err != nil {
+		return 0, err
+	}
+	s.sizes[filename] = size
+	return size, nil
+}
+
+// setPending caches the new contents of a block until the file is synced.
+// This method must be called with the mutex lock already held.
+func (s *FaultyStorage) setPending(block *Block, contents []byte) {
+	blocks, ok := s.pending[block.Filename()]
+	if !ok {
+		blocks = make(map[int32][]byte)
+		s.pending[block.Filename()] = blocks
+	}
+	blocks[block.Number()] = append([]byte(nil), contents...)
+}
@@ -0,0 +1,99 @@
+package file
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestFaultyStorage(t *testing.T) {
+	disk, err := NewManager(t.TempDir(), 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewFaultyStorage(disk, 1)
+
+	write := func(t *testing.T, block *Block, val int32) error {
+		t.Helper()
+		p := NewPage(s.BlockSize())
+		for offset := int32(0); offset < s.BlockSize(); offset += 4 {
+			p.WriteInt32At(offset, val)
+		}
+		return s.Write(block, p)
+	}
+	read := func(t *testing.T, block *Block) []int32 {
+		t.Helper()
+		p := NewPage(s.BlockSize())
+		if err := s.Read(block, p); err != nil {
+			t.Fatal(err)
+		}
+		var vals []int32
+		for offset := int32(0); offset < s.BlockSize(); offset += 4 {
+			val, _ := p.ReadInt32At(offset)
+			vals = append(vals, val)
+		}
+		return vals
+	}
+
+	synced, err := s.Append("testfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := write(t, synced, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Sync("testfile"); err != nil {
+		t.Fatal(err)
+	}
+
+	unsynced, err := s.Append("testfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := write(t, unsynced, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := write(t, synced, 3); err != nil {
+		t.Fatal(err)
+	}
+	if got := read(t, synced)[0]; got != 3 {
+		t.Errorf("unsynced write: read %d, want 3", got)
+	}
+
+	// A crash loses the unsynced append and write.
+	s.Crash()
+	if _, err := s.Size("testfile"); !errors.Is(err, ErrCrashed) {
+		t.Errorf("Size after crash: got error %v, want %v", err, ErrCrashed)
+	}
+	s.Restart()
+	if size, err := s.Size("testfile"); err != nil || size != 1 {
+		t.Errorf("Size after restart: got (%d, %v), want (1, nil)", size, err)
+	}
+	if got := read(t, synced)[0]; got != 1 {
+		t.Errorf("after restart: read %d, want 1", got)
+	}
+
+	// The third write from now fails, and is torn.
+	s.FailNthWrite(3)
+	s.TearWrites(func(block *Block) bool { return true })
+	for i, wantErr := range []error{nil, nil, ErrCrashed, ErrCrashed} {
+		if err := write(t, synced, 4); !errors.Is(err, wantErr) {
+			t.Errorf("write %d: got error %v, want %v", i+1, err, wantErr)
+		}
+	}
+	s.Restart()
+
+	// The torn block starts with the new contents and ends with the old.
+	vals := read(t, synced)
+	torn := false
+	for i, val := range vals {
+		if val != 1 && val != 4 {
+			// The tear may split an int32.
+			continue
+		}
+		if val == 1 {
+			torn = true
+		} else if torn {
+			t.Fatalf("new contents after old contents at %d: %v", i, vals)
+		}
+	}
+}
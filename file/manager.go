@@ -1,12 +1,16 @@
 package file
 
 import (
+	"errors"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"strings"
 	"sync"
 )
 
+// Manager is the Storage that keeps each database file in an operating
+// system file of the database directory.
 type Manager struct {
 	mu        sync.Mutex
 	directory string
@@ -115,6 +119,70 @@ func (m *Manager) Append(filename string) (*Block, error) {
 	return block, nil
 }
 
+// Sync commits the contents of the specified file to stable storage. Since
+// files are opened with O_SYNC, writes are already durable when they return,
+// so this is only needed by implementations of Storage that cache writes.
+func (m *Manager) Sync(filename string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f, err := m.getOpenFile(filename)
+	if err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// Truncate shortens the specified file to the given number of blocks.
+// It is safe for concurrent use.
+func (m *Manager) Truncate(filename string, size int32) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f, err := m.getOpenFile(filename)
+	if err != nil {
+		return err
+	}
+	return f.Truncate(int64(size) * int64(m.blockSize))
+}
+
+// List returns the names of the files in the database directory, in sorted
+// order.
+func (m *Manager) List() ([]string, error) {
+	entries, err := os.ReadDir(m.directory)
+	if err != nil {
+		return nil, err
+	}
+
+	var filenames []string
+	for _, entry := range entries {
+		if entry.Type().IsRegular() {
+			filenames = append(filenames, entry.Name())
+		}
+	}
+	return filenames, nil
+}
+
+// Remove closes and deletes the specified file.
+// It is safe for concurrent use.
+func (m *Manager) Remove(filename string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if f, ok := m.openFiles[filename]; ok {
+		delete(m.openFiles, filename)
+		if err := f.Close(); err != nil {
+			return err
+		}
+	}
+
+	err := os.Remove(filepath.Join(m.directory, filename))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
 // Size returns the number of blocks in the specified file.
 func (m *Manager) Size(filename string) (int32, error) {
 	f, err := m.getOpenFile(filename)
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"os"
 	"path/filepath"
+	"slices"
 	"testing"
 )
 
@@ -198,3 +199,88 @@ func TestManager_Size(t *testing.T) {
 		t.Errorf("Size() after writing to block 2 = %d, want 3", size)
 	}
 }
+
+func TestManager_Truncate(t *testing.T) {
+	directory := t.TempDir()
+	const blockSize = 400
+	const filename = "testtruncatefile"
+	manager, err := NewManager(directory, blockSize)
+	if err != nil {
+		t.Fatalf("Failed to create file manager: %v", err)
+	}
+
+	p := NewPage(blockSize)
+	p.WriteStringAt(0, "kept data")
+	if err := manager.Write(NewBlock(filename, 0), p); err != nil {
+		t.Fatalf("Write() failed: %v", err)
+	}
+	for range 2 {
+		if _, err := manager.Append(filename); err != nil {
+			t.Fatalf("Append() failed: %v", err)
+		}
+	}
+
+	if err := manager.Truncate(filename, 1); err != nil {
+		t.Fatalf("Truncate() failed: %v", err)
+	}
+	size, err := manager.Size(filename)
+	if err != nil {
+		t.Fatalf("Size() after truncation failed: %v", err)
+	}
+	if size != 1 {
+		t.Errorf("Size() after truncation = %d, want 1", size)
+	}
+
+	// The blocks before the cut keep their contents.
+	read := NewPage(blockSize)
+	if err := manager.Read(NewBlock(filename, 0), read); err != nil {
+		t.Fatalf("Read() after truncation failed: %v", err)
+	}
+	if got, _ := read.ReadStringAt(0); got != "kept data" {
+		t.Errorf("ReadStringAt() after truncation = %q, want %q", got, "kept data")
+	}
+
+	// A block appended after the cut follows the blocks kept.
+	block, err := manager.Append(filename)
+	if err != nil {
+		t.Fatalf("Append() after truncation failed: %v", err)
+	}
+	if block.Number() != 1 {
+		t.Errorf("block.Number() after truncation = %d, want 1", block.Number())
+	}
+}
+
+func TestManager_Remove(t *testing.T) {
+	directory := t.TempDir()
+	const filename = "testremovefile"
+	manager, err := NewManager(directory, 400)
+	if err != nil {
+		t.Fatalf("Failed to create file manager: %v", err)
+	}
+
+	if _, err := manager.Append(filename); err != nil {
+		t.Fatalf("Append() failed: %v", err)
+	}
+	if filenames, err := manager.List(); err != nil || !slices.Equal(filenames, []string{filename}) {
+		t.Errorf("List() = %v, %v, want [%s]", filenames, err, filename)
+	}
+	if err := manager.Remove(filename); err != nil {
+		t.Fatalf("Remove() failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(directory, filename)); !os.IsNotExist(err) {
+		t.Errorf("File %q was not removed", filename)
+	}
+
+	// The file is created again when it is used after its removal.
+	size, err := manager.Size(filename)
+	if err != nil {
+		t.Fatalf("Size() after Remove() failed: %v", err)
+	}
+	if size != 0 {
+		t.Errorf("Size() after Remove() = %d, want 0", size)
+	}
+
+	if err := manager.Remove("missingfile"); err != nil {
+		t.Errorf("Remove() of a missing file failed: %v", err)
+	}
+}
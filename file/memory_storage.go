@@ -0,0 +1,107 @@
+package file
+
+import (
+	"io"
+	"maps"
+	"slices"
+	"sync"
+)
+
+// MemoryStorage is the Storage that keeps the database files in memory. It
+// is meant for tests and ephemeral databases: its contents are lost when it
+// is discarded, so syncing a file does nothing.
+//
+// It is safe for concurrent use.
+type MemoryStorage struct {
+	mu        sync.Mutex
+	blockSize int32
+	files     map[string][][]byte // blocks by file name
+}
+
+// NewMemoryStorage creates an empty in-memory storage.
+func NewMemoryStorage(blockSize int32) *MemoryStorage {
+	return &MemoryStorage{
+		blockSize: blockSize,
+		files:     make(map[string][][]byte),
+	}
+}
+
+func (s *MemoryStorage) BlockSize() int32 {
+	return s.blockSize
+}
+
+// Read reads the contents of a block into a page. As when reading past the
+// end of an operating system file, reading a block beyond the end of the
+// file fails with io.EOF; the page is then zeroed.
+func (s *MemoryStorage) Read(block *Block, page *Page) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	blocks := s.files[block.Filename()]
+	if block.Number() < 0 || int(block.Number()) >= len(blocks) {
+		clear(page.Buf())
+		return io.EOF
+	}
+	copy(page.Buf(), blocks[block.Number()])
+	return nil
+}
+
+// Write writes the contents of a page to a block. Writing beyond the end of
+// the file extends it with zeroed blocks.
+func (s *MemoryStorage) Write(block *Block, page *Page) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	blocks := s.files[block.Filename()]
+	for int(block.Number()) >= len(blocks) {
+		blocks = append(blocks, make([]byte, s.blockSize))
+	}
+	copy(blocks[block.Number()], page.Buf())
+	s.files[block.Filename()] = blocks
+	return nil
+}
+
+func (s *MemoryStorage) Append(filename string) (*Block, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	block := NewBlock(filename, int32(len(s.files[filename])))
+	s.files[filename] = append(s.files[filename], make([]byte, s.blockSize))
+	return block, nil
+}
+
+func (s *MemoryStorage) Size(filename string) (int32, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return int32(len(s.files[filename])), nil
+}
+
+func (s *MemoryStorage) Sync(filename string) error {
+	return nil
+}
+
+func (s *MemoryStorage) Truncate(filename string, size int32) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if blocks := s.files[filename]; int(size) < len(blocks) {
+		s.files[filename] = blocks[:size]
+	}
+	return nil
+}
+
+func (s *MemoryStorage) List() ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return slices.Sorted(maps.Keys(s.files)), nil
+}
+
+func (s *MemoryStorage) Remove(filename string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.files, filename)
+	return nil
+}
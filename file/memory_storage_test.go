@@ -0,0 +1,132 @@
+package file
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"slices"
+	"testing"
+)
+
+func TestMemoryStorage(t *testing.T) {
+	const blockSize = 400
+
+	t.Run("Reads back written blocks", func(t *testing.T) {
+		storage := NewMemoryStorage(blockSize)
+		block := NewBlock("testfile", 2)
+
+		p1 := NewPage(blockSize)
+		p1.WriteStringAt(88, "hello world")
+		p1.WriteInt32At(20, 12345)
+		if err := storage.Write(block, p1); err != nil {
+			t.Fatalf("Write() failed: %v", err)
+		}
+
+		p2 := NewPage(blockSize)
+		if err := storage.Read(block, p2); err != nil {
+			t.Fatalf("Read() failed: %v", err)
+		}
+		if !bytes.Equal(p1.Buf(), p2.Buf()) {
+			t.Errorf("Page buffers do not match after read/write cycle")
+		}
+
+		// The blocks before the written one are zeroed.
+		if err := storage.Read(NewBlock("testfile", 0), p2); err != nil {
+			t.Fatalf("Read() failed: %v", err)
+		}
+		if !bytes.Equal(p2.Buf(), make([]byte, blockSize)) {
+			t.Errorf("Block 0 is not zeroed")
+		}
+
+		// Changing the page after writing it does not change the block.
+		p1.WriteInt32At(20, 6789)
+		if err := storage.Read(block, p2); err != nil {
+			t.Fatalf("Read() failed: %v", err)
+		}
+		if got, _ := p2.ReadInt32At(20); got != 12345 {
+			t.Errorf("ReadInt32At(20) = %d, want 12345", got)
+		}
+	})
+
+	t.Run("Fails to read beyond the end of the file", func(t *testing.T) {
+		storage := NewMemoryStorage(blockSize)
+		if err := storage.Read(NewBlock("testfile", 0), NewPage(blockSize)); !errors.Is(err, io.EOF) {
+			t.Errorf("Read() error = %v, want %v", err, io.EOF)
+		}
+	})
+
+	t.Run("Appends and sizes files", func(t *testing.T) {
+		storage := NewMemoryStorage(blockSize)
+		for want := range int32(3) {
+			size, err := storage.Size("testfile")
+			if err != nil {
+				t.Fatalf("Size() failed: %v", err)
+			}
+			if size != want {
+				t.Errorf("Size() = %d, want %d", size, want)
+			}
+
+			block, err := storage.Append("testfile")
+			if err != nil {
+				t.Fatalf("Append() failed: %v", err)
+			}
+			if block.Number() != want {
+				t.Errorf("block.Number() = %d, want %d", block.Number(), want)
+			}
+		}
+	})
+
+	t.Run("Truncates files", func(t *testing.T) {
+		storage := NewMemoryStorage(blockSize)
+		for range 3 {
+			if _, err := storage.Append("testfile"); err != nil {
+				t.Fatalf("Append() failed: %v", err)
+			}
+		}
+		if err := storage.Truncate("testfile", 1); err != nil {
+			t.Fatalf("Truncate() failed: %v", err)
+		}
+		if size, _ := storage.Size("testfile"); size != 1 {
+			t.Errorf("Size() after Truncate() = %d, want 1", size)
+		}
+		block, err := storage.Append("testfile")
+		if err != nil {
+			t.Fatalf("Append() failed: %v", err)
+		}
+		if block.Number() != 1 {
+			t.Errorf("block.Number() after Truncate() = %d, want 1", block.Number())
+		}
+	})
+
+	t.Run("Lists files", func(t *testing.T) {
+		storage := NewMemoryStorage(blockSize)
+		for _, filename := range []string{"b", "a"} {
+			if _, err := storage.Append(filename); err != nil {
+				t.Fatalf("Append() failed: %v", err)
+			}
+		}
+		filenames, err := storage.List()
+		if err != nil {
+			t.Fatalf("List() failed: %v", err)
+		}
+		if !slices.Equal(filenames, []string{"a", "b"}) {
+			t.Errorf("List() = %v, want [a b]", filenames)
+		}
+	})
+
+	t.Run("Removes files", func(t *testing.T) {
+		storage := NewMemoryStorage(blockSize)
+		if _, err := storage.Append("testfile"); err != nil {
+			t.Fatalf("Append() failed: %v", err)
+		}
+		if err := storage.Remove("testfile"); err != nil {
+			t.Fatalf("Remove() failed: %v", err)
+		}
+		if size, _ := storage.Size("testfile"); size != 0 {
+			t.Errorf("Size() after Remove() = %d, want 0", size)
+		}
+		if err := storage.Remove("testfile"); err != nil {
+			t.Errorf("Remove() of a missing file failed: %v", err)
+		}
+	})
+}
@@ -3,6 +3,10 @@ package file
 import (
 	"encoding/binary"
 	"io"
+	"math"
+	"time"
+
+	"simpledb/decimal"
 )
 
 // How data is stored in a Page at a given `offset`:
@@ -59,6 +63,105 @@ func (p *Page) ReadInt32At(offset int32) (int32, error) {
 	return int32(binary.BigEndian.Uint32(p.buf[offset : offset+4])), nil
 }
 
+// WriteInt64At writes an int64 value to the page at a specific offset.
+// It returns an io.EOF error if the write would exceed the page's bounds.
+func (p *Page) WriteInt64At(offset int32, n int64) error {
+	if offset+8 > int32(len(p.buf)) {
+		return io.EOF
+	}
+	binary.BigEndian.PutUint64(p.buf[offset:], uint64(n))
+	return nil
+}
+
+// ReadInt64At reads an int64 value from the page at a specific offset.
+// It returns an io.EOF error if the read would exceed the page's bounds.
+func (p *Page) ReadInt64At(offset int32) (int64, error) {
+	if offset+8 > int32(len(p.buf)) {
+		return 0, io.EOF
+	}
+	return int64(binary.BigEndian.Uint64(p.buf[offset : offset+8])), nil
+}
+
+// WriteFloat64At writes a float64 value to the page at a specific offset,
+// as the 8 bytes of its IEEE 754 representation.
+// It returns an io.EOF error if the write would exceed the page's bounds.
+func (p *Page) WriteFloat64At(offset int32, f float64) error {
+	return p.WriteInt64At(offset, int64(math.Float64bits(f)))
+}
+
+// ReadFloat64At reads a float64 value from the page at a specific offset.
+// It returns an io.EOF error if the read would exceed the page's bounds.
+func (p *Page) ReadFloat64At(offset int32) (float64, error) {
+	n, err := p.ReadInt64At(offset)
+	if err != nil {
+		return 0, err
+	}
+	return math.Float64frombits(uint64(n)), nil
+}
+
+// WriteBoolAt writes a bool value to the page at a specific offset, as a
+// 4-byte integer that is 1 for true and 0 for false.
+// It returns an io.EOF error if the write would exceed the page's bounds.
+func (p *Page) WriteBoolAt(offset int32, b bool) error {
+	var n int32
+	if b {
+		n = 1
+	}
+	return p.WriteInt32At(offset, n)
+}
+
+// ReadBoolAt reads a bool value from the page at a specific offset.
+// It returns an io.EOF error if the read would exceed the page's bounds.
+func (p *Page) ReadBoolAt(offset int32) (bool, error) {
+	n, err := p.ReadInt32At(offset)
+	if err != nil {
+		return false, err
+	}
+	return n != 0, nil
+}
+
+// WriteTimeAt writes a time value to the page at a specific offset, as the
+// 8-byte number of microseconds since the Unix epoch. Finer precision and
+// the time zone are not kept.
+// It returns an io.EOF error if the write would exceed the page's bounds.
+func (p *Page) WriteTimeAt(offset int32, t time.Time) error {
+	return p.WriteInt64At(offset, t.UnixMicro())
+}
+
+// ReadTimeAt reads a time value from the page at a specific offset. The
+// time is returned in UTC.
+// It returns an io.EOF error if the read would exceed the page's bounds.
+func (p *Page) ReadTimeAt(offset int32) (time.Time, error) {
+	n, err := p.ReadInt64At(offset)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.UnixMicro(n).UTC(), nil
+}
+
+// WriteDecimalAt writes a decimal to the page at a specific offset, as the
+// 8-byte coefficient of the decimal rescaled to the specified scale.
+// It returns decimal.ErrRange if the coefficient does not fit in 8 bytes,
+// and an io.EOF error if the write would exceed the page's bounds.
+func (p *Page) WriteDecimalAt(offset int32, d decimal.Decimal, scale int32) error {
+	n, err := d.Rescale(scale).Unscaled()
+	if err != nil {
+		return err
+	}
+	return p.WriteInt64At(offset, n)
+}
+
+// ReadDecimalAt reads a decimal with the specified scale from the page at a
+// specific offset.
+// It returns an io.EOF error if the read would exceed the page's bounds.
+func (p *Page) ReadDecimalAt(offset int32, scale int32) (decimal.Decimal, error) {
+	n, err := p.ReadInt64At(offset)
+	if err != nil {
+		return decimal.Decimal{}, err
+	}
+	return decimal.New(n, scale), nil
+}
+
 // WriteBytesAt writes a byte slice to the page at a specific offset.
 // It first writes the length of the slice as a 4-byte integer, followed by the
 // bytes of the slice itself.
@@ -90,6 +193,28 @@ func (p *Page) ReadBytesAt(offset int32) ([]byte, error) {
 	return b, nil
 }
 
+// WriteRawAt copies a byte slice to the page at a specific offset, without
+// a length prefix.
+// It returns an io.EOF error if the write would exceed the page's bounds.
+func (p *Page) WriteRawAt(offset int32, b []byte) error {
+	if offset+int32(len(b)) > int32(len(p.buf)) {
+		return io.EOF
+	}
+	copy(p.buf[offset:], b)
+	return nil
+}
+
+// ReadRawAt returns a copy of the n bytes of the page at a specific offset.
+// It returns an io.EOF error if the read would exceed the page's bounds.
+func (p *Page) ReadRawAt(offset int32, n int32) ([]byte, error) {
+	if offset+n > int32(len(p.buf)) {
+		return nil, io.EOF
+	}
+	b := make([]byte, n)
+	copy(b, p.buf[offset:offset+n])
+	return b, nil
+}
+
 // WriteStringAt writes a string to the page at a specific offset.
 // It is a convenience wrapper around WriteBytesAt. The string is stored as a
 // 4-byte length prefix followed by its byte representation.
@@ -3,7 +3,11 @@ package file
 import (
 	"bytes"
 	"io"
+	"math"
 	"testing"
+	"time"
+
+	"simpledb/decimal"
 )
 
 func TestPage_WriteInt32At(t *testing.T) {
@@ -106,6 +110,121 @@ func TestPage_ReadInt32At(t *testing.T) {
 	}
 }
 
+func TestPage_Int64At(t *testing.T) {
+	const blockSize = 100
+	p := NewPage(blockSize)
+
+	testCases := []struct {
+		name    string
+		offset  int32
+		val     int64
+		wantErr error
+	}{
+		{"Value wider than 32 bits", 0, 1 << 40, nil},
+		{"Negative value", 20, -98765, nil},
+		{"Last possible offset", 92, 12345, nil},
+		{"Out of bounds", 93, 1, io.EOF},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := p.WriteInt64At(tc.offset, tc.val); err != tc.wantErr {
+				t.Fatalf("WriteInt64At() error = %v, wantErr %v", err, tc.wantErr)
+			}
+			gotVal, err := p.ReadInt64At(tc.offset)
+			if err != tc.wantErr {
+				t.Fatalf("ReadInt64At() error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if tc.wantErr == nil && gotVal != tc.val {
+				t.Errorf("ReadInt64At() gotVal = %v, want %v", gotVal, tc.val)
+			}
+		})
+	}
+}
+
+func TestPage_FixedWidthValues(t *testing.T) {
+	const blockSize = 100
+	p := NewPage(blockSize)
+
+	for _, f := range []float64{0, -1.5, math.MaxFloat64, math.Inf(-1)} {
+		if err := p.WriteFloat64At(8, f); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := p.ReadFloat64At(8); err != nil || got != f {
+			t.Errorf("ReadFloat64At() = %v, %v, want %v", got, err, f)
+		}
+	}
+
+	for _, b := range []bool{true, false} {
+		if err := p.WriteBoolAt(96, b); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := p.ReadBoolAt(96); err != nil || got != b {
+			t.Errorf("ReadBoolAt() = %v, %v, want %v", got, err, b)
+		}
+	}
+
+	// Times keep microsecond precision.
+	ts := time.Date(1969, 7, 20, 20, 17, 40, 123456789, time.FixedZone("X", 3600))
+	if err := p.WriteTimeAt(40, ts); err != nil {
+		t.Fatal(err)
+	}
+	got, err := p.ReadTimeAt(40)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := ts.Truncate(time.Microsecond); !got.Equal(want) || got.Location() != time.UTC {
+		t.Errorf("ReadTimeAt() = %v, want %v in UTC", got, want)
+	}
+
+	d, err := decimal.Parse("-1234.567")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.WriteDecimalAt(60, d, 2); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := p.ReadDecimalAt(60, 2); err != nil || got.String() != "-1234.57" {
+		t.Errorf("ReadDecimalAt() = %v, %v, want %v", got, err, "-1234.57")
+	}
+	if err := p.WriteDecimalAt(60, d, 17); err != decimal.ErrRange {
+		t.Errorf("WriteDecimalAt() too wide: error = %v, want %v", err, decimal.ErrRange)
+	}
+
+	if err := p.WriteFloat64At(93, 1); err != io.EOF {
+		t.Errorf("WriteFloat64At() out of bounds: error = %v, want %v", err, io.EOF)
+	}
+	if err := p.WriteTimeAt(93, ts); err != io.EOF {
+		t.Errorf("WriteTimeAt() out of bounds: error = %v, want %v", err, io.EOF)
+	}
+	if err := p.WriteBoolAt(97, true); err != io.EOF {
+		t.Errorf("WriteBoolAt() out of bounds: error = %v, want %v", err, io.EOF)
+	}
+}
+
+func TestPage_RawAt(t *testing.T) {
+	const blockSize = 100
+	p := NewPage(blockSize)
+
+	want := []byte("raw bytes")
+	if err := p.WriteRawAt(91, want); err != nil {
+		t.Fatal(err)
+	}
+	got, err := p.ReadRawAt(91, int32(len(want)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("ReadRawAt() = %q, want %q", got, want)
+	}
+	if err := p.WriteRawAt(92, want); err != io.EOF {
+		t.Errorf("WriteRawAt() out of bounds: error = %v, want %v", err, io.EOF)
+	}
+	if _, err := p.ReadRawAt(92, int32(len(want))); err != io.EOF {
+		t.Errorf("ReadRawAt() out of bounds: error = %v, want %v", err, io.EOF)
+	}
+}
+
 func TestPage_WriteBytesAt(t *testing.T) {
 	const blockSize = 100
 
@@ -0,0 +1,29 @@
+package file
+
+// Storage keeps the blocks of the database files. Manager stores them in
+// operating system files and MemoryStorage in memory; FaultyStorage wraps
+// another Storage to simulate crashes in tests.
+//
+// A block written with Write is only guaranteed to survive a crash once the
+// file has been synced.
+type Storage interface {
+	// BlockSize returns the size of the blocks, in bytes.
+	BlockSize() int32
+	// Read reads the contents of a block into a page.
+	Read(block *Block, page *Page) error
+	// Write writes the contents of a page to a block.
+	Write(block *Block, page *Page) error
+	// Append appends a new, zeroed block to the end of the file.
+	Append(filename string) (*Block, error)
+	// Size returns the number of blocks in the file.
+	Size(filename string) (int32, error)
+	// Sync makes the blocks written to the file durable.
+	Sync(filename string) error
+	// Truncate shortens the file to the given number of blocks.
+	Truncate(filename string, size int32) error
+	// List returns the names of the files, in sorted order.
+	List() ([]string, error)
+	// Remove deletes the file. Removing a file that does not exist is not
+	// an error.
+	Remove(filename string) error
+}
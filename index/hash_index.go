@@ -0,0 +1,160 @@
+package index
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"time"
+
+	"simpledb/decimal"
+	"simpledb/query"
+	"simpledb/record"
+	"simpledb/transaction"
+)
+
+// numBuckets is the number of buckets of a hash index.
+const numBuckets = 100
+
+// HashIndex is a static hash index. Its entries are spread over a fixed
+// number of buckets by the hash of their data value, and each bucket is a
+// table of block, id and dataval records, where block and id make up the
+// RID.
+type HashIndex struct {
+	tx        *transaction.Transaction
+	indexName string
+	layout    *record.Layout
+	searchKey any
+	tableScan *record.TableScan
+}
+
+func NewHashIndex(tx *transaction.Transaction, indexName string, layout *record.Layout) *HashIndex {
+	return &HashIndex{tx: tx, indexName: indexName, layout: layout}
+}
+
+// SearchCost returns the number of blocks read to search an index of the
+// specified number of blocks, which is that of a bucket.
+func SearchCost(numBlocks int32, recordsPerBlock int32) int32 {
+	return numBlocks / numBuckets
+}
+
+// DropHashIndex deletes the buckets of the index once the transaction
+// commits.
+func DropHashIndex(tx *transaction.Transaction, indexName string) error {
+	for bucket := range uint32(numBuckets) {
+		if err := record.DropTable(tx, bucketTable(indexName, bucket)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// HashIndexDropped reports whether the transaction dropped the index, whose
+// buckets are then only deleted when it commits.
+func HashIndexDropped(tx *transaction.Transaction, indexName string) bool {
+	return record.TableDropped(tx, bucketTable(indexName, 0))
+}
+
+func (hi *HashIndex) BeforeFirst(searchKey any) error {
+	hi.Close()
+	hi.searchKey = searchKey
+	tableName := bucketTable(hi.indexName, hash(searchKey)%numBuckets)
+	tableScan, err := record.NewTableScan(hi.tx, tableName, hi.layout)
+	if err != nil {
+		return err
+	}
+	hi.tableScan = tableScan
+	return nil
+}
+
+func (hi *HashIndex) Next() bool {
+	for hi.tableScan.Next() {
+		value, err := hi.tableScan.ReadValue("dataval")
+		if err != nil {
+			return false
+		}
+		if c, ok := query.CompareValues(value, hi.searchKey); ok && c == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (hi *HashIndex) GetDataRID() (*record.RID, error) {
+	blockNum, err := hi.tableScan.ReadInt32("block")
+	if err != nil {
+		return nil, err
+	}
+	id, err := hi.tableScan.ReadInt32("id")
+	if err != nil {
+		return nil, err
+	}
+	return record.NewRID(blockNum, id), nil
+}
+
+func (hi *HashIndex) Insert(dataVal any, rid *record.RID) error {
+	if err := hi.BeforeFirst(dataVal); err != nil {
+		return err
+	}
+	if err := hi.tableScan.Insert(); err != nil {
+		return err
+	}
+	if err := hi.tableScan.WriteInt32("block", rid.BlockNumber()); err != nil {
+		return err
+	}
+	if err := hi.tableScan.WriteInt32("id", rid.Slot()); err != nil {
+		return err
+	}
+	return hi.tableScan.WriteValue("dataval", dataVal)
+}
+
+func (hi *HashIndex) Delete(dataVal any, rid *record.RID) error {
+	if err := hi.BeforeFirst(dataVal); err != nil {
+		return err
+	}
+	for hi.Next() {
+		dataRID, err := hi.GetDataRID()
+		if err != nil {
+			return err
+		}
+		if dataRID.Equals(rid) {
+			return hi.tableScan.Delete()
+		}
+	}
+	return nil
+}
+
+func (hi *HashIndex) Close() {
+	if hi.tableScan != nil {
+		hi.tableScan.Close()
+		hi.tableScan = nil
+	}
+}
+
+func bucketTable(indexName string, bucket uint32) string {
+	return fmt.Sprintf("%s#%d", indexName, bucket)
+}
+
+// hash hashes a data value. Values that compare as equal hash the same, as
+// integers of either width, and times stored with the precision of a
+// Timestamp field, or decimals of different scales.
+func hash(value any) uint32 {
+	h := fnv.New32a()
+	switch v := value.(type) {
+	case int32:
+		fmt.Fprint(h, int64(v))
+	case time.Time:
+		fmt.Fprint(h, v.UnixMicro())
+	case decimal.Decimal:
+		// Trailing zeros after the point do not change the value.
+		s := v.String()
+		if strings.Contains(s, ".") {
+			s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+		}
+		fmt.Fprint(h, s)
+	case []byte:
+		h.Write(v)
+	default:
+		fmt.Fprint(h, v)
+	}
+	return h.Sum32()
+}
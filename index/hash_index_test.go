@@ -0,0 +1,70 @@
+package index
+
+import (
+	"testing"
+
+	"simpledb/decimal"
+	"simpledb/record"
+	"simpledb/server"
+)
+
+func TestHashIndex(t *testing.T) {
+	simpleDB := server.NewMemorySimpleDB(400, 8)
+	tx := simpleDB.NewTx()
+	defer tx.Commit()
+
+	schema := record.NewSchema()
+	schema.AddIntField("block")
+	schema.AddIntField("id")
+	schema.AddStringField("dataval", 10)
+	hi := NewHashIndex(tx, "MyIndex", record.NewLayout(schema))
+	defer hi.Close()
+
+	// Every value is held by three records.
+	for i := range int32(60) {
+		if err := hi.Insert(string(rune('a'+i%20)), record.NewRID(i, i%7)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := hi.Delete("c", record.NewRID(22, 1)); err != nil {
+		t.Fatal(err)
+	}
+
+	for key, want := range map[string][]*record.RID{
+		"a": {record.NewRID(0, 0), record.NewRID(20, 6), record.NewRID(40, 5)},
+		"c": {record.NewRID(2, 2), record.NewRID(42, 0)},
+		"z": nil,
+	} {
+		if err := hi.BeforeFirst(key); err != nil {
+			t.Fatal(err)
+		}
+		var got []*record.RID
+		for hi.Next() {
+			rid, err := hi.GetDataRID()
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, rid)
+		}
+		if len(got) != len(want) {
+			t.Errorf("invalid number of entries of %q: got %d, want %d", key, len(got), len(want))
+			continue
+		}
+		for i := range got {
+			if !got[i].Equals(want[i]) {
+				t.Errorf("invalid entry of %q: got %v, want %v", key, got[i], want[i])
+			}
+		}
+	}
+}
+
+func TestHashIndex_Hash(t *testing.T) {
+	a, _ := decimal.Parse("1.50")
+	b, _ := decimal.Parse("1.5")
+	if hash(a) != hash(b) {
+		t.Errorf("equal decimals hash differently: %d and %d", hash(a), hash(b))
+	}
+	if hash(int32(7)) != hash(int64(7)) {
+		t.Errorf("equal integers hash differently: %d and %d", hash(int32(7)), hash(int64(7)))
+	}
+}
@@ -0,0 +1,22 @@
+package index
+
+import "simpledb/record"
+
+// An Index locates the records of a table by the value of one of their
+// fields. Its entries pair a value, the data value, with the RID of a
+// record holding it.
+type Index interface {
+	// BeforeFirst positions the index before the first entry with the
+	// search key.
+	BeforeFirst(searchKey any) error
+	// Next moves to the next entry with the search key, and reports whether
+	// there is one.
+	Next() bool
+	// GetDataRID returns the RID of the record of the current entry.
+	GetDataRID() (*record.RID, error)
+	// Insert adds an entry for the data value and the RID.
+	Insert(dataVal any, rid *record.RID) error
+	// Delete removes the entry for the data value and the RID.
+	Delete(dataVal any, rid *record.RID) error
+	Close()
+}
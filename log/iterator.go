@@ -7,16 +7,18 @@ import (
 // Iterator provides a way to read log records from the log file in reverse order.
 // It allows clients to iterate over the log records from most recent to oldest.
 type Iterator struct {
-	fileManager *file.Manager
+	fileManager file.Storage
 	block       *file.Block
 	page        *file.Page
 	currentPos  int32
+	lsn         int32 // LSN of the record returned by Next
+	nextLSN     int32 // LSN of the record Next returns
 }
 
 // NewIterator creates a new iterator for the log records in a file, starting
 // from a specific block. The iterator is positioned at the most recent log record
 // in that block.
-func NewIterator(fileManager *file.Manager, block *file.Block) (*Iterator, error) {
+func NewIterator(fileManager file.Storage, block *file.Block) (*Iterator, error) {
 	page := file.NewPage(fileManager.BlockSize())
 
 	i := &Iterator{
@@ -58,9 +60,16 @@ func (i *Iterator) Next() ([]byte, error) {
 	}
 
 	i.currentPos += int32(len(log)) + 4
+	i.lsn = i.nextLSN
+	i.nextLSN--
 	return log, nil
 }
 
+// LSN returns the LSN of the record most recently returned by Next.
+func (i *Iterator) LSN() int32 {
+	return i.lsn
+}
+
 // moveToBlock loads the contents of a specified block into the iterator's page
 // and positions the iterator at the first log record in that block. The log
 // records are stored from the end of the block, and the boundary of the used
@@ -71,11 +80,6 @@ func (i *Iterator) moveToBlock(block *file.Block) error {
 		return err
 	}
 
-	boundary, err := i.page.ReadInt32At(0)
-	if err != nil {
-		return err
-	}
-	i.currentPos = boundary
-
-	return nil
+	i.currentPos, i.nextLSN, err = readHeader(i.page)
+	return err
 }
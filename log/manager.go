@@ -1,14 +1,23 @@
 package log
 
 import (
+	"errors"
 	"sync"
 
 	"simpledb/file"
 )
 
+// Manager appends records to the log file. Each block of the log starts
+// with a header holding the boundary of its used space, the LSN of its most
+// recent record and the version of the log format; records are stored from
+// the end of the block towards the header.
+//
+// An LSN is the position of a record in the log, counting from 1. Since the
+// LSN of the most recent record is kept in the log, LSNs keep increasing
+// across restarts, and identify records durably.
 type Manager struct {
 	mu           sync.Mutex
-	fileManager  *file.Manager
+	fileManager  file.Storage
 	logFile      string
 	logPage      *file.Page
 	currentBlock *file.Block
@@ -16,12 +25,28 @@ type Manager struct {
 	lastSavedLSN int32
 }
 
+// The header of a log block.
+const (
+	lsnOffset     = 4 // the LSN of the block's most recent record follows the boundary
+	versionOffset = 8
+	headerSize    = 12
+)
+
+// version identifies the format of the log. Logs written before LSNs were
+// kept in the header have a 4-byte header and no version, and cannot be
+// read, as the positions of their records cannot be told apart from LSNs.
+const version int32 = 0x5344_4c02
+
+// ErrLogFormat is returned when reading a log written in another format,
+// such as one written before the version was added to the header.
+var ErrLogFormat = errors.New("log: log file was written in an unsupported format")
+
 // NewManager creates a new log manager for a given log file.
 // If the log file does not exist, it creates a new one with a single, empty block.
 // If the log file exists, it reads the last block of the file into its internal
 // log page. This setup allows new log records to be appended to the end of the
 // existing log.
-func NewManager(fileManager *file.Manager, logFile string) (*Manager, error) {
+func NewManager(fileManager file.Storage, logFile string) (*Manager, error) {
 	logPage := file.NewPage(fileManager.BlockSize())
 	logSize, err := fileManager.Size(logFile)
 	if err != nil {
@@ -30,7 +55,7 @@ func NewManager(fileManager *file.Manager, logFile string) (*Manager, error) {
 
 	var currentBlock *file.Block
 	if logSize == 0 {
-		currentBlock, err = appendNewBlock(fileManager, logFile, logPage)
+		currentBlock, err = appendNewBlock(fileManager, logFile, logPage, 0)
 		if err != nil {
 			return nil, err
 		}
@@ -42,20 +67,28 @@ func NewManager(fileManager *file.Manager, logFile string) (*Manager, error) {
 		}
 	}
 
+	_, latestLSN, err := readHeader(logPage)
+	if err != nil {
+		return nil, err
+	}
+
 	return &Manager{
 		mu:           sync.Mutex{},
 		fileManager:  fileManager,
 		logFile:      logFile,
 		logPage:      logPage,
 		currentBlock: currentBlock,
-		latestLSN:    0,
-		lastSavedLSN: 0,
+		latestLSN:    latestLSN,
+		lastSavedLSN: latestLSN,
 	}, nil
 }
 
 // Flush ensures that all log records with LSN values less than or equal to the
 // specified LSN have been written to disk.
 func (m *Manager) Flush(lsn int32) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
 	if lsn >= m.lastSavedLSN {
 		return m.flush()
 	}
@@ -65,6 +98,9 @@ func (m *Manager) Flush(lsn int32) error {
 // Iterator returns a log iterator starting from the most recent log record.
 // It ensures all current logs are flushed to disk before creating the iterator.
 func (m *Manager) Iterator() (*Iterator, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
 	err := m.flush()
 	if err != nil {
 		return nil, err
@@ -73,6 +109,20 @@ func (m *Manager) Iterator() (*Iterator, error) {
 	return NewIterator(m.fileManager, m.currentBlock)
 }
 
+// LatestLSN returns the LSN of the most recent record, or 0 if the log is
+// empty. Records appended from now on get greater LSNs.
+func (m *Manager) LatestLSN() int32 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.latestLSN
+}
+
+// Filename returns the name of the log file.
+func (m *Manager) Filename() string {
+	return m.logFile
+}
+
 // Append adds a new log record to the log file and returns its assigned LSN.
 // It handles block switching if the log record doesn't fit in the current block
 // and ensures proper synchronization for concurrent access.
@@ -86,14 +136,14 @@ func (m *Manager) Append(log []byte) (int32, error) {
 	}
 
 	needBytes := int32(len(log)) + 4
-	if boundary-needBytes < 4 {
+	if boundary-needBytes < headerSize {
 		// It doesn't fit, so move to the next block.
 		err := m.flush()
 		if err != nil {
 			return 0, err
 		}
 
-		m.currentBlock, err = appendNewBlock(m.fileManager, m.logFile, m.logPage)
+		m.currentBlock, err = appendNewBlock(m.fileManager, m.logFile, m.logPage, m.latestLSN)
 		if err != nil {
 			return 0, err
 		}
@@ -117,19 +167,32 @@ func (m *Manager) Append(log []byte) (int32, error) {
 
 	m.latestLSN += 1
 
+	err = m.logPage.WriteInt32At(lsnOffset, m.latestLSN)
+	if err != nil {
+		return 0, err
+	}
+
 	return m.latestLSN, nil
 }
 
+// flush writes the log page to disk.
+// This method must be called with the mutex lock already held.
 func (m *Manager) flush() error {
 	err := m.fileManager.Write(m.currentBlock, m.logPage)
 	if err != nil {
 		return err
 	}
+	err = m.fileManager.Sync(m.logFile)
+	if err != nil {
+		return err
+	}
 	m.lastSavedLSN = m.latestLSN
 	return nil
 }
 
-func appendNewBlock(fileManager *file.Manager, logFile string, logPage *file.Page) (*file.Block, error) {
+// appendNewBlock appends an empty block to the log, whose header carries on
+// the LSN of the most recent record.
+func appendNewBlock(fileManager file.Storage, logFile string, logPage *file.Page, latestLSN int32) (*file.Block, error) {
 	block, err := fileManager.Append(logFile)
 	if err != nil {
 		return nil, err
@@ -140,6 +203,16 @@ func appendNewBlock(fileManager *file.Manager, logFile string, logPage *file.Pag
 		return nil, err
 	}
 
+	err = logPage.WriteInt32At(lsnOffset, latestLSN)
+	if err != nil {
+		return nil, err
+	}
+
+	err = logPage.WriteInt32At(versionOffset, version)
+	if err != nil {
+		return nil, err
+	}
+
 	err = fileManager.Write(block, logPage)
 	if err != nil {
 		return nil, err
@@ -147,3 +220,59 @@ func appendNewBlock(fileManager *file.Manager, logFile string, logPage *file.Pag
 
 	return block, nil
 }
+
+// readHeader returns the boundary and the LSN held in the header of a log
+// block, or ErrLogFormat if the block is not in the format of this version.
+func readHeader(page *file.Page) (int32, int32, error) {
+	v, err := page.ReadInt32At(versionOffset)
+	if err != nil {
+		return 0, 0, err
+	}
+	if v != version {
+		return 0, 0, ErrLogFormat
+	}
+	boundary, err := page.ReadInt32At(0)
+	if err != nil {
+		return 0, 0, err
+	}
+	lsn, err := page.ReadInt32At(lsnOffset)
+	if err != nil {
+		return 0, 0, err
+	}
+	return boundary, lsn, nil
+}
+
+// Truncate cuts the log after the record with the specified LSN, so that it
+// becomes the most recent record. The log must not be in use by a Manager.
+func Truncate(fileManager file.Storage, logFile string, lsn int32) error {
+	size, err := fileManager.Size(logFile)
+	if err != nil || size == 0 {
+		return err
+	}
+
+	iter, err := NewIterator(fileManager, file.NewBlock(logFile, size-1))
+	if err != nil {
+		return err
+	}
+	for iter.HasNext() && iter.nextLSN > lsn {
+		if _, err := iter.Next(); err != nil {
+			return err
+		}
+	}
+
+	// The iterator is positioned at the most recent record to keep, or at the
+	// end of the block it is in.
+	if err := iter.page.WriteInt32At(0, iter.currentPos); err != nil {
+		return err
+	}
+	if err := iter.page.WriteInt32At(lsnOffset, min(lsn, iter.nextLSN)); err != nil {
+		return err
+	}
+	if err := fileManager.Write(iter.block, iter.page); err != nil {
+		return err
+	}
+	if err := fileManager.Truncate(logFile, iter.block.Number()+1); err != nil {
+		return err
+	}
+	return fileManager.Sync(logFile)
+}
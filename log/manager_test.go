@@ -1,6 +1,9 @@
 package log
 
 import (
+	"errors"
+	"fmt"
+	"slices"
 	"testing"
 
 	"simpledb/file"
@@ -55,6 +58,7 @@ func TestNewManager(t *testing.T) {
 		// Set the boundary to a custom value to verify it's read correctly.
 		const boundaryOffset = 120
 		page.WriteInt32At(0, boundaryOffset)
+		page.WriteInt32At(versionOffset, version)
 		page.WriteStringAt(boundaryOffset, "some old log data")
 
 		// Write this page to the first block of the log file
@@ -83,6 +87,26 @@ func TestNewManager(t *testing.T) {
 			t.Errorf("expected loaded boundary to be %d, got %d", boundaryOffset, boundary)
 		}
 	})
+
+	t.Run("with a log file in an older format", func(t *testing.T) {
+		fileManager, _, _ := setup(t, blockSize)
+
+		// Before the LSN and the version were added to the header, a block
+		// started with its boundary alone.
+		page := file.NewPage(blockSize)
+		page.WriteInt32At(0, blockSize-24)
+		page.WriteStringAt(blockSize-24, "some old log data")
+		if err := fileManager.Write(file.NewBlock(logFile, 0), page); err != nil {
+			t.Fatalf("failed to write initial log block: %v", err)
+		}
+
+		if _, err := NewManager(fileManager, logFile); !errors.Is(err, ErrLogFormat) {
+			t.Errorf("NewManager() got error %v, want %v", err, ErrLogFormat)
+		}
+		if _, err := NewIterator(fileManager, file.NewBlock(logFile, 0)); !errors.Is(err, ErrLogFormat) {
+			t.Errorf("NewIterator() got error %v, want %v", err, ErrLogFormat)
+		}
+	})
 }
 
 func TestLogManager_Append(t *testing.T) {
@@ -151,6 +175,11 @@ func TestLogManager_Append(t *testing.T) {
 		if size != 2 {
 			t.Errorf("log file should have 2 blocks after overflow, but has %d", size)
 		}
+
+		// The LSNs continue in the new block.
+		if got := logManager.LatestLSN(); got != 2 {
+			t.Errorf("LatestLSN() = %d, want 2", got)
+		}
 	})
 }
 
@@ -238,3 +267,140 @@ func TestLogManager_Iterator(t *testing.T) {
 		}
 	})
 }
+
+func TestLogManager_LSN(t *testing.T) {
+	const blockSize = 100
+	fileManager, logManager, logFile := setup(t, blockSize)
+
+	// Enough records to span several blocks.
+	for i := range 10 {
+		if _, err := logManager.Append([]byte(fmt.Sprintf("log record %d", i))); err != nil {
+			t.Fatalf("failed to append log: %v", err)
+		}
+	}
+	if err := logManager.Flush(logManager.LatestLSN()); err != nil {
+		t.Fatalf("failed to flush: %v", err)
+	}
+
+	t.Run("LSNs continue after a restart", func(t *testing.T) {
+		reopened, err := NewManager(fileManager, logFile)
+		if err != nil {
+			t.Fatalf("NewManager() failed: %v", err)
+		}
+		if got := reopened.LatestLSN(); got != 10 {
+			t.Errorf("LatestLSN() = %d, want 10", got)
+		}
+	})
+
+	t.Run("Iterator reports the LSN of each record", func(t *testing.T) {
+		iter, err := logManager.Iterator()
+		if err != nil {
+			t.Fatalf("failed to create iterator: %v", err)
+		}
+		for want := int32(10); iter.HasNext(); want-- {
+			record, err := iter.Next()
+			if err != nil {
+				t.Fatalf("Next() failed: %v", err)
+			}
+			if iter.LSN() != want {
+				t.Errorf("LSN() = %d, want %d", iter.LSN(), want)
+			}
+			if got, want := string(record), fmt.Sprintf("log record %d", want-1); got != want {
+				t.Errorf("record %d = %q, want %q", iter.LSN(), got, want)
+			}
+		}
+	})
+
+	t.Run("Truncate cuts the log after a record", func(t *testing.T) {
+		if err := Truncate(fileManager, logFile, 4); err != nil {
+			t.Fatalf("Truncate() failed: %v", err)
+		}
+
+		truncated, err := NewManager(fileManager, logFile)
+		if err != nil {
+			t.Fatalf("NewManager() failed: %v", err)
+		}
+		if got := truncated.LatestLSN(); got != 4 {
+			t.Errorf("LatestLSN() after Truncate() = %d, want 4", got)
+		}
+
+		lsn, err := truncated.Append([]byte("new log record"))
+		if err != nil {
+			t.Fatalf("failed to append log: %v", err)
+		}
+		if lsn != 5 {
+			t.Errorf("LSN after Truncate() = %d, want 5", lsn)
+		}
+
+		iter, err := truncated.Iterator()
+		if err != nil {
+			t.Fatalf("failed to create iterator: %v", err)
+		}
+		var records []string
+		for iter.HasNext() {
+			record, err := iter.Next()
+			if err != nil {
+				t.Fatalf("Next() failed: %v", err)
+			}
+			records = append(records, string(record))
+		}
+		want := []string{"new log record", "log record 3", "log record 2", "log record 1", "log record 0"}
+		if !slices.Equal(records, want) {
+			t.Errorf("records after Truncate() = %q, want %q", records, want)
+		}
+	})
+}
+
+func TestManager_RecordsAfter(t *testing.T) {
+	_, lm, _ := setup(t, 100)
+
+	appendRecords := func(from int, to int) {
+		t.Helper()
+		for n := from; n <= to; n++ {
+			if _, err := lm.Append([]byte(fmt.Sprintf("rec%d", n))); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	wantRecords := func(from int, to int) []string {
+		var want []string
+		for n := from; n <= to; n++ {
+			want = append(want, fmt.Sprintf("rec%d", n))
+		}
+		return want
+	}
+	read := func(c *cursor) []string {
+		t.Helper()
+		records, err := lm.recordsAfter(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, record := range records {
+			got = append(got, string(record))
+		}
+		return got
+	}
+
+	appendRecords(1, 5)
+	c := &cursor{lsn: 2, block: -1}
+	if got, want := read(c), wantRecords(3, 5); !slices.Equal(got, want) {
+		t.Fatalf("first read: got %v, want %v", got, want)
+	}
+	if got := read(c); got != nil {
+		t.Errorf("read without new records: got %v, want none", got)
+	}
+
+	// Later reads start from the cursor, also across blocks.
+	appendRecords(6, 40)
+	if got, want := read(c), wantRecords(6, 40); !slices.Equal(got, want) {
+		t.Errorf("second read: got %v, want %v", got, want)
+	}
+	appendRecords(41, 41)
+	if got, want := read(c), wantRecords(41, 41); !slices.Equal(got, want) {
+		t.Errorf("third read: got %v, want %v", got, want)
+	}
+	if c.lsn != 41 {
+		t.Errorf("cursor at LSN %d, want 41", c.lsn)
+	}
+}
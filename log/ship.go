@@ -0,0 +1,158 @@
+package log
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"slices"
+	"time"
+
+	"simpledb/file"
+)
+
+// Ship sends the log records after the specified LSN to w, oldest first,
+// and then keeps sending the records appended later, checking for them at
+// every interval. Each record is sent as its LSN and its length, both as
+// big-endian int32s, followed by its bytes, and can be read back with
+// Receive. Shipping forces the records it sends to disk, so that a replica
+// never gets ahead of the log.
+//
+// Ship only returns when sending fails, as when the replica disconnects.
+func (m *Manager) Ship(w io.Writer, lsn int32, interval time.Duration) error {
+	bw := bufio.NewWriter(w)
+	c := &cursor{lsn: lsn, block: -1}
+	for {
+		records, err := m.recordsAfter(c)
+		if err != nil {
+			return err
+		}
+
+		for _, record := range records {
+			lsn++
+			var header [8]byte
+			binary.BigEndian.PutUint32(header[0:], uint32(lsn))
+			binary.BigEndian.PutUint32(header[4:], uint32(len(record)))
+			if _, err := bw.Write(header[:]); err != nil {
+				return err
+			}
+			if _, err := bw.Write(record); err != nil {
+				return err
+			}
+		}
+		if err := bw.Flush(); err != nil {
+			return err
+		}
+
+		time.Sleep(interval)
+	}
+}
+
+// Receive reads a log record sent by Ship, and returns its LSN.
+func Receive(r io.Reader) (int32, []byte, error) {
+	var header [8]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, nil, err
+	}
+
+	record := make([]byte, binary.BigEndian.Uint32(header[4:]))
+	if _, err := io.ReadFull(r, record); err != nil {
+		return 0, nil, err
+	}
+	return int32(binary.BigEndian.Uint32(header[0:])), record, nil
+}
+
+// cursor is the position in the log of the last record shipped, so that
+// the records appended since can be read without going through the log from
+// its end.
+type cursor struct {
+	lsn   int32 // LSN of the last record shipped
+	block int32 // block holding it, or -1 if it is not located yet
+	pos   int32 // position of the record in the block
+}
+
+// recordsAfter returns the records after the cursor, oldest first, and
+// moves the cursor to the last of them. The first call locates the records
+// by going back from the end of the log; later ones read the blocks from
+// the cursor on.
+func (m *Manager) recordsAfter(c *cursor) ([][]byte, error) {
+	if m.LatestLSN() <= c.lsn {
+		return nil, nil
+	}
+	if c.block < 0 {
+		return m.locate(c)
+	}
+
+	if err := m.Flush(m.LatestLSN()); err != nil {
+		return nil, err
+	}
+	size, err := m.fileManager.Size(m.logFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var records [][]byte
+	page := file.NewPage(m.fileManager.BlockSize())
+	for blockNum := c.block; blockNum < size; blockNum++ {
+		if err := m.fileManager.Read(file.NewBlock(m.logFile, blockNum), page); err != nil {
+			return nil, err
+		}
+		boundary, lsn, err := readHeader(page)
+		if err != nil {
+			return nil, err
+		}
+
+		// The records of a block are stored newest first from its boundary,
+		// and those of the cursor's block after it are before its position.
+		end := m.fileManager.BlockSize()
+		if blockNum == c.block {
+			end = c.pos
+		}
+		var newer [][]byte
+		for pos := boundary; pos < end; {
+			record, err := page.ReadBytesAt(pos)
+			if err != nil {
+				return nil, err
+			}
+			newer = append(newer, record)
+			pos += int32(len(record)) + 4
+		}
+		slices.Reverse(newer)
+		records = append(records, newer...)
+
+		c.block, c.pos = blockNum, boundary
+		if boundary < m.fileManager.BlockSize() {
+			c.lsn = lsn
+		}
+	}
+	return records, nil
+}
+
+// locate returns the records after the cursor's LSN, oldest first, going
+// back from the end of the log, and moves the cursor to the last of them.
+func (m *Manager) locate(c *cursor) ([][]byte, error) {
+	iter, err := m.Iterator()
+	if err != nil {
+		return nil, err
+	}
+
+	var records [][]byte
+	for iter.HasNext() {
+		record, err := iter.Next()
+		if err != nil {
+			return nil, err
+		}
+		if iter.LSN() <= c.lsn {
+			break
+		}
+		if records == nil {
+			c.block = iter.block.Number()
+			c.pos = iter.currentPos - int32(len(record)) - 4
+		}
+		records = append(records, record)
+	}
+	if records != nil {
+		c.lsn += int32(len(records))
+	}
+	slices.Reverse(records)
+	return records, nil
+}
@@ -6,13 +6,9 @@ import (
 	"simpledb/file"
 )
 
-func setup(t *testing.T, blockSize int32) (*file.Manager, *Manager, string) {
+func setup(t *testing.T, blockSize int32) (*file.MemoryStorage, *Manager, string) {
 	t.Helper()
-	dir := t.TempDir()
-	fm, err := file.NewManager(dir, blockSize)
-	if err != nil {
-		t.Fatalf("failed to create file manager: %v", err)
-	}
+	fm := file.NewMemoryStorage(blockSize)
 	logFile := "testlogfile"
 	lm, err := NewManager(fm, logFile)
 	if err != nil {
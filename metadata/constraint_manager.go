@@ -0,0 +1,370 @@
+package metadata
+
+import (
+	"errors"
+
+	"simpledb/query"
+	"simpledb/record"
+	"simpledb/transaction"
+)
+
+var (
+	// ErrConstraintNotFound is returned when dropping a constraint that is
+	// not in the catalog.
+	ErrConstraintNotFound = errors.New("metadata: constraint not found")
+	// ErrPrimaryKeyExists is returned when adding a primary key to a table
+	// that already has one.
+	ErrPrimaryKeyExists = errors.New("metadata: table already has a primary key")
+	// ErrNullableKey is returned when adding a primary key on a field that
+	// can hold NULL.
+	ErrNullableKey = errors.New("metadata: primary key field is nullable")
+	// ErrConstraintIndex is returned when dropping an index that backs a
+	// constraint, which must be dropped instead.
+	ErrConstraintIndex = errors.New("metadata: index backs a constraint")
+	// ErrConstraintExists is returned when adding a constraint with the name
+	// of an existing one.
+	ErrConstraintExists = errors.New("metadata: constraint already exists")
+	// ErrNotKey is returned when adding a foreign key that references a
+	// field which is neither a primary key nor unique.
+	ErrNotKey = errors.New("metadata: referenced field is not a key")
+	// ErrKeyType is returned when adding a foreign key on a field whose type
+	// differs from that of the referenced key.
+	ErrKeyType = errors.New("metadata: foreign key and referenced key differ in type")
+	// ErrReferenced is returned when dropping a table or key that a foreign
+	// key references, without dropping the foreign key too.
+	ErrReferenced = errors.New("metadata: key is referenced by a foreign key")
+)
+
+// ConstraintKind tells what a constraint requires of the records of its
+// table.
+type ConstraintKind int32
+
+const (
+	// PrimaryKey requires the field to identify the records: no two
+	// records may hold the same value, and the field cannot be NULL.
+	PrimaryKey ConstraintKind = iota
+	// Unique requires that no two records hold the same non-NULL value of
+	// the field.
+	Unique
+	// Check requires that a predicate over the fields of a record is not
+	// false, as it is not when a field it compares is NULL.
+	Check
+	// ForeignKey requires every non-NULL value of the field to be held by a
+	// record of the referenced table, in the referenced key.
+	ForeignKey
+)
+
+// ReferentialAction tells what deleting a record does to the records whose
+// foreign key refers to it.
+type ReferentialAction int32
+
+const (
+	// Restrict rejects the deletion.
+	Restrict ReferentialAction = iota
+	// Cascade deletes the referring records too.
+	Cascade
+	// SetNull sets the foreign key of the referring records to NULL.
+	SetNull
+)
+
+type ConstraintInfo struct {
+	name      string
+	tableName string
+	fieldName string
+	kind      ConstraintKind
+	// check is the predicate of a Check constraint.
+	check *query.Predicate
+	// refTable and refField are the key a foreign key references, and
+	// onDelete what deleting a record of refTable does to the records
+	// referring to it.
+	refTable string
+	refField string
+	onDelete ReferentialAction
+}
+
+func (ci ConstraintInfo) Name() string {
+	return ci.name
+}
+
+// FieldName returns the field of a primary key or unique constraint, or ""
+// for a Check constraint.
+func (ci ConstraintInfo) FieldName() string {
+	return ci.fieldName
+}
+
+func (ci ConstraintInfo) Kind() ConstraintKind {
+	return ci.kind
+}
+
+// Predicate returns the predicate of a Check constraint, or nil for other
+// constraints.
+func (ci ConstraintInfo) Predicate() *query.Predicate {
+	return ci.check
+}
+
+// ReferencedTable returns the table a foreign key references, or "" for
+// other constraints.
+func (ci ConstraintInfo) ReferencedTable() string {
+	return ci.refTable
+}
+
+// ReferencedField returns the key a foreign key references, or "" for other
+// constraints.
+func (ci ConstraintInfo) ReferencedField() string {
+	return ci.refField
+}
+
+// OnDelete returns what deleting a referenced record does to the records
+// whose foreign key refers to it.
+func (ci ConstraintInfo) OnDelete() ReferentialAction {
+	return ci.onDelete
+}
+
+// predicateCheck is a Check constraint, as evaluated by table scans.
+type predicateCheck struct {
+	pred *query.Predicate
+}
+
+func (pc predicateCheck) IsViolated(ts *record.TableScan) bool {
+	return pc.pred.Evaluate(ts) == query.False
+}
+
+// ConstraintManager keeps the constraints of the tables in the concat
+// catalog table. Primary key and unique constraints are enforced by a
+// unique index named after the constraint, and Check constraints by the
+// scans that write the table, which keep the predicate in the catalog as
+// Predicate.String formats it.
+type ConstraintManager struct {
+	tableManager *TableManager
+}
+
+func NewConstraintManager(isNew bool, tableManager *TableManager, tx *transaction.Transaction) *ConstraintManager {
+	if isNew {
+		schema := record.NewSchema()
+		schema.AddStringField("consname", maxName)
+		schema.AddStringField("tblname", maxName)
+		schema.AddStringField("fldname", maxName)
+		schema.AddIntField("kind")
+		schema.AddField("expr", record.Text, maxName, true)
+		schema.AddStringField("reftable", maxName)
+		schema.AddStringField("reffield", maxName)
+		schema.AddIntField("ondelete")
+		tableManager.CreateTable("concat", schema, tx)
+	}
+	return &ConstraintManager{tableManager: tableManager}
+}
+
+// addConstraint saves the constraint in the catalog.
+func (cm *ConstraintManager) addConstraint(info ConstraintInfo, tx *transaction.Transaction) error {
+	layout, err := cm.tableManager.GetLayout("concat", tx)
+	if err != nil {
+		return err
+	}
+	tableScan, err := record.NewTableScan(tx, "concat", layout)
+	if err != nil {
+		return err
+	}
+	defer tableScan.Close()
+	if err := tableScan.Insert(); err != nil {
+		return err
+	}
+	if err := tableScan.WriteString("consname", info.name); err != nil {
+		return err
+	}
+	if err := tableScan.WriteString("tblname", info.tableName); err != nil {
+		return err
+	}
+	if err := tableScan.WriteString("fldname", info.fieldName); err != nil {
+		return err
+	}
+	if err := tableScan.WriteInt32("kind", int32(info.kind)); err != nil {
+		return err
+	}
+	if err := tableScan.WriteString("reftable", info.refTable); err != nil {
+		return err
+	}
+	if err := tableScan.WriteString("reffield", info.refField); err != nil {
+		return err
+	}
+	if err := tableScan.WriteInt32("ondelete", int32(info.onDelete)); err != nil {
+		return err
+	}
+	if info.check == nil {
+		return nil
+	}
+	return tableScan.WriteString("expr", info.check.String())
+}
+
+// GetConstraints returns the constraints of the table.
+func (cm *ConstraintManager) GetConstraints(tableName string, tx *transaction.Transaction) ([]ConstraintInfo, error) {
+	return cm.constraints(func(info ConstraintInfo) bool { return info.tableName == tableName }, tx)
+}
+
+// constraint returns the constraint with the name, and whether there is
+// one.
+func (cm *ConstraintManager) constraint(name string, tx *transaction.Transaction) (ConstraintInfo, bool, error) {
+	constraints, err := cm.constraints(func(info ConstraintInfo) bool { return info.name == name }, tx)
+	if err != nil || len(constraints) == 0 {
+		return ConstraintInfo{}, false, err
+	}
+	return constraints[0], true, nil
+}
+
+// constraints returns the constraints that match.
+func (cm *ConstraintManager) constraints(match func(info ConstraintInfo) bool, tx *transaction.Transaction) ([]ConstraintInfo, error) {
+	layout, err := cm.tableManager.GetLayout("concat", tx)
+	if err != nil {
+		return nil, err
+	}
+	tableScan, err := record.NewTableScan(tx, "concat", layout)
+	if err != nil {
+		return nil, err
+	}
+	defer tableScan.Close()
+
+	var res []ConstraintInfo
+	for tableScan.Next() {
+		var info ConstraintInfo
+		if info.name, err = tableScan.ReadString("consname"); err != nil {
+			return nil, err
+		}
+		if info.tableName, err = tableScan.ReadString("tblname"); err != nil {
+			return nil, err
+		}
+		if info.fieldName, err = tableScan.ReadString("fldname"); err != nil {
+			return nil, err
+		}
+		kind, err := tableScan.ReadInt32("kind")
+		if err != nil {
+			return nil, err
+		}
+		info.kind = ConstraintKind(kind)
+		if info.refTable, err = tableScan.ReadString("reftable"); err != nil {
+			return nil, err
+		}
+		if info.refField, err = tableScan.ReadString("reffield"); err != nil {
+			return nil, err
+		}
+		onDelete, err := tableScan.ReadInt32("ondelete")
+		if err != nil {
+			return nil, err
+		}
+		info.onDelete = ReferentialAction(onDelete)
+		if info.kind == Check {
+			expr, err := tableScan.ReadString("expr")
+			if err != nil {
+				return nil, err
+			}
+			if info.check, err = query.ParsePredicate(expr); err != nil {
+				return nil, err
+			}
+		}
+		if match(info) {
+			res = append(res, info)
+		}
+	}
+	return res, nil
+}
+
+// dropConstraint removes the constraint from the catalog.
+func (cm *ConstraintManager) dropConstraint(name string, tx *transaction.Transaction) error {
+	layout, err := cm.tableManager.GetLayout("concat", tx)
+	if err != nil {
+		return err
+	}
+	n, err := deleteRows("concat", layout, "consname", name, tx)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrConstraintNotFound
+	}
+	return nil
+}
+
+// dropConstraints removes the constraints of the table from the catalog.
+func (cm *ConstraintManager) dropConstraints(tableName string, tx *transaction.Transaction) error {
+	layout, err := cm.tableManager.GetLayout("concat", tx)
+	if err != nil {
+		return err
+	}
+	_, err = deleteRows("concat", layout, "tblname", tableName, tx)
+	return err
+}
+
+// attachChecks makes the table scan enforce the Check constraints of the
+// table.
+func (cm *ConstraintManager) attachChecks(tableName string, tableScan *record.TableScan, tx *transaction.Transaction) error {
+	constraints, err := cm.GetConstraints(tableName, tx)
+	if err != nil {
+		return err
+	}
+	for _, info := range constraints {
+		if info.kind == Check {
+			tableScan.AddCheck(info.name, predicateCheck{pred: info.check})
+		}
+	}
+	return nil
+}
+
+// renameField makes the constraints on a renamed field of the table, the
+// predicates of its Check constraints, and the foreign keys referencing the
+// field, refer to its new name.
+func (cm *ConstraintManager) renameField(tableName string, oldName string, newName string, tx *transaction.Transaction) error {
+	layout, err := cm.tableManager.GetLayout("concat", tx)
+	if err != nil {
+		return err
+	}
+	tableScan, err := record.NewTableScan(tx, "concat", layout)
+	if err != nil {
+		return err
+	}
+	defer tableScan.Close()
+	for tableScan.Next() {
+		refTable, err := tableScan.ReadString("reftable")
+		if err != nil {
+			return err
+		}
+		refField, err := tableScan.ReadString("reffield")
+		if err != nil {
+			return err
+		}
+		if refTable == tableName && refField == oldName {
+			if err := tableScan.WriteString("reffield", newName); err != nil {
+				return err
+			}
+		}
+
+		name, err := tableScan.ReadString("tblname")
+		if err != nil {
+			return err
+		}
+		if name != tableName {
+			continue
+		}
+		field, err := tableScan.ReadString("fldname")
+		if err != nil {
+			return err
+		}
+		if field == oldName {
+			if err := tableScan.WriteString("fldname", newName); err != nil {
+				return err
+			}
+		}
+		expr, err := tableScan.ReadValue("expr")
+		if err != nil {
+			return err
+		}
+		if expr == nil {
+			continue
+		}
+		pred, err := query.ParsePredicate(expr.(string))
+		if err != nil {
+			return err
+		}
+		if err := tableScan.WriteString("expr", pred.RenameField(oldName, newName).String()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
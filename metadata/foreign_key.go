@@ -0,0 +1,315 @@
+package metadata
+
+import (
+	"simpledb/query"
+	"simpledb/record"
+	"simpledb/transaction"
+)
+
+// AddForeignKey adds a foreign key constraint on the field of the table,
+// which references the field of the referenced table. The referenced field
+// must be the primary key of its table or unique, and of the same type,
+// and its index is searched for the value of each record written. Deleting
+// a referenced record does as onDelete tells to the records referring to
+// it, in the same transaction, and a referenced key cannot be updated. The
+// records referring to a key are found by scanning the whole referring
+// table, so deleting a referenced record, or writing its key, costs a scan
+// of each table that refers to it.
+//
+// AddForeignKey fails with ErrNotKey if the referenced field is not a key,
+// with ErrKeyType if the fields differ in type, with record.ErrNotNullable
+// if onDelete is SetNull and the field cannot hold NULL, and with a
+// record.ConstraintViolationError if a record already refers to a missing
+// key.
+func (mm *MetadataManager) AddForeignKey(constraintName string, tableName string, fieldName string, refTable string, refField string, onDelete ReferentialAction, tx *transaction.Transaction) error {
+	info := ConstraintInfo{
+		name:      constraintName,
+		tableName: tableName,
+		fieldName: fieldName,
+		kind:      ForeignKey,
+		refTable:  refTable,
+		refField:  refField,
+		onDelete:  onDelete,
+	}
+	layout, err := mm.newConstraintTable(info, tx)
+	if err != nil {
+		return err
+	}
+	refLayout, err := mm.tableManager.existingLayout(refTable, tx)
+	if err != nil {
+		return err
+	}
+	if !layout.Schema().HasField(fieldName) || !refLayout.Schema().HasField(refField) {
+		return ErrFieldNotFound
+	}
+	if layout.Schema().FieldType(fieldName) != refLayout.Schema().FieldType(refField) {
+		return ErrKeyType
+	}
+	if onDelete == SetNull && !layout.Schema().IsNullable(fieldName) {
+		return record.ErrNotNullable
+	}
+	check, err := mm.foreignKeyCheck(info, tx)
+	if err != nil {
+		return err
+	}
+
+	tableScan, err := record.NewTableScan(tx, tableName, layout)
+	if err != nil {
+		return err
+	}
+	defer tableScan.Close()
+	for tableScan.Next() {
+		if check.IsViolated(tableScan) {
+			return &record.ConstraintViolationError{Constraint: constraintName}
+		}
+	}
+	return mm.constraintManager.addConstraint(info, tx)
+}
+
+// foreignKeyCheck returns the check of the foreign key, which searches the
+// index of the referenced key. It fails with ErrNotKey if the referenced
+// field is not a key.
+func (mm *MetadataManager) foreignKeyCheck(info ConstraintInfo, tx *transaction.Transaction) (*foreignKeyCheck, error) {
+	keys, err := mm.constraintManager.constraints(func(key ConstraintInfo) bool {
+		return key.tableName == info.refTable && key.fieldName == info.refField && (key.kind == PrimaryKey || key.kind == Unique)
+	}, tx)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, ErrNotKey
+	}
+	indexes, err := mm.indexManager.indexes(func(indexName string, _ string, _ string) bool {
+		return indexName == keys[0].name
+	}, tx)
+	if err != nil {
+		return nil, err
+	}
+	if len(indexes) == 0 {
+		return nil, ErrNotKey
+	}
+	return &foreignKeyCheck{fieldName: info.fieldName, key: indexes[0]}, nil
+}
+
+// attachForeignKeys makes the table scan enforce the foreign keys of the
+// table, and act on the records referring to the records of the table.
+func (mm *MetadataManager) attachForeignKeys(tableName string, tableScan *record.TableScan, deleting *deletions, tx *transaction.Transaction) error {
+	foreignKeys, err := mm.constraintManager.constraints(func(info ConstraintInfo) bool {
+		return info.kind == ForeignKey && (info.tableName == tableName || info.refTable == tableName)
+	}, tx)
+	if err != nil {
+		return err
+	}
+
+	referring := make(map[string][]ConstraintInfo)
+	var refFields []string
+	for _, info := range foreignKeys {
+		if info.tableName == tableName {
+			check, err := mm.foreignKeyCheck(info, tx)
+			if err != nil {
+				return err
+			}
+			tableScan.AddFieldCheck(info.name, info.fieldName, check)
+		}
+		if info.refTable == tableName {
+			if _, ok := referring[info.refField]; !ok {
+				refFields = append(refFields, info.refField)
+			}
+			referring[info.refField] = append(referring[info.refField], info)
+		}
+	}
+	for _, refField := range refFields {
+		tableScan.AddReferencedKey(refField, &referencedKey{
+			mm:          mm,
+			tableName:   tableName,
+			fieldName:   refField,
+			foreignKeys: referring[refField],
+			deleting:    deleting,
+			tx:          tx,
+		})
+	}
+	return nil
+}
+
+// foreignKeyCheck is a foreign key, as evaluated by the scans of the
+// referring table. A record violates it if the value of its field is not
+// in the index of the referenced key, which is also taken as a violation
+// if the index cannot be searched.
+type foreignKeyCheck struct {
+	fieldName string
+	key       *IndexInfo
+}
+
+func (fc *foreignKeyCheck) IsViolated(ts *record.TableScan) bool {
+	value, err := ts.ReadValue(fc.fieldName)
+	if err != nil {
+		return true
+	}
+	if value == nil {
+		return false
+	}
+	index := fc.key.Open()
+	defer index.Close()
+	if err := index.BeforeFirst(value); err != nil {
+		return true
+	}
+	return !index.Next()
+}
+
+// referencedKey is a key that foreign keys reference, as seen by the scans
+// of its table.
+type referencedKey struct {
+	mm          *MetadataManager
+	tableName   string
+	fieldName   string
+	foreignKeys []ConstraintInfo
+	deleting    *deletions
+	tx          *transaction.Transaction
+}
+
+// deletions are the keys whose records are being deleted, by a deletion and
+// the deletions it cascades to. The records referring to a key are acted on
+// before its record is deleted, so a record that refers to itself, or to a
+// record referring back to it, is left to the deletion under way rather
+// than deleted again.
+type deletions []deletion
+
+// deletion is the value of the key of a record being deleted.
+type deletion struct {
+	tableName string
+	fieldName string
+	value     any
+}
+
+// Deleting rejects the deletion if a foreign key whose action is Restrict
+// refers to the value, and otherwise does the action of each foreign key to
+// the records referring to it. If an action fails, as when a cascaded
+// deletion is restricted, the records already acted on are left so.
+// Records that are being deleted are not taken as referring to the value.
+func (rk *referencedKey) Deleting(value any) error {
+	*rk.deleting = append(*rk.deleting, deletion{tableName: rk.tableName, fieldName: rk.fieldName, value: value})
+	defer func() {
+		*rk.deleting = (*rk.deleting)[:len(*rk.deleting)-1]
+	}()
+
+	for _, info := range rk.foreignKeys {
+		if info.onDelete != Restrict {
+			continue
+		}
+		referred, err := rk.isReferred(info, value)
+		if err != nil {
+			return err
+		}
+		if referred {
+			return &record.ConstraintViolationError{Constraint: info.name}
+		}
+	}
+	for _, info := range rk.foreignKeys {
+		if info.onDelete == Restrict {
+			continue
+		}
+		if err := rk.act(info, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Updating rejects a change of the value if a foreign key refers to it.
+func (rk *referencedKey) Updating(old any, value any) error {
+	if c, ok := query.CompareValues(old, value); ok && c == 0 {
+		return nil
+	}
+	for _, info := range rk.foreignKeys {
+		referred, err := rk.isReferred(info, old)
+		if err != nil {
+			return err
+		}
+		if referred {
+			return &record.ConstraintViolationError{Constraint: info.name}
+		}
+	}
+	return nil
+}
+
+// isReferred reports whether a record that is not being deleted refers to
+// the value through the foreign key.
+func (rk *referencedKey) isReferred(info ConstraintInfo, value any) (bool, error) {
+	layout, err := rk.mm.tableManager.GetLayout(info.tableName, rk.tx)
+	if err != nil {
+		return false, err
+	}
+	tableScan, err := record.NewTableScan(rk.tx, info.tableName, layout)
+	if err != nil {
+		return false, err
+	}
+	defer tableScan.Close()
+	for tableScan.Next() {
+		referred, err := rk.refersTo(info, tableScan, value)
+		if err != nil || referred {
+			return referred, err
+		}
+	}
+	return false, nil
+}
+
+// act deletes the records referring to the value through the foreign key,
+// or sets their foreign key to NULL, through a scan that enforces the
+// constraints of their table in turn. Records that are being deleted are
+// left to their deletion.
+func (rk *referencedKey) act(info ConstraintInfo, value any) error {
+	tableScan, err := rk.mm.openTable(info.tableName, rk.deleting, rk.tx)
+	if err != nil {
+		return err
+	}
+	defer tableScan.Close()
+	for tableScan.Next() {
+		referred, err := rk.refersTo(info, tableScan, value)
+		if err != nil {
+			return err
+		}
+		if !referred {
+			continue
+		}
+		if info.onDelete == Cascade {
+			err = tableScan.Delete()
+		} else {
+			err = tableScan.SetNull(info.fieldName)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// refersTo reports whether the current record of the scan of the referring
+// table refers to the value through the foreign key, and is not being
+// deleted.
+func (rk *referencedKey) refersTo(info ConstraintInfo, tableScan *record.TableScan, value any) (bool, error) {
+	referred, err := refersTo(tableScan, info.fieldName, value)
+	if err != nil || !referred {
+		return false, err
+	}
+	for _, d := range *rk.deleting {
+		if d.tableName != info.tableName {
+			continue
+		}
+		deleted, err := refersTo(tableScan, d.fieldName, d.value)
+		if err != nil || deleted {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// refersTo reports whether the current record of the scan holds the value
+// in the field.
+func refersTo(tableScan *record.TableScan, fieldName string, value any) (bool, error) {
+	v, err := tableScan.ReadValue(fieldName)
+	if err != nil || v == nil {
+		return false, err
+	}
+	c, ok := query.CompareValues(v, value)
+	return ok && c == 0, nil
+}
@@ -1,13 +1,26 @@
 package metadata
 
 import (
+	"errors"
+
+	"simpledb/index"
 	"simpledb/record"
 	"simpledb/transaction"
 )
 
+var (
+	// ErrIndexNotFound is returned when dropping an index that is not in the
+	// catalog.
+	ErrIndexNotFound = errors.New("metadata: index not found")
+	// ErrIndexExists is returned when creating an index, or a constraint
+	// backed by one, with the name of an existing index.
+	ErrIndexExists = errors.New("metadata: index already exists")
+)
+
 type IndexInfo struct {
 	indexName   string
 	fieldName   string
+	unique      bool
 	tx          *transaction.Transaction
 	tableSchema *record.Schema
 	indexLayout *record.Layout
@@ -26,11 +39,23 @@ func NewIndexInfo(indexName string, fieldName string, tableSchema *record.Schema
 	return indexInfo
 }
 
-// TODO
-func (ii *IndexInfo) Opens() {}
+// Open opens the index.
+func (ii *IndexInfo) Open() index.Index {
+	return index.NewHashIndex(ii.tx, ii.indexName, ii.indexLayout)
+}
 
-// TODO
-func (ii *IndexInfo) BlocksAccessed() {}
+// IsUnique reports whether no two records may hold the same value of the
+// indexed field.
+func (ii *IndexInfo) IsUnique() bool {
+	return ii.unique
+}
+
+// BlocksAccessed estimates the number of blocks read to search the index.
+func (ii *IndexInfo) BlocksAccessed() int32 {
+	recordsPerBlock := ii.tx.BlockSize() / ii.indexLayout.SlotSize()
+	numBlocks := ii.statInfo.RecordsOutput() / recordsPerBlock
+	return index.SearchCost(numBlocks, recordsPerBlock)
+}
 
 func (ii *IndexInfo) RecordsOutput() int32 {
 	return ii.statInfo.RecordsOutput() / ii.statInfo.DistinctValues(ii.fieldName)
@@ -43,19 +68,55 @@ func (ii *IndexInfo) DistinctValues(fieldName string) int32 {
 	return ii.statInfo.DistinctValues(fieldName)
 }
 
+// writer returns the index as kept up to date by table scans, which
+// rejects duplicate values if the index is unique.
+func (ii *IndexInfo) writer() record.IndexWriter {
+	if ii.unique {
+		return &uniqueIndex{Index: ii.Open(), name: ii.indexName}
+	}
+	return ii.Open()
+}
+
+// createIndexLayout creates the layout of the index records, whose dataval
+// field is defined as the indexed field is.
 func (ii *IndexInfo) createIndexLayout() *record.Layout {
 	schema := record.NewSchema()
 	schema.AddIntField("block")
 	schema.AddIntField("id")
-	if ii.tableSchema.FieldType(ii.fieldName) == record.Integer {
-		schema.AddIntField("dataval")
+	fieldType := ii.tableSchema.FieldType(ii.fieldName)
+	fieldLength := ii.tableSchema.FieldLength(ii.fieldName)
+	if fieldType == record.Decimal {
+		schema.AddDecimalField("dataval", fieldLength, ii.tableSchema.FieldScale(ii.fieldName), false)
 	} else {
-		fieldLength := ii.tableSchema.FieldLength(ii.fieldName)
-		schema.AddStringField("dataval", fieldLength)
+		schema.AddField("dataval", fieldType, fieldLength, false)
 	}
 	return record.NewLayout(schema)
 }
 
+// uniqueIndex is a unique index, which rejects an entry for a value that
+// another record already holds with a ConstraintViolationError naming the
+// index.
+type uniqueIndex struct {
+	index.Index
+	name string
+}
+
+func (ui *uniqueIndex) Insert(dataVal any, rid *record.RID) error {
+	if err := ui.BeforeFirst(dataVal); err != nil {
+		return err
+	}
+	for ui.Next() {
+		dataRID, err := ui.GetDataRID()
+		if err != nil {
+			return err
+		}
+		if !dataRID.Equals(rid) {
+			return &record.ConstraintViolationError{Constraint: ui.name}
+		}
+	}
+	return ui.Index.Insert(dataVal, rid)
+}
+
 type IndexManager struct {
 	layout       *record.Layout
 	tableManager *TableManager
@@ -63,41 +124,232 @@ type IndexManager struct {
 }
 
 func NewIndexManager(isNew bool, tableManager *TableManager, statManager *StatManager, tx *transaction.Transaction) *IndexManager {
+	schema := record.NewSchema()
+	schema.AddStringField("indexname", maxName)
+	schema.AddStringField("tablename", maxName)
+	schema.AddStringField("fieldname", maxName)
+	schema.AddIntField("isunique")
+	layout := record.NewLayout(schema)
 	if isNew {
-		schema := record.NewSchema()
-		schema.AddStringField("indexname", maxName)
-		schema.AddStringField("tablename", maxName)
-		schema.AddStringField("fieldname", maxName)
 		tableManager.CreateTable("idxcat", schema, tx)
 	}
-	layout := tableManager.GetLayout("idxcat", tx)
 	return &IndexManager{layout: layout, tableManager: tableManager, statManager: statManager}
 }
 
-func (im *IndexManager) CreateIndex(indexName string, tableName string, fieldName string, tx *transaction.Transaction) {
-	tableScan, _ := record.NewTableScan(tx, "idxcat", im.layout)
-	tableScan.Insert()
-	tableScan.WriteString("indexname", indexName)
-	tableScan.WriteString("tablename", tableName)
-	tableScan.WriteString("fieldname", fieldName)
-	tableScan.Close()
+// CreateIndex saves the index in the catalog, and builds it from the
+// records of the table. It fails with ErrNameDropped if the transaction
+// dropped an index of the same name.
+func (im *IndexManager) CreateIndex(indexName string, tableName string, fieldName string, tx *transaction.Transaction) error {
+	return im.createIndex(indexName, tableName, fieldName, false, tx)
+}
+
+// CreateUniqueIndex is like CreateIndex, but the index rejects values that
+// another record already holds. It fails with a ConstraintViolationError
+// naming the index if records of the table already share a value, and the
+// index is not created.
+func (im *IndexManager) CreateUniqueIndex(indexName string, tableName string, fieldName string, tx *transaction.Transaction) error {
+	return im.createIndex(indexName, tableName, fieldName, true, tx)
+}
+
+func (im *IndexManager) createIndex(indexName string, tableName string, fieldName string, unique bool, tx *transaction.Transaction) error {
+	indexes, err := im.indexes(func(string, string, string) bool { return true }, tx)
+	if err != nil {
+		return err
+	}
+	for _, ii := range indexes {
+		if ii.indexName == indexName {
+			return ErrIndexExists
+		}
+	}
+	if index.HashIndexDropped(tx, indexName) {
+		return ErrNameDropped
+	}
+
+	layout, err := im.tableManager.GetLayout(tableName, tx)
+	if err != nil {
+		return err
+	}
+	statInfo := im.statManager.GetStatInfo(tableName, layout, tx)
+	indexInfo := NewIndexInfo(indexName, fieldName, layout.Schema(), tx, statInfo)
+	indexInfo.unique = unique
+	if err := buildIndex(tableName, layout, indexInfo, tx); err != nil {
+		// The entries already built are deleted along with the index files.
+		if dropErr := index.DropHashIndex(tx, indexName); dropErr != nil {
+			return dropErr
+		}
+		return err
+	}
+
+	tableScan, err := record.NewTableScan(tx, "idxcat", im.layout)
+	if err != nil {
+		return err
+	}
+	defer tableScan.Close()
+	if err := tableScan.Insert(); err != nil {
+		return err
+	}
+	if err := tableScan.WriteString("indexname", indexName); err != nil {
+		return err
+	}
+	if err := tableScan.WriteString("tablename", tableName); err != nil {
+		return err
+	}
+	if err := tableScan.WriteString("fieldname", fieldName); err != nil {
+		return err
+	}
+	var isUnique int32
+	if unique {
+		isUnique = 1
+	}
+	return tableScan.WriteInt32("isunique", isUnique)
+}
+
+// buildIndex inserts the entries of the records of the table into the
+// index.
+func buildIndex(tableName string, layout *record.Layout, indexInfo *IndexInfo, tx *transaction.Transaction) error {
+	tableScan, err := record.NewTableScan(tx, tableName, layout)
+	if err != nil {
+		return err
+	}
+	defer tableScan.Close()
+	writer := indexInfo.writer()
+	defer writer.Close()
+
+	for tableScan.Next() {
+		value, err := tableScan.ReadValue(indexInfo.fieldName)
+		if err != nil {
+			return err
+		}
+		if value == nil {
+			continue
+		}
+		if err := writer.Insert(value, tableScan.GetRID()); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (im *IndexManager) GetIndexInfo(tableName string, tx *transaction.Transaction) map[string]*IndexInfo {
 	res := make(map[string]*IndexInfo)
-	tableScan, _ := record.NewTableScan(tx, "idxcat", im.layout)
+	indexes, _ := im.indexes(func(_ string, name string, _ string) bool { return name == tableName }, tx)
+	for _, indexInfo := range indexes {
+		res[indexInfo.fieldName] = indexInfo
+	}
+	return res
+}
+
+// indexes returns the indexes whose index, table and field names match.
+func (im *IndexManager) indexes(match func(indexName string, tableName string, fieldName string) bool, tx *transaction.Transaction) ([]*IndexInfo, error) {
+	tableScan, err := record.NewTableScan(tx, "idxcat", im.layout)
+	if err != nil {
+		return nil, err
+	}
+	defer tableScan.Close()
+
+	var res []*IndexInfo
 	for tableScan.Next() {
-		indexName, _ := tableScan.ReadString("tablename")
-		if indexName == tableName {
-			indexName, _ := tableScan.ReadString("indexname")
-			fieldName, _ := tableScan.ReadString("fieldname")
-			layout := im.tableManager.GetLayout(tableName, tx)
-			statInfo := im.statManager.GetStatInfo(tableName, layout, tx)
-			indexInfo := NewIndexInfo(indexName, fieldName, layout.Schema(), tx, statInfo)
-			res[fieldName] = indexInfo
+		indexName, err := tableScan.ReadString("indexname")
+		if err != nil {
+			return nil, err
+		}
+		tableName, err := tableScan.ReadString("tablename")
+		if err != nil {
+			return nil, err
+		}
+		fieldName, err := tableScan.ReadString("fieldname")
+		if err != nil {
+			return nil, err
+		}
+		if !match(indexName, tableName, fieldName) {
+			continue
 		}
+		isUnique, err := tableScan.ReadInt32("isunique")
+		if err != nil {
+			return nil, err
+		}
+		layout, err := im.tableManager.GetLayout(tableName, tx)
+		if err != nil {
+			return nil, err
+		}
+		statInfo := im.statManager.GetStatInfo(tableName, layout, tx)
+		indexInfo := NewIndexInfo(indexName, fieldName, layout.Schema(), tx, statInfo)
+		indexInfo.unique = isUnique != 0
+		res = append(res, indexInfo)
 	}
+	return res, nil
+}
 
-	tableScan.Close()
-	return res
+// attachIndexes makes the table scan keep the indexes of the table up to
+// date.
+func (im *IndexManager) attachIndexes(tableName string, tableScan *record.TableScan, tx *transaction.Transaction) error {
+	indexes, err := im.indexes(func(_ string, name string, _ string) bool { return name == tableName }, tx)
+	if err != nil {
+		return err
+	}
+	for _, indexInfo := range indexes {
+		tableScan.AddIndex(indexInfo.fieldName, indexInfo.writer())
+	}
+	return nil
+}
+
+// hasIndex reports whether an index is built on the field of the table.
+func (im *IndexManager) hasIndex(tableName string, fieldName string, tx *transaction.Transaction) (bool, error) {
+	indexes, err := im.indexes(func(_ string, name string, field string) bool {
+		return name == tableName && field == fieldName
+	}, tx)
+	return len(indexes) > 0, err
+}
+
+// renameField makes the indexes on a renamed field of the table refer to
+// its new name.
+func (im *IndexManager) renameField(tableName string, oldName string, newName string, tx *transaction.Transaction) error {
+	tableScan, err := record.NewTableScan(tx, "idxcat", im.layout)
+	if err != nil {
+		return err
+	}
+	defer tableScan.Close()
+	for tableScan.Next() {
+		name, err := tableScan.ReadString("tablename")
+		if err != nil {
+			return err
+		}
+		field, err := tableScan.ReadString("fieldname")
+		if err != nil {
+			return err
+		}
+		if name == tableName && field == oldName {
+			if err := tableScan.WriteString("fieldname", newName); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// DropIndex removes the index from the catalog, and deletes its files once
+// the transaction commits.
+func (im *IndexManager) DropIndex(indexName string, tx *transaction.Transaction) error {
+	n, err := deleteRows("idxcat", im.layout, "indexname", indexName, tx)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrIndexNotFound
+	}
+	return index.DropHashIndex(tx, indexName)
+}
+
+// dropIndexes drops the indexes of the table.
+func (im *IndexManager) dropIndexes(tableName string, tx *transaction.Transaction) error {
+	indexes, err := im.indexes(func(_ string, name string, _ string) bool { return name == tableName }, tx)
+	if err != nil {
+		return err
+	}
+	for _, indexInfo := range indexes {
+		if err := im.DropIndex(indexInfo.indexName, tx); err != nil {
+			return err
+		}
+	}
+	return nil
 }
@@ -8,10 +8,9 @@ import (
 )
 
 func TestIndexManager(t *testing.T) {
-	directory := t.TempDir()
-
-	simpleDB := server.NewSimpleDB(directory, 400, 8)
+	simpleDB := server.NewMemorySimpleDB(400, 8)
 	tx := simpleDB.NewTx()
+	defer tx.Commit()
 
 	tm := NewTableManager(true, tx)
 	sm := NewStatManager(tm)
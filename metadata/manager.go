@@ -1,15 +1,32 @@
 package metadata
 
 import (
+	"errors"
+
+	"simpledb/query"
 	"simpledb/record"
 	"simpledb/transaction"
 )
 
+var (
+	// ErrFieldIndexed is returned when dropping a field an index is built
+	// on.
+	ErrFieldIndexed = errors.New("metadata: field is indexed")
+	// ErrFieldChecked is returned when dropping a field a Check constraint
+	// compares, or that is a foreign key.
+	ErrFieldChecked = errors.New("metadata: field is used by a constraint")
+	// ErrDependentViews is returned when dropping a table or view that
+	// views depend on, without dropping them too, or when dropping or
+	// renaming a column that views use.
+	ErrDependentViews = errors.New("metadata: views depend on the table, view or column")
+)
+
 type MetadataManager struct {
-	tableManager *TableManager
-	viewManager  *ViewManager
-	statManager  *StatManager
-	indexManager *IndexManager
+	tableManager      *TableManager
+	viewManager       *ViewManager
+	statManager       *StatManager
+	indexManager      *IndexManager
+	constraintManager *ConstraintManager
 }
 
 func NewMetadataManager(isNew bool, tx *transaction.Transaction) *MetadataManager {
@@ -17,17 +34,384 @@ func NewMetadataManager(isNew bool, tx *transaction.Transaction) *MetadataManage
 	viewManager := NewViewManager(isNew, tableManager, tx)
 	statManager := NewStatManager(tableManager)
 	indexManager := NewIndexManager(isNew, tableManager, statManager, tx)
-	return &MetadataManager{tableManager: tableManager, viewManager: viewManager, statManager: statManager, indexManager: indexManager}
+	constraintManager := NewConstraintManager(isNew, tableManager, tx)
+	return &MetadataManager{
+		tableManager:      tableManager,
+		viewManager:       viewManager,
+		statManager:       statManager,
+		indexManager:      indexManager,
+		constraintManager: constraintManager,
+	}
 }
 
-func (mm *MetadataManager) CreateTable(tableName string, schema *record.Schema, tx *transaction.Transaction) {
-	mm.tableManager.CreateTable(tableName, schema, tx)
+func (mm *MetadataManager) CreateTable(tableName string, schema *record.Schema, tx *transaction.Transaction) error {
+	return mm.tableManager.CreateTable(tableName, schema, tx)
 }
 
-func (mm *MetadataManager) GetLayout(tableName string, tx *transaction.Transaction) *record.Layout {
+func (mm *MetadataManager) CreateTableWithLayout(tableName string, layout *record.Layout, tx *transaction.Transaction) error {
+	return mm.tableManager.CreateTableWithLayout(tableName, layout, tx)
+}
+
+func (mm *MetadataManager) GetLayout(tableName string, tx *transaction.Transaction) (*record.Layout, error) {
 	return mm.tableManager.GetLayout(tableName, tx)
 }
 
+// OpenTable opens a scan of the table that keeps the indexes of the table
+// up to date, and enforces its constraints. Writes to the table must go
+// through such a scan, since a scan opened with record.NewTableScan knows
+// nothing of them.
+func (mm *MetadataManager) OpenTable(tableName string, tx *transaction.Transaction) (*record.TableScan, error) {
+	return mm.openTable(tableName, &deletions{}, tx)
+}
+
+// openTable is OpenTable, where the scan shares the keys being deleted with
+// the scan whose deletion it acts on, if any.
+func (mm *MetadataManager) openTable(tableName string, deleting *deletions, tx *transaction.Transaction) (*record.TableScan, error) {
+	layout, err := mm.tableManager.existingLayout(tableName, tx)
+	if err != nil {
+		return nil, err
+	}
+	tableScan, err := record.NewTableScan(tx, tableName, layout)
+	if err != nil {
+		return nil, err
+	}
+	if err := mm.indexManager.attachIndexes(tableName, tableScan, tx); err != nil {
+		tableScan.Close()
+		return nil, err
+	}
+	if err := mm.constraintManager.attachChecks(tableName, tableScan, tx); err != nil {
+		tableScan.Close()
+		return nil, err
+	}
+	if err := mm.attachForeignKeys(tableName, tableScan, deleting, tx); err != nil {
+		tableScan.Close()
+		return nil, err
+	}
+	return tableScan, nil
+}
+
+// AddColumn adds the field of the specified schema to the table, with the
+// default value in every record.
+func (mm *MetadataManager) AddColumn(tableName string, fieldName string, schema *record.Schema, defaultValue any, tx *transaction.Transaction) error {
+	return mm.tableManager.addColumn(tableName, fieldName, schema, defaultValue, mm.attachIndexes(tableName, tx), tx)
+}
+
+// DropColumn removes the field from the table. It fails with
+// ErrFieldIndexed if an index is built on the field, with ErrFieldChecked
+// if a Check constraint compares it or it is a foreign key, and with
+// ErrDependentViews if a view uses it.
+func (mm *MetadataManager) DropColumn(tableName string, fieldName string, tx *transaction.Transaction) error {
+	if err := mm.checkFieldViews(tableName, fieldName, tx); err != nil {
+		return err
+	}
+	indexed, err := mm.indexManager.hasIndex(tableName, fieldName, tx)
+	if err != nil {
+		return err
+	}
+	if indexed {
+		return ErrFieldIndexed
+	}
+	layout, err := mm.tableManager.existingLayout(tableName, tx)
+	if err != nil {
+		return err
+	}
+	remaining := record.NewSchema()
+	for _, name := range layout.Schema().Fields() {
+		if name != fieldName {
+			remaining.Add(name, layout.Schema())
+		}
+	}
+	constraints, err := mm.constraintManager.GetConstraints(tableName, tx)
+	if err != nil {
+		return err
+	}
+	for _, info := range constraints {
+		if (info.kind == Check && !info.check.AppliesTo(remaining)) || (info.kind == ForeignKey && info.fieldName == fieldName) {
+			return ErrFieldChecked
+		}
+	}
+	return mm.tableManager.dropColumn(tableName, fieldName, mm.attachIndexes(tableName, tx), tx)
+}
+
+// SetNullable sets whether the field of the table can hold NULL. It fails
+// with ErrNullableKey if the field is the primary key of the table, and
+// with record.ErrNotNullable if a record holds NULL in a field made not
+// nullable.
+func (mm *MetadataManager) SetNullable(tableName string, fieldName string, nullable bool, tx *transaction.Transaction) error {
+	if nullable {
+		constraints, err := mm.constraintManager.GetConstraints(tableName, tx)
+		if err != nil {
+			return err
+		}
+		for _, info := range constraints {
+			if info.kind == PrimaryKey && info.fieldName == fieldName {
+				return ErrNullableKey
+			}
+		}
+	}
+	return mm.tableManager.setNullable(tableName, fieldName, nullable, mm.attachIndexes(tableName, tx), tx)
+}
+
+// SetDefault sets the value the field of the table holds in a new record.
+// A nil value removes the default.
+func (mm *MetadataManager) SetDefault(tableName string, fieldName string, value any, tx *transaction.Transaction) error {
+	return mm.tableManager.SetDefault(tableName, fieldName, value, tx)
+}
+
+// attachIndexes returns a function that makes a scan rewriting the table
+// keep its indexes up to date, since the records are moved.
+func (mm *MetadataManager) attachIndexes(tableName string, tx *transaction.Transaction) func(*record.TableScan) error {
+	return func(tableScan *record.TableScan) error {
+		return mm.indexManager.attachIndexes(tableName, tableScan, tx)
+	}
+}
+
+// RenameColumn renames a field of the table, along with the field of the
+// indexes and constraints on it. It fails with ErrDependentViews if a view
+// uses the field, since the view's definition would still name it.
+func (mm *MetadataManager) RenameColumn(tableName string, oldName string, newName string, tx *transaction.Transaction) error {
+	if err := mm.checkFieldViews(tableName, oldName, tx); err != nil {
+		return err
+	}
+	if err := mm.tableManager.RenameColumn(tableName, oldName, newName, tx); err != nil {
+		return err
+	}
+	if err := mm.indexManager.renameField(tableName, oldName, newName, tx); err != nil {
+		return err
+	}
+	return mm.constraintManager.renameField(tableName, oldName, newName, tx)
+}
+
+// DropTable removes the table, its indexes and constraints, and deletes their files once
+// the transaction commits. If views depend on the table, or foreign keys of
+// other tables reference it, they are dropped too when cascade is true, and
+// DropTable fails with ErrDependentViews or ErrReferenced otherwise.
+func (mm *MetadataManager) DropTable(tableName string, cascade bool, tx *transaction.Transaction) error {
+	if _, err := mm.tableManager.existingLayout(tableName, tx); err != nil {
+		return err
+	}
+	if err := mm.dropReferencingKeys(func(info ConstraintInfo) bool {
+		return info.refTable == tableName && info.tableName != tableName
+	}, cascade, tx); err != nil {
+		return err
+	}
+	if err := mm.dropDependentViews(tableName, cascade, tx); err != nil {
+		return err
+	}
+	if err := mm.constraintManager.dropConstraints(tableName, tx); err != nil {
+		return err
+	}
+	if err := mm.indexManager.dropIndexes(tableName, tx); err != nil {
+		return err
+	}
+	if err := mm.tableManager.DropTable(tableName, tx); err != nil {
+		return err
+	}
+	// The statistics are only forgotten once the table is gone, since other
+	// transactions may calculate them again until then.
+	tx.OnCommit(func() {
+		mm.statManager.invalidate(tableName)
+	})
+	return nil
+}
+
+// DropView removes the view. Views that depend on it are dropped too when
+// cascade is true, and DropView fails with ErrDependentViews otherwise.
+func (mm *MetadataManager) DropView(viewName string, cascade bool, tx *transaction.Transaction) error {
+	if err := mm.dropDependentViews(viewName, cascade, tx); err != nil {
+		return err
+	}
+	return mm.viewManager.DropView(viewName, tx)
+}
+
+// DropIndex removes the index. It fails with ErrConstraintIndex if the
+// index backs a constraint.
+func (mm *MetadataManager) DropIndex(indexName string, tx *transaction.Transaction) error {
+	_, found, err := mm.constraintManager.constraint(indexName, tx)
+	if err != nil {
+		return err
+	}
+	if found {
+		return ErrConstraintIndex
+	}
+	return mm.indexManager.DropIndex(indexName, tx)
+}
+
+// AddPrimaryKey adds a primary key constraint on the field of the table,
+// which cannot be nullable. It fails with ErrPrimaryKeyExists if the table
+// already has one, and with a record.ConstraintViolationError if records
+// already share a value of the field.
+func (mm *MetadataManager) AddPrimaryKey(constraintName string, tableName string, fieldName string, tx *transaction.Transaction) error {
+	return mm.addKey(ConstraintInfo{name: constraintName, tableName: tableName, fieldName: fieldName, kind: PrimaryKey}, tx)
+}
+
+// AddUnique adds a unique constraint on the field of the table. It fails
+// with a record.ConstraintViolationError if records already share a
+// non-NULL value of the field.
+func (mm *MetadataManager) AddUnique(constraintName string, tableName string, fieldName string, tx *transaction.Transaction) error {
+	return mm.addKey(ConstraintInfo{name: constraintName, tableName: tableName, fieldName: fieldName, kind: Unique}, tx)
+}
+
+// addKey adds a primary key or unique constraint, along with the unique
+// index that backs it.
+func (mm *MetadataManager) addKey(info ConstraintInfo, tx *transaction.Transaction) error {
+	layout, err := mm.newConstraintTable(info, tx)
+	if err != nil {
+		return err
+	}
+	if !layout.Schema().HasField(info.fieldName) {
+		return ErrFieldNotFound
+	}
+	if info.kind == PrimaryKey {
+		if layout.Schema().IsNullable(info.fieldName) {
+			return ErrNullableKey
+		}
+		constraints, err := mm.constraintManager.GetConstraints(info.tableName, tx)
+		if err != nil {
+			return err
+		}
+		for _, constraint := range constraints {
+			if constraint.kind == PrimaryKey {
+				return ErrPrimaryKeyExists
+			}
+		}
+	}
+
+	if err := mm.indexManager.CreateUniqueIndex(info.name, info.tableName, info.fieldName, tx); err != nil {
+		return err
+	}
+	return mm.constraintManager.addConstraint(info, tx)
+}
+
+// AddCheck adds a Check constraint to the table, which rejects records for
+// which the predicate is false. The scans opened with OpenTable evaluate it
+// in InsertValues and UpdateValues, once all the fields are written. It
+// fails with ErrFieldNotFound if the predicate compares fields the table
+// does not have, and with a record.ConstraintViolationError if a record
+// already violates it.
+func (mm *MetadataManager) AddCheck(constraintName string, tableName string, pred *query.Predicate, tx *transaction.Transaction) error {
+	info := ConstraintInfo{name: constraintName, tableName: tableName, kind: Check, check: pred}
+	layout, err := mm.newConstraintTable(info, tx)
+	if err != nil {
+		return err
+	}
+	if !pred.AppliesTo(layout.Schema()) {
+		return ErrFieldNotFound
+	}
+
+	tableScan, err := record.NewTableScan(tx, tableName, layout)
+	if err != nil {
+		return err
+	}
+	defer tableScan.Close()
+	check := predicateCheck{pred: pred}
+	for tableScan.Next() {
+		if check.IsViolated(tableScan) {
+			return &record.ConstraintViolationError{Constraint: constraintName}
+		}
+	}
+	return mm.constraintManager.addConstraint(info, tx)
+}
+
+// newConstraintTable returns the layout of the table of a new constraint.
+// It fails with ErrConstraintExists if the name of the constraint is taken.
+func (mm *MetadataManager) newConstraintTable(info ConstraintInfo, tx *transaction.Transaction) (*record.Layout, error) {
+	layout, err := mm.tableManager.existingLayout(info.tableName, tx)
+	if err != nil {
+		return nil, err
+	}
+	_, found, err := mm.constraintManager.constraint(info.name, tx)
+	if err != nil {
+		return nil, err
+	}
+	if found {
+		return nil, ErrConstraintExists
+	}
+	return layout, nil
+}
+
+// GetConstraints returns the constraints of the table.
+func (mm *MetadataManager) GetConstraints(tableName string, tx *transaction.Transaction) ([]ConstraintInfo, error) {
+	return mm.constraintManager.GetConstraints(tableName, tx)
+}
+
+// DropConstraint removes the constraint, along with the index that backs
+// it if it is a primary key or unique constraint. It fails with
+// ErrReferenced if a foreign key references the key.
+func (mm *MetadataManager) DropConstraint(constraintName string, tx *transaction.Transaction) error {
+	info, found, err := mm.constraintManager.constraint(constraintName, tx)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return ErrConstraintNotFound
+	}
+	if info.kind != PrimaryKey && info.kind != Unique {
+		return mm.constraintManager.dropConstraint(constraintName, tx)
+	}
+	if err := mm.dropReferencingKeys(func(fk ConstraintInfo) bool {
+		return fk.refTable == info.tableName && fk.refField == info.fieldName
+	}, false, tx); err != nil {
+		return err
+	}
+	if err := mm.constraintManager.dropConstraint(constraintName, tx); err != nil {
+		return err
+	}
+	return mm.indexManager.DropIndex(constraintName, tx)
+}
+
+// dropReferencingKeys drops the foreign keys that match if cascade is
+// true, and fails with ErrReferenced if there are any otherwise.
+func (mm *MetadataManager) dropReferencingKeys(match func(info ConstraintInfo) bool, cascade bool, tx *transaction.Transaction) error {
+	foreignKeys, err := mm.constraintManager.constraints(func(info ConstraintInfo) bool {
+		return info.kind == ForeignKey && match(info)
+	}, tx)
+	if err != nil {
+		return err
+	}
+	if len(foreignKeys) > 0 && !cascade {
+		return ErrReferenced
+	}
+	for _, info := range foreignKeys {
+		if err := mm.constraintManager.dropConstraint(info.name, tx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dropDependentViews drops the views that depend on the table or view, and
+// those that depend on them, if cascade is true.
+func (mm *MetadataManager) dropDependentViews(name string, cascade bool, tx *transaction.Transaction) error {
+	views, err := mm.viewManager.dependentViews(name, tx)
+	if err != nil {
+		return err
+	}
+	if len(views) > 0 && !cascade {
+		return ErrDependentViews
+	}
+	for _, viewName := range views {
+		// The view may already be gone, along with a view it depends on.
+		if err := mm.DropView(viewName, true, tx); err != nil && !errors.Is(err, ErrViewNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkFieldViews fails with ErrDependentViews if views use the field of the
+// table.
+func (mm *MetadataManager) checkFieldViews(tableName string, fieldName string, tx *transaction.Transaction) error {
+	views, err := mm.viewManager.fieldViews(tableName, fieldName, tx)
+	if err != nil {
+		return err
+	}
+	if len(views) > 0 {
+		return ErrDependentViews
+	}
+	return nil
+}
+
 func (mm *MetadataManager) CreateView(viewName string, viewDef string, tx *transaction.Transaction) {
 	mm.viewManager.CreateView(viewName, viewDef, tx)
 }
@@ -36,8 +420,8 @@ func (mm *MetadataManager) GetViewDef(viewName string, tx *transaction.Transacti
 	return mm.viewManager.GetViewDef(viewName, tx)
 }
 
-func (mm *MetadataManager) CreateIndex(indexName string, tableName string, fieldName string, tx *transaction.Transaction) {
-	mm.indexManager.CreateIndex(indexName, tableName, fieldName, tx)
+func (mm *MetadataManager) CreateIndex(indexName string, tableName string, fieldName string, tx *transaction.Transaction) error {
+	return mm.indexManager.CreateIndex(indexName, tableName, fieldName, tx)
 }
 
 func (mm *MetadataManager) GetIndexInfo(tableName string, tx *transaction.Transaction) map[string]*IndexInfo {
@@ -0,0 +1,889 @@
+package metadata
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"testing"
+
+	"simpledb/decimal"
+	"simpledb/file"
+	"simpledb/query"
+	"simpledb/record"
+	"simpledb/server"
+)
+
+func TestMetadataManager_Drop(t *testing.T) {
+	fm := file.NewMemoryStorage(400)
+	simpleDB := server.NewSimpleDBWithStorage(fm, 8)
+	tx := simpleDB.NewTx()
+
+	mm := NewMetadataManager(true, tx)
+
+	schema := record.NewSchema()
+	schema.AddIntField("A")
+	mm.CreateTable("T", schema, tx)
+	mm.CreateTable("U", schema, tx)
+	layout := getLayout(t, mm.GetLayout, "T", tx)
+	ts, err := record.NewTableScan(tx, "T", layout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range int32(10) {
+		ts.Insert()
+		ts.WriteInt32("A", i)
+	}
+	ts.Close()
+	mm.CreateIndex("TIndex", "T", "A", tx)
+	mm.CreateView("V1", "SELECT A FROM T", tx)
+	mm.CreateView("V2", "SELECT A FROM V1, T", tx)
+	mm.CreateView("V3", "SELECT A FROM U", tx)
+	mm.GetStatInfo("T", layout, tx)
+	tx.Commit()
+
+	tx = simpleDB.NewTx()
+	if err := mm.DropTable("T", false, tx); err != ErrDependentViews {
+		t.Errorf("invalid error dropping a table views depend on: got %v, want %v", err, ErrDependentViews)
+	}
+	if err := mm.DropTable("T", true, tx); err != nil {
+		t.Fatal(err)
+	}
+	if err := mm.DropTable("Missing", true, tx); err != ErrTableNotFound {
+		t.Errorf("invalid error dropping a missing table: got %v, want %v", err, ErrTableNotFound)
+	}
+	// The files are only deleted once the transaction commits.
+	if files, _ := fm.List(); !slices.Contains(files, "T.tbl") {
+		t.Errorf("file deleted before commit: got %v", files)
+	}
+	tx.Commit()
+
+	if files, _ := fm.List(); slices.Contains(files, "T.tbl") {
+		t.Errorf("file not deleted: got %v", files)
+	}
+	if _, ok := mm.statManager.tableStats["T"]; ok {
+		t.Error("statistics of a dropped table are still cached")
+	}
+
+	tx = simpleDB.NewTx()
+	defer tx.Commit()
+	if size := getLayout(t, mm.GetLayout, "T", tx).SlotSize(); size != -1 {
+		t.Errorf("invalid slot size of a dropped table: got %d, want %d", size, -1)
+	}
+	for _, viewName := range []string{"V1", "V2"} {
+		if viewDef := mm.GetViewDef(viewName, tx); viewDef != "" {
+			t.Errorf("view %s not dropped: got %s", viewName, viewDef)
+		}
+	}
+	if viewDef := mm.GetViewDef("V3", tx); viewDef != "SELECT A FROM U" {
+		t.Errorf("invalid view definition: got %s, want %s", viewDef, "SELECT A FROM U")
+	}
+	if indexes := mm.GetIndexInfo("T", tx); len(indexes) != 0 {
+		t.Errorf("invalid number of indexes of a dropped table: got %d, want %d", len(indexes), 0)
+	}
+	if err := mm.DropIndex("TIndex", tx); err != ErrIndexNotFound {
+		t.Errorf("invalid error dropping a missing index: got %v, want %v", err, ErrIndexNotFound)
+	}
+	if err := mm.DropView("V3", false, tx); err != nil {
+		t.Fatal(err)
+	}
+	if err := mm.DropView("V3", false, tx); err != ErrViewNotFound {
+		t.Errorf("invalid error dropping a missing view: got %v, want %v", err, ErrViewNotFound)
+	}
+}
+
+func TestMetadataManager_DropRollback(t *testing.T) {
+	fm := file.NewMemoryStorage(400)
+	simpleDB := server.NewSimpleDBWithStorage(fm, 8)
+	tx := simpleDB.NewTx()
+
+	mm := NewMetadataManager(true, tx)
+
+	schema := record.NewSchema()
+	schema.AddIntField("A")
+	mm.CreateTable("T", schema, tx)
+	mm.CreateIndex("TIndex", "T", "A", tx)
+	ts, err := record.NewTableScan(tx, "T", getLayout(t, mm.GetLayout, "T", tx))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts.Insert()
+	ts.WriteInt32("A", 42)
+	ts.Close()
+	tx.Commit()
+
+	tx = simpleDB.NewTx()
+	if err := mm.DropIndex("TIndex", tx); err != nil {
+		t.Fatal(err)
+	}
+	if err := mm.DropTable("T", false, tx); err != nil {
+		t.Fatal(err)
+	}
+	tx.Rollback()
+
+	if files, _ := fm.List(); !slices.Contains(files, "T.tbl") {
+		t.Errorf("file deleted by a rolled back drop: got %v", files)
+	}
+
+	tx = simpleDB.NewTx()
+	defer tx.Commit()
+	if fields := getLayout(t, mm.GetLayout, "T", tx).Schema().Fields(); !slices.Equal(fields, []string{"A"}) {
+		t.Errorf("invalid schema: got %v, want %v", fields, []string{"A"})
+	}
+	if indexes := mm.GetIndexInfo("T", tx); len(indexes) != 1 {
+		t.Errorf("invalid number of indexes: got %d, want %d", len(indexes), 1)
+	}
+	ts, err = record.NewTableScan(tx, "T", getLayout(t, mm.GetLayout, "T", tx))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ts.Close()
+	if !ts.Next() {
+		t.Fatal("record of the table is gone")
+	}
+	if a, _ := ts.ReadInt32("A"); a != 42 {
+		t.Errorf("invalid value: got %d, want %d", a, 42)
+	}
+}
+
+func TestMetadataManager_DropAndCreate(t *testing.T) {
+	fm := file.NewMemoryStorage(400)
+	simpleDB := server.NewSimpleDBWithStorage(fm, 8)
+	tx := simpleDB.NewTx()
+
+	mm := NewMetadataManager(true, tx)
+
+	schema := record.NewSchema()
+	schema.AddIntField("A")
+	if err := mm.CreateTable("T", schema, tx); err != nil {
+		t.Fatal(err)
+	}
+	if err := mm.CreateTable("U", schema, tx); err != nil {
+		t.Fatal(err)
+	}
+	if err := mm.CreateIndex("TIndex", "T", "A", tx); err != nil {
+		t.Fatal(err)
+	}
+	tx.Commit()
+
+	// The names are only free once the files of the dropped table and
+	// index are deleted.
+	tx = simpleDB.NewTx()
+	if err := mm.DropTable("T", false, tx); err != nil {
+		t.Fatal(err)
+	}
+	if err := mm.CreateTable("T", schema, tx); err != ErrNameDropped {
+		t.Errorf("invalid error creating a dropped table: got %v, want %v", err, ErrNameDropped)
+	}
+	if err := mm.CreateIndex("TIndex", "U", "A", tx); err != ErrNameDropped {
+		t.Errorf("invalid error creating a dropped index: got %v, want %v", err, ErrNameDropped)
+	}
+	tx.Commit()
+
+	tx = simpleDB.NewTx()
+	if err := mm.CreateTable("T", schema, tx); err != nil {
+		t.Fatal(err)
+	}
+	if err := mm.CreateIndex("TIndex", "T", "A", tx); err != nil {
+		t.Fatal(err)
+	}
+	ts, err := mm.OpenTable("T", tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range int32(5) {
+		if err := ts.InsertValues(map[string]any{"A": i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ts.Close()
+	tx.Commit()
+
+	tx = simpleDB.NewTx()
+	defer tx.Commit()
+	ts, err = mm.OpenTable("T", tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ts.Close()
+	var n int
+	for ts.Next() {
+		n++
+	}
+	if n != 5 {
+		t.Errorf("invalid number of records of the created table: got %d, want %d", n, 5)
+	}
+}
+
+func TestMetadataManager_ColumnViews(t *testing.T) {
+	simpleDB := server.NewMemorySimpleDB(400, 8)
+	tx := simpleDB.NewTx()
+
+	mm := NewMetadataManager(true, tx)
+
+	schema := record.NewSchema()
+	schema.AddIntField("A")
+	schema.AddIntField("B")
+	if err := mm.CreateTable("T", schema, tx); err != nil {
+		t.Fatal(err)
+	}
+	if err := mm.CreateTable("U", schema, tx); err != nil {
+		t.Fatal(err)
+	}
+	mm.CreateView("V", "SELECT A FROM T", tx)
+	tx.Commit()
+
+	tx = simpleDB.NewTx()
+	defer tx.Commit()
+	if err := mm.DropColumn("T", "A", tx); err != ErrDependentViews {
+		t.Errorf("invalid error dropping a column a view uses: got %v, want %v", err, ErrDependentViews)
+	}
+	if err := mm.RenameColumn("T", "A", "C", tx); err != ErrDependentViews {
+		t.Errorf("invalid error renaming a column a view uses: got %v, want %v", err, ErrDependentViews)
+	}
+	if viewDef := mm.GetViewDef("V", tx); viewDef != "SELECT A FROM T" {
+		t.Errorf("invalid view definition: got %s, want %s", viewDef, "SELECT A FROM T")
+	}
+
+	// Columns the view does not use, and columns of the same name in other
+	// tables, can change.
+	if err := mm.RenameColumn("T", "B", "C", tx); err != nil {
+		t.Fatal(err)
+	}
+	if err := mm.DropColumn("T", "C", tx); err != nil {
+		t.Fatal(err)
+	}
+	if err := mm.RenameColumn("U", "A", "C", tx); err != nil {
+		t.Fatal(err)
+	}
+	if err := mm.DropColumn("U", "C", tx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMetadataManager_Keys(t *testing.T) {
+	simpleDB := server.NewMemorySimpleDB(400, 8)
+	tx := simpleDB.NewTx()
+
+	mm := NewMetadataManager(true, tx)
+
+	schema := record.NewSchema()
+	schema.AddIntField("id")
+	schema.AddField("email", record.Varchar, 20, true)
+	schema.AddField("note", record.Varchar, 20, true)
+	mm.CreateTable("T", schema, tx)
+
+	ts, err := mm.OpenTable("T", tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range int32(20) {
+		ts.Insert()
+		ts.WriteInt32("id", i)
+		ts.WriteString("email", fmt.Sprintf("u%d@db", i))
+	}
+	ts.Close()
+
+	if err := mm.AddPrimaryKey("T_pkey", "T", "id", tx); err != nil {
+		t.Fatal(err)
+	}
+	if err := mm.AddUnique("T_email", "T", "email", tx); err != nil {
+		t.Fatal(err)
+	}
+	if err := mm.AddPrimaryKey("T_pkey2", "T", "id", tx); err != ErrPrimaryKeyExists {
+		t.Errorf("invalid error adding a second primary key: got %v, want %v", err, ErrPrimaryKeyExists)
+	}
+	if err := mm.AddPrimaryKey("T_pkey2", "T", "note", tx); err != ErrNullableKey {
+		t.Errorf("invalid error adding a nullable primary key: got %v, want %v", err, ErrNullableKey)
+	}
+	if err := mm.DropIndex("T_pkey", tx); err != ErrConstraintIndex {
+		t.Errorf("invalid error dropping the index of a constraint: got %v, want %v", err, ErrConstraintIndex)
+	}
+	constraints, err := mm.GetConstraints("T", tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(constraints) != 2 || constraints[0].Kind() != PrimaryKey || constraints[1].FieldName() != "email" {
+		t.Errorf("invalid constraints: got %v", constraints)
+	}
+	tx.Commit()
+
+	tx = simpleDB.NewTx()
+	ts, err = mm.OpenTable("T", tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A duplicate key is rejected, and the transaction goes on.
+	ts.Insert()
+	var violation *record.ConstraintViolationError
+	if err := ts.WriteInt32("id", 5); !errors.As(err, &violation) || violation.Constraint != "T_pkey" {
+		t.Errorf("invalid error inserting a duplicate key: got %v, want a violation of T_pkey", err)
+	}
+	if err := ts.WriteInt32("id", 20); err != nil {
+		t.Fatal(err)
+	}
+	// Unique fields can hold NULL more than once.
+	ts.SetNull("email")
+	ts.Insert()
+	ts.WriteInt32("id", 21)
+	ts.SetNull("email")
+
+	ts.BeforeFirst()
+	for ts.Next() {
+		id, _ := ts.ReadInt32("id")
+		switch id {
+		case 3:
+			if err := ts.WriteString("email", "u4@db"); !errors.As(err, &violation) || violation.Constraint != "T_email" {
+				t.Errorf("invalid error updating to a duplicate: got %v, want a violation of T_email", err)
+			}
+			if email, _ := ts.ReadString("email"); email != "u3@db" {
+				t.Errorf("invalid value after a rejected update: got %s, want %s", email, "u3@db")
+			}
+		case 4:
+			// The key of a deleted record can be used again.
+			if err := ts.Delete(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	ts.Insert()
+	if err := ts.WriteInt32("id", 4); err != nil {
+		t.Fatal(err)
+	}
+	if err := ts.WriteString("email", "u4@db"); err != nil {
+		t.Fatal(err)
+	}
+	ts.Close()
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	tx = simpleDB.NewTx()
+	defer tx.Commit()
+	ts, err = mm.OpenTable("T", tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var count int
+	for ts.Next() {
+		count++
+	}
+	ts.Close()
+	if count != 22 {
+		t.Errorf("invalid number of records: got %d, want %d", count, 22)
+	}
+
+	// A dropped constraint no longer applies, and adding a key fails if
+	// records already share a value.
+	if err := mm.AddUnique("T_id", "T", "id", tx); err != nil {
+		t.Fatal(err)
+	}
+	if err := mm.DropConstraint("T_id", tx); err != nil {
+		t.Fatal(err)
+	}
+	ts, err = mm.OpenTable("T", tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ts.Next() {
+		ts.WriteString("note", "same")
+	}
+	ts.Close()
+	if err := mm.AddUnique("T_note", "T", "note", tx); !errors.As(err, &violation) || violation.Constraint != "T_note" {
+		t.Errorf("invalid error adding a violated constraint: got %v, want a violation of T_note", err)
+	}
+	if constraints, _ := mm.GetConstraints("T", tx); len(constraints) != 2 {
+		t.Errorf("invalid number of constraints: got %d, want %d", len(constraints), 2)
+	}
+}
+
+func TestMetadataManager_OpenTableUnpins(t *testing.T) {
+	simpleDB := server.NewMemorySimpleDB(400, 8)
+	tx := simpleDB.NewTx()
+	defer tx.Commit()
+
+	mm := NewMetadataManager(true, tx)
+
+	schema := record.NewSchema()
+	schema.AddIntField("A")
+	schema.AddIntField("B")
+	if err := mm.CreateTable("T", schema, tx); err != nil {
+		t.Fatal(err)
+	}
+	if err := mm.CreateIndex("T_A", "T", "A", tx); err != nil {
+		t.Fatal(err)
+	}
+	if err := mm.CreateIndex("T_B", "T", "B", tx); err != nil {
+		t.Fatal(err)
+	}
+
+	available := tx.AvailableBuffers()
+	for i := range int32(20) {
+		ts, err := mm.OpenTable("T", tx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ts.Insert(); err != nil {
+			t.Fatal(err)
+		}
+		if err := ts.WriteInt32("A", i); err != nil {
+			t.Fatal(err)
+		}
+		if err := ts.WriteInt32("B", i); err != nil {
+			t.Fatal(err)
+		}
+		ts.Close()
+
+		if got := tx.AvailableBuffers(); got != available {
+			t.Fatalf("invalid number of available buffers after closing scan %d: got %d, want %d", i, got, available)
+		}
+	}
+}
+
+func TestMetadataManager_Checks(t *testing.T) {
+	simpleDB := server.NewMemorySimpleDB(400, 8)
+	tx := simpleDB.NewTx()
+
+	mm := NewMetadataManager(true, tx)
+
+	schema := record.NewSchema()
+	schema.AddIntField("id")
+	schema.AddIntField("qty")
+	schema.AddDecimalField("price", 6, 2, false)
+	schema.SetDefault("qty", int32(1))
+	mm.CreateTable("T", schema, tx)
+
+	ts, err := mm.OpenTable("T", tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range int32(5) {
+		if err := ts.InsertValues(map[string]any{"id": i, "price": decimal.New(int64(i)*100, 2)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ts.Close()
+
+	positive, err := query.ParsePredicate("qty > 0 AND price >= 0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expensive, err := query.ParsePredicate("price > 1.00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	missing, err := query.ParsePredicate("weight > 0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var violation *record.ConstraintViolationError
+	if err := mm.AddCheck("T_expensive", "T", expensive, tx); !errors.As(err, &violation) || violation.Constraint != "T_expensive" {
+		t.Errorf("invalid error adding a violated check: got %v, want a violation of T_expensive", err)
+	}
+	if err := mm.AddCheck("T_weight", "T", missing, tx); err != ErrFieldNotFound {
+		t.Errorf("invalid error adding a check of a missing field: got %v, want %v", err, ErrFieldNotFound)
+	}
+	if err := mm.AddCheck("T_positive", "T", positive, tx); err != nil {
+		t.Fatal(err)
+	}
+	if err := mm.AddCheck("T_positive", "T", expensive, tx); err != ErrConstraintExists {
+		t.Errorf("invalid error adding a check with a taken name: got %v, want %v", err, ErrConstraintExists)
+	}
+	tx.Commit()
+
+	tx = simpleDB.NewTx()
+	ts, err = mm.OpenTable("T", tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ts.InsertValues(map[string]any{"id": int32(5), "qty": int32(0), "price": decimal.New(100, 2)}); !errors.As(err, &violation) || violation.Constraint != "T_positive" {
+		t.Errorf("invalid error inserting a violating record: got %v, want a violation of T_positive", err)
+	}
+	ts.BeforeFirst()
+	for ts.Next() {
+		if id, _ := ts.ReadInt32("id"); id != 2 {
+			continue
+		}
+		if err := ts.UpdateValues(map[string]any{"price": decimal.New(-1, 0)}); !errors.As(err, &violation) || violation.Constraint != "T_positive" {
+			t.Errorf("invalid error writing a violating value: got %v, want a violation of T_positive", err)
+		}
+		if err := ts.WriteInt32("qty", 3); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ts.Close()
+
+	// The check follows a renamed field, which cannot be dropped.
+	if err := mm.RenameColumn("T", "qty", "quantity", tx); err != nil {
+		t.Fatal(err)
+	}
+	if err := mm.DropColumn("T", "quantity", tx); err != ErrFieldChecked {
+		t.Errorf("invalid error dropping a checked field: got %v, want %v", err, ErrFieldChecked)
+	}
+	constraints, err := mm.GetConstraints("T", tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(constraints) != 1 || constraints[0].Kind() != Check || constraints[0].Predicate().String() != "quantity > 0 AND price >= 0" {
+		t.Errorf("invalid constraints: got %v", constraints)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	tx = simpleDB.NewTx()
+	defer tx.Commit()
+	ts, err = mm.OpenTable("T", tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var count, total int32
+	for ts.Next() {
+		quantity, _ := ts.ReadInt32("quantity")
+		count++
+		total += quantity
+	}
+	ts.BeforeFirst()
+	ts.Next()
+	if err := ts.UpdateValues(map[string]any{"quantity": int32(-1)}); !errors.As(err, &violation) || violation.Constraint != "T_positive" {
+		t.Errorf("invalid error writing a violating value: got %v, want a violation of T_positive", err)
+	}
+	ts.Close()
+	if count != 5 || total != 7 {
+		t.Errorf("invalid records: got %d with a total quantity of %d, want %d and %d", count, total, 5, 7)
+	}
+
+	if err := mm.DropConstraint("T_positive", tx); err != nil {
+		t.Fatal(err)
+	}
+	if err := mm.DropConstraint("T_positive", tx); err != ErrConstraintNotFound {
+		t.Errorf("invalid error dropping a missing constraint: got %v, want %v", err, ErrConstraintNotFound)
+	}
+	ts, err = mm.OpenTable("T", tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ts.Close()
+	if err := ts.InsertValues(map[string]any{"id": int32(6), "quantity": int32(-1), "price": decimal.New(100, 2)}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMetadataManager_ForeignKeys(t *testing.T) {
+	simpleDB := server.NewMemorySimpleDB(400, 8)
+	tx := simpleDB.NewTx()
+
+	mm := NewMetadataManager(true, tx)
+
+	parent := record.NewSchema()
+	parent.AddIntField("id")
+	parent.AddStringField("name", 10)
+	mm.CreateTable("P", parent, tx)
+	if err := mm.AddPrimaryKey("P_pkey", "P", "id", tx); err != nil {
+		t.Fatal(err)
+	}
+	child := record.NewSchema()
+	child.AddIntField("id")
+	child.AddField("pid", record.Integer, 0, true)
+	for _, tableName := range []string{"R", "C", "N"} {
+		mm.CreateTable(tableName, child, tx)
+	}
+
+	insert := func(tableName string, values map[string]any) error {
+		t.Helper()
+		ts, err := mm.OpenTable(tableName, tx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ts.Close()
+		return ts.InsertValues(values)
+	}
+	count := func(tableName string, fieldName string, value any) int {
+		t.Helper()
+		ts, err := mm.OpenTable(tableName, tx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ts.Close()
+		var n int
+		for ts.Next() {
+			if v, _ := ts.ReadValue(fieldName); v == value {
+				n++
+			}
+		}
+		return n
+	}
+	violates := func(err error, constraint string) bool {
+		var violation *record.ConstraintViolationError
+		return errors.As(err, &violation) && violation.Constraint == constraint
+	}
+
+	for i := range int32(4) {
+		if err := insert("P", map[string]any{"id": i, "name": fmt.Sprintf("p%d", i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Records referring to missing keys keep a foreign key from being added.
+	if err := insert("R", map[string]any{"id": int32(0), "pid": int32(9)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := mm.AddForeignKey("R_fkey", "R", "pid", "P", "id", Restrict, tx); !violates(err, "R_fkey") {
+		t.Errorf("invalid error adding a violated foreign key: got %v, want a violation of R_fkey", err)
+	}
+	if err := mm.AddForeignKey("R_fkey", "R", "pid", "P", "name", Restrict, tx); err != ErrKeyType {
+		t.Errorf("invalid error adding a foreign key of another type: got %v, want %v", err, ErrKeyType)
+	}
+	if err := mm.AddForeignKey("R_fkey", "R", "id", "R", "pid", Restrict, tx); err != ErrNotKey {
+		t.Errorf("invalid error adding a foreign key referencing no key: got %v, want %v", err, ErrNotKey)
+	}
+	if err := mm.AddForeignKey("R_fkey", "R", "id", "P", "id", SetNull, tx); err != record.ErrNotNullable {
+		t.Errorf("invalid error adding a foreign key set to NULL on a non-nullable field: got %v, want %v", err, record.ErrNotNullable)
+	}
+	ts, err := mm.OpenTable("R", tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ts.Next() {
+		ts.Delete()
+	}
+	ts.Close()
+	if err := mm.AddForeignKey("R_fkey", "R", "pid", "P", "id", Restrict, tx); err != nil {
+		t.Fatal(err)
+	}
+	if err := mm.AddForeignKey("C_fkey", "C", "pid", "P", "id", Cascade, tx); err != nil {
+		t.Fatal(err)
+	}
+	if err := mm.AddForeignKey("N_fkey", "N", "pid", "P", "id", SetNull, tx); err != nil {
+		t.Fatal(err)
+	}
+	tx.Commit()
+
+	tx = simpleDB.NewTx()
+	// Records of the referring tables must refer to existing keys, or hold
+	// NULL.
+	if err := insert("R", map[string]any{"id": int32(1), "pid": int32(9)}); !violates(err, "R_fkey") {
+		t.Errorf("invalid error inserting a record referring to a missing key: got %v, want a violation of R_fkey", err)
+	}
+	if err := insert("R", map[string]any{"id": int32(1)}); err != nil {
+		t.Fatal(err)
+	}
+	for _, tableName := range []string{"R", "C", "N"} {
+		for pid := range int32(3) {
+			if err := insert(tableName, map[string]any{"id": pid, "pid": pid}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	ts, err = mm.OpenTable("C", tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts.Next()
+	if err := ts.WriteInt32("pid", 9); !violates(err, "C_fkey") {
+		t.Errorf("invalid error updating a record to refer to a missing key: got %v, want a violation of C_fkey", err)
+	}
+	ts.Close()
+
+	// Deleting referenced keys does the actions of the foreign keys.
+	ts, err = mm.OpenTable("P", tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ts.Next() {
+		id, _ := ts.ReadInt32("id")
+		switch id {
+		case 0:
+			if err := ts.Delete(); !violates(err, "R_fkey") {
+				t.Errorf("invalid error deleting a restricted key: got %v, want a violation of R_fkey", err)
+			}
+			if err := ts.WriteInt32("id", 10); !violates(err, "R_fkey") {
+				t.Errorf("invalid error updating a referenced key: got %v, want a violation of R_fkey", err)
+			}
+		case 2:
+			if err := ts.WriteInt32("id", 20); !violates(err, "R_fkey") {
+				t.Errorf("invalid error updating a referenced key: got %v, want a violation of R_fkey", err)
+			}
+		case 3:
+			if err := ts.WriteInt32("id", 30); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	ts.Close()
+	ts, err = mm.OpenTable("R", tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ts.Next() {
+		if pid, _ := ts.ReadValue("pid"); pid == int32(1) {
+			ts.Delete()
+		}
+	}
+	ts.Close()
+	ts, err = mm.OpenTable("P", tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ts.Next() {
+		if id, _ := ts.ReadInt32("id"); id == 1 {
+			if err := ts.Delete(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	ts.Close()
+	if n := count("C", "pid", int32(1)); n != 0 {
+		t.Errorf("invalid number of records referring to a deleted key with cascade: got %d, want %d", n, 0)
+	}
+	if n := count("C", "pid", int32(0)); n != 1 {
+		t.Errorf("invalid number of records referring to a kept key: got %d, want %d", n, 1)
+	}
+	if n := count("N", "pid", nil); n != 1 {
+		t.Errorf("invalid number of records set to NULL: got %d, want %d", n, 1)
+	}
+	if n := count("P", "id", int32(1)); n != 0 {
+		t.Errorf("invalid number of deleted keys: got %d, want %d", n, 0)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	tx = simpleDB.NewTx()
+	defer tx.Commit()
+	if err := mm.DropConstraint("P_pkey", tx); err != ErrReferenced {
+		t.Errorf("invalid error dropping a referenced key: got %v, want %v", err, ErrReferenced)
+	}
+	if err := mm.DropColumn("C", "pid", tx); err != ErrFieldChecked {
+		t.Errorf("invalid error dropping a foreign key field: got %v, want %v", err, ErrFieldChecked)
+	}
+	if err := mm.RenameColumn("P", "id", "key", tx); err != nil {
+		t.Fatal(err)
+	}
+	constraints, err := mm.GetConstraints("N", tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(constraints) != 1 || constraints[0].ReferencedTable() != "P" || constraints[0].ReferencedField() != "key" || constraints[0].OnDelete() != SetNull {
+		t.Errorf("invalid constraints: got %v", constraints)
+	}
+	if err := mm.DropTable("P", false, tx); err != ErrReferenced {
+		t.Errorf("invalid error dropping a referenced table: got %v, want %v", err, ErrReferenced)
+	}
+	if err := mm.DropTable("P", true, tx); err != nil {
+		t.Fatal(err)
+	}
+	if constraints, _ := mm.GetConstraints("R", tx); len(constraints) != 0 {
+		t.Errorf("invalid number of constraints of a table referencing a dropped one: got %d, want %d", len(constraints), 0)
+	}
+	// The records no longer have to refer to anything.
+	if err := insert("R", map[string]any{"id": int32(5), "pid": int32(9)}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMetadataManager_SelfReferencingForeignKeys(t *testing.T) {
+	testCases := []struct {
+		name     string
+		onDelete ReferentialAction
+		deleted  []int32
+		want     map[int32]any // boss of each remaining employee
+	}{
+		{
+			name:     "Cascade",
+			onDelete: Cascade,
+			deleted:  []int32{1, 4, 7},
+			want:     map[int32]any{6: nil},
+		},
+		{
+			name:     "SetNull",
+			onDelete: SetNull,
+			deleted:  []int32{1, 4, 7},
+			want:     map[int32]any{2: nil, 3: int32(2), 5: nil, 6: nil},
+		},
+		{
+			name:     "Restrict",
+			onDelete: Restrict,
+			deleted:  []int32{7},
+			want:     map[int32]any{1: int32(1), 2: int32(1), 3: int32(2), 4: int32(5), 5: int32(4), 6: nil},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			simpleDB := server.NewMemorySimpleDB(400, 8)
+			tx := simpleDB.NewTx()
+			defer tx.Commit()
+
+			mm := NewMetadataManager(true, tx)
+
+			schema := record.NewSchema()
+			schema.AddIntField("id")
+			schema.AddField("boss", record.Integer, 0, true)
+			if err := mm.CreateTable("E", schema, tx); err != nil {
+				t.Fatal(err)
+			}
+			if err := mm.AddPrimaryKey("E_pkey", "E", "id", tx); err != nil {
+				t.Fatal(err)
+			}
+			if err := mm.AddForeignKey("E_fkey", "E", "boss", "E", "id", tc.onDelete, tx); err != nil {
+				t.Fatal(err)
+			}
+
+			ts, err := mm.OpenTable("E", tx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// 1 and 7 are their own bosses, 1 is the boss of 2, who is the
+			// boss of 3, and 4 and 5 are each other's bosses.
+			for _, values := range []map[string]any{
+				{"id": int32(1), "boss": int32(1)},
+				{"id": int32(2), "boss": int32(1)},
+				{"id": int32(3), "boss": int32(2)},
+				{"id": int32(4)},
+				{"id": int32(5), "boss": int32(4)},
+				{"id": int32(6)},
+				{"id": int32(7), "boss": int32(7)},
+			} {
+				if err := ts.InsertValues(values); err != nil {
+					t.Fatal(err)
+				}
+			}
+			ts.BeforeFirst()
+			for ts.Next() {
+				if id, _ := ts.ReadInt32("id"); id == 4 {
+					if err := ts.UpdateValues(map[string]any{"boss": int32(5)}); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+
+			ts.BeforeFirst()
+			for ts.Next() {
+				id, _ := ts.ReadInt32("id")
+				switch {
+				case slices.Contains(tc.deleted, id):
+					if err := ts.Delete(); err != nil {
+						t.Errorf("deleting %d: %v", id, err)
+					}
+				case tc.onDelete == Restrict && id == 1:
+					var violation *record.ConstraintViolationError
+					if err := ts.Delete(); !errors.As(err, &violation) || violation.Constraint != "E_fkey" {
+						t.Errorf("invalid error deleting a restricted key: got %v, want a violation of E_fkey", err)
+					}
+				}
+			}
+
+			got := make(map[int32]any)
+			ts.BeforeFirst()
+			for ts.Next() {
+				id, _ := ts.ReadInt32("id")
+				got[id], _ = ts.ReadValue("boss")
+			}
+			ts.Close()
+			if !maps.Equal(got, tc.want) {
+				t.Errorf("invalid employees: got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
@@ -61,15 +61,26 @@ func (sm *StatManager) GetStatInfo(tableName string, layout *record.Layout, tx *
 	return info
 }
 
+// invalidate forgets the statistics of the table, so that they are
+// calculated again the next time they are needed.
+func (sm *StatManager) invalidate(tableName string) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	delete(sm.tableStats, tableName)
+}
+
 func (sm *StatManager) refreshStatisics(tx *transaction.Transaction) {
 	tableStats := make(map[string]StatInfo)
 	sm.numCalls = 0
 
-	tcatLayout := sm.tableManager.GetLayout("tblcat", tx)
-	tcat, _ := record.NewTableScan(tx, "tblcat", tcatLayout)
+	tcat, _ := record.NewTableScan(tx, "tblcat", sm.tableManager.tcatLayout)
 	for tcat.Next() {
 		tableName, _ := tcat.ReadString("tblname")
-		layout := sm.tableManager.GetLayout(tableName, tx)
+		layout, err := sm.tableManager.GetLayout(tableName, tx)
+		if err != nil {
+			continue
+		}
 		info := sm.calcTableStats(tableName, layout, tx)
 		tableStats[tableName] = info
 	}
@@ -8,10 +8,9 @@ import (
 )
 
 func TestStatManager(t *testing.T) {
-	directory := t.TempDir()
-
-	simpleDB := server.NewSimpleDB(directory, 400, 8)
+	simpleDB := server.NewMemorySimpleDB(400, 8)
 	tx := simpleDB.NewTx()
+	defer tx.Commit()
 
 	tm := NewTableManager(true, tx)
 
@@ -20,14 +19,14 @@ func TestStatManager(t *testing.T) {
 	schema.AddStringField("B", 9)
 	tm.CreateTable("MyTable", schema, tx)
 
-	tableScan, _ := record.NewTableScan(tx, "MyTable", tm.GetLayout("MyTable", tx))
+	tableScan, _ := record.NewTableScan(tx, "MyTable", getLayout(t, tm.GetLayout, "MyTable", tx))
 	tableScan.Insert()
 	tableScan.WriteInt32("A", 1)
 	tableScan.WriteString("B", "test")
 	tableScan.Close()
 
 	sm := NewStatManager(tm)
-	statInfo := sm.GetStatInfo("MyTable", tm.GetLayout("MyTable", tx), tx)
+	statInfo := sm.GetStatInfo("MyTable", getLayout(t, tm.GetLayout, "MyTable", tx), tx)
 
 	if statInfo.BlocksAccessed() != 1 {
 		t.Errorf("invalid blocks accessed: got %d, want %d", statInfo.BlocksAccessed(), 1)
@@ -1,12 +1,40 @@
 package metadata
 
 import (
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"time"
+
+	"simpledb/decimal"
 	"simpledb/record"
 	"simpledb/transaction"
 )
 
 const maxName int32 = 16
 
+var (
+	// ErrTableNotFound is returned when altering a table that is not in the
+	// catalog.
+	ErrTableNotFound = errors.New("metadata: table not found")
+	// ErrFieldNotFound is returned when altering a field the table does not
+	// have.
+	ErrFieldNotFound = errors.New("metadata: field not found")
+	// ErrFieldExists is returned when adding a field, or renaming one, to a
+	// name the table already has.
+	ErrFieldExists = errors.New("metadata: field already exists")
+	// ErrDefaultType is returned when setting a default value that is not of
+	// the type of the field.
+	ErrDefaultType = errors.New("metadata: default value is not of the type of the field")
+	// ErrNameTooLong is returned when naming a table or field with a name
+	// longer than the catalog holds.
+	ErrNameTooLong = errors.New("metadata: name is too long")
+	// ErrNameDropped is returned when creating a table or index with the
+	// name of one the transaction dropped, whose files are only deleted
+	// once it commits.
+	ErrNameDropped = errors.New("metadata: name was dropped by the transaction")
+)
+
 type TableManager struct {
 	tcatLayout *record.Layout
 	fcatLayout *record.Layout
@@ -16,6 +44,7 @@ func NewTableManager(isNew bool, tx *transaction.Transaction) *TableManager {
 	tcatSchema := record.NewSchema()
 	tcatSchema.AddStringField("tblname", maxName)
 	tcatSchema.AddIntField("slotsize")
+	tcatSchema.AddIntField("flags")
 	tcatLayout := record.NewLayout(tcatSchema)
 
 	fcatSchema := record.NewSchema()
@@ -24,6 +53,9 @@ func NewTableManager(isNew bool, tx *transaction.Transaction) *TableManager {
 	fcatSchema.AddIntField("type")
 	fcatSchema.AddIntField("length")
 	fcatSchema.AddIntField("offset")
+	fcatSchema.AddIntField("scale")
+	fcatSchema.AddIntField("nullable")
+	fcatSchema.AddField("defaultval", record.Text, maxName, true)
 	fcatLayout := record.NewLayout(fcatSchema)
 
 	tm := &TableManager{tcatLayout: tcatLayout, fcatLayout: fcatLayout}
@@ -37,38 +69,120 @@ func NewTableManager(isNew bool, tx *transaction.Transaction) *TableManager {
 }
 
 // CreateTable calculates the record offsets and saves it all in the catalog.
-func (tm *TableManager) CreateTable(tableName string, schema *record.Schema, tx *transaction.Transaction) {
-	layout := record.NewLayout(schema)
+func (tm *TableManager) CreateTable(tableName string, schema *record.Schema, tx *transaction.Transaction) error {
+	return tm.CreateTableWithLayout(tableName, record.NewLayout(schema), tx)
+}
+
+// CreateTableWithLayout saves a table whose records are stored with the
+// specified layout in the catalog. It is used to create tables whose layout
+// is not the default one, such as multi-version tables. It fails with
+// ErrNameTooLong if the name of the table or of one of its fields is longer
+// than the catalog holds, and with ErrNameDropped if the transaction dropped
+// a table of the same name.
+func (tm *TableManager) CreateTableWithLayout(tableName string, layout *record.Layout, tx *transaction.Transaction) error {
+	if err := checkNames(append([]string{tableName}, layout.Schema().Fields()...)...); err != nil {
+		return err
+	}
+	if record.TableDropped(tx, tableName) {
+		return ErrNameDropped
+	}
 
 	// Insert one record into tblcat.
-	tcat, _ := record.NewTableScan(tx, "tblcat", tm.tcatLayout)
+	tcat, err := record.NewTableScan(tx, "tblcat", tm.tcatLayout)
+	if err != nil {
+		return err
+	}
+	defer tcat.Close()
+	if err := tcat.Insert(); err != nil {
+		return err
+	}
+	if err := tcat.WriteString("tblname", tableName); err != nil {
+		return err
+	}
+	if err := tcat.WriteInt32("slotsize", layout.SlotSize()); err != nil {
+		return err
+	}
+	if err := tcat.WriteInt32("flags", layout.Flags()); err != nil {
+		return err
+	}
 
-	tcat.Insert()
-	tcat.WriteString("tblname", tableName)
-	tcat.WriteInt32("slotsize", layout.SlotSize())
-	tcat.Close()
+	return tm.insertFields(tableName, layout, tx)
+}
+
+// checkNames returns ErrNameTooLong if one of the names is longer than the
+// catalog holds.
+func checkNames(names ...string) error {
+	for _, name := range names {
+		if len(name) > int(maxName) {
+			return ErrNameTooLong
+		}
+	}
+	return nil
+}
 
-	fcat, _ := record.NewTableScan(tx, "fldcat", tm.fcatLayout)
+// insertFields saves the fields of the table in fldcat.
+func (tm *TableManager) insertFields(tableName string, layout *record.Layout, tx *transaction.Transaction) error {
+	schema := layout.Schema()
+	fcat, err := record.NewTableScan(tx, "fldcat", tm.fcatLayout)
+	if err != nil {
+		return err
+	}
+	defer fcat.Close()
 	for _, fieldName := range schema.Fields() {
-		fcat.Insert()
-		fcat.WriteString("tblname", tableName)
-		fcat.WriteString("fldname", fieldName)
-		fcat.WriteInt32("type", int32(schema.FieldType(fieldName)))
-		fcat.WriteInt32("length", schema.FieldLength(fieldName))
-		fcat.WriteInt32("offset", layout.Offset(fieldName))
+		if err := fcat.Insert(); err != nil {
+			return err
+		}
+		if err := fcat.WriteString("tblname", tableName); err != nil {
+			return err
+		}
+		if err := fcat.WriteString("fldname", fieldName); err != nil {
+			return err
+		}
+		if err := fcat.WriteInt32("type", int32(schema.FieldType(fieldName))); err != nil {
+			return err
+		}
+		if err := fcat.WriteInt32("length", schema.FieldLength(fieldName)); err != nil {
+			return err
+		}
+		if err := fcat.WriteInt32("offset", layout.Offset(fieldName)); err != nil {
+			return err
+		}
+		if err := fcat.WriteInt32("scale", schema.FieldScale(fieldName)); err != nil {
+			return err
+		}
+		var nullable int32
+		if schema.IsNullable(fieldName) {
+			nullable = 1
+		}
+		if err := fcat.WriteInt32("nullable", nullable); err != nil {
+			return err
+		}
+		if value := schema.DefaultValue(fieldName); value != nil {
+			if err := fcat.WriteString("defaultval", formatValue(value)); err != nil {
+				return err
+			}
+		}
 	}
-	fcat.Close()
+	return nil
 }
 
 // GetLayout goes to the catalog, extracts the metadata for the specified table,
-// and returns a Layout object containing the metadata.
-func (tm *TableManager) GetLayout(tableName string, tx *transaction.Transaction) *record.Layout {
+// and returns a Layout object containing the metadata. The layout of a table
+// that is not in the catalog has a slot size of -1. GetLayout fails with
+// ErrDefaultType if the catalog holds a default value it cannot read as a
+// value of its field.
+func (tm *TableManager) GetLayout(tableName string, tx *transaction.Transaction) (*record.Layout, error) {
 	var size int32 = -1
-	tcat, _ := record.NewTableScan(tx, "tblcat", tm.tcatLayout)
+	var flags int32
+	tcat, err := record.NewTableScan(tx, "tblcat", tm.tcatLayout)
+	if err != nil {
+		return nil, err
+	}
 	for tcat.Next() {
 		name, _ := tcat.ReadString("tblname")
 		if name == tableName {
 			size, _ = tcat.ReadInt32("slotsize")
+			flags, _ = tcat.ReadInt32("flags")
 			break
 		}
 	}
@@ -76,7 +190,10 @@ func (tm *TableManager) GetLayout(tableName string, tx *transaction.Transaction)
 
 	schema := record.NewSchema()
 	offsets := make(map[string]int32)
-	fcat, _ := record.NewTableScan(tx, "fldcat", tm.fcatLayout)
+	fcat, err := record.NewTableScan(tx, "fldcat", tm.fcatLayout)
+	if err != nil {
+		return nil, err
+	}
 	for fcat.Next() {
 		name, _ := fcat.ReadString("tblname")
 		if name == tableName {
@@ -84,11 +201,420 @@ func (tm *TableManager) GetLayout(tableName string, tx *transaction.Transaction)
 			fieldType, _ := fcat.ReadInt32("type")
 			length, _ := fcat.ReadInt32("length")
 			offset, _ := fcat.ReadInt32("offset")
+			scale, _ := fcat.ReadInt32("scale")
+			nullable, _ := fcat.ReadInt32("nullable")
+			defaultValue, _ := fcat.ReadValue("defaultval")
 			offsets[fieldName] = offset
-			schema.AddField(fieldName, record.FieldType(fieldType), length)
+			if record.FieldType(fieldType) == record.Decimal {
+				schema.AddDecimalField(fieldName, length, scale, nullable != 0)
+			} else {
+				schema.AddField(fieldName, record.FieldType(fieldType), length, nullable != 0)
+			}
+			if defaultValue != nil {
+				value, err := parseValue(defaultValue.(string), record.FieldType(fieldType))
+				if err != nil {
+					fcat.Close()
+					return nil, errors.Join(ErrDefaultType, err)
+				}
+				schema.SetDefault(fieldName, value)
+			}
 		}
 	}
 	fcat.Close()
 
-	return record.NewLayoutFromMetadata(schema, offsets, size)
+	return record.NewLayoutFromMetadata(schema, offsets, size, flags), nil
+}
+
+// AddColumn adds the field of the specified schema to the table, rewriting
+// its records with the default value in the new field. A nil default stands
+// for the default value of the field in the schema, if it has one, and
+// otherwise sets the field to NULL, failing with record.ErrNotNullable if
+// the field cannot hold it.
+func (tm *TableManager) AddColumn(tableName string, fieldName string, schema *record.Schema, defaultValue any, tx *transaction.Transaction) error {
+	return tm.addColumn(tableName, fieldName, schema, defaultValue, nil, tx)
+}
+
+// addColumn is AddColumn, where attach, if not nil, is called with the scan
+// that rewrites the table, so that the indexes of the table can be kept up
+// to date.
+func (tm *TableManager) addColumn(tableName string, fieldName string, schema *record.Schema, defaultValue any, attach func(*record.TableScan) error, tx *transaction.Transaction) error {
+	layout, err := tm.existingLayout(tableName, tx)
+	if err != nil {
+		return err
+	}
+	if layout.Schema().HasField(fieldName) {
+		return ErrFieldExists
+	}
+	if err := checkNames(fieldName); err != nil {
+		return err
+	}
+	if defaultValue == nil {
+		defaultValue = schema.DefaultValue(fieldName)
+	}
+	if defaultValue == nil && !schema.IsNullable(fieldName) {
+		return record.ErrNotNullable
+	}
+
+	newSchema := record.NewSchema()
+	newSchema.AddAll(layout.Schema())
+	newSchema.Add(fieldName, schema)
+	return tm.rewriteTable(tableName, layout, newSchema, func(dst *record.TableScan, src *record.TableScan) error {
+		if err := copyFields(dst, src, layout.Schema().Fields()); err != nil {
+			return err
+		}
+		if defaultValue == nil {
+			return nil
+		}
+		return dst.WriteValue(fieldName, defaultValue)
+	}, attach, tx)
+}
+
+// DropColumn removes the field from the table, rewriting its records
+// without it.
+func (tm *TableManager) DropColumn(tableName string, fieldName string, tx *transaction.Transaction) error {
+	return tm.dropColumn(tableName, fieldName, nil, tx)
+}
+
+// dropColumn is DropColumn, where attach is as for addColumn.
+func (tm *TableManager) dropColumn(tableName string, fieldName string, attach func(*record.TableScan) error, tx *transaction.Transaction) error {
+	layout, err := tm.existingLayout(tableName, tx)
+	if err != nil {
+		return err
+	}
+	if !layout.Schema().HasField(fieldName) {
+		return ErrFieldNotFound
+	}
+
+	newSchema := record.NewSchema()
+	for _, name := range layout.Schema().Fields() {
+		if name != fieldName {
+			newSchema.Add(name, layout.Schema())
+		}
+	}
+	return tm.rewriteTable(tableName, layout, newSchema, func(dst *record.TableScan, src *record.TableScan) error {
+		return copyFields(dst, src, newSchema.Fields())
+	}, attach, tx)
+}
+
+// SetNullable sets whether the field of the table can hold NULL, rewriting
+// its records, since the layout of a nullable field differs. Making the
+// field not nullable fails with record.ErrNotNullable if a record holds
+// NULL in it.
+func (tm *TableManager) SetNullable(tableName string, fieldName string, nullable bool, tx *transaction.Transaction) error {
+	return tm.setNullable(tableName, fieldName, nullable, nil, tx)
+}
+
+// setNullable is SetNullable, where attach is as for addColumn.
+func (tm *TableManager) setNullable(tableName string, fieldName string, nullable bool, attach func(*record.TableScan) error, tx *transaction.Transaction) error {
+	layout, err := tm.existingLayout(tableName, tx)
+	if err != nil {
+		return err
+	}
+	if !layout.Schema().HasField(fieldName) {
+		return ErrFieldNotFound
+	}
+	if layout.Schema().IsNullable(fieldName) == nullable {
+		return nil
+	}
+	if !nullable {
+		// The records are checked before any of them is rewritten, which
+		// could not be undone but by rolling back.
+		hasNull, err := hasNull(tableName, layout, fieldName, tx)
+		if err != nil {
+			return err
+		}
+		if hasNull {
+			return record.ErrNotNullable
+		}
+	}
+
+	newSchema := record.NewSchema()
+	newSchema.AddAll(layout.Schema())
+	newSchema.SetNullable(fieldName, nullable)
+	return tm.rewriteTable(tableName, layout, newSchema, func(dst *record.TableScan, src *record.TableScan) error {
+		return copyFields(dst, src, newSchema.Fields())
+	}, attach, tx)
+}
+
+// hasNull reports whether a record of the table holds NULL in the field.
+func hasNull(tableName string, layout *record.Layout, fieldName string, tx *transaction.Transaction) (bool, error) {
+	tableScan, err := record.NewTableScan(tx, tableName, layout)
+	if err != nil {
+		return false, err
+	}
+	defer tableScan.Close()
+	for tableScan.Next() {
+		isNull, err := tableScan.IsNull(fieldName)
+		if err != nil || isNull {
+			return isNull, err
+		}
+	}
+	return false, nil
+}
+
+// SetDefault sets the value the field of the table holds in a new record,
+// which must be of the type of the field. A nil value removes the default.
+// Only the catalog changes, and the records are left as they are.
+func (tm *TableManager) SetDefault(tableName string, fieldName string, value any, tx *transaction.Transaction) error {
+	layout, err := tm.existingLayout(tableName, tx)
+	if err != nil {
+		return err
+	}
+	if !layout.Schema().HasField(fieldName) {
+		return ErrFieldNotFound
+	}
+	if value != nil && !hasType(value, layout.Schema().FieldType(fieldName)) {
+		return ErrDefaultType
+	}
+
+	fcat, err := record.NewTableScan(tx, "fldcat", tm.fcatLayout)
+	if err != nil {
+		return err
+	}
+	defer fcat.Close()
+	for fcat.Next() {
+		name, err := fcat.ReadString("tblname")
+		if err != nil {
+			return err
+		}
+		field, err := fcat.ReadString("fldname")
+		if err != nil {
+			return err
+		}
+		if name == tableName && field == fieldName {
+			if value == nil {
+				return fcat.SetNull("defaultval")
+			}
+			return fcat.WriteString("defaultval", formatValue(value))
+		}
+	}
+	return nil
+}
+
+// RenameColumn renames a field of the table. Only the catalog changes,
+// since the records do not hold the names of their fields. It fails with
+// ErrNameTooLong if the new name is longer than the catalog holds.
+func (tm *TableManager) RenameColumn(tableName string, oldName string, newName string, tx *transaction.Transaction) error {
+	layout, err := tm.existingLayout(tableName, tx)
+	if err != nil {
+		return err
+	}
+	if !layout.Schema().HasField(oldName) {
+		return ErrFieldNotFound
+	}
+	if layout.Schema().HasField(newName) {
+		return ErrFieldExists
+	}
+	if err := checkNames(newName); err != nil {
+		return err
+	}
+
+	fcat, err := record.NewTableScan(tx, "fldcat", tm.fcatLayout)
+	if err != nil {
+		return err
+	}
+	defer fcat.Close()
+	for fcat.Next() {
+		name, err := fcat.ReadString("tblname")
+		if err != nil {
+			return err
+		}
+		fieldName, err := fcat.ReadString("fldname")
+		if err != nil {
+			return err
+		}
+		if name == tableName && fieldName == oldName {
+			return fcat.WriteString("fldname", newName)
+		}
+	}
+	return nil
+}
+
+// existingLayout returns the layout of the table, or ErrTableNotFound if it
+// is not in the catalog.
+func (tm *TableManager) existingLayout(tableName string, tx *transaction.Transaction) (*record.Layout, error) {
+	layout, err := tm.GetLayout(tableName, tx)
+	if err != nil {
+		return nil, err
+	}
+	if layout.SlotSize() < 0 {
+		return nil, ErrTableNotFound
+	}
+	return layout, nil
+}
+
+// rewriteTable rewrites the records of the table with a layout for the new
+// schema, stored as the old layout is, and saves the new layout in the
+// catalog. fill writes the fields of each new record from the old one.
+func (tm *TableManager) rewriteTable(tableName string, layout *record.Layout, schema *record.Schema, fill func(dst *record.TableScan, src *record.TableScan) error, attach func(*record.TableScan) error, tx *transaction.Transaction) error {
+	newLayout := record.NewLayoutWithFlags(schema, layout.Flags())
+	tableScan, err := record.NewTableScan(tx, tableName, layout)
+	if err != nil {
+		return err
+	}
+	defer tableScan.Close()
+	if attach != nil {
+		if err := attach(tableScan); err != nil {
+			return err
+		}
+	}
+	if err := tableScan.Rewrite(newLayout, fill); err != nil {
+		return err
+	}
+	return tm.replaceLayout(tableName, newLayout, tx)
+}
+
+// replaceLayout saves the new layout of the table in the catalog, in place
+// of its current one.
+func (tm *TableManager) replaceLayout(tableName string, layout *record.Layout, tx *transaction.Transaction) error {
+	tcat, err := record.NewTableScan(tx, "tblcat", tm.tcatLayout)
+	if err != nil {
+		return err
+	}
+	for tcat.Next() {
+		name, err := tcat.ReadString("tblname")
+		if err != nil {
+			tcat.Close()
+			return err
+		}
+		if name == tableName {
+			if err := tcat.WriteInt32("slotsize", layout.SlotSize()); err != nil {
+				tcat.Close()
+				return err
+			}
+			break
+		}
+	}
+	tcat.Close()
+
+	if _, err := deleteRows("fldcat", tm.fcatLayout, "tblname", tableName, tx); err != nil {
+		return err
+	}
+	return tm.insertFields(tableName, layout, tx)
+}
+
+// DropTable removes the table from the catalog, and deletes its files once
+// the transaction commits.
+func (tm *TableManager) DropTable(tableName string, tx *transaction.Transaction) error {
+	n, err := deleteRows("tblcat", tm.tcatLayout, "tblname", tableName, tx)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrTableNotFound
+	}
+	if _, err := deleteRows("fldcat", tm.fcatLayout, "tblname", tableName, tx); err != nil {
+		return err
+	}
+	return record.DropTable(tx, tableName)
+}
+
+// deleteRows deletes the records of the catalog table whose field holds the
+// value, and returns how many it deleted.
+func deleteRows(catalog string, layout *record.Layout, fieldName string, value string, tx *transaction.Transaction) (int, error) {
+	tableScan, err := record.NewTableScan(tx, catalog, layout)
+	if err != nil {
+		return 0, err
+	}
+	defer tableScan.Close()
+
+	var n int
+	for tableScan.Next() {
+		v, err := tableScan.ReadString(fieldName)
+		if err != nil {
+			return n, err
+		}
+		if v != value {
+			continue
+		}
+		if err := tableScan.Delete(); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// copyFields copies the values of the fields from the current record of src
+// to that of dst.
+func copyFields(dst *record.TableScan, src *record.TableScan, fields []string) error {
+	for _, fieldName := range fields {
+		value, err := src.ReadValue(fieldName)
+		if err != nil {
+			return err
+		}
+		if err := dst.WriteValue(fieldName, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hasType reports whether the value is of the Go type of field values of
+// the type.
+func hasType(value any, fieldType record.FieldType) bool {
+	switch value.(type) {
+	case int32:
+		return fieldType == record.Integer
+	case int64:
+		return fieldType == record.BigInt
+	case float64:
+		return fieldType == record.Double
+	case bool:
+		return fieldType == record.Boolean
+	case time.Time:
+		return fieldType == record.Date || fieldType == record.Timestamp
+	case decimal.Decimal:
+		return fieldType == record.Decimal
+	case []byte:
+		return fieldType == record.Blob
+	case string:
+		return fieldType == record.Varchar || fieldType == record.Text
+	}
+	return false
+}
+
+// formatValue formats a field value for the catalog.
+func formatValue(value any) string {
+	switch v := value.(type) {
+	case int32:
+		return strconv.FormatInt(int64(v), 10)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	case decimal.Decimal:
+		return v.String()
+	case []byte:
+		return hex.EncodeToString(v)
+	default:
+		return v.(string)
+	}
+}
+
+// parseValue parses a field value of the type formatted by formatValue.
+func parseValue(s string, fieldType record.FieldType) (any, error) {
+	switch fieldType {
+	case record.Integer:
+		n, err := strconv.ParseInt(s, 10, 32)
+		return int32(n), err
+	case record.BigInt:
+		return strconv.ParseInt(s, 10, 64)
+	case record.Double:
+		return strconv.ParseFloat(s, 64)
+	case record.Boolean:
+		return strconv.ParseBool(s)
+	case record.Date, record.Timestamp:
+		return time.Parse(time.RFC3339Nano, s)
+	case record.Decimal:
+		return decimal.Parse(s)
+	case record.Blob:
+		return hex.DecodeString(s)
+	default:
+		return s, nil
+	}
 }
@@ -1,18 +1,23 @@
 package metadata
 
 import (
+	"errors"
+	"fmt"
 	"slices"
+	"strings"
 	"testing"
+	"time"
 
+	"simpledb/decimal"
 	"simpledb/record"
 	"simpledb/server"
+	"simpledb/transaction"
 )
 
 func TestTableManager(t *testing.T) {
-	directory := t.TempDir()
-
-	simpleDB := server.NewSimpleDB(directory, 400, 8)
+	simpleDB := server.NewMemorySimpleDB(400, 8)
 	tx := simpleDB.NewTx()
+	defer tx.Commit()
 
 	tm := NewTableManager(true, tx)
 
@@ -21,7 +26,7 @@ func TestTableManager(t *testing.T) {
 	schema.AddStringField("B", 9)
 	tm.CreateTable("MyTable", schema, tx)
 
-	layout := tm.GetLayout("MyTable", tx)
+	layout := getLayout(t, tm.GetLayout, "MyTable", tx)
 	size := layout.SlotSize()
 	if size != 21 {
 		t.Errorf("invalid slot size: got %d, want %d", size, 21)
@@ -35,3 +40,380 @@ func TestTableManager(t *testing.T) {
 		t.Errorf("invalid field length: got %d, want %d", gotSchema.FieldLength("B"), 9)
 	}
 }
+
+func TestTableManager_Nullable(t *testing.T) {
+	simpleDB := server.NewMemorySimpleDB(400, 8)
+	tx := simpleDB.NewTx()
+	defer tx.Commit()
+
+	tm := NewTableManager(true, tx)
+
+	schema := record.NewSchema()
+	schema.AddIntField("A")
+	schema.AddField("B", record.Varchar, 9, true)
+	tm.CreateTable("MyTable", schema, tx)
+
+	layout := getLayout(t, tm.GetLayout, "MyTable", tx)
+	// The null bitmap takes one word after the in-use flag.
+	if size := layout.SlotSize(); size != 25 {
+		t.Errorf("invalid slot size: got %d, want %d", size, 25)
+	}
+	if layout.Schema().IsNullable("A") {
+		t.Error("A is nullable, want not nullable")
+	}
+	if !layout.Schema().IsNullable("B") {
+		t.Error("B is not nullable, want nullable")
+	}
+}
+
+func TestTableManager_Types(t *testing.T) {
+	simpleDB := server.NewMemorySimpleDB(400, 8)
+	tx := simpleDB.NewTx()
+	defer tx.Commit()
+
+	tm := NewTableManager(true, tx)
+
+	schema := record.NewSchema()
+	types := map[string]record.FieldType{
+		"big":  record.BigInt,
+		"dbl":  record.Double,
+		"bool": record.Boolean,
+		"date": record.Date,
+		"ts":   record.Timestamp,
+	}
+	for _, fieldName := range []string{"big", "dbl", "bool", "date", "ts"} {
+		schema.AddField(fieldName, types[fieldName], 0, false)
+	}
+	schema.AddDecimalField("dec", 10, 3, false)
+	types["dec"] = record.Decimal
+	tm.CreateTable("MyTable", schema, tx)
+
+	layout := getLayout(t, tm.GetLayout, "MyTable", tx)
+	for fieldName, want := range types {
+		if got := layout.Schema().FieldType(fieldName); got != want {
+			t.Errorf("invalid type of %s: got %d, want %d", fieldName, got, want)
+		}
+	}
+	if size := layout.SlotSize(); size != 48 {
+		t.Errorf("invalid slot size: got %d, want %d", size, 48)
+	}
+	if got := layout.Schema().FieldLength("dec"); got != 10 {
+		t.Errorf("invalid precision: got %d, want %d", got, 10)
+	}
+	if got := layout.Schema().FieldScale("dec"); got != 3 {
+		t.Errorf("invalid scale: got %d, want %d", got, 3)
+	}
+}
+
+func TestTableManager_AlterTable(t *testing.T) {
+	for name, slotted := range map[string]bool{"fixed": false, "slotted": true} {
+		t.Run(name, func(t *testing.T) {
+			simpleDB := server.NewMemorySimpleDB(400, 8)
+			tx := simpleDB.NewTx()
+
+			tm := NewTableManager(true, tx)
+
+			schema := record.NewSchema()
+			schema.AddIntField("A")
+			schema.AddStringField("B", 9)
+			schema.AddField("C", record.Text, 9, false)
+			layout := record.NewLayout(schema)
+			if slotted {
+				layout = record.NewSlottedLayout(schema)
+			}
+			tm.CreateTableWithLayout("MyTable", layout, tx)
+
+			ts, err := record.NewTableScan(tx, "MyTable", layout)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := range int32(60) {
+				ts.Insert()
+				ts.WriteInt32("A", i)
+				ts.WriteString("B", fmt.Sprint(i))
+				ts.WriteString("C", strings.Repeat(fmt.Sprint(i), 20))
+			}
+			ts.Close()
+			tx.Commit()
+
+			tx = simpleDB.NewTx()
+			added := record.NewSchema()
+			added.AddField("D", record.BigInt, 0, false)
+			if err := tm.AddColumn("MyTable", "D", added, int64(7), tx); err != nil {
+				t.Fatal(err)
+			}
+			if err := tm.DropColumn("MyTable", "B", tx); err != nil {
+				t.Fatal(err)
+			}
+			if err := tm.RenameColumn("MyTable", "A", "E", tx); err != nil {
+				t.Fatal(err)
+			}
+			if err := tm.RenameColumn("MyTable", "E", "C", tx); err != ErrFieldExists {
+				t.Errorf("invalid error renaming to an existing field: got %v, want %v", err, ErrFieldExists)
+			}
+			longName := strings.Repeat("x", int(maxName)+1)
+			if err := tm.RenameColumn("MyTable", "E", longName, tx); err != ErrNameTooLong {
+				t.Errorf("invalid error renaming to a long name: got %v, want %v", err, ErrNameTooLong)
+			}
+			long := record.NewSchema()
+			long.AddField(longName, record.BigInt, 0, true)
+			if err := tm.AddColumn("MyTable", longName, long, nil, tx); err != ErrNameTooLong {
+				t.Errorf("invalid error adding a field with a long name: got %v, want %v", err, ErrNameTooLong)
+			}
+			if err := tm.CreateTable(longName, schema, tx); err != ErrNameTooLong {
+				t.Errorf("invalid error creating a table with a long name: got %v, want %v", err, ErrNameTooLong)
+			}
+			if err := tm.DropColumn("MyTable", "B", tx); err != ErrFieldNotFound {
+				t.Errorf("invalid error dropping a missing field: got %v, want %v", err, ErrFieldNotFound)
+			}
+			nullable := record.NewSchema()
+			nullable.AddIntField("F")
+			if err := tm.AddColumn("MyTable", "F", nullable, nil, tx); err != record.ErrNotNullable {
+				t.Errorf("invalid error adding a field without a default: got %v, want %v", err, record.ErrNotNullable)
+			}
+			if err := tm.AddColumn("NoTable", "F", added, int64(7), tx); err != ErrTableNotFound {
+				t.Errorf("invalid error altering a missing table: got %v, want %v", err, ErrTableNotFound)
+			}
+			tx.Commit()
+
+			tx = simpleDB.NewTx()
+			defer tx.Commit()
+			layout = getLayout(t, tm.GetLayout, "MyTable", tx)
+			if !slices.Equal(layout.Schema().Fields(), []string{"E", "C", "D"}) {
+				t.Errorf("invalid schema: got %v, want %v", layout.Schema().Fields(), []string{"E", "C", "D"})
+			}
+			if layout.IsSlotted() != slotted {
+				t.Errorf("invalid storage: got slotted %t, want %t", layout.IsSlotted(), slotted)
+			}
+
+			ts, err = record.NewTableScan(tx, "MyTable", layout)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ts.Close()
+			seen := make(map[int32]bool)
+			for ts.Next() {
+				e, _ := ts.ReadInt32("E")
+				c, _ := ts.ReadString("C")
+				d, _ := ts.ReadInt64("D")
+				if want := strings.Repeat(fmt.Sprint(e), 20); c != want {
+					t.Errorf("invalid C of record %d: got %q, want %q", e, c, want)
+				}
+				if d != 7 {
+					t.Errorf("invalid D of record %d: got %d, want %d", e, d, 7)
+				}
+				seen[e] = true
+			}
+			if len(seen) != 60 {
+				t.Errorf("invalid number of records: got %d, want %d", len(seen), 60)
+			}
+		})
+	}
+}
+
+func TestTableManager_AlterTableRollback(t *testing.T) {
+	simpleDB := server.NewMemorySimpleDB(400, 8)
+	tx := simpleDB.NewTx()
+
+	tm := NewTableManager(true, tx)
+
+	schema := record.NewSchema()
+	schema.AddIntField("A")
+	tm.CreateTable("MyTable", schema, tx)
+	layout := getLayout(t, tm.GetLayout, "MyTable", tx)
+	ts, err := record.NewTableScan(tx, "MyTable", layout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range int32(50) {
+		ts.Insert()
+		ts.WriteInt32("A", i)
+	}
+	ts.Close()
+	tx.Commit()
+
+	tx = simpleDB.NewTx()
+	added := record.NewSchema()
+	added.AddField("B", record.Varchar, 9, true)
+	if err := tm.AddColumn("MyTable", "B", added, "x", tx); err != nil {
+		t.Fatal(err)
+	}
+	tx.Rollback()
+
+	tx = simpleDB.NewTx()
+	defer tx.Commit()
+	layout = getLayout(t, tm.GetLayout, "MyTable", tx)
+	if !slices.Equal(layout.Schema().Fields(), []string{"A"}) {
+		t.Errorf("invalid schema: got %v, want %v", layout.Schema().Fields(), []string{"A"})
+	}
+	ts, err = record.NewTableScan(tx, "MyTable", layout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ts.Close()
+	var sum int32
+	count := 0
+	for ts.Next() {
+		a, _ := ts.ReadInt32("A")
+		sum += a
+		count++
+	}
+	if count != 50 || sum != 49*50/2 {
+		t.Errorf("invalid records: got %d with sum %d, want %d with sum %d", count, sum, 50, 49*50/2)
+	}
+}
+
+func TestTableManager_Defaults(t *testing.T) {
+	simpleDB := server.NewMemorySimpleDB(400, 8)
+	tx := simpleDB.NewTx()
+	defer tx.Commit()
+
+	tm := NewTableManager(true, tx)
+
+	at := time.Date(2024, 1, 2, 15, 4, 5, 123456000, time.UTC)
+	defaults := map[string]any{
+		"I":  int32(-7),
+		"L":  int64(1 << 40),
+		"F":  0.1,
+		"Bo": true,
+		"Ts": at,
+		"D":  decimal.New(1999, 2),
+		"Bl": []byte{0, 255},
+		"S":  "it's",
+	}
+	schema := record.NewSchema()
+	schema.AddIntField("I")
+	schema.AddField("L", record.BigInt, 0, false)
+	schema.AddField("F", record.Double, 0, false)
+	schema.AddField("Bo", record.Boolean, 0, false)
+	schema.AddField("Ts", record.Timestamp, 0, false)
+	schema.AddDecimalField("D", 6, 2, false)
+	schema.AddField("Bl", record.Blob, 8, true)
+	schema.AddField("S", record.Varchar, 10, true)
+	schema.AddField("N", record.Varchar, 10, true)
+	for fieldName, value := range defaults {
+		schema.SetDefault(fieldName, value)
+	}
+	tm.CreateTable("T", schema, tx)
+
+	gotSchema := getLayout(t, tm.GetLayout, "T", tx).Schema()
+	for fieldName, want := range defaults {
+		got := gotSchema.DefaultValue(fieldName)
+		switch want := want.(type) {
+		case []byte:
+			if b, ok := got.([]byte); !ok || !slices.Equal(b, want) {
+				t.Errorf("invalid default of %s: got %v, want %v", fieldName, got, want)
+			}
+		case time.Time:
+			if at, ok := got.(time.Time); !ok || !at.Equal(want) {
+				t.Errorf("invalid default of %s: got %v, want %v", fieldName, got, want)
+			}
+		case decimal.Decimal:
+			if d, ok := got.(decimal.Decimal); !ok || d.Cmp(want) != 0 {
+				t.Errorf("invalid default of %s: got %v, want %v", fieldName, got, want)
+			}
+		default:
+			if got != want {
+				t.Errorf("invalid default of %s: got %v (%T), want %v (%T)", fieldName, got, got, want, want)
+			}
+		}
+	}
+	if got := gotSchema.DefaultValue("N"); got != nil {
+		t.Errorf("invalid default of N: got %v, want <nil>", got)
+	}
+
+	if err := tm.SetDefault("T", "I", int64(1), tx); err != ErrDefaultType {
+		t.Errorf("invalid error setting a default of another type: got %v, want %v", err, ErrDefaultType)
+	}
+	if err := tm.SetDefault("T", "I", int32(8), tx); err != nil {
+		t.Fatal(err)
+	}
+	if err := tm.SetDefault("T", "S", nil, tx); err != nil {
+		t.Fatal(err)
+	}
+	layout := getLayout(t, tm.GetLayout, "T", tx)
+	ts, err := record.NewTableScan(tx, "T", layout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts.Insert()
+	if i, _ := ts.ReadInt32("I"); i != 8 {
+		t.Errorf("invalid default of I: got %d, want %d", i, 8)
+	}
+	if s, _ := ts.ReadValue("S"); s != nil {
+		t.Errorf("invalid value of S without a default: got %v, want <nil>", s)
+	}
+	ts.Close()
+
+	// A field holding NULL cannot be made not nullable.
+	if err := tm.SetNullable("T", "S", false, tx); err != record.ErrNotNullable {
+		t.Errorf("invalid error making a field holding NULL not nullable: got %v, want %v", err, record.ErrNotNullable)
+	}
+	if err := tm.SetNullable("T", "Bl", false, tx); err != nil {
+		t.Fatal(err)
+	}
+	if err := tm.SetNullable("T", "I", true, tx); err != nil {
+		t.Fatal(err)
+	}
+	layout = getLayout(t, tm.GetLayout, "T", tx)
+	if layout.Schema().IsNullable("Bl") || !layout.Schema().IsNullable("I") {
+		t.Errorf("invalid nullability: got Bl %v and I %v, want false and true", layout.Schema().IsNullable("Bl"), layout.Schema().IsNullable("I"))
+	}
+	ts, err = record.NewTableScan(tx, "T", layout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ts.Close()
+	if !ts.Next() {
+		t.Fatal("record of the table is gone")
+	}
+	if bl, _ := ts.ReadBlob("Bl"); !slices.Equal(bl, []byte{0, 255}) {
+		t.Errorf("invalid value of Bl: got %v, want %v", bl, []byte{0, 255})
+	}
+	if err := ts.SetNull("I"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTableManager_InvalidDefault(t *testing.T) {
+	simpleDB := server.NewMemorySimpleDB(400, 8)
+	tx := simpleDB.NewTx()
+	defer tx.Commit()
+
+	tm := NewTableManager(true, tx)
+	schema := record.NewSchema()
+	schema.AddIntField("A")
+	if err := tm.CreateTable("T", schema, tx); err != nil {
+		t.Fatal(err)
+	}
+
+	// The catalog holds a default value that is not an integer.
+	fcat, err := record.NewTableScan(tx, "fldcat", tm.fcatLayout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for fcat.Next() {
+		name, _ := fcat.ReadString("tblname")
+		if name == "T" {
+			if err := fcat.WriteString("defaultval", "seven"); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	fcat.Close()
+
+	if _, err := tm.GetLayout("T", tx); !errors.Is(err, ErrDefaultType) {
+		t.Errorf("GetLayout: got error %v, want %v", err, ErrDefaultType)
+	}
+}
+
+// getLayout returns the layout get finds for the table, failing the test if
+// it returns an error.
+func getLayout(t *testing.T, get func(string, *transaction.Transaction) (*record.Layout, error), tableName string, tx *transaction.Transaction) *record.Layout {
+	t.Helper()
+	layout, err := get(tableName, tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return layout
+}
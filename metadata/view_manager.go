@@ -1,16 +1,24 @@
 package metadata
 
 import (
+	"errors"
+	"slices"
+	"strings"
+	"unicode"
+
 	"simpledb/record"
 	"simpledb/transaction"
 )
 
-// View definitions are stored as varchar strings.
-// The current limit of 100 characters is completely unrealistic,
-// as a view definition could be thousands of characters long.
-// A better choice would be to implement the ViewDef field as a clob type, such as clob(9999).
+// View definitions are stored as Text, so that they can be of any length.
+// Definitions of up to maxViewDef bytes are stored in the catalog record,
+// and longer ones in overflow blocks.
 const maxViewDef int32 = 100
 
+// ErrViewNotFound is returned when dropping a view that is not in the
+// catalog.
+var ErrViewNotFound = errors.New("metadata: view not found")
+
 type ViewManager struct {
 	tableManager *TableManager
 }
@@ -19,14 +27,17 @@ func NewViewManager(isNew bool, tableManager *TableManager, tx *transaction.Tran
 	if isNew {
 		schema := record.NewSchema()
 		schema.AddStringField("viewname", maxName)
-		schema.AddStringField("viewdef", maxViewDef)
+		schema.AddField("viewdef", record.Text, maxViewDef, false)
 		tableManager.CreateTable("viewcat", schema, tx)
 	}
 	return &ViewManager{tableManager: tableManager}
 }
 
 func (vm *ViewManager) CreateView(viewName string, viewDef string, tx *transaction.Transaction) {
-	layout := vm.tableManager.GetLayout("viewcat", tx)
+	layout, err := vm.tableManager.GetLayout("viewcat", tx)
+	if err != nil {
+		return
+	}
 	tableScan, _ := record.NewTableScan(tx, "viewcat", layout)
 	tableScan.Insert()
 	tableScan.WriteString("viewname", viewName)
@@ -35,7 +46,10 @@ func (vm *ViewManager) CreateView(viewName string, viewDef string, tx *transacti
 }
 
 func (vm *ViewManager) GetViewDef(viewName string, tx *transaction.Transaction) string {
-	layout := vm.tableManager.GetLayout("viewcat", tx)
+	layout, err := vm.tableManager.GetLayout("viewcat", tx)
+	if err != nil {
+		return ""
+	}
 	tableScan, _ := record.NewTableScan(tx, "viewcat", layout)
 	for tableScan.Next() {
 		name, _ := tableScan.ReadString("viewname")
@@ -47,3 +61,68 @@ func (vm *ViewManager) GetViewDef(viewName string, tx *transaction.Transaction)
 	tableScan.Close()
 	return ""
 }
+
+// DropView removes the view from the catalog.
+func (vm *ViewManager) DropView(viewName string, tx *transaction.Transaction) error {
+	layout, err := vm.tableManager.GetLayout("viewcat", tx)
+	if err != nil {
+		return err
+	}
+	n, err := deleteRows("viewcat", layout, "viewname", viewName, tx)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrViewNotFound
+	}
+	return nil
+}
+
+// dependentViews returns the views that depend on the table or view. Views
+// are only stored as their definitions, so a view is taken to depend on
+// every name that appears as a word in its definition.
+func (vm *ViewManager) dependentViews(name string, tx *transaction.Transaction) ([]string, error) {
+	return vm.viewsMentioning(tx, name)
+}
+
+// fieldViews returns the views that depend on the field of the table: the
+// views whose definitions mention both the table and the field.
+func (vm *ViewManager) fieldViews(tableName string, fieldName string, tx *transaction.Transaction) ([]string, error) {
+	return vm.viewsMentioning(tx, tableName, fieldName)
+}
+
+// viewsMentioning returns the views, other than the first name, whose
+// definitions have every name as a word.
+func (vm *ViewManager) viewsMentioning(tx *transaction.Transaction, names ...string) ([]string, error) {
+	layout, err := vm.tableManager.GetLayout("viewcat", tx)
+	if err != nil {
+		return nil, err
+	}
+	tableScan, err := record.NewTableScan(tx, "viewcat", layout)
+	if err != nil {
+		return nil, err
+	}
+	defer tableScan.Close()
+
+	var views []string
+	for tableScan.Next() {
+		viewName, err := tableScan.ReadString("viewname")
+		if err != nil {
+			return nil, err
+		}
+		viewDef, err := tableScan.ReadString("viewdef")
+		if err != nil {
+			return nil, err
+		}
+		words := strings.FieldsFunc(viewDef, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+		})
+		if viewName == names[0] {
+			continue
+		}
+		if slices.IndexFunc(names, func(name string) bool { return !slices.Contains(words, name) }) < 0 {
+			views = append(views, viewName)
+		}
+	}
+	return views, nil
+}
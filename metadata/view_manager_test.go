@@ -1,16 +1,16 @@
 package metadata
 
 import (
+	"strings"
 	"testing"
 
 	"simpledb/server"
 )
 
 func TestViewManager(t *testing.T) {
-	directory := t.TempDir()
-
-	simpleDB := server.NewSimpleDB(directory, 400, 8)
+	simpleDB := server.NewMemorySimpleDB(400, 8)
 	tx := simpleDB.NewTx()
+	defer tx.Commit()
 
 	tm := NewTableManager(true, tx)
 	vm := NewViewManager(true, tm, tx)
@@ -22,3 +22,24 @@ func TestViewManager(t *testing.T) {
 		t.Errorf("invalid view definition: got %s, want %s", viewDef, "SELECT * FROM MyTable")
 	}
 }
+
+func TestViewManager_LongDefinition(t *testing.T) {
+	simpleDB := server.NewMemorySimpleDB(400, 8)
+	tx := simpleDB.NewTx()
+	defer tx.Commit()
+
+	tm := NewTableManager(true, tx)
+	vm := NewViewManager(true, tm, tx)
+
+	// The definition spans several overflow blocks.
+	want := "SELECT A FROM MyTable WHERE " + strings.Repeat("A = 1 OR ", 200) + "A = 2"
+	vm.CreateView("MyView", want, tx)
+	vm.CreateView("OtherView", "SELECT * FROM MyTable", tx)
+
+	if viewDef := vm.GetViewDef("MyView", tx); viewDef != want {
+		t.Errorf("invalid view definition: got %d bytes, want %d", len(viewDef), len(want))
+	}
+	if viewDef := vm.GetViewDef("OtherView", tx); viewDef != "SELECT * FROM MyTable" {
+		t.Errorf("invalid view definition: got %s, want %s", viewDef, "SELECT * FROM MyTable")
+	}
+}
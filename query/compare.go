@@ -0,0 +1,102 @@
+package query
+
+import (
+	"bytes"
+	"cmp"
+	"strings"
+	"time"
+
+	"simpledb/decimal"
+)
+
+// CompareValues compares two non-NULL field values, and returns -1, 0 or +1
+// as a is less than, equal to or greater than b. Integers of either width,
+// decimals and doubles are compared numerically with each other, exactly
+// unless a double is involved. False orders before true, and times are
+// compared as instants, and byte strings byte by byte. The second result is false if the values are of
+// types that cannot be compared.
+func CompareValues(a any, b any) (int, bool) {
+	switch a := a.(type) {
+	case int32, int64, float64, decimal.Decimal:
+		return compareNumbers(a, b)
+	case string:
+		if b, ok := b.(string); ok {
+			return strings.Compare(a, b), true
+		}
+	case []byte:
+		if b, ok := b.([]byte); ok {
+			return bytes.Compare(a, b), true
+		}
+	case bool:
+		if b, ok := b.(bool); ok {
+			return compareBools(a, b), true
+		}
+	case time.Time:
+		if b, ok := b.(time.Time); ok {
+			return a.Compare(b), true
+		}
+	}
+	return 0, false
+}
+
+func compareNumbers(a any, b any) (int, bool) {
+	ai, aIsInt := asInt64(a)
+	bi, bIsInt := asInt64(b)
+	if aIsInt && bIsInt {
+		return cmp.Compare(ai, bi), true
+	}
+
+	ad, aIsDecimal := asDecimal(a)
+	bd, bIsDecimal := asDecimal(b)
+	if aIsDecimal && bIsDecimal {
+		return ad.Cmp(bd), true
+	}
+
+	af, ok := asFloat64(a)
+	if !ok {
+		return 0, false
+	}
+	bf, ok := asFloat64(b)
+	if !ok {
+		return 0, false
+	}
+	return cmp.Compare(af, bf), true
+}
+
+func asInt64(v any) (int64, bool) {
+	switch v := v.(type) {
+	case int32:
+		return int64(v), true
+	case int64:
+		return v, true
+	}
+	return 0, false
+}
+
+// asDecimal converts an integer or a decimal to a decimal.
+func asDecimal(v any) (decimal.Decimal, bool) {
+	if n, ok := asInt64(v); ok {
+		return decimal.New(n, 0), true
+	}
+	d, ok := v.(decimal.Decimal)
+	return d, ok
+}
+
+func asFloat64(v any) (float64, bool) {
+	if d, ok := asDecimal(v); ok {
+		return d.Float64(), true
+	}
+	f, ok := v.(float64)
+	return f, ok
+}
+
+func compareBools(a bool, b bool) int {
+	switch {
+	case a == b:
+		return 0
+	case b:
+		return -1
+	default:
+		return 1
+	}
+}
@@ -0,0 +1,43 @@
+package query
+
+import (
+	"testing"
+	"time"
+
+	"simpledb/decimal"
+)
+
+func TestCompareValues(t *testing.T) {
+	now := time.Now()
+
+	testCases := []struct {
+		name   string
+		a, b   any
+		want   int
+		wantOK bool
+	}{
+		{"Integers", int32(1), int32(2), -1, true},
+		{"Integers of different widths", int64(1 << 40), int32(7), 1, true},
+		{"Integer and double", int32(2), 2.0, 0, true},
+		{"Doubles", -0.5, 0.25, -1, true},
+		{"Strings", "b", "a", 1, true},
+		{"Booleans", false, true, -1, true},
+		{"Same instant in different zones", now, now.UTC(), 0, true},
+		{"Times", now, now.Add(time.Microsecond), -1, true},
+		{"Decimals of different scales", decimal.New(110, 2), decimal.New(11, 1), 0, true},
+		{"Decimal and integer", decimal.New(-199, 2), int32(-2), 1, true},
+		{"Decimal beyond double precision", decimal.New(1<<62+1, 0), int64(1 << 62), 1, true},
+		{"Decimal and double", decimal.New(5, 1), 0.25, 1, true},
+		{"Integer and string", int32(1), "1", 0, false},
+		{"Boolean and integer", true, int32(1), 0, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, ok := CompareValues(tc.a, tc.b)
+			if ok != tc.wantOK || got != tc.want {
+				t.Errorf("CompareValues(%v, %v) = %d, %v, want %d, %v", tc.a, tc.b, got, ok, tc.want, tc.wantOK)
+			}
+		})
+	}
+}
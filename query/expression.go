@@ -1,12 +1,36 @@
 package query
 
-import "simpledb/record"
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"simpledb/decimal"
+	"simpledb/record"
+)
+
+// ArithmeticOperator is the operation an arithmetic expression applies to
+// its two operands.
+type ArithmeticOperator int
+
+const (
+	Plus ArithmeticOperator = iota
+	Minus
+	Times
+)
 
 type Expression struct {
 	constant  any
 	fieldName *string
+	// operands holds the two operands of an arithmetic expression.
+	operands []Expression
+	op       ArithmeticOperator
 }
 
+// NewExpressionWithValue creates a constant expression. A nil value is the
+// NULL constant.
 func NewExpressionWithValue(value any) Expression {
 	return Expression{
 		constant: value,
@@ -19,10 +43,24 @@ func NewExpressionWithFieldName(fieldName string) Expression {
 	}
 }
 
+// NewArithmeticExpression creates an expression that applies the operator
+// to the values of the two expressions.
+func NewArithmeticExpression(lhs Expression, op ArithmeticOperator, rhs Expression) Expression {
+	return Expression{
+		operands: []Expression{lhs, rhs},
+		op:       op,
+	}
+}
+
 func (e Expression) IsFieldName() bool {
 	return e.fieldName != nil
 }
 
+// IsConstant reports whether the expression is a constant, including NULL.
+func (e Expression) IsConstant() bool {
+	return e.fieldName == nil && e.operands == nil
+}
+
 func (e Expression) AsConstant() any {
 	return e.constant
 }
@@ -31,8 +69,13 @@ func (e Expression) AsFieldName() string {
 	return *e.fieldName
 }
 
+// Evaluate returns the value of the expression for the current record of
+// the scan, or nil if it is NULL.
 func (e Expression) Evaluate(scan Scan) any {
-	if e.constant != nil {
+	if e.operands != nil {
+		return evaluateArithmetic(e.op, e.operands[0].Evaluate(scan), e.operands[1].Evaluate(scan))
+	}
+	if e.fieldName == nil {
 		return e.constant
 	}
 	val, _ := scan.ReadValue(*e.fieldName)
@@ -40,8 +83,127 @@ func (e Expression) Evaluate(scan Scan) any {
 }
 
 func (e Expression) AppliesTo(schema *record.Schema) bool {
-	if e.constant != nil {
+	if e.operands != nil {
+		return e.operands[0].AppliesTo(schema) && e.operands[1].AppliesTo(schema)
+	}
+	if e.fieldName == nil {
 		return true
 	}
 	return schema.HasField(*e.fieldName)
 }
+
+// renameField returns the expression with the field renamed.
+func (e Expression) renameField(oldName string, newName string) Expression {
+	if e.operands != nil {
+		return NewArithmeticExpression(e.operands[0].renameField(oldName, newName), e.op, e.operands[1].renameField(oldName, newName))
+	}
+	if e.fieldName != nil && *e.fieldName == oldName {
+		return NewExpressionWithFieldName(newName)
+	}
+	return e
+}
+
+// String formats the expression as ParsePredicate reads it. Arithmetic
+// expressions are enclosed in parentheses.
+func (e Expression) String() string {
+	if e.operands != nil {
+		return fmt.Sprintf("(%s %s %s)", e.operands[0], e.op, e.operands[1])
+	}
+	if e.fieldName != nil {
+		return *e.fieldName
+	}
+	return formatConstant(e.constant)
+}
+
+func (op ArithmeticOperator) String() string {
+	switch op {
+	case Plus:
+		return "+"
+	case Minus:
+		return "-"
+	default:
+		return "*"
+	}
+}
+
+// formatConstant formats a constant as a literal of its type. Decimals
+// always have a point, and doubles an exponent, so that they are read back
+// as the same type.
+func formatConstant(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return "NULL"
+	case int32:
+		return strconv.FormatInt(int64(v), 10)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'e', -1, 64)
+	case decimal.Decimal:
+		s := v.String()
+		if !strings.Contains(s, ".") {
+			s += "."
+		}
+		return s
+	case bool:
+		if v {
+			return "TRUE"
+		}
+		return "FALSE"
+	case string:
+		return "'" + strings.ReplaceAll(v, "'", "''") + "'"
+	case []byte:
+		return "X'" + hex.EncodeToString(v) + "'"
+	case time.Time:
+		return "TIMESTAMP '" + v.Format(time.RFC3339Nano) + "'"
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// evaluateArithmetic applies the operator to two values. Integers and
+// decimals are computed exactly: the result of two integers is an int64,
+// or a decimal if it does not fit in one, and a decimal otherwise. If
+// either value is a double, so is the result. The result is NULL if either
+// value is NULL or is not a number.
+func evaluateArithmetic(op ArithmeticOperator, a any, b any) any {
+	ad, aIsDecimal := asDecimal(a)
+	bd, bIsDecimal := asDecimal(b)
+	if aIsDecimal && bIsDecimal {
+		var res decimal.Decimal
+		switch op {
+		case Plus:
+			res = ad.Add(bd)
+		case Minus:
+			res = ad.Sub(bd)
+		case Times:
+			res = ad.Mul(bd)
+		}
+
+		_, aIsInt := asInt64(a)
+		_, bIsInt := asInt64(b)
+		if aIsInt && bIsInt {
+			if n, err := res.Unscaled(); err == nil {
+				return n
+			}
+		}
+		return res
+	}
+
+	af, ok := asFloat64(a)
+	if !ok {
+		return nil
+	}
+	bf, ok := asFloat64(b)
+	if !ok {
+		return nil
+	}
+	switch op {
+	case Plus:
+		return af + bf
+	case Minus:
+		return af - bf
+	default:
+		return af * bf
+	}
+}
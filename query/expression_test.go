@@ -0,0 +1,43 @@
+package query
+
+import (
+	"math"
+	"testing"
+
+	"simpledb/decimal"
+)
+
+func TestExpression_Arithmetic(t *testing.T) {
+	constant := NewExpressionWithValue
+	price := decimal.New(2147483647, 2) // 21474836.47
+
+	testCases := []struct {
+		name string
+		expr Expression
+		want any
+	}{
+		{"Integers", NewArithmeticExpression(constant(int32(2)), Times, constant(int64(3))), int64(6)},
+		{"Integer overflow", NewArithmeticExpression(constant(int64(math.MaxInt64)), Plus, constant(int32(1))), decimal.New(math.MaxInt64, 0).Add(decimal.New(1, 0))},
+		{"Decimals", NewArithmeticExpression(constant(price), Plus, constant(price)), decimal.New(4294967294, 2)},
+		{"Decimal and integer", NewArithmeticExpression(constant(price), Times, constant(int32(3))), decimal.New(6442450941, 2)},
+		{"Decimal and double", NewArithmeticExpression(constant(decimal.New(5, 1)), Minus, constant(0.25)), 0.25},
+		{"Nested", NewArithmeticExpression(NewArithmeticExpression(constant(int32(1)), Plus, constant(int32(2))), Minus, constant(int32(4))), int64(-1)},
+		{"NULL operand", NewArithmeticExpression(constant(nil), Plus, constant(int32(1))), nil},
+		{"Non-numeric operand", NewArithmeticExpression(constant("a"), Plus, constant(int32(1))), nil},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := tc.expr.Evaluate(nil)
+			if want, ok := tc.want.(decimal.Decimal); ok {
+				if d, ok := got.(decimal.Decimal); !ok || d.Cmp(want) != 0 {
+					t.Errorf("Evaluate() = %v, want %v", got, want)
+				}
+				return
+			}
+			if got != tc.want {
+				t.Errorf("Evaluate() = %v (%T), want %v (%T)", got, got, tc.want, tc.want)
+			}
+		})
+	}
+}
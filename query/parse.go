@@ -0,0 +1,298 @@
+package query
+
+import (
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"simpledb/decimal"
+)
+
+// ErrSyntax is returned when parsing a string that is not a predicate.
+var ErrSyntax = errors.New("query: invalid syntax")
+
+// ParsePredicate parses a predicate written as Predicate.String formats it:
+// terms comparing two expressions with =, <>, <, <=, > or >=, joined by
+// AND. Expressions are field names, constants and arithmetic with +, - and
+// *, where * binds tighter and parentheses group. Constants are NULL, TRUE,
+// FALSE, integers, decimals such as 12.50, doubles such as 1.5e3, strings
+// in single quotes, in which a quote is written twice, byte strings such as
+// X'00ff', and times such as TIMESTAMP '2024-01-02T15:04:05Z'. Keywords are
+// not case-sensitive.
+func ParsePredicate(s string) (*Predicate, error) {
+	p := &parser{s: s}
+	pred := &Predicate{}
+	for {
+		term, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+		pred.terms = append(pred.terms, term)
+		if !p.keyword("AND") {
+			break
+		}
+	}
+	p.skipSpace()
+	if p.pos != len(p.s) {
+		return nil, ErrSyntax
+	}
+	return pred, nil
+}
+
+// parser reads a predicate from a string, from left to right.
+type parser struct {
+	s   string
+	pos int
+}
+
+func (p *parser) term() (Term, error) {
+	lhs, err := p.expression()
+	if err != nil {
+		return Term{}, err
+	}
+	var op Operator
+	switch {
+	case p.symbol("<="):
+		op = LessOrEqual
+	case p.symbol(">="):
+		op = GreaterOrEqual
+	case p.symbol("<>"), p.symbol("!="):
+		op = NotEqual
+	case p.symbol("<"):
+		op = Less
+	case p.symbol(">"):
+		op = Greater
+	case p.symbol("="):
+		op = Equal
+	default:
+		return Term{}, ErrSyntax
+	}
+	rhs, err := p.expression()
+	if err != nil {
+		return Term{}, err
+	}
+	return NewComparisonTerm(lhs, op, rhs), nil
+}
+
+// expression reads a sum of products.
+func (p *parser) expression() (Expression, error) {
+	lhs, err := p.product()
+	if err != nil {
+		return Expression{}, err
+	}
+	for {
+		var op ArithmeticOperator
+		switch {
+		case p.symbol("+"):
+			op = Plus
+		case p.symbol("-"):
+			op = Minus
+		default:
+			return lhs, nil
+		}
+		rhs, err := p.product()
+		if err != nil {
+			return Expression{}, err
+		}
+		lhs = NewArithmeticExpression(lhs, op, rhs)
+	}
+}
+
+func (p *parser) product() (Expression, error) {
+	lhs, err := p.operand()
+	if err != nil {
+		return Expression{}, err
+	}
+	for p.symbol("*") {
+		rhs, err := p.operand()
+		if err != nil {
+			return Expression{}, err
+		}
+		lhs = NewArithmeticExpression(lhs, Times, rhs)
+	}
+	return lhs, nil
+}
+
+func (p *parser) operand() (Expression, error) {
+	p.skipSpace()
+	if p.symbol("(") {
+		expr, err := p.expression()
+		if err != nil {
+			return Expression{}, err
+		}
+		if !p.symbol(")") {
+			return Expression{}, ErrSyntax
+		}
+		return expr, nil
+	}
+	if p.pos < len(p.s) && p.s[p.pos] == '\'' {
+		s, err := p.quoted()
+		if err != nil {
+			return Expression{}, err
+		}
+		return NewExpressionWithValue(s), nil
+	}
+	if p.startsNumber() {
+		return p.number()
+	}
+
+	word := p.word()
+	switch strings.ToUpper(word) {
+	case "":
+		return Expression{}, ErrSyntax
+	case "NULL":
+		return NewExpressionWithValue(nil), nil
+	case "TRUE":
+		return NewExpressionWithValue(true), nil
+	case "FALSE":
+		return NewExpressionWithValue(false), nil
+	case "TIMESTAMP":
+		p.skipSpace()
+		s, err := p.quoted()
+		if err != nil {
+			return Expression{}, err
+		}
+		t, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return Expression{}, ErrSyntax
+		}
+		return NewExpressionWithValue(t), nil
+	case "X":
+		if p.pos < len(p.s) && p.s[p.pos] == '\'' {
+			s, err := p.quoted()
+			if err != nil {
+				return Expression{}, err
+			}
+			b, err := hex.DecodeString(s)
+			if err != nil {
+				return Expression{}, ErrSyntax
+			}
+			return NewExpressionWithValue(b), nil
+		}
+	}
+	return NewExpressionWithFieldName(word), nil
+}
+
+// startsNumber reports whether a number, possibly negative, is next.
+func (p *parser) startsNumber() bool {
+	i := p.pos
+	if i < len(p.s) && p.s[i] == '-' {
+		i++
+	}
+	return i < len(p.s) && '0' <= p.s[i] && p.s[i] <= '9'
+}
+
+// number reads an integer, a decimal or a double. An integer too large for
+// an int64 is read as a decimal.
+func (p *parser) number() (Expression, error) {
+	start := p.pos
+	if p.s[p.pos] == '-' {
+		p.pos++
+	}
+	isDecimal, isDouble := false, false
+	for p.pos < len(p.s) {
+		c := p.s[p.pos]
+		switch {
+		case '0' <= c && c <= '9':
+		case c == '.' && !isDecimal && !isDouble:
+			isDecimal = true
+		case (c == 'e' || c == 'E') && !isDouble:
+			isDouble = true
+			if p.pos+1 < len(p.s) && (p.s[p.pos+1] == '+' || p.s[p.pos+1] == '-') {
+				p.pos++
+			}
+		default:
+			return p.numberValue(p.s[start:p.pos], isDecimal, isDouble)
+		}
+		p.pos++
+	}
+	return p.numberValue(p.s[start:], isDecimal, isDouble)
+}
+
+func (p *parser) numberValue(s string, isDecimal bool, isDouble bool) (Expression, error) {
+	if isDouble {
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return Expression{}, ErrSyntax
+		}
+		return NewExpressionWithValue(f), nil
+	}
+	if !isDecimal {
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return NewExpressionWithValue(n), nil
+		}
+	}
+	d, err := decimal.Parse(s)
+	if err != nil {
+		return Expression{}, ErrSyntax
+	}
+	return NewExpressionWithValue(d), nil
+}
+
+// quoted reads a string enclosed in single quotes, in which a quote is
+// written twice.
+func (p *parser) quoted() (string, error) {
+	if p.pos >= len(p.s) || p.s[p.pos] != '\'' {
+		return "", ErrSyntax
+	}
+	p.pos++
+	var sb strings.Builder
+	for p.pos < len(p.s) {
+		c := p.s[p.pos]
+		p.pos++
+		if c != '\'' {
+			sb.WriteByte(c)
+			continue
+		}
+		if p.pos < len(p.s) && p.s[p.pos] == '\'' {
+			sb.WriteByte(c)
+			p.pos++
+			continue
+		}
+		return sb.String(), nil
+	}
+	return "", ErrSyntax
+}
+
+// word reads a name made of letters, digits and underscores.
+func (p *parser) word() string {
+	p.skipSpace()
+	start := p.pos
+	for p.pos < len(p.s) {
+		c := rune(p.s[p.pos])
+		if c != '_' && !unicode.IsLetter(c) && !unicode.IsDigit(c) {
+			break
+		}
+		p.pos++
+	}
+	return p.s[start:p.pos]
+}
+
+// keyword reads the keyword if it is next.
+func (p *parser) keyword(keyword string) bool {
+	start := p.pos
+	if strings.EqualFold(p.word(), keyword) {
+		return true
+	}
+	p.pos = start
+	return false
+}
+
+// symbol reads the symbol if it is next.
+func (p *parser) symbol(symbol string) bool {
+	p.skipSpace()
+	if strings.HasPrefix(p.s[p.pos:], symbol) {
+		p.pos += len(symbol)
+		return true
+	}
+	return false
+}
+
+func (p *parser) skipSpace() {
+	for p.pos < len(p.s) && unicode.IsSpace(rune(p.s[p.pos])) {
+		p.pos++
+	}
+}
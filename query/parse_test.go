@@ -0,0 +1,127 @@
+package query
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"simpledb/decimal"
+)
+
+func TestParsePredicate(t *testing.T) {
+	testCases := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"Comparison", "price >= 0", "price >= 0"},
+		{"Conjunction", "lo<=hi and hi <> 10", "lo <= hi AND hi <> 10"},
+		{"Precedence", "a + b * 2 - 1 = c", "((a + (b * 2)) - 1) = c"},
+		{"Parentheses", "(a + b) * 2 != -3", "((a + b) * 2) <> -3"},
+		{"Negative operand", "a - -5 > 0", "(a - -5) > 0"},
+		{"Decimal", "price < 19.990", "price < 19.990"},
+		{"Double", "ratio < 1.5E3", "ratio < 1.5e+03"},
+		{"Huge integer", "n < 99999999999999999999", "n < 99999999999999999999."},
+		{"String", "name = 'it''s'", "name = 'it''s'"},
+		{"Constants", "flag = TRUE AND note = null", "flag = TRUE AND note = NULL"},
+		{"Byte string", "data = X'00FF'", "data = X'00ff'"},
+		{"Time", "at > timestamp '2024-01-02T15:04:05.5Z'", "at > TIMESTAMP '2024-01-02T15:04:05.5Z'"},
+		{"Field named x", "x*x > 1", "(x * x) > 1"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			pred, err := ParsePredicate(tc.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := pred.String(); got != tc.want {
+				t.Errorf("ParsePredicate(%q).String() = %q, want %q", tc.input, got, tc.want)
+			}
+			// The formatted predicate is read back the same.
+			again, err := ParsePredicate(pred.String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := again.String(); got != tc.want {
+				t.Errorf("ParsePredicate(%q).String() = %q, want %q", pred.String(), got, tc.want)
+			}
+		})
+	}
+}
+
+func TestParsePredicate_Constants(t *testing.T) {
+	at := time.Date(2024, 1, 2, 15, 4, 5, 500000000, time.UTC)
+
+	testCases := []struct {
+		name  string
+		value any
+	}{
+		{"Integer", int64(-42)},
+		{"Decimal", decimal.New(1999, 2)},
+		{"Decimal without fraction", decimal.New(7, 0)},
+		{"Double", 0.1},
+		{"String", "a 'quoted' AND b"},
+		{"Boolean", false},
+		{"Bytes", []byte{0, 1, 255}},
+		{"Time", at},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			pred := NewPredicate(NewTerm(NewExpressionWithFieldName("f"), NewExpressionWithValue(tc.value)))
+			parsed, err := ParsePredicate(pred.String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := parsed.EquatesWithConstant("f")
+			switch want := tc.value.(type) {
+			case []byte:
+				if b, ok := got.([]byte); !ok || !bytes.Equal(b, want) {
+					t.Errorf("parsed constant = %v, want %v", got, want)
+				}
+			case decimal.Decimal, time.Time:
+				if c, ok := CompareValues(got, want); !ok || c != 0 {
+					t.Errorf("parsed constant = %v (%T), want %v (%T)", got, got, want, want)
+				}
+			default:
+				if got != want {
+					t.Errorf("parsed constant = %v (%T), want %v (%T)", got, got, want, want)
+				}
+			}
+		})
+	}
+}
+
+func TestParsePredicate_Errors(t *testing.T) {
+	for _, input := range []string{
+		"",
+		"a",
+		"a = ",
+		"a = b AND",
+		"a = 'open",
+		"(a + b = c",
+		"a = b c",
+		"a = X'0g'",
+		"a = TIMESTAMP 'yesterday'",
+		"a = 1e",
+	} {
+		if _, err := ParsePredicate(input); err != ErrSyntax {
+			t.Errorf("ParsePredicate(%q) error = %v, want %v", input, err, ErrSyntax)
+		}
+	}
+}
+
+func TestPredicate_RenameField(t *testing.T) {
+	pred, err := ParsePredicate("lo <= hi AND lo * 2 > 0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "low <= hi AND (low * 2) > 0"
+	if got := pred.RenameField("lo", "low").String(); got != want {
+		t.Errorf("RenameField() = %q, want %q", got, want)
+	}
+	if got := pred.String(); got != "lo <= hi AND (lo * 2) > 0" {
+		t.Errorf("RenameField() changed the predicate: got %q", got)
+	}
+}
@@ -1,6 +1,10 @@
 package query
 
-import "simpledb/record"
+import (
+	"strings"
+
+	"simpledb/record"
+)
 
 type Predicate struct {
 	terms []Term
@@ -16,15 +20,57 @@ func (p *Predicate) ConjoinWith(pred *Predicate) {
 	p.terms = append(p.terms, pred.terms...)
 }
 
+// Evaluate returns the conjunction of the terms for the current record of
+// the scan. It is False if any term is False, and otherwise Unknown if any
+// term is Unknown.
+func (p *Predicate) Evaluate(scan Scan) Truth {
+	res := True
+	for _, term := range p.terms {
+		switch term.Evaluate(scan) {
+		case False:
+			return False
+		case Unknown:
+			res = Unknown
+		}
+	}
+	return res
+}
+
+// IsSatisfied reports whether the predicate is true for the current record
+// of the scan. Records for which it is Unknown are not selected.
 func (p *Predicate) IsSatisfied(scan Scan) bool {
+	return p.Evaluate(scan) == True
+}
+
+// AppliesTo reports whether every term of the predicate applies to the
+// schema, so that it can be evaluated for its records.
+func (p *Predicate) AppliesTo(schema *record.Schema) bool {
 	for _, term := range p.terms {
-		if !term.IsSatisfied(scan) {
+		if !term.AppliesTo(schema) {
 			return false
 		}
 	}
 	return true
 }
 
+// RenameField returns the predicate with the field renamed in its terms.
+func (p *Predicate) RenameField(oldName string, newName string) *Predicate {
+	res := &Predicate{}
+	for _, term := range p.terms {
+		res.terms = append(res.terms, term.renameField(oldName, newName))
+	}
+	return res
+}
+
+// String formats the predicate as ParsePredicate reads it.
+func (p *Predicate) String() string {
+	terms := make([]string, len(p.terms))
+	for i, term := range p.terms {
+		terms[i] = term.String()
+	}
+	return strings.Join(terms, " AND ")
+}
+
 // TODO
 // func (p *Predicate) ReductionFactor() int32 {}
 
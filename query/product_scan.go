@@ -1,5 +1,11 @@
 package query
 
+import (
+	"time"
+
+	"simpledb/decimal"
+)
+
 type ProductScan struct {
 	scan1 Scan
 	scan2 Scan
@@ -40,6 +46,48 @@ func (ps *ProductScan) ReadString(fieldName string) (string, error) {
 	return ps.scan2.ReadString(fieldName)
 }
 
+func (ps *ProductScan) ReadInt64(fieldName string) (int64, error) {
+	if ps.scan1.HasField(fieldName) {
+		return ps.scan1.ReadInt64(fieldName)
+	}
+	return ps.scan2.ReadInt64(fieldName)
+}
+
+func (ps *ProductScan) ReadFloat64(fieldName string) (float64, error) {
+	if ps.scan1.HasField(fieldName) {
+		return ps.scan1.ReadFloat64(fieldName)
+	}
+	return ps.scan2.ReadFloat64(fieldName)
+}
+
+func (ps *ProductScan) ReadBool(fieldName string) (bool, error) {
+	if ps.scan1.HasField(fieldName) {
+		return ps.scan1.ReadBool(fieldName)
+	}
+	return ps.scan2.ReadBool(fieldName)
+}
+
+func (ps *ProductScan) ReadTime(fieldName string) (time.Time, error) {
+	if ps.scan1.HasField(fieldName) {
+		return ps.scan1.ReadTime(fieldName)
+	}
+	return ps.scan2.ReadTime(fieldName)
+}
+
+func (ps *ProductScan) ReadDecimal(fieldName string) (decimal.Decimal, error) {
+	if ps.scan1.HasField(fieldName) {
+		return ps.scan1.ReadDecimal(fieldName)
+	}
+	return ps.scan2.ReadDecimal(fieldName)
+}
+
+func (ps *ProductScan) ReadBlob(fieldName string) ([]byte, error) {
+	if ps.scan1.HasField(fieldName) {
+		return ps.scan1.ReadBlob(fieldName)
+	}
+	return ps.scan2.ReadBlob(fieldName)
+}
+
 func (ps *ProductScan) ReadValue(fieldName string) (any, error) {
 	if ps.scan1.HasField(fieldName) {
 		return ps.scan1.ReadValue(fieldName)
@@ -47,6 +95,13 @@ func (ps *ProductScan) ReadValue(fieldName string) (any, error) {
 	return ps.scan2.ReadValue(fieldName)
 }
 
+func (ps *ProductScan) IsNull(fieldName string) (bool, error) {
+	if ps.scan1.HasField(fieldName) {
+		return ps.scan1.IsNull(fieldName)
+	}
+	return ps.scan2.IsNull(fieldName)
+}
+
 func (ps *ProductScan) HasField(fieldName string) bool {
 	return ps.scan1.HasField(fieldName) || ps.scan2.HasField(fieldName)
 }
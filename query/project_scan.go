@@ -1,6 +1,11 @@
 package query
 
-import "errors"
+import (
+	"errors"
+	"time"
+
+	"simpledb/decimal"
+)
 
 type ProjectScan struct {
 	scan   Scan
@@ -38,6 +43,48 @@ func (ps *ProjectScan) ReadString(fieldName string) (string, error) {
 	return "", ErrFieldNotFound
 }
 
+func (ps *ProjectScan) ReadInt64(fieldName string) (int64, error) {
+	if ps.HasField(fieldName) {
+		return ps.scan.ReadInt64(fieldName)
+	}
+	return 0, ErrFieldNotFound
+}
+
+func (ps *ProjectScan) ReadFloat64(fieldName string) (float64, error) {
+	if ps.HasField(fieldName) {
+		return ps.scan.ReadFloat64(fieldName)
+	}
+	return 0, ErrFieldNotFound
+}
+
+func (ps *ProjectScan) ReadBool(fieldName string) (bool, error) {
+	if ps.HasField(fieldName) {
+		return ps.scan.ReadBool(fieldName)
+	}
+	return false, ErrFieldNotFound
+}
+
+func (ps *ProjectScan) ReadTime(fieldName string) (time.Time, error) {
+	if ps.HasField(fieldName) {
+		return ps.scan.ReadTime(fieldName)
+	}
+	return time.Time{}, ErrFieldNotFound
+}
+
+func (ps *ProjectScan) ReadDecimal(fieldName string) (decimal.Decimal, error) {
+	if ps.HasField(fieldName) {
+		return ps.scan.ReadDecimal(fieldName)
+	}
+	return decimal.Decimal{}, ErrFieldNotFound
+}
+
+func (ps *ProjectScan) ReadBlob(fieldName string) ([]byte, error) {
+	if ps.HasField(fieldName) {
+		return ps.scan.ReadBlob(fieldName)
+	}
+	return nil, ErrFieldNotFound
+}
+
 func (ps *ProjectScan) ReadValue(fieldName string) (any, error) {
 	if ps.HasField(fieldName) {
 		return ps.scan.ReadValue(fieldName)
@@ -45,6 +92,13 @@ func (ps *ProjectScan) ReadValue(fieldName string) (any, error) {
 	return nil, ErrFieldNotFound
 }
 
+func (ps *ProjectScan) IsNull(fieldName string) (bool, error) {
+	if ps.HasField(fieldName) {
+		return ps.scan.IsNull(fieldName)
+	}
+	return false, ErrFieldNotFound
+}
+
 func (ps *ProjectScan) HasField(fieldName string) bool {
 	for _, fld := range ps.fields {
 		if fld == fieldName {
@@ -1,24 +1,43 @@
 package query
 
-import "simpledb/record"
+import (
+	"time"
+
+	"simpledb/decimal"
+	"simpledb/record"
+)
 
 type Scan interface {
 	BeforeFirst()
 	Next() bool
 	ReadInt32(fieldName string) (int32, error)
 	ReadString(fieldName string) (string, error)
+	ReadInt64(fieldName string) (int64, error)
+	ReadFloat64(fieldName string) (float64, error)
+	ReadBool(fieldName string) (bool, error)
+	ReadTime(fieldName string) (time.Time, error)
+	ReadDecimal(fieldName string) (decimal.Decimal, error)
+	ReadBlob(fieldName string) ([]byte, error)
 	ReadValue(fieldName string) (any, error)
+	IsNull(fieldName string) (bool, error)
 	HasField(fieldName string) bool
 	Close()
 }
 
 type UpdateScan interface {
 	Scan
-	WriteInt32(fieldName string, value int32)
-	WriteString(fieldName string, value string)
-	WriteValue(fieldName string, value any)
-	Insert()
-	Delete()
+	WriteInt32(fieldName string, value int32) error
+	WriteString(fieldName string, value string) error
+	WriteInt64(fieldName string, value int64) error
+	WriteFloat64(fieldName string, value float64) error
+	WriteBool(fieldName string, value bool) error
+	WriteTime(fieldName string, value time.Time) error
+	WriteDecimal(fieldName string, value decimal.Decimal) error
+	WriteBlob(fieldName string, value []byte) error
+	WriteValue(fieldName string, value any) error
+	SetNull(fieldName string) error
+	Insert() error
+	Delete() error
 	GetRID() *record.RID
-	MoveToRID(rid *record.RID)
+	MoveToRID(rid *record.RID) error
 }
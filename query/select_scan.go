@@ -1,6 +1,9 @@
 package query
 
 import (
+	"time"
+
+	"simpledb/decimal"
 	"simpledb/record"
 )
 
@@ -37,10 +40,38 @@ func (ss *SelectScan) ReadString(fieldName string) (string, error) {
 	return ss.scan.ReadString(fieldName)
 }
 
+func (ss *SelectScan) ReadInt64(fieldName string) (int64, error) {
+	return ss.scan.ReadInt64(fieldName)
+}
+
+func (ss *SelectScan) ReadFloat64(fieldName string) (float64, error) {
+	return ss.scan.ReadFloat64(fieldName)
+}
+
+func (ss *SelectScan) ReadBool(fieldName string) (bool, error) {
+	return ss.scan.ReadBool(fieldName)
+}
+
+func (ss *SelectScan) ReadTime(fieldName string) (time.Time, error) {
+	return ss.scan.ReadTime(fieldName)
+}
+
+func (ss *SelectScan) ReadDecimal(fieldName string) (decimal.Decimal, error) {
+	return ss.scan.ReadDecimal(fieldName)
+}
+
+func (ss *SelectScan) ReadBlob(fieldName string) ([]byte, error) {
+	return ss.scan.ReadBlob(fieldName)
+}
+
 func (ss *SelectScan) ReadValue(fieldName string) (any, error) {
 	return ss.scan.ReadValue(fieldName)
 }
 
+func (ss *SelectScan) IsNull(fieldName string) (bool, error) {
+	return ss.scan.IsNull(fieldName)
+}
+
 func (ss *SelectScan) HasField(fieldName string) bool {
 	return ss.scan.HasField(fieldName)
 }
@@ -49,30 +80,58 @@ func (ss *SelectScan) Close() {
 	ss.scan.Close()
 }
 
-func (ss *SelectScan) WriteInt32(fieldName string, value int32) {
-	ss.scan.WriteInt32(fieldName, value)
+func (ss *SelectScan) WriteInt32(fieldName string, value int32) error {
+	return ss.scan.WriteInt32(fieldName, value)
+}
+
+func (ss *SelectScan) WriteString(fieldName string, value string) error {
+	return ss.scan.WriteString(fieldName, value)
+}
+
+func (ss *SelectScan) WriteInt64(fieldName string, value int64) error {
+	return ss.scan.WriteInt64(fieldName, value)
+}
+
+func (ss *SelectScan) WriteFloat64(fieldName string, value float64) error {
+	return ss.scan.WriteFloat64(fieldName, value)
+}
+
+func (ss *SelectScan) WriteBool(fieldName string, value bool) error {
+	return ss.scan.WriteBool(fieldName, value)
+}
+
+func (ss *SelectScan) WriteTime(fieldName string, value time.Time) error {
+	return ss.scan.WriteTime(fieldName, value)
+}
+
+func (ss *SelectScan) WriteDecimal(fieldName string, value decimal.Decimal) error {
+	return ss.scan.WriteDecimal(fieldName, value)
+}
+
+func (ss *SelectScan) WriteBlob(fieldName string, value []byte) error {
+	return ss.scan.WriteBlob(fieldName, value)
 }
 
-func (ss *SelectScan) WriteString(fieldName string, value string) {
-	ss.scan.WriteString(fieldName, value)
+func (ss *SelectScan) WriteValue(fieldName string, value any) error {
+	return ss.scan.WriteValue(fieldName, value)
 }
 
-func (ss *SelectScan) WriteValue(fieldName string, value any) {
-	ss.scan.WriteValue(fieldName, value)
+func (ss *SelectScan) SetNull(fieldName string) error {
+	return ss.scan.SetNull(fieldName)
 }
 
-func (ss *SelectScan) Insert() {
-	ss.scan.Insert()
+func (ss *SelectScan) Insert() error {
+	return ss.scan.Insert()
 }
 
-func (ss *SelectScan) Delete() {
-	ss.scan.Delete()
+func (ss *SelectScan) Delete() error {
+	return ss.scan.Delete()
 }
 
 func (ss *SelectScan) GetRID() *record.RID {
 	return ss.scan.GetRID()
 }
 
-func (ss *SelectScan) MoveToRID(rid *record.RID) {
-	ss.scan.MoveToRID(rid)
+func (ss *SelectScan) MoveToRID(rid *record.RID) error {
+	return ss.scan.MoveToRID(rid)
 }
@@ -1,23 +1,120 @@
 package query
 
-import "simpledb/record"
+import (
+	"fmt"
+
+	"simpledb/record"
+)
+
+// Operator is the comparison a term makes between its two sides.
+type Operator int
+
+const (
+	Equal Operator = iota
+	NotEqual
+	Less
+	LessOrEqual
+	Greater
+	GreaterOrEqual
+)
 
 type Term struct {
 	lhs Expression
+	op  Operator
 	rhs Expression
 }
 
+// NewTerm creates a term that tests whether the two expressions are equal.
 func NewTerm(lhs Expression, rhs Expression) Term {
+	return NewComparisonTerm(lhs, Equal, rhs)
+}
+
+// NewComparisonTerm creates a term that compares the two expressions with
+// the operator.
+func NewComparisonTerm(lhs Expression, op Operator, rhs Expression) Term {
 	return Term{
 		lhs: lhs,
+		op:  op,
 		rhs: rhs,
 	}
 }
 
-func (t Term) IsSatisfied(scan Scan) bool {
+// Truth is the value of a condition under the three-valued logic of SQL,
+// where comparing with NULL is neither true nor false.
+type Truth int
+
+const (
+	False Truth = iota
+	True
+	Unknown
+)
+
+// Evaluate compares the two sides of the term for the current record of the
+// scan, as by CompareValues. The result is Unknown if either side is NULL,
+// and False if the values cannot be compared.
+func (t Term) Evaluate(scan Scan) Truth {
 	lhsVal := t.lhs.Evaluate(scan)
 	rhsVal := t.rhs.Evaluate(scan)
-	return lhsVal == rhsVal
+	if lhsVal == nil || rhsVal == nil {
+		return Unknown
+	}
+	c, ok := CompareValues(lhsVal, rhsVal)
+	if !ok {
+		return False
+	}
+
+	var res bool
+	switch t.op {
+	case Equal:
+		res = c == 0
+	case NotEqual:
+		res = c != 0
+	case Less:
+		res = c < 0
+	case LessOrEqual:
+		res = c <= 0
+	case Greater:
+		res = c > 0
+	case GreaterOrEqual:
+		res = c >= 0
+	}
+	if res {
+		return True
+	}
+	return False
+}
+
+// IsSatisfied reports whether the term is true for the current record of
+// the scan. A term whose value is Unknown is not satisfied.
+func (t Term) IsSatisfied(scan Scan) bool {
+	return t.Evaluate(scan) == True
+}
+
+// String formats the term as ParsePredicate reads it.
+func (t Term) String() string {
+	return fmt.Sprintf("%s %s %s", t.lhs, t.op, t.rhs)
+}
+
+func (op Operator) String() string {
+	switch op {
+	case Equal:
+		return "="
+	case NotEqual:
+		return "<>"
+	case Less:
+		return "<"
+	case LessOrEqual:
+		return "<="
+	case Greater:
+		return ">"
+	default:
+		return ">="
+	}
+}
+
+// renameField returns the term with the field renamed.
+func (t Term) renameField(oldName string, newName string) Term {
+	return NewComparisonTerm(t.lhs.renameField(oldName, newName), t.op, t.rhs.renameField(oldName, newName))
 }
 
 func (t Term) AppliesTo(schema *record.Schema) bool {
@@ -28,16 +125,22 @@ func (t Term) AppliesTo(schema *record.Schema) bool {
 // func (t Term) ReductionFactor()
 
 func (t Term) EquqtesWithConstant(fieldName string) any {
-	if t.lhs.IsFieldName() && t.lhs.AsFieldName() == fieldName && !t.rhs.IsFieldName() {
+	if t.op != Equal {
+		return nil
+	}
+	if t.lhs.IsFieldName() && t.lhs.AsFieldName() == fieldName && t.rhs.IsConstant() {
 		return t.rhs.AsConstant()
 	}
-	if t.rhs.IsFieldName() && t.rhs.AsFieldName() == fieldName && !t.lhs.IsFieldName() {
+	if t.rhs.IsFieldName() && t.rhs.AsFieldName() == fieldName && t.lhs.IsConstant() {
 		return t.lhs.AsConstant()
 	}
 	return nil
 }
 
 func (t Term) EquatesWithField(fieldName string) string {
+	if t.op != Equal {
+		return ""
+	}
 	if t.lhs.IsFieldName() && t.lhs.AsFieldName() == fieldName && t.rhs.IsFieldName() {
 		return t.rhs.AsFieldName()
 	}
@@ -0,0 +1,213 @@
+package record
+
+import "errors"
+
+// ErrFieldNotFound is returned when writing values to fields the table does
+// not have.
+var ErrFieldNotFound = errors.New("record: field not found")
+
+// A Check is a condition the records of a table must satisfy, such as a
+// CHECK constraint.
+type Check interface {
+	// IsViolated reports whether the current record of the scan violates
+	// the condition.
+	IsViolated(ts *TableScan) bool
+}
+
+// A ReferencedKey is told of changes to a field that records of other
+// tables refer to, such as the key a foreign key references, before the
+// scan makes them, so that it can reject them or act on the referring
+// records.
+type ReferencedKey interface {
+	// Deleting is called with the value of the field before the record
+	// holding it is deleted.
+	Deleting(value any) error
+	// Updating is called with the old value of the field before it is
+	// replaced with the new one, which may be equal to it.
+	Updating(old any, value any) error
+}
+
+// fieldKey is a referenced key on the field.
+type fieldKey struct {
+	fieldName string
+	key       ReferencedKey
+}
+
+// namedCheck is a check the scan evaluates, named after its constraint.
+type namedCheck struct {
+	name      string
+	fieldName string // the field the check is on, or "" for the whole record
+	check     Check
+}
+
+// AddCheck makes InsertValues and UpdateValues reject values that leave the
+// record violating the check with a ConstraintViolationError naming it, in
+// which case the record is left as it was. The record is checked once all
+// the values are written, since a check can compare several fields. Writes
+// of single fields are not checked, as the record may only satisfy the
+// check once its other fields are written too.
+func (ts *TableScan) AddCheck(name string, check Check) {
+	ts.checks = append(ts.checks, namedCheck{name: name, check: check})
+}
+
+// AddFieldCheck is AddCheck for a check that only depends on the field, such
+// as a foreign key. Writes of the field are checked too, and one that leaves
+// the record violating the check is rejected in the same way.
+func (ts *TableScan) AddFieldCheck(name string, fieldName string, check Check) {
+	ts.checks = append(ts.checks, namedCheck{name: name, fieldName: fieldName, check: check})
+}
+
+// AddReferencedKey makes the scan tell the key of deletions of records
+// holding a non-NULL value of the field, and of writes replacing one. If
+// the key returns an error, the record is left as it was, unless the key
+// already acted on other records.
+func (ts *TableScan) AddReferencedKey(fieldName string, key ReferencedKey) {
+	ts.keys = append(ts.keys, fieldKey{fieldName: fieldName, key: key})
+}
+
+// deleting tells the referenced keys that the record in the slot of the
+// page is about to be deleted.
+func (ts *TableScan) deleting(page *Page, slot int32) error {
+	for _, fk := range ts.keys {
+		value, err := page.value(slot, fk.fieldName)
+		if err != nil {
+			return err
+		}
+		if value == nil {
+			continue
+		}
+		if err := fk.key.Deleting(value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// updating tells the referenced keys on the field that its old value is
+// about to be replaced.
+func (ts *TableScan) updating(fieldName string, old any, value any) error {
+	if old == nil {
+		return nil
+	}
+	for _, fk := range ts.keys {
+		if fk.fieldName != fieldName {
+			continue
+		}
+		if err := fk.key.Updating(old, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// InsertValues inserts a new record holding the values, keyed by field
+// name, and the default values in its other fields. It fails with
+// ErrNotNullable if a field that cannot hold NULL has neither a value nor a
+// default value. If a value is rejected, or the record violates a check of
+// the scan, the record is deleted again and the error is returned.
+func (ts *TableScan) InsertValues(values map[string]any) error {
+	fields, err := ts.valueFields(values)
+	if err != nil {
+		return err
+	}
+	schema := ts.layout.Schema()
+	for _, fieldName := range schema.Fields() {
+		if _, ok := values[fieldName]; ok {
+			continue
+		}
+		if !schema.IsNullable(fieldName) && schema.DefaultValue(fieldName) == nil {
+			return ErrNotNullable
+		}
+	}
+	if err := ts.Insert(); err != nil {
+		return err
+	}
+	for _, fieldName := range fields {
+		if err = ts.WriteValue(fieldName, values[fieldName]); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = ts.checkRecord()
+	}
+	if err != nil {
+		if deleteErr := ts.Delete(); deleteErr != nil {
+			return deleteErr
+		}
+		return err
+	}
+	return nil
+}
+
+// UpdateValues writes the values, keyed by field name, to the current
+// record. If a value is rejected, or the record violates a check of the
+// scan, the old values are written back and the error is returned.
+func (ts *TableScan) UpdateValues(values map[string]any) error {
+	fields, err := ts.valueFields(values)
+	if err != nil {
+		return err
+	}
+	old := make([]any, len(fields))
+	for i, fieldName := range fields {
+		if old[i], err = ts.ReadValue(fieldName); err != nil {
+			return err
+		}
+	}
+
+	var written int
+	for _, fieldName := range fields {
+		if err = ts.WriteValue(fieldName, values[fieldName]); err != nil {
+			break
+		}
+		written++
+	}
+	if err == nil {
+		err = ts.checkRecord()
+	}
+	if err != nil {
+		for i := written - 1; i >= 0; i-- {
+			if undoErr := ts.WriteValue(fields[i], old[i]); undoErr != nil {
+				return undoErr
+			}
+		}
+		return err
+	}
+	return nil
+}
+
+// valueFields returns the fields of the values, in the order of the
+// schema. It fails with ErrFieldNotFound if the table lacks one of them.
+func (ts *TableScan) valueFields(values map[string]any) ([]string, error) {
+	var fields []string
+	for _, fieldName := range ts.layout.Schema().Fields() {
+		if _, ok := values[fieldName]; ok {
+			fields = append(fields, fieldName)
+		}
+	}
+	if len(fields) != len(values) {
+		return nil, ErrFieldNotFound
+	}
+	return fields, nil
+}
+
+// checkRecord returns a ConstraintViolationError naming the first check the
+// current record violates.
+func (ts *TableScan) checkRecord() error {
+	for _, nc := range ts.checks {
+		if nc.check.IsViolated(ts) {
+			return &ConstraintViolationError{Constraint: nc.name}
+		}
+	}
+	return nil
+}
+
+// checkField returns a ConstraintViolationError naming the first check on
+// the field that the current record violates.
+func (ts *TableScan) checkField(fieldName string) error {
+	for _, nc := range ts.checks {
+		if nc.fieldName == fieldName && nc.check.IsViolated(ts) {
+			return &ConstraintViolationError{Constraint: nc.name}
+		}
+	}
+	return nil
+}
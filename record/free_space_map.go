@@ -0,0 +1,110 @@
+package record
+
+import (
+	"simpledb/file"
+	"simpledb/transaction"
+)
+
+// A freeSpaceMap records which blocks of a table are full, so that inserts
+// can skip them instead of searching each one for an empty slot. The map
+// file holds an int32 per block of the table, 1 if the block is full and 0
+// otherwise, and blocks beyond the end of the map are not full.
+//
+// The map is only a hint: a block marked as not full may have been filled
+// since, and is searched before it is used. Entries are written under
+// locks on the entry alone, treated as a record of the map block, so that
+// transactions only wait for each other when they fill or free the same
+// block of the table.
+type freeSpaceMap struct {
+	tx       *transaction.Transaction
+	filename string
+}
+
+func newFreeSpaceMap(tx *transaction.Transaction, filename string) *freeSpaceMap {
+	return &freeSpaceMap{tx: tx, filename: filename}
+}
+
+// next returns the first block from the specified one on that is not marked
+// as full, or size if all blocks up to the size of the table are.
+func (m *freeSpaceMap) next(blockNum int32, size int32) (int32, error) {
+	mapSize, err := m.tx.PeekSize(m.filename)
+	if err != nil {
+		return 0, err
+	}
+
+	var block *file.Block
+	defer func() {
+		if block != nil {
+			m.tx.Unpin(block)
+		}
+	}()
+	for ; blockNum < size; blockNum++ {
+		entryBlock, pos := m.entry(blockNum)
+		if entryBlock.Number() >= mapSize {
+			return blockNum, nil
+		}
+		if block == nil || !block.Equals(entryBlock) {
+			if block != nil {
+				m.tx.Unpin(block)
+				block = nil
+			}
+			if err := m.tx.Pin(entryBlock); err != nil {
+				return 0, err
+			}
+			block = entryBlock
+		}
+		full, err := m.tx.PeekInt32(block, pos)
+		if err != nil {
+			return 0, err
+		}
+		if full == 0 {
+			return blockNum, nil
+		}
+	}
+	return size, nil
+}
+
+// setFull marks the block of the table as full or not. Nothing is written if
+// the entry already holds the value.
+func (m *freeSpaceMap) setFull(blockNum int32, full bool) error {
+	block, pos := m.entry(blockNum)
+	for {
+		mapSize, err := m.tx.PeekSize(m.filename)
+		if err != nil {
+			return err
+		}
+		if block.Number() < mapSize {
+			break
+		}
+		if !full {
+			return nil
+		}
+		// Concurrent transactions may both append a block, but the extra
+		// one only holds entries that are not full.
+		if _, err := m.tx.Append(m.filename); err != nil {
+			return err
+		}
+	}
+
+	if err := m.tx.Pin(block); err != nil {
+		return err
+	}
+	defer m.tx.Unpin(block)
+
+	var val int32
+	if full {
+		val = 1
+	}
+	old, err := m.tx.PeekInt32(block, pos)
+	if err != nil || old == val {
+		return err
+	}
+	return m.tx.WriteRecordInt32(block, pos/4, pos, val, true)
+}
+
+// entry returns the block of the map holding the entry of the block of the
+// table, and the position of the entry within it.
+func (m *freeSpaceMap) entry(blockNum int32) (*file.Block, int32) {
+	perBlock := m.tx.BlockSize() / 4
+	return file.NewBlock(m.filename, blockNum/perBlock), blockNum % perBlock * 4
+}
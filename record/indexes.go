@@ -0,0 +1,199 @@
+package record
+
+import "fmt"
+
+// An IndexWriter keeps an index on a field of a table up to date as the
+// records of the table change. Insert can reject a value, as a unique index
+// does when another record already holds it, in which case the record is
+// left as it was.
+type IndexWriter interface {
+	// Insert adds an entry for the value of the field of the record at rid.
+	Insert(value any, rid *RID) error
+	// Delete removes the entry for the value of the field of the record at
+	// rid.
+	Delete(value any, rid *RID) error
+	// Close releases the blocks the writer holds pinned. The writer can
+	// still be used afterwards.
+	Close()
+}
+
+// ConstraintViolationError is returned when a change to a record would
+// violate a constraint of its table. The record is left as it was, and the
+// transaction can go on.
+type ConstraintViolationError struct {
+	// Constraint is the name of the violated constraint.
+	Constraint string
+}
+
+func (e *ConstraintViolationError) Error() string {
+	return fmt.Sprintf("record: violation of constraint %s", e.Constraint)
+}
+
+// fieldIndex is an index the scan keeps up to date, on the field.
+type fieldIndex struct {
+	fieldName string
+	index     IndexWriter
+}
+
+// AddIndex makes the scan keep the index on the field up to date when it
+// writes the field, deletes records, or moves them to another slot. Only
+// non-NULL values are indexed, and a new record has no entries until its
+// fields are written.
+func (ts *TableScan) AddIndex(fieldName string, index IndexWriter) {
+	ts.indexes = append(ts.indexes, fieldIndex{fieldName: fieldName, index: index})
+}
+
+// indexesOn returns the indexes on the field.
+func (ts *TableScan) indexesOn(fieldName string) []IndexWriter {
+	var indexes []IndexWriter
+	for _, fi := range ts.indexes {
+		if fi.fieldName == fieldName {
+			indexes = append(indexes, fi.index)
+		}
+	}
+	return indexes
+}
+
+// writeField writes the field of the current record, updates the indexes
+// on it, and evaluates the checks on it. The referenced keys on the field
+// are told first. If an index or key rejects the value, or the record
+// violates a check on the field, the record is left as it was.
+func (ts *TableScan) writeField(fieldName string, value any, write func(page *Page, slot int32) error) error {
+	if len(ts.indexes) == 0 && len(ts.checks) == 0 && len(ts.keys) == 0 {
+		return ts.write(write)
+	}
+
+	old, err := ts.ReadValue(fieldName)
+	if err != nil {
+		return err
+	}
+	if err := ts.updating(fieldName, old, value); err != nil {
+		return err
+	}
+	if err := ts.updateField(fieldName, old, value, write); err != nil {
+		return err
+	}
+	if err := ts.checkField(fieldName); err != nil {
+		if undoErr := ts.updateField(fieldName, value, old, valueWriter(fieldName, old)); undoErr != nil {
+			return undoErr
+		}
+		return err
+	}
+	return nil
+}
+
+// updateField writes the field of the current record, replacing the entries
+// for its old value in the indexes on it. If an index rejects the value,
+// the entries for the old value are restored, and the record is left as it
+// was.
+func (ts *TableScan) updateField(fieldName string, old any, value any, write func(page *Page, slot int32) error) error {
+	rid := ts.GetRID()
+	indexes := ts.indexesOn(fieldName)
+	if err := replaceEntries(indexes, old, value, rid); err != nil {
+		return err
+	}
+	if err := ts.write(write); err != nil {
+		if undoErr := replaceEntries(indexes, value, old, rid); undoErr != nil {
+			return undoErr
+		}
+		return err
+	}
+	// Writing any field can move the record.
+	return ts.moveEntries(rid)
+}
+
+// valueWriter returns a write of the value to the field, which sets it to
+// NULL if the value is nil.
+func valueWriter(fieldName string, value any) func(page *Page, slot int32) error {
+	return func(page *Page, slot int32) error {
+		if value == nil {
+			return page.SetNull(slot, fieldName)
+		}
+		return page.writeValue(slot, fieldName, value)
+	}
+}
+
+// moveEntries makes the entries of the current record point to it, if it
+// was moved from the specified RID, as by an update that outgrew its block
+// or wrote a new version.
+func (ts *TableScan) moveEntries(from *RID) error {
+	to := ts.GetRID()
+	if to.Equals(from) {
+		return nil
+	}
+	for _, fi := range ts.indexes {
+		value, err := ts.ReadValue(fi.fieldName)
+		if err != nil {
+			return err
+		}
+		if err := moveEntry(fi.index, value, from, to); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// indexedValues returns the values of the indexed fields of the record in
+// the slot of the page, in the order of the indexes.
+func (ts *TableScan) indexedValues(page *Page, slot int32) ([]any, error) {
+	values := make([]any, len(ts.indexes))
+	for i, fi := range ts.indexes {
+		value, err := page.value(slot, fi.fieldName)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = value
+	}
+	return values, nil
+}
+
+// replaceEntries replaces the entries for the old value with entries for
+// the new one. If an index rejects the new value, the entries are left as
+// they were.
+func replaceEntries(indexes []IndexWriter, old any, value any, rid *RID) error {
+	for i, index := range indexes {
+		if err := replaceEntry(index, old, value, rid); err != nil {
+			for _, done := range indexes[:i] {
+				if undoErr := replaceEntry(done, value, old, rid); undoErr != nil {
+					return undoErr
+				}
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// replaceEntry replaces the entry for the old value with an entry for the
+// new one. The old entry is deleted first, so that a unique index does not
+// reject a value equal to it.
+func replaceEntry(index IndexWriter, old any, value any, rid *RID) error {
+	if old != nil {
+		if err := index.Delete(old, rid); err != nil {
+			return err
+		}
+	}
+	if value == nil {
+		return nil
+	}
+	if err := index.Insert(value, rid); err != nil {
+		if old != nil {
+			if undoErr := index.Insert(old, rid); undoErr != nil {
+				return undoErr
+			}
+		}
+		return err
+	}
+	return nil
+}
+
+// moveEntry makes the entry for the value point to the new RID.
+func moveEntry(index IndexWriter, value any, from *RID, to *RID) error {
+	if value == nil {
+		return nil
+	}
+	if err := index.Delete(value, from); err != nil {
+		return err
+	}
+	return index.Insert(value, to)
+}
@@ -1,30 +1,78 @@
 package record
 
+// Flags that select how the records of a table are stored.
+const (
+	// Versioned marks a multi-version table. Each slot header carries the
+	// numbers of the transactions that created and deleted the version.
+	Versioned int32 = 1 << iota
+	// Slotted marks a table whose blocks are slotted pages, which hold
+	// records of variable length. It cannot be combined with Versioned.
+	Slotted
+)
+
+// Sizes of the slot header fields.
+const (
+	flagSize    = 4
+	versionSize = 8
+)
+
 type Layout struct {
 	schema   *Schema
 	offsets  map[string]int32
 	slotSize int32
+	flags    int32
 }
 
 func NewLayout(schema *Schema) *Layout {
+	return newLayout(schema, 0)
+}
+
+// NewVersionedLayout creates a layout for a multi-version table, whose slot
+// header holds the in-use flag followed by the creating (xmin) and deleting
+// (xmax) transaction numbers.
+func NewVersionedLayout(schema *Schema) *Layout {
+	return newLayout(schema, Versioned)
+}
+
+// NewSlottedLayout creates a layout for a table stored in slotted pages,
+// where a Varchar field only takes the space of its actual value. The
+// offsets are relative to the start of the record, and a Varchar field
+// holds the position of its value within the record.
+func NewSlottedLayout(schema *Schema) *Layout {
+	return newLayout(schema, Slotted)
+}
+
+// NewLayoutWithFlags creates a layout for records stored as the flags
+// select, such as those of an existing table whose fields change.
+func NewLayoutWithFlags(schema *Schema, flags int32) *Layout {
+	return newLayout(schema, flags)
+}
+
+func newLayout(schema *Schema, flags int32) *Layout {
 	offsets := make(map[string]int32)
-	pos := int32(4)
+	pos := headerSize(flags) + int32(len(nullBitmap(schema)))*4
 	for _, fieldName := range schema.fields {
 		offsets[fieldName] = pos
-		pos += lengthInBytes(schema, fieldName)
+		if flags&Slotted != 0 && schema.FieldType(fieldName) == Varchar {
+			pos += 4
+		} else {
+			pos += lengthInBytes(schema, fieldName)
+		}
 	}
 	return &Layout{
 		schema:   schema,
 		offsets:  offsets,
 		slotSize: pos,
+		flags:    flags,
 	}
 }
 
-func NewLayoutFromMetadata(schema *Schema, offsets map[string]int32, slotSize int32) *Layout {
+func NewLayoutFromMetadata(schema *Schema, offsets map[string]int32, slotSize int32, flags int32) *Layout {
 	return &Layout{
 		schema:   schema,
 		offsets:  offsets,
 		slotSize: slotSize,
+		flags:    flags,
 	}
 }
 
@@ -36,17 +84,86 @@ func (l *Layout) Offset(fieldName string) int32 {
 	return l.offsets[fieldName]
 }
 
+// SlotSize returns the size of a record. In a slotted page, it is the size
+// of the fixed-length part of the record, which Varchar values follow.
 func (l *Layout) SlotSize() int32 {
 	return l.slotSize
 }
 
+// Flags returns the storage flags of the table.
+func (l *Layout) Flags() int32 {
+	return l.flags
+}
+
+// IsVersioned reports whether the table keeps multiple record versions.
+func (l *Layout) IsVersioned() bool {
+	return l.flags&Versioned != 0
+}
+
+// maxRecordSize returns the size of a record of a slotted page whose
+// Varchar values all have the largest length of their field.
+func (l *Layout) maxRecordSize() int32 {
+	size := l.slotSize
+	for _, fieldName := range l.schema.fields {
+		if l.schema.FieldType(fieldName) == Varchar {
+			size += lengthInBytes(l.schema, fieldName)
+		}
+	}
+	return size
+}
+
+// nullBit returns the position of the word of the null bitmap holding the
+// bit of the field, relative to the start of the slot, and the mask of the
+// bit within the word. The bitmap follows the slot header, with one bit per
+// field of the schema in order.
+func (l *Layout) nullBit(fieldName string) (int32, int32) {
+	i := int32(l.schema.fieldIndex(fieldName))
+	return headerSize(l.flags) + i/32*4, int32(uint32(1) << (i % 32))
+}
+
+// nullBitmap returns the words of the null bitmap of a record whose
+// nullable fields are all NULL. Tables without nullable fields have no
+// bitmap.
+func nullBitmap(schema *Schema) []int32 {
+	if !schema.HasNullableFields() {
+		return nil
+	}
+	words := make([]int32, (len(schema.fields)+31)/32)
+	for i, fieldName := range schema.fields {
+		if schema.IsNullable(fieldName) {
+			words[i/32] |= int32(uint32(1) << (i % 32))
+		}
+	}
+	return words
+}
+
+// IsSlotted reports whether the table is stored in slotted pages.
+func (l *Layout) IsSlotted() bool {
+	return l.flags&Slotted != 0
+}
+
+func headerSize(flags int32) int32 {
+	// The slot directory of a slotted page tells which records are in use.
+	if flags&Slotted != 0 {
+		return 0
+	}
+	if flags&Versioned != 0 {
+		return flagSize + 2*versionSize
+	}
+	return flagSize
+}
+
 func lengthInBytes(schema *Schema, fieldName string) int32 {
 	fieldType := schema.FieldType(fieldName)
 	switch fieldType {
-	case Integer:
+	case Integer, Boolean:
 		return 4
+	case BigInt, Double, Date, Timestamp, Decimal:
+		return 8
 	case Varchar:
 		return schema.FieldLength(fieldName) + 4
+	case Text, Blob:
+		return schema.FieldLength(fieldName) + 8
 	default:
 		return 0
 	}
@@ -0,0 +1,346 @@
+package record
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+
+	"simpledb/file"
+	"simpledb/transaction"
+)
+
+// A Text or Blob field holds the number of the first overflow block of its
+// value, followed by the value itself as a length-prefixed string if it is
+// no longer than the field, in which case the block number is 0:
+//
+//	+------+------------------+
+//	| head | length | inline  |
+//	+------+------------------+
+//
+// Longer values are stored in a chain of blocks of the overflow file of the
+// table, each holding the number of the next block, 0 at the end of the
+// chain, the number of bytes of the value it holds, and the bytes:
+//
+//	+------+------+---------+
+//	| next | used | data    |
+//	+------+------+---------+
+//
+// Block 0 of the overflow file holds the head of the list of free blocks,
+// which are chained the same way. A chain is freed when its value is
+// overwritten or its record deleted. In a multi-version table, each version
+// of a record has chains of its own, which older snapshots may still read
+// after the record is updated or deleted, so they are only freed when the
+// version is reclaimed.
+const (
+	freeListPos = 0
+
+	nextPos     = 0
+	usedPos     = 4
+	overflowPos = 8
+)
+
+// ReadLargeValue returns a reader of the Text or Blob field of the record in
+// the specified slot. Overflow blocks are read as the reader is consumed.
+func (p *Page) ReadLargeValue(slot int32, fieldName string) (io.Reader, error) {
+	pos, err := p.fieldPos(slot, fieldName)
+	if err != nil {
+		return nil, err
+	}
+	head, err := p.readInt32(slot, pos)
+	if err != nil {
+		return nil, err
+	}
+	if head != 0 {
+		return &overflowReader{tx: p.tx, filename: p.overflowFile(), next: head}, nil
+	}
+	inline, err := p.readString(slot, pos+4)
+	if err != nil {
+		return nil, err
+	}
+	return strings.NewReader(inline), nil
+}
+
+// WriteLargeValue writes the contents of the reader to the Text or Blob
+// field of the record in the specified slot. The value is stored in the
+// record if it is no longer than the field, and in a new overflow chain
+// otherwise.
+func (p *Page) WriteLargeValue(slot int32, fieldName string, r io.Reader) error {
+	if !p.layout.IsSlotted() {
+		if err := p.tx.XLockRecord(p.block, slot); err != nil {
+			return err
+		}
+	}
+
+	inline := make([]byte, p.layout.Schema().FieldLength(fieldName)+1)
+	n, err := io.ReadFull(r, inline)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return err
+	}
+	inline = inline[:n]
+
+	var head int32
+	if n > int(p.layout.Schema().FieldLength(fieldName)) {
+		head, err = p.writeChain(io.MultiReader(bytes.NewReader(inline), r))
+		if err != nil {
+			return err
+		}
+		inline = nil
+	}
+
+	if err := p.freeLargeValue(slot, fieldName); err != nil {
+		return err
+	}
+	pos, err := p.fieldPos(slot, fieldName)
+	if err != nil {
+		return err
+	}
+	if err := p.writeInt32(slot, pos, head); err != nil {
+		return err
+	}
+	if err := p.writeString(slot, pos+4, string(inline)); err != nil {
+		return err
+	}
+	return p.setNullBit(slot, fieldName, false)
+}
+
+// freeLargeValues frees the overflow chains of the Text and Blob fields of
+// the record in the specified slot.
+func (p *Page) freeLargeValues(slot int32) error {
+	schema := p.layout.Schema()
+	for _, fieldName := range schema.fields {
+		if fieldType := schema.FieldType(fieldName); fieldType != Text && fieldType != Blob {
+			continue
+		}
+		if err := p.freeLargeValue(slot, fieldName); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// freeLargeValue frees the overflow chain of the Text or Blob field of the
+// record in the specified slot, if it has one, and detaches it from the
+// field.
+func (p *Page) freeLargeValue(slot int32, fieldName string) error {
+	pos, err := p.fieldPos(slot, fieldName)
+	if err != nil {
+		return err
+	}
+	head, err := p.readInt32(slot, pos)
+	if err != nil || head == 0 {
+		return err
+	}
+	if err := p.freeChain(head); err != nil {
+		return err
+	}
+	return p.writeInt32(slot, pos, 0)
+}
+
+// writeChain writes the contents of the reader to a chain of overflow
+// blocks, and returns the number of its first block.
+func (p *Page) writeChain(r io.Reader) (int32, error) {
+	var head int32
+	var prev *file.Block
+	defer func() {
+		if prev != nil {
+			p.tx.Unpin(prev)
+		}
+	}()
+
+	chunk := make([]byte, p.tx.BlockSize()-overflowPos)
+	for {
+		n, err := io.ReadFull(r, chunk)
+		if errors.Is(err, io.EOF) {
+			return head, nil
+		}
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return 0, err
+		}
+
+		block, allocErr := p.allocateOverflow()
+		if allocErr != nil {
+			return 0, allocErr
+		}
+		if err := p.tx.WriteInt32(block, nextPos, 0, true); err != nil {
+			return 0, err
+		}
+		if err := p.tx.WriteInt32(block, usedPos, int32(n), true); err != nil {
+			return 0, err
+		}
+		if err := p.tx.WriteBytes(block, overflowPos, chunk[:n], true); err != nil {
+			return 0, err
+		}
+
+		if prev == nil {
+			head = block.Number()
+		} else {
+			if err := p.tx.WriteInt32(prev, nextPos, block.Number(), true); err != nil {
+				return 0, err
+			}
+			p.tx.Unpin(prev)
+		}
+		prev = block
+
+		if err != nil {
+			return head, nil
+		}
+	}
+}
+
+// allocateOverflow returns a pinned block of the overflow file for a new
+// chain, reusing a free block if there is one. The header block is locked
+// until the transaction finishes, so that the blocks it frees or allocates
+// cannot be taken by others before it commits.
+func (p *Page) allocateOverflow() (*file.Block, error) {
+	header, err := p.overflowHeader()
+	if err != nil {
+		return nil, err
+	}
+	defer p.tx.Unpin(header)
+
+	free, err := p.tx.ReadInt32(header, freeListPos)
+	if err != nil {
+		return nil, err
+	}
+	if free == 0 {
+		block, err := p.tx.Append(p.overflowFile())
+		if err != nil {
+			return nil, err
+		}
+		return block, p.tx.Pin(block)
+	}
+
+	block := file.NewBlock(p.overflowFile(), free)
+	if err := p.tx.Pin(block); err != nil {
+		return nil, err
+	}
+	next, err := p.tx.ReadInt32(block, nextPos)
+	if err != nil {
+		p.tx.Unpin(block)
+		return nil, err
+	}
+	if err := p.tx.WriteInt32(header, freeListPos, next, true); err != nil {
+		p.tx.Unpin(block)
+		return nil, err
+	}
+	return block, nil
+}
+
+// freeChain adds the chain of overflow blocks starting at head to the free
+// list.
+func (p *Page) freeChain(head int32) error {
+	header, err := p.overflowHeader()
+	if err != nil {
+		return err
+	}
+	defer p.tx.Unpin(header)
+
+	tail := file.NewBlock(p.overflowFile(), head)
+	for {
+		if err := p.tx.Pin(tail); err != nil {
+			return err
+		}
+		next, err := p.tx.ReadInt32(tail, nextPos)
+		if err != nil {
+			p.tx.Unpin(tail)
+			return err
+		}
+		if next == 0 {
+			break
+		}
+		p.tx.Unpin(tail)
+		tail = file.NewBlock(p.overflowFile(), next)
+	}
+	defer p.tx.Unpin(tail)
+
+	free, err := p.tx.ReadInt32(header, freeListPos)
+	if err != nil {
+		return err
+	}
+	if err := p.tx.WriteInt32(tail, nextPos, free, true); err != nil {
+		return err
+	}
+	return p.tx.WriteInt32(header, freeListPos, head, true)
+}
+
+// overflowHeader returns the pinned header block of the overflow file,
+// locked in X, and creates the file if it does not exist yet.
+func (p *Page) overflowHeader() (*file.Block, error) {
+	filename := p.overflowFile()
+	size, err := p.tx.Size(filename)
+	if err != nil {
+		return nil, err
+	}
+	header := file.NewBlock(filename, 0)
+	if size == 0 {
+		if header, err = p.tx.Append(filename); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.tx.Pin(header); err != nil {
+		return nil, err
+	}
+	if err := p.tx.LockBlock(header, transaction.X); err != nil {
+		p.tx.Unpin(header)
+		return nil, err
+	}
+	if size == 0 {
+		if err := p.tx.WriteInt32(header, freeListPos, 0, false); err != nil {
+			p.tx.Unpin(header)
+			return nil, err
+		}
+	}
+	return header, nil
+}
+
+// overflowFile returns the name of the file holding the overflow blocks of
+// the table.
+func (p *Page) overflowFile() string {
+	return strings.TrimSuffix(p.block.Filename(), ".tbl") + ".ovf"
+}
+
+// overflowReader reads a value from a chain of overflow blocks, one block
+// at a time.
+type overflowReader struct {
+	tx       *transaction.Transaction
+	filename string
+	next     int32
+	buf      []byte
+}
+
+func (r *overflowReader) Read(b []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.next == 0 {
+			return 0, io.EOF
+		}
+		if err := r.readBlock(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(b, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func (r *overflowReader) readBlock() error {
+	block := file.NewBlock(r.filename, r.next)
+	if err := r.tx.Pin(block); err != nil {
+		return err
+	}
+	defer r.tx.Unpin(block)
+
+	next, err := r.tx.ReadInt32(block, nextPos)
+	if err != nil {
+		return err
+	}
+	used, err := r.tx.ReadInt32(block, usedPos)
+	if err != nil {
+		return err
+	}
+	if r.buf, err = r.tx.ReadBytes(block, overflowPos, used); err != nil {
+		return err
+	}
+	r.next = next
+	return nil
+}
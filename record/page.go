@@ -1,10 +1,37 @@
 package record
 
 import (
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"strings"
+	"time"
+
+	"simpledb/decimal"
 	"simpledb/file"
 	"simpledb/transaction"
 )
 
+// Values of the in-use flag at the start of each slot.
+const (
+	empty int32 = iota
+	used
+)
+
+// ErrNotNullable is returned when NULL is written to a field that cannot
+// hold it.
+var ErrNotNullable = errors.New("record: field is not nullable")
+
+// ErrOutOfRange is returned when a value does not fit in its field, as when
+// it has more digits than its Decimal field is declared to hold, or is
+// longer than its Varchar field in a slotted page.
+var ErrOutOfRange = errors.New("record: value out of range")
+
+// Page gives access to the records stored in the slots of a block. Records
+// are locked individually, by block and slot, so that transactions can read
+// and write different records of the same block concurrently. Slotted pages
+// are the exception, and are locked as a whole.
 type Page struct {
 	tx     *transaction.Transaction
 	block  *file.Block
@@ -23,47 +50,349 @@ func NewPage(tx *transaction.Transaction, block *file.Block, layout *Layout) (*P
 }
 
 func (p *Page) ReadInt32(slot int32, fieldName string) (int32, error) {
-	pos := p.offest(slot) + p.layout.Offset(fieldName)
-	return p.tx.ReadInt32(p.block, pos)
+	pos, err := p.fieldPos(slot, fieldName)
+	if err != nil {
+		return 0, err
+	}
+	return p.readInt32(slot, pos)
 }
 
+// ReadString reads a Varchar or Text field. A Text value is read whole
+// from its overflow blocks.
 func (p *Page) ReadString(slot int32, fieldName string) (string, error) {
-	pos := p.offest(slot) + p.layout.Offset(fieldName)
-	return p.tx.ReadString(p.block, pos)
+	if p.layout.Schema().FieldType(fieldName) == Text {
+		r, err := p.ReadLargeValue(slot, fieldName)
+		if err != nil {
+			return "", err
+		}
+		var sb strings.Builder
+		_, err = io.Copy(&sb, r)
+		return sb.String(), err
+	}
+	if p.layout.IsSlotted() {
+		return p.readStringSlotted(slot, fieldName)
+	}
+	return p.readString(slot, p.offest(slot)+p.layout.Offset(fieldName))
+}
+
+func (p *Page) ReadInt64(slot int32, fieldName string) (int64, error) {
+	pos, err := p.fieldPos(slot, fieldName)
+	if err != nil {
+		return 0, err
+	}
+	return p.readInt64(slot, pos)
+}
+
+// ReadFloat64 reads a Double field, which is stored in the 8 bytes of its
+// IEEE 754 representation, as by file.Page.WriteFloat64At.
+func (p *Page) ReadFloat64(slot int32, fieldName string) (float64, error) {
+	n, err := p.ReadInt64(slot, fieldName)
+	if err != nil {
+		return 0, err
+	}
+	return math.Float64frombits(uint64(n)), nil
+}
+
+// ReadBool reads a Boolean field, which is stored as a 4-byte integer, as
+// by file.Page.WriteBoolAt.
+func (p *Page) ReadBool(slot int32, fieldName string) (bool, error) {
+	n, err := p.ReadInt32(slot, fieldName)
+	if err != nil {
+		return false, err
+	}
+	return n != 0, nil
+}
+
+// ReadTime reads a Date or Timestamp field, which is stored as the number
+// of microseconds since the Unix epoch, as by file.Page.WriteTimeAt. The
+// time is returned in UTC.
+func (p *Page) ReadTime(slot int32, fieldName string) (time.Time, error) {
+	n, err := p.ReadInt64(slot, fieldName)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.UnixMicro(n).UTC(), nil
+}
+
+// ReadDecimal reads a Decimal field, which is stored as its coefficient at
+// the scale of the field, as by file.Page.WriteDecimalAt.
+func (p *Page) ReadDecimal(slot int32, fieldName string) (decimal.Decimal, error) {
+	n, err := p.ReadInt64(slot, fieldName)
+	if err != nil {
+		return decimal.Decimal{}, err
+	}
+	return decimal.New(n, p.layout.Schema().FieldScale(fieldName)), nil
+}
+
+// ReadBlob reads a Blob field whole from its overflow blocks.
+func (p *Page) ReadBlob(slot int32, fieldName string) ([]byte, error) {
+	r, err := p.ReadLargeValue(slot, fieldName)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
 }
 
 func (p *Page) WriteInt32(slot int32, fieldName string, value int32) error {
-	pos := p.offest(slot) + p.layout.Offset(fieldName)
-	return p.tx.WriteInt32(p.block, pos, value, true)
+	pos, err := p.fieldPos(slot, fieldName)
+	if err != nil {
+		return err
+	}
+	if err := p.writeInt32(slot, pos, value); err != nil {
+		return err
+	}
+	return p.setNullBit(slot, fieldName, false)
 }
 
 func (p *Page) WriteString(slot int32, fieldName string, value string) error {
-	pos := p.offest(slot) + p.layout.Offset(fieldName)
-	return p.tx.WriteString(p.block, pos, value, true)
+	if p.layout.Schema().FieldType(fieldName) == Text {
+		return p.WriteLargeValue(slot, fieldName, strings.NewReader(value))
+	}
+
+	var err error
+	if p.layout.IsSlotted() {
+		err = p.writeStringSlotted(slot, fieldName, value)
+	} else {
+		err = p.writeString(slot, p.offest(slot)+p.layout.Offset(fieldName), value)
+	}
+	if err != nil {
+		return err
+	}
+	return p.setNullBit(slot, fieldName, false)
+}
+
+// WriteBlob writes a Blob field, storing the value in overflow blocks if it
+// is longer than the field.
+func (p *Page) WriteBlob(slot int32, fieldName string, value []byte) error {
+	return p.WriteLargeValue(slot, fieldName, bytes.NewReader(value))
 }
 
+func (p *Page) WriteInt64(slot int32, fieldName string, value int64) error {
+	pos, err := p.fieldPos(slot, fieldName)
+	if err != nil {
+		return err
+	}
+	if err := p.writeInt64(slot, pos, value); err != nil {
+		return err
+	}
+	return p.setNullBit(slot, fieldName, false)
+}
+
+func (p *Page) WriteFloat64(slot int32, fieldName string, value float64) error {
+	return p.WriteInt64(slot, fieldName, int64(math.Float64bits(value)))
+}
+
+func (p *Page) WriteBool(slot int32, fieldName string, value bool) error {
+	var n int32
+	if value {
+		n = 1
+	}
+	return p.WriteInt32(slot, fieldName, n)
+}
+
+// WriteTime writes a Date or Timestamp field. A Date keeps only the
+// calendar date of the time, in the time's location.
+func (p *Page) WriteTime(slot int32, fieldName string, value time.Time) error {
+	if p.layout.Schema().FieldType(fieldName) == Date {
+		y, m, d := value.Date()
+		value = time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+	}
+	return p.WriteInt64(slot, fieldName, value.UnixMicro())
+}
+
+// WriteDecimal writes a Decimal field. The value is rounded to the scale of
+// the field, and ErrOutOfRange is returned if it then has more digits than
+// the precision of the field.
+func (p *Page) WriteDecimal(slot int32, fieldName string, value decimal.Decimal) error {
+	schema := p.layout.Schema()
+	value = value.Rescale(schema.FieldScale(fieldName))
+	if value.Precision() > min(schema.FieldLength(fieldName), MaxDecimalPrecision) {
+		return ErrOutOfRange
+	}
+	n, err := value.Unscaled()
+	if err != nil {
+		return err
+	}
+	return p.WriteInt64(slot, fieldName, n)
+}
+
+// IsNull reports whether the field of the record in the specified slot is
+// NULL. Fields that are not nullable are never NULL.
+func (p *Page) IsNull(slot int32, fieldName string) (bool, error) {
+	if !p.layout.Schema().IsNullable(fieldName) {
+		return false, nil
+	}
+	base, err := p.recordPos(slot)
+	if err != nil {
+		return false, err
+	}
+	pos, mask := p.layout.nullBit(fieldName)
+	word, err := p.readInt32(slot, base+pos)
+	if err != nil {
+		return false, err
+	}
+	return word&mask != 0, nil
+}
+
+// SetNull sets the field of the record in the specified slot to NULL. The
+// stored value of the field is left as it is, and is meaningless until the
+// field is written again. It fails with ErrNotNullable if the field cannot
+// hold NULL.
+func (p *Page) SetNull(slot int32, fieldName string) error {
+	if !p.layout.Schema().IsNullable(fieldName) {
+		return ErrNotNullable
+	}
+	return p.setNullBit(slot, fieldName, true)
+}
+
+// Delete deletes the record in the specified slot. In a multi-version table
+// the slot is kept, and the version is marked as deleted by the transaction
+// instead, so that older snapshots can still read it. A slotted page is
+// compacted once the record is deleted. Otherwise, the overflow blocks of
+// the record are freed.
 func (p *Page) Delete(slot int32) error {
-	return p.setFlag(slot, 0)
+	if p.layout.IsVersioned() {
+		return p.expire(slot)
+	}
+	if err := p.freeLargeValues(slot); err != nil {
+		return err
+	}
+	return p.remove(slot)
+}
+
+// Version returns the numbers of the transactions that created and deleted
+// the record version in the specified slot of a multi-version table.
+// The deleting transaction number is 0 if the version is live.
+func (p *Page) Version(slot int32) (xmin int64, xmax int64, err error) {
+	xmin, err = p.readInt64(slot, p.offest(slot)+flagSize)
+	if err != nil {
+		return 0, 0, err
+	}
+	xmax, err = p.readInt64(slot, p.offest(slot)+flagSize+versionSize)
+	if err != nil {
+		return 0, 0, err
+	}
+	return xmin, xmax, nil
+}
+
+// Reclaim frees the slot of a record version that no transaction can see
+// any more, so that it can be reused by later inserts, along with the
+// overflow blocks of its values, which no other version refers to.
+func (p *Page) Reclaim(slot int32) error {
+	if err := p.freeLargeValues(slot); err != nil {
+		return err
+	}
+	return p.setFlag(slot, empty)
+}
+
+// Prune reclaims the slots of the record versions in the page that no
+// transaction can see any more, and returns how many were reclaimed.
+func (p *Page) Prune() (int32, error) {
+	var pruned int32
+	slot, err := p.searchAfter(-1, used, p.peekInt32)
+	for err == nil && slot >= 0 {
+		var obsolete bool
+		obsolete, err = p.isObsolete(slot)
+		if err != nil {
+			break
+		}
+		if obsolete {
+			if err = p.tx.XLockRecord(p.block, slot); err != nil {
+				break
+			}
+			// The deleter may have rolled back and restored the version
+			// before the lock was granted.
+			if obsolete, err = p.isObsolete(slot); err != nil {
+				break
+			}
+		}
+		if obsolete {
+			if err = p.Reclaim(slot); err != nil {
+				break
+			}
+			pruned++
+		}
+		slot, err = p.searchAfter(slot, used, p.peekInt32)
+	}
+	return pruned, err
+}
+
+// isObsolete reports whether the slot holds a record version that no
+// transaction can see any more. The header of the version is read without
+// locking it.
+func (p *Page) isObsolete(slot int32) (bool, error) {
+	flag, err := p.peekInt32(slot, p.offest(slot))
+	if err != nil || flag != used {
+		return false, err
+	}
+	xmax, err := p.tx.PeekInt64(p.block, p.offest(slot)+flagSize+versionSize)
+	if err != nil {
+		return false, err
+	}
+	return p.tx.IsObsolete(xmax), nil
 }
 
+// Format empties every slot of a new block. The writes are not logged, since
+// a new block holds nothing that would have to be restored, and redo empties
+// an appended block again. Only the header of a slotted page, which is not
+// all zeros, is logged.
 func (p *Page) Format() error {
+	return p.format(false)
+}
+
+// format empties every slot of the block. If log is true, the contents of
+// the whole block are logged first, since they may have been written with
+// another layout, whose values cannot be logged one by one.
+func (p *Page) format(log bool) error {
+	if log {
+		if err := p.tx.WriteBytes(p.block, 0, make([]byte, p.tx.BlockSize()), true); err != nil {
+			return err
+		}
+	}
+	if p.layout.IsSlotted() {
+		return p.formatSlotted()
+	}
+
 	var slot int32
 	for p.isValidSlot(slot) {
-		if err := p.tx.WriteInt32(p.block, p.offest(slot), 0, false); err != nil {
+		if err := p.tx.WriteInt32(p.block, p.offest(slot), empty, false); err != nil {
 			return err
 		}
 
+		if p.layout.IsVersioned() {
+			if err := p.tx.WriteInt64(p.block, p.offest(slot)+flagSize, 0, false); err != nil {
+				return err
+			}
+			if err := p.tx.WriteInt64(p.block, p.offest(slot)+flagSize+versionSize, 0, false); err != nil {
+				return err
+			}
+		}
+
+		pos := p.offest(slot) + headerSize(p.layout.Flags())
+		for i := range nullBitmap(p.layout.Schema()) {
+			if err := p.tx.WriteInt32(p.block, pos+int32(i)*4, 0, false); err != nil {
+				return err
+			}
+		}
+
 		schema := p.layout.Schema()
 		for _, fieldName := range schema.fields {
 			pos := p.offest(slot) + p.layout.Offset(fieldName)
-			if schema.FieldType(fieldName) == Integer {
-				if err := p.tx.WriteInt32(p.block, pos, 0, false); err != nil {
-					return err
-				}
-			} else {
-				if err := p.tx.WriteString(p.block, pos, "", false); err != nil {
-					return err
+			var err error
+			switch schema.FieldType(fieldName) {
+			case Integer, Boolean:
+				err = p.tx.WriteInt32(p.block, pos, 0, false)
+			case BigInt, Double, Date, Timestamp, Decimal:
+				err = p.tx.WriteInt64(p.block, pos, 0, false)
+			case Text, Blob:
+				if err = p.tx.WriteInt32(p.block, pos, 0, false); err == nil {
+					err = p.tx.WriteString(p.block, pos+4, "", false)
 				}
+			default:
+				err = p.tx.WriteString(p.block, pos, "", false)
+			}
+			if err != nil {
+				return err
 			}
 		}
 		slot++
@@ -72,21 +401,175 @@ func (p *Page) Format() error {
 	return nil
 }
 
+// NextAfter returns the first used slot after the specified one, or -1 if
+// there is none. In a multi-version table, versions that are not visible to
+// the transaction are skipped.
+//
+// Each slot examined is locked in shared mode, including empty ones, so that
+// depending on the isolation level, records cannot be inserted into the
+// slots the transaction has scanned.
 func (p *Page) NextAfter(slot int32) (int32, error) {
-	return p.searchAfter(slot, 1)
+	if p.layout.IsSlotted() {
+		return p.nextSlotted(slot)
+	}
+
+	for {
+		var err error
+		slot, err = p.searchAfter(slot, used, p.readInt32)
+		if err != nil || slot < 0 || !p.layout.IsVersioned() {
+			return slot, err
+		}
+
+		xmin, xmax, err := p.Version(slot)
+		if err != nil {
+			return 0, err
+		}
+		if p.tx.IsVisible(xmin, xmax) {
+			return slot, nil
+		}
+	}
 }
 
+// InsertAfter claims the first empty slot after the specified one, and
+// returns it, or -1 if there is none. Slots locked by other transactions
+// are skipped rather than waited for: they are either being filled by
+// another insert, or have been scanned by a transaction that must not see
+// new records appear in them.
+//
+// In a slotted page, a slot is added to the directory if there is no empty
+// one, and -1 is only returned if the block has no room for a new record.
 func (p *Page) InsertAfter(slot int32) (int32, error) {
-	newSlot, err := p.searchAfter(slot, 0)
-	if err != nil {
-		return 0, err
+	slot, _, err := p.insertAfter(slot)
+	return slot, err
+}
+
+// insertAfter is InsertAfter, and also reports whether empty slots were
+// skipped because other transactions held locks on them, in which case the
+// block may have room once they finish, even if no slot was claimed.
+func (p *Page) insertAfter(slot int32) (int32, bool, error) {
+	if p.layout.IsSlotted() {
+		slot, err := p.insertSlotted(slot, 0)
+		return slot, false, err
 	}
-	if newSlot >= 0 {
-		if err := p.setFlag(newSlot, 1); err != nil {
-			return 0, err
+
+	skipped := false
+	for {
+		var err error
+		slot, err = p.searchAfter(slot, empty, p.peekInt32)
+		if err != nil || slot < 0 {
+			return slot, skipped, err
+		}
+
+		locked, err := p.tx.TryXLockRecord(p.block, slot)
+		if err != nil {
+			return 0, skipped, err
+		}
+		if !locked {
+			skipped = true
+			continue
 		}
+
+		// The slot may have been filled between the search and the lock.
+		flag, err := p.readInt32(slot, p.offest(slot))
+		if err != nil {
+			return 0, skipped, err
+		}
+		if flag != empty {
+			continue
+		}
+
+		if p.layout.IsVersioned() {
+			// Stamp the version before marking the slot as used, so that
+			// concurrent readers never see a used slot without its creator.
+			pos := p.offest(slot) + flagSize
+			if err := p.tx.WriteRecordInt64(p.block, slot, pos, p.tx.TxNum(), true); err != nil {
+				return 0, skipped, err
+			}
+			if err := p.tx.WriteRecordInt64(p.block, slot, pos+versionSize, 0, true); err != nil {
+				return 0, skipped, err
+			}
+		}
+
+		// The fields of a new record are NULL until they are written, or
+		// zero if they cannot hold NULL. The bitmap and the fields are reset
+		// since the slot may hold those of a deleted record.
+		pos := p.offest(slot) + headerSize(p.layout.Flags())
+		for i, word := range nullBitmap(p.layout.Schema()) {
+			if err := p.tx.WriteRecordInt32(p.block, slot, pos+int32(i)*4, word, true); err != nil {
+				return 0, skipped, err
+			}
+		}
+		schema := p.layout.Schema()
+		for _, fieldName := range schema.fields {
+			if schema.IsNullable(fieldName) {
+				continue
+			}
+			if err := p.zeroField(slot, fieldName); err != nil {
+				return 0, skipped, err
+			}
+		}
+
+		if err := p.setFlag(slot, used); err != nil {
+			return 0, skipped, err
+		}
+		return slot, skipped, nil
+	}
+}
+
+// zeroField writes the zero value of the field, as a new block holds it, to
+// the record in the slot.
+func (p *Page) zeroField(slot int32, fieldName string) error {
+	pos := p.offest(slot) + p.layout.Offset(fieldName)
+	switch p.layout.Schema().FieldType(fieldName) {
+	case Integer, Boolean:
+		return p.writeInt32(slot, pos, 0)
+	case BigInt, Double, Date, Timestamp, Decimal:
+		return p.writeInt64(slot, pos, 0)
+	case Text, Blob:
+		if err := p.writeInt32(slot, pos, 0); err != nil {
+			return err
+		}
+		return p.writeString(slot, pos+4, "")
+	default:
+		return p.writeString(slot, pos, "")
 	}
-	return newSlot, nil
+}
+
+// recordBytes returns the stored bytes of the record in the specified slot
+// of a table that is not multi-version, including its slot header.
+func (p *Page) recordBytes(slot int32) ([]byte, error) {
+	if p.layout.IsSlotted() {
+		offset, length, err := p.entry(slot)
+		if err != nil {
+			return nil, err
+		}
+		return p.tx.ReadBytes(p.block, offset, length)
+	}
+	return p.tx.ReadBytes(p.block, p.offest(slot), p.layout.SlotSize())
+}
+
+// insertBytes stores a record read by recordBytes in an empty slot, and
+// returns the slot, or -1 if the page has no room for it. The overflow
+// blocks of the record are shared with the original, which must be removed
+// rather than deleted. The caller must hold an X lock on the block.
+func (p *Page) insertBytes(record []byte) (int32, error) {
+	if p.layout.IsSlotted() {
+		return p.insertSlottedRecord(-1, record, 0)
+	}
+	slot, err := p.searchAfter(-1, empty, p.peekInt32)
+	if err != nil || slot < 0 {
+		return slot, err
+	}
+	return slot, p.tx.WriteBytes(p.block, p.offest(slot), record, true)
+}
+
+// remove empties the slot, without freeing the overflow blocks of the
+// record.
+func (p *Page) remove(slot int32) error {
+	if p.layout.IsSlotted() {
+		return p.deleteSlotted(slot)
+	}
+	return p.setFlag(slot, empty)
 }
 
 func (p *Page) Block() *file.Block {
@@ -94,13 +577,216 @@ func (p *Page) Block() *file.Block {
 }
 
 func (p *Page) setFlag(slot int32, flag int32) error {
-	return p.tx.WriteInt32(p.block, p.offest(slot), flag, true)
+	return p.tx.WriteRecordInt32(p.block, slot, p.offest(slot), flag, true)
+}
+
+// value reads the field of the record in the specified slot, or returns
+// nil if it is NULL.
+func (p *Page) value(slot int32, fieldName string) (any, error) {
+	null, err := p.IsNull(slot, fieldName)
+	if err != nil || null {
+		return nil, err
+	}
+	return p.readValue(slot, fieldName)
+}
+
+// readValue reads the field of the record in the specified slot as the Go
+// type of its field type.
+func (p *Page) readValue(slot int32, fieldName string) (any, error) {
+	switch p.layout.Schema().FieldType(fieldName) {
+	case Integer:
+		return p.ReadInt32(slot, fieldName)
+	case BigInt:
+		return p.ReadInt64(slot, fieldName)
+	case Double:
+		return p.ReadFloat64(slot, fieldName)
+	case Boolean:
+		return p.ReadBool(slot, fieldName)
+	case Date, Timestamp:
+		return p.ReadTime(slot, fieldName)
+	case Decimal:
+		return p.ReadDecimal(slot, fieldName)
+	case Blob:
+		return p.ReadBlob(slot, fieldName)
+	default:
+		return p.ReadString(slot, fieldName)
+	}
+}
+
+// writeValue writes a value of the Go type of the field type to the field
+// of the record in the specified slot.
+func (p *Page) writeValue(slot int32, fieldName string, value any) error {
+	switch p.layout.Schema().FieldType(fieldName) {
+	case Integer:
+		return p.WriteInt32(slot, fieldName, value.(int32))
+	case BigInt:
+		return p.WriteInt64(slot, fieldName, value.(int64))
+	case Double:
+		return p.WriteFloat64(slot, fieldName, value.(float64))
+	case Boolean:
+		return p.WriteBool(slot, fieldName, value.(bool))
+	case Date, Timestamp:
+		return p.WriteTime(slot, fieldName, value.(time.Time))
+	case Decimal:
+		return p.WriteDecimal(slot, fieldName, value.(decimal.Decimal))
+	case Blob:
+		return p.WriteBlob(slot, fieldName, value.([]byte))
+	default:
+		return p.WriteString(slot, fieldName, value.(string))
+	}
+}
+
+// setNullBit sets or clears the null bit of the field of the record in the
+// specified slot. Nothing is written if the field is not nullable, or if
+// the bit is already as requested.
+func (p *Page) setNullBit(slot int32, fieldName string, null bool) error {
+	if !p.layout.Schema().IsNullable(fieldName) {
+		return nil
+	}
+	if !p.layout.IsSlotted() {
+		if err := p.tx.XLockRecord(p.block, slot); err != nil {
+			return err
+		}
+	}
+
+	base, err := p.recordPos(slot)
+	if err != nil {
+		return err
+	}
+	pos, mask := p.layout.nullBit(fieldName)
+	pos += base
+	var word int32
+	if p.layout.IsSlotted() {
+		word, err = p.tx.ReadInt32(p.block, pos)
+	} else {
+		word, err = p.tx.ReadRecordInt32(p.block, slot, pos)
+	}
+	if err != nil {
+		return err
+	}
+	newWord := word &^ mask
+	if null {
+		newWord = word | mask
+	}
+	if newWord == word {
+		return nil
+	}
+	return p.writeInt32(slot, pos, newWord)
+}
+
+// expire marks the version in the specified slot as deleted by the
+// transaction. The slot header is read under an exclusive lock on the
+// record, so that a concurrent writer of the version must have finished
+// first. If the version has already been deleted by another transaction,
+// the write conflicts and ErrWriteConflict is returned.
+func (p *Page) expire(slot int32) error {
+	if err := p.tx.XLockRecord(p.block, slot); err != nil {
+		return err
+	}
+
+	pos := p.offest(slot) + flagSize + versionSize
+	xmax, err := p.tx.ReadRecordInt64(p.block, slot, pos)
+	if err != nil {
+		return err
+	}
+	if xmax == p.tx.TxNum() {
+		return nil
+	}
+	if xmax != 0 {
+		return transaction.ErrWriteConflict
+	}
+	return p.tx.WriteRecordInt64(p.block, slot, pos, p.tx.TxNum(), true)
+}
+
+// recordPos returns the position of the record in the slot.
+func (p *Page) recordPos(slot int32) (int32, error) {
+	if p.layout.IsSlotted() {
+		offset, _, err := p.entry(slot)
+		return offset, err
+	}
+	return p.offest(slot), nil
+}
+
+// fieldPos returns the position of the field of the record in the slot.
+func (p *Page) fieldPos(slot int32, fieldName string) (int32, error) {
+	pos, err := p.recordPos(slot)
+	if err != nil {
+		return 0, err
+	}
+	return pos + p.layout.Offset(fieldName), nil
+}
+
+// readInt32 reads an int32 at the specified position of the record in the
+// slot. Multi-version tables are read through the transaction's snapshot,
+// and slotted pages under a lock on the block.
+func (p *Page) readInt32(slot int32, pos int32) (int32, error) {
+	if p.layout.IsVersioned() {
+		return p.tx.SnapshotReadInt32(p.block, slot, pos)
+	}
+	if p.layout.IsSlotted() {
+		return p.tx.ReadInt32(p.block, pos)
+	}
+	return p.tx.ReadRecordInt32(p.block, slot, pos)
+}
+
+// readInt64 is the int64 counterpart of readInt32.
+func (p *Page) readInt64(slot int32, pos int32) (int64, error) {
+	if p.layout.IsVersioned() {
+		return p.tx.SnapshotReadInt64(p.block, slot, pos)
+	}
+	if p.layout.IsSlotted() {
+		return p.tx.ReadInt64(p.block, pos)
+	}
+	return p.tx.ReadRecordInt64(p.block, slot, pos)
+}
+
+// readString is the string counterpart of readInt32.
+func (p *Page) readString(slot int32, pos int32) (string, error) {
+	if p.layout.IsVersioned() {
+		return p.tx.SnapshotReadString(p.block, slot, pos)
+	}
+	if p.layout.IsSlotted() {
+		return p.tx.ReadString(p.block, pos)
+	}
+	return p.tx.ReadRecordString(p.block, slot, pos)
+}
+
+// writeInt32 writes an int32 at the specified position of the record in the
+// slot, locking the record, or the block of a slotted page.
+func (p *Page) writeInt32(slot int32, pos int32, value int32) error {
+	if p.layout.IsSlotted() {
+		return p.tx.WriteInt32(p.block, pos, value, true)
+	}
+	return p.tx.WriteRecordInt32(p.block, slot, pos, value, true)
+}
+
+// writeInt64 is the int64 counterpart of writeInt32.
+func (p *Page) writeInt64(slot int32, pos int32, value int64) error {
+	if p.layout.IsSlotted() {
+		return p.tx.WriteInt64(p.block, pos, value, true)
+	}
+	return p.tx.WriteRecordInt64(p.block, slot, pos, value, true)
+}
+
+// writeString is the string counterpart of writeInt32.
+func (p *Page) writeString(slot int32, pos int32, value string) error {
+	if p.layout.IsSlotted() {
+		return p.tx.WriteString(p.block, pos, value, true)
+	}
+	return p.tx.WriteRecordString(p.block, slot, pos, value, true)
+}
+
+// peekInt32 reads an int32 at the specified position without locking the
+// record. The value must be checked again under a lock before it is acted
+// upon.
+func (p *Page) peekInt32(slot int32, pos int32) (int32, error) {
+	return p.tx.PeekInt32(p.block, pos)
 }
 
-func (p *Page) searchAfter(slot int32, flag int32) (int32, error) {
+func (p *Page) searchAfter(slot int32, flag int32, read func(slot int32, pos int32) (int32, error)) (int32, error) {
 	slot++
 	for p.isValidSlot(slot) {
-		value, err := p.tx.ReadInt32(p.block, p.offest(slot))
+		value, err := read(slot, p.offest(slot))
 		if err != nil {
 			return 0, err
 		}
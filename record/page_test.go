@@ -12,21 +12,21 @@ import (
 )
 
 func TestPage(t *testing.T) {
-	directory := t.TempDir()
+	fileManager := file.NewMemoryStorage(400)
 
-	fileManager, err := file.NewManager(directory, 400)
+	logManager, err := log.NewManager(fileManager, "testlogfile")
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	logManager, err := log.NewManager(fileManager, "testlogfile")
+	bufferManager := buffer.NewManager(fileManager, logManager, 8)
+
+	transactionManager, err := transaction.NewManager(fileManager, logManager, bufferManager)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	bufferManager := buffer.NewManager(fileManager, logManager, 8)
-
-	tx, err := transaction.NewTransaction(fileManager, logManager, bufferManager)
+	tx, err := transactionManager.NewTransaction()
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1,15 +1,33 @@
 package record
 
+import "slices"
+
 type FieldType int32
 
 const (
 	Integer FieldType = iota
 	Varchar
+	BigInt    // int64
+	Double    // float64
+	Boolean   // bool
+	Date      // time.Time at midnight UTC
+	Timestamp // time.Time with microsecond precision
+	Decimal   // decimal.Decimal with a declared precision and scale
+	Text      // string of any length, stored out of line when long
+	Blob      // []byte of any length, stored out of line when long
 )
 
+// MaxDecimalPrecision is the largest number of digits a Decimal field can
+// hold, so that its coefficient fits in 8 bytes.
+const MaxDecimalPrecision = 18
+
 type fieldInfo struct {
 	fieldType FieldType
 	length    int32
+	scale     int32
+	nullable  bool
+	// defaultValue is the value of the field in a new record, or nil.
+	defaultValue any
 }
 
 type Schema struct {
@@ -23,26 +41,38 @@ func NewSchema() *Schema {
 	}
 }
 
-func (s *Schema) AddField(fieldName string, fieldType FieldType, length int32) {
+// AddField adds a field to the schema. A nullable field can hold NULL,
+// which is recorded in the null bitmap of the slot header.
+func (s *Schema) AddField(fieldName string, fieldType FieldType, length int32, nullable bool) {
 	s.fields = append(s.fields, fieldName)
 	s.info[fieldName] = fieldInfo{
 		fieldType: fieldType,
 		length:    length,
+		nullable:  nullable,
 	}
 }
 
 func (s *Schema) AddIntField(fieldName string) {
-	s.AddField(fieldName, Integer, 0)
+	s.AddField(fieldName, Integer, 0, false)
 }
 
 func (s *Schema) AddStringField(fieldName string, length int32) {
-	s.AddField(fieldName, Varchar, length)
+	s.AddField(fieldName, Varchar, length, false)
+}
+
+// AddDecimalField adds a Decimal field holding numbers of at most
+// precision digits, scale of which are after the decimal point. The
+// precision must be between 1 and MaxDecimalPrecision.
+func (s *Schema) AddDecimalField(fieldName string, precision int32, scale int32, nullable bool) {
+	s.AddField(fieldName, Decimal, precision, nullable)
+	info := s.info[fieldName]
+	info.scale = scale
+	s.info[fieldName] = info
 }
 
 func (s *Schema) Add(fieldName string, schema *Schema) {
-	fieldType := schema.FieldType(fieldName)
-	length := schema.FieldLength(fieldName)
-	s.AddField(fieldName, fieldType, length)
+	s.fields = append(s.fields, fieldName)
+	s.info[fieldName] = schema.info[fieldName]
 }
 
 func (s *Schema) AddAll(schema *Schema) {
@@ -64,6 +94,58 @@ func (s *Schema) FieldType(fieldName string) FieldType {
 	return s.info[fieldName].fieldType
 }
 
+// FieldLength returns the declared length of the field. For a Text or Blob
+// field, it is the length up to which values are stored in the record
+// rather than in overflow blocks.
 func (s *Schema) FieldLength(fieldName string) int32 {
 	return s.info[fieldName].length
 }
+
+// FieldScale returns the number of digits after the decimal point of a
+// Decimal field. The precision of the field is its length.
+func (s *Schema) FieldScale(fieldName string) int32 {
+	return s.info[fieldName].scale
+}
+
+// IsNullable reports whether the field can hold NULL.
+func (s *Schema) IsNullable(fieldName string) bool {
+	return s.info[fieldName].nullable
+}
+
+// SetNullable sets whether the field can hold NULL.
+func (s *Schema) SetNullable(fieldName string, nullable bool) {
+	info := s.info[fieldName]
+	info.nullable = nullable
+	s.info[fieldName] = info
+}
+
+// SetDefault sets the value the field holds in a new record, until it is
+// written. The value must be of the type of the field, as for
+// TableScan.WriteValue. A nil value removes the default, so that the field
+// is NULL in a new record, or zero if it cannot hold NULL.
+func (s *Schema) SetDefault(fieldName string, value any) {
+	info := s.info[fieldName]
+	info.defaultValue = value
+	s.info[fieldName] = info
+}
+
+// DefaultValue returns the value the field holds in a new record, or nil if
+// it has no default.
+func (s *Schema) DefaultValue(fieldName string) any {
+	return s.info[fieldName].defaultValue
+}
+
+// HasNullableFields reports whether any field of the schema can hold NULL.
+func (s *Schema) HasNullableFields() bool {
+	for _, info := range s.info {
+		if info.nullable {
+			return true
+		}
+	}
+	return false
+}
+
+// fieldIndex returns the position of the field in the schema.
+func (s *Schema) fieldIndex(fieldName string) int {
+	return slices.Index(s.fields, fieldName)
+}
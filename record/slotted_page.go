@@ -0,0 +1,396 @@
+package record
+
+import (
+	"cmp"
+	"errors"
+	"slices"
+
+	"simpledb/file"
+)
+
+// A slotted page stores records of variable length. The block starts with a
+// header and a directory of slots, and the records are allocated from the
+// end of the block towards the directory:
+//
+//	+-------+---------+--------+--------+-----+-------+---------+---------+
+//	| count | freeEnd | entry0 | entry1 | ... | free  | record1 | record0 |
+//	+-------+---------+--------+--------+-----+-------+---------+---------+
+//
+// count is the number of directory entries, and freeEnd the offset of the
+// lowest record. Each entry holds the offset of the record in its slot, or 0
+// if the slot is empty, and the number of bytes allocated to the record. A
+// record is the fixed-length part described by the layout, followed by the
+// values of its Varchar fields, each a 4-byte length and the bytes.
+//
+// Records move within the block when they grow, and when the block is
+// compacted to reclaim the space of deleted and shrunk records. Slot
+// numbers never change, so RIDs stay valid, but since the bytes of a record
+// are not stable, a slotted page is locked as a whole rather than record by
+// record.
+const (
+	slotCountPos = 0
+	freeEndPos   = 4
+	directoryPos = 8
+	entrySize    = 8
+)
+
+// errPageFull is returned when a record of a slotted page cannot grow
+// because the block has no room left for it.
+var errPageFull = errors.New("record: no room in page")
+
+func (p *Page) formatSlotted() error {
+	if err := p.tx.WriteInt32(p.block, slotCountPos, 0, false); err != nil {
+		return err
+	}
+	// Redo only restores the block as empty, so the end of its free space
+	// must be logged.
+	return p.tx.WriteInt32(p.block, freeEndPos, p.tx.BlockSize(), true)
+}
+
+func (p *Page) nextSlotted(slot int32) (int32, error) {
+	count, err := p.tx.ReadInt32(p.block, slotCountPos)
+	if err != nil {
+		return 0, err
+	}
+	for slot++; slot < count; slot++ {
+		offset, _, err := p.entry(slot)
+		if err != nil {
+			return 0, err
+		}
+		if offset != 0 {
+			return slot, nil
+		}
+	}
+	return -1, nil
+}
+
+// insertSlotted claims the first empty slot after the specified one, adding
+// a slot to the directory if there is none, and stores a new record in it.
+// The slot is only claimed if the block has room for reserve bytes of
+// records besides it, and -1 is returned otherwise.
+func (p *Page) insertSlotted(slot int32, reserve int32) (int32, error) {
+	return p.insertSlottedRecord(slot, p.encodeRecord(p.newFixedPart(), nil), reserve)
+}
+
+// insertSlottedRecord is like insertSlotted, but stores the specified bytes
+// as the record.
+func (p *Page) insertSlottedRecord(slot int32, record []byte, reserve int32) (int32, error) {
+	count, err := p.tx.ReadInt32(p.block, slotCountPos)
+	if err != nil {
+		return 0, err
+	}
+	target := count
+	for s := slot + 1; s < count; s++ {
+		offset, _, err := p.entry(s)
+		if err != nil {
+			return 0, err
+		}
+		if offset == 0 {
+			target = s
+			break
+		}
+	}
+
+	need := max(int32(len(record)), reserve)
+	if target == count {
+		need += entrySize
+	}
+	available, err := p.available(-1)
+	if err != nil {
+		return 0, err
+	}
+	if available < need {
+		return -1, nil
+	}
+
+	if target == count {
+		freeEnd, err := p.tx.ReadInt32(p.block, freeEndPos)
+		if err != nil {
+			return 0, err
+		}
+		// The directory grows into the free space, which may be
+		// scattered between the records.
+		if freeEnd-p.entryPos(count) < entrySize {
+			if _, err := p.compact(); err != nil {
+				return 0, err
+			}
+		}
+		if err := p.tx.WriteInt32(p.block, slotCountPos, count+1, true); err != nil {
+			return 0, err
+		}
+		// Until the record is stored, the new slot must read as empty.
+		if err := p.setEntry(target, 0, 0); err != nil {
+			return 0, err
+		}
+	}
+
+	offset, err := p.allocate(int32(len(record)))
+	if err != nil {
+		return 0, err
+	}
+	if err := p.tx.WriteBytes(p.block, offset, record, true); err != nil {
+		return 0, err
+	}
+	if err := p.setEntry(target, offset, int32(len(record))); err != nil {
+		return 0, err
+	}
+	return target, nil
+}
+
+func (p *Page) deleteSlotted(slot int32) error {
+	if err := p.setEntry(slot, 0, 0); err != nil {
+		return err
+	}
+	_, err := p.compact()
+	return err
+}
+
+func (p *Page) readStringSlotted(slot int32, fieldName string) (string, error) {
+	offset, _, err := p.entry(slot)
+	if err != nil {
+		return "", err
+	}
+	pos, err := p.tx.ReadInt32(p.block, offset+p.layout.Offset(fieldName))
+	if err != nil {
+		return "", err
+	}
+	return p.tx.ReadString(p.block, offset+pos)
+}
+
+// writeStringSlotted rewrites the record in the slot with the new value of
+// the Varchar field. A record that grows is moved to a new place in the
+// block, and errPageFull is returned if there is none, even after
+// compaction. The value must not be longer than the field.
+func (p *Page) writeStringSlotted(slot int32, fieldName string, value string) error {
+	if int32(len(value)) > p.layout.Schema().FieldLength(fieldName) {
+		return ErrOutOfRange
+	}
+
+	offset, length, err := p.entry(slot)
+	if err != nil {
+		return err
+	}
+	fixed, values, err := p.decodeRecord(offset, length)
+	if err != nil {
+		return err
+	}
+	values[fieldName] = value
+	record := p.encodeRecord(fixed, values)
+	size := int32(len(record))
+
+	if size <= length {
+		if err := p.tx.WriteBytes(p.block, offset, record, true); err != nil {
+			return err
+		}
+		if size == length {
+			return nil
+		}
+		// The rest of the space becomes free at the next compaction.
+		return p.setEntry(slot, offset, size)
+	}
+
+	available, err := p.available(slot)
+	if err != nil {
+		return err
+	}
+	if available < size {
+		return errPageFull
+	}
+	// Free the old record first, so that compaction can reclaim its space.
+	if err := p.setEntry(slot, 0, 0); err != nil {
+		return err
+	}
+	offset, err = p.allocate(size)
+	if err != nil {
+		return err
+	}
+	if err := p.tx.WriteBytes(p.block, offset, record, true); err != nil {
+		return err
+	}
+	return p.setEntry(slot, offset, size)
+}
+
+// entry returns the offset of the record in the slot, and the number of
+// bytes allocated to it.
+func (p *Page) entry(slot int32) (int32, int32, error) {
+	offset, err := p.tx.ReadInt32(p.block, p.entryPos(slot))
+	if err != nil {
+		return 0, 0, err
+	}
+	length, err := p.tx.ReadInt32(p.block, p.entryPos(slot)+4)
+	if err != nil {
+		return 0, 0, err
+	}
+	return offset, length, nil
+}
+
+func (p *Page) setEntry(slot int32, offset int32, length int32) error {
+	if err := p.tx.WriteInt32(p.block, p.entryPos(slot), offset, true); err != nil {
+		return err
+	}
+	return p.tx.WriteInt32(p.block, p.entryPos(slot)+4, length, true)
+}
+
+func (p *Page) entryPos(slot int32) int32 {
+	return directoryPos + slot*entrySize
+}
+
+// available returns the number of bytes the block would have free after
+// compaction, not counting the record in the excluded slot as used.
+func (p *Page) available(exclude int32) (int32, error) {
+	count, err := p.tx.ReadInt32(p.block, slotCountPos)
+	if err != nil {
+		return 0, err
+	}
+	used := p.entryPos(count)
+	for slot := range count {
+		if slot == exclude {
+			continue
+		}
+		_, length, err := p.entry(slot)
+		if err != nil {
+			return 0, err
+		}
+		used += length
+	}
+	return p.tx.BlockSize() - used, nil
+}
+
+// allocate reserves size bytes for a record and returns their offset,
+// compacting the block first if the free space between the directory and
+// the records is too small. The caller must have checked that the block
+// has room.
+func (p *Page) allocate(size int32) (int32, error) {
+	count, err := p.tx.ReadInt32(p.block, slotCountPos)
+	if err != nil {
+		return 0, err
+	}
+	freeEnd, err := p.tx.ReadInt32(p.block, freeEndPos)
+	if err != nil {
+		return 0, err
+	}
+	if freeEnd-p.entryPos(count) < size {
+		if freeEnd, err = p.compact(); err != nil {
+			return 0, err
+		}
+	}
+
+	freeEnd -= size
+	if err := p.tx.WriteInt32(p.block, freeEndPos, freeEnd, true); err != nil {
+		return 0, err
+	}
+	return freeEnd, nil
+}
+
+// compact moves the records to the end of the block, so that the space of
+// deleted and shrunk records joins the free space, and returns the new
+// offset of the lowest record.
+func (p *Page) compact() (int32, error) {
+	count, err := p.tx.ReadInt32(p.block, slotCountPos)
+	if err != nil {
+		return 0, err
+	}
+
+	type live struct {
+		slot, offset, length int32
+	}
+	var records []live
+	for slot := range count {
+		offset, length, err := p.entry(slot)
+		if err != nil {
+			return 0, err
+		}
+		if offset != 0 {
+			records = append(records, live{slot, offset, length})
+		}
+	}
+	// Moving the highest record first never overwrites one not moved yet.
+	slices.SortFunc(records, func(a, b live) int {
+		return cmp.Compare(b.offset, a.offset)
+	})
+
+	end := p.tx.BlockSize()
+	for _, r := range records {
+		end -= r.length
+		if r.offset == end {
+			continue
+		}
+		b, err := p.tx.ReadBytes(p.block, r.offset, r.length)
+		if err != nil {
+			return 0, err
+		}
+		if err := p.tx.WriteBytes(p.block, end, b, true); err != nil {
+			return 0, err
+		}
+		if err := p.setEntry(r.slot, end, r.length); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := p.tx.WriteInt32(p.block, freeEndPos, end, true); err != nil {
+		return 0, err
+	}
+	return end, nil
+}
+
+// newFixedPart returns the fixed-length part of a new record, whose
+// nullable fields are NULL and other fields are zero.
+func (p *Page) newFixedPart() []byte {
+	fixed := file.NewPage(p.layout.SlotSize())
+	for i, word := range nullBitmap(p.layout.Schema()) {
+		fixed.WriteInt32At(headerSize(p.layout.Flags())+int32(i)*4, word)
+	}
+	return fixed.Buf()
+}
+
+// decodeRecord reads the record at the offset, and returns its fixed-length
+// part and the values of its Varchar fields.
+func (p *Page) decodeRecord(offset int32, length int32) ([]byte, map[string]string, error) {
+	b, err := p.tx.ReadBytes(p.block, offset, length)
+	if err != nil {
+		return nil, nil, err
+	}
+	record := file.NewPageFromBuf(b)
+
+	values := make(map[string]string)
+	schema := p.layout.Schema()
+	for _, fieldName := range schema.fields {
+		if schema.FieldType(fieldName) != Varchar {
+			continue
+		}
+		pos, err := record.ReadInt32At(p.layout.Offset(fieldName))
+		if err != nil {
+			return nil, nil, err
+		}
+		values[fieldName], err = record.ReadStringAt(pos)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return b[:p.layout.SlotSize()], values, nil
+}
+
+// encodeRecord returns the bytes of a record with the fixed-length part and
+// the values of the Varchar fields. Missing values are empty.
+func (p *Page) encodeRecord(fixed []byte, values map[string]string) []byte {
+	schema := p.layout.Schema()
+	size := int32(len(fixed))
+	for _, fieldName := range schema.fields {
+		if schema.FieldType(fieldName) == Varchar {
+			size += 4 + int32(len(values[fieldName]))
+		}
+	}
+
+	record := file.NewPage(size)
+	record.WriteRawAt(0, fixed)
+	pos := int32(len(fixed))
+	for _, fieldName := range schema.fields {
+		if schema.FieldType(fieldName) != Varchar {
+			continue
+		}
+		record.WriteInt32At(p.layout.Offset(fieldName), pos)
+		record.WriteStringAt(pos, values[fieldName])
+		pos += 4 + int32(len(values[fieldName]))
+	}
+	return record.Buf()
+}
@@ -1,8 +1,14 @@
 package record
 
 import (
+	"errors"
 	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
 
+	"simpledb/decimal"
 	"simpledb/file"
 	"simpledb/transaction"
 )
@@ -13,6 +19,28 @@ type TableScan struct {
 	recordPage  *Page
 	filename    string
 	currentSlot int32
+	// fsm tells which blocks are full, so that inserts can skip them.
+	fsm *freeSpaceMap
+	// indexes are the indexes the scan keeps up to date.
+	indexes []fieldIndex
+	// checks are the conditions the records written by InsertValues and
+	// UpdateValues must satisfy. Those on a single field are also
+	// evaluated when the field is written.
+	checks []namedCheck
+	// keys are the referenced keys the scan tells of changes.
+	keys []fieldKey
+
+	// In a multi-version table, updating a record created by another
+	// transaction writes a new version. versionPage and versionSlot locate
+	// the new version of the current record, while the scan keeps its
+	// position at the old one. A record of a slotted table that outgrows
+	// its block is moved the same way.
+	versionPage *Page
+	versionSlot int32
+	// created holds the versions and moved records written by updates
+	// during the current pass, so that the scan does not visit an updated
+	// record twice.
+	created map[RID]struct{}
 }
 
 func NewTableScan(tx *transaction.Transaction, tableName string, layout *Layout) (*TableScan, error) {
@@ -21,43 +49,92 @@ func NewTableScan(tx *transaction.Transaction, tableName string, layout *Layout)
 		tx:       tx,
 		layout:   layout,
 		filename: fileName,
+		fsm:      newFreeSpaceMap(tx, fmt.Sprintf("%s.fsm", tableName)),
+		created:  make(map[RID]struct{}),
 	}
 
-	size, err := tx.Size(fileName)
-	if err != nil {
+	if err := ts.moveToStart(); err != nil {
 		return nil, err
 	}
-	if size == 0 {
-		if err := ts.moveToNewBlock(); err != nil {
-			return nil, err
-		}
-	} else {
-		if err := ts.moveToBlock(0); err != nil {
-			return nil, err
+	return ts, nil
+}
+
+// DropTable deletes the files of the table, including those a rewrite may
+// have left behind, once the transaction commits.
+func DropTable(tx *transaction.Transaction, tableName string) error {
+	filenames := append(tableFiles(tableName), tableFiles(scratchTable(tableName))...)
+	for _, filename := range filenames {
+		if err := tx.Remove(filename); err != nil {
+			return err
 		}
 	}
+	return nil
+}
 
-	return ts, nil
+// TableDropped reports whether the transaction dropped the table, whose
+// files are then only deleted when it commits.
+func TableDropped(tx *transaction.Transaction, tableName string) bool {
+	return slices.ContainsFunc(tableFiles(tableName), tx.Removing)
 }
 
+// tableFiles returns the names of the files of the table: its records, its
+// free-space map and its overflow blocks.
+func tableFiles(tableName string) []string {
+	return []string{tableName + ".tbl", tableName + ".fsm", tableName + ".ovf"}
+}
+
+// scratchTable returns the name of the table the records of the table are
+// copied to while it is rewritten.
+func scratchTable(tableName string) string {
+	return tableName + "$rewrite"
+}
+
+// Close unpins the current block of the scan, and closes the index writers
+// added to it. The scan can still be used afterwards, and then pins blocks
+// again, as do its index writers.
 func (ts *TableScan) Close() {
+	ts.unpin()
+	for _, fi := range ts.indexes {
+		fi.index.Close()
+	}
+}
+
+// unpin unpins the current block of the scan, before moving to another.
+func (ts *TableScan) unpin() {
+	ts.releaseVersion()
 	if ts.recordPage != nil {
 		ts.tx.Unpin(ts.recordPage.Block())
 	}
 }
 
 func (ts *TableScan) BeforeFirst() {
-	ts.moveToBlock(0)
+	clear(ts.created)
+	ts.moveToStart()
 }
 
 func (ts *TableScan) Next() bool {
-	slot, err := ts.recordPage.NextAfter(ts.currentSlot)
-	if err != nil {
-		return false
-	}
-	ts.currentSlot = slot
+	ts.releaseVersion()
+
+	for {
+		if ts.recordPage == nil {
+			// The table was empty, but records may have been inserted since.
+			if err := ts.moveToStart(); err != nil || ts.recordPage == nil {
+				return false
+			}
+		}
+		slot, err := ts.recordPage.NextAfter(ts.currentSlot)
+		if err != nil {
+			return false
+		}
+		ts.currentSlot = slot
+
+		if slot >= 0 {
+			if _, ok := ts.created[*ts.GetRID()]; !ok {
+				return true
+			}
+			continue
+		}
 
-	for slot < 0 {
 		lastBlock, err := ts.atLastBlock()
 		if err != nil {
 			return false
@@ -68,71 +145,276 @@ func (ts *TableScan) Next() bool {
 		if err := ts.moveToBlock(ts.recordPage.Block().Number() + 1); err != nil {
 			return false
 		}
-		slot, err = ts.recordPage.NextAfter(ts.currentSlot)
-		if err != nil {
-			return false
-		}
-		ts.currentSlot = slot
 	}
-	return true
 }
 
 func (ts *TableScan) ReadInt32(fieldName string) (int32, error) {
-	return ts.recordPage.ReadInt32(ts.currentSlot, fieldName)
+	page, slot := ts.current()
+	return page.ReadInt32(slot, fieldName)
 }
 
 func (ts *TableScan) ReadString(fieldName string) (string, error) {
-	return ts.recordPage.ReadString(ts.currentSlot, fieldName)
+	page, slot := ts.current()
+	return page.ReadString(slot, fieldName)
+}
+
+func (ts *TableScan) ReadInt64(fieldName string) (int64, error) {
+	page, slot := ts.current()
+	return page.ReadInt64(slot, fieldName)
+}
+
+func (ts *TableScan) ReadFloat64(fieldName string) (float64, error) {
+	page, slot := ts.current()
+	return page.ReadFloat64(slot, fieldName)
+}
+
+func (ts *TableScan) ReadBool(fieldName string) (bool, error) {
+	page, slot := ts.current()
+	return page.ReadBool(slot, fieldName)
 }
 
+func (ts *TableScan) ReadTime(fieldName string) (time.Time, error) {
+	page, slot := ts.current()
+	return page.ReadTime(slot, fieldName)
+}
+
+func (ts *TableScan) ReadDecimal(fieldName string) (decimal.Decimal, error) {
+	page, slot := ts.current()
+	return page.ReadDecimal(slot, fieldName)
+}
+
+func (ts *TableScan) ReadBlob(fieldName string) ([]byte, error) {
+	page, slot := ts.current()
+	return page.ReadBlob(slot, fieldName)
+}
+
+// ReadLargeValue returns a reader of the Text or Blob field of the current
+// record, which streams the value from its overflow blocks. The reader must
+// be consumed before the field is written again.
+func (ts *TableScan) ReadLargeValue(fieldName string) (io.Reader, error) {
+	page, slot := ts.current()
+	return page.ReadLargeValue(slot, fieldName)
+}
+
+// ReadValue returns the value of the field in the current record, or nil if
+// it is NULL.
 func (ts *TableScan) ReadValue(fieldName string) (any, error) {
-	if ts.layout.Schema().FieldType(fieldName) == Integer {
-		return ts.ReadInt32(fieldName)
-	}
-	return ts.ReadString(fieldName)
+	page, slot := ts.current()
+	return page.value(slot, fieldName)
+}
+
+// IsNull reports whether the field of the current record is NULL.
+func (ts *TableScan) IsNull(fieldName string) (bool, error) {
+	page, slot := ts.current()
+	return page.IsNull(slot, fieldName)
 }
 
 func (ts *TableScan) HasField(fieldName string) bool {
 	return ts.layout.Schema().HasField(fieldName)
 }
 
-func (ts *TableScan) WriteInt32(fieldName string, value int32) {
-	ts.recordPage.WriteInt32(ts.currentSlot, fieldName, value)
+func (ts *TableScan) WriteInt32(fieldName string, value int32) error {
+	return ts.writeField(fieldName, value, func(page *Page, slot int32) error {
+		return page.WriteInt32(slot, fieldName, value)
+	})
+}
+
+func (ts *TableScan) WriteString(fieldName string, value string) error {
+	return ts.writeField(fieldName, value, func(page *Page, slot int32) error {
+		return page.WriteString(slot, fieldName, value)
+	})
+}
+
+func (ts *TableScan) WriteInt64(fieldName string, value int64) error {
+	return ts.writeField(fieldName, value, func(page *Page, slot int32) error {
+		return page.WriteInt64(slot, fieldName, value)
+	})
+}
+
+func (ts *TableScan) WriteFloat64(fieldName string, value float64) error {
+	return ts.writeField(fieldName, value, func(page *Page, slot int32) error {
+		return page.WriteFloat64(slot, fieldName, value)
+	})
 }
 
-func (ts *TableScan) WriteString(fieldName string, value string) {
-	ts.recordPage.WriteString(ts.currentSlot, fieldName, value)
+func (ts *TableScan) WriteBool(fieldName string, value bool) error {
+	return ts.writeField(fieldName, value, func(page *Page, slot int32) error {
+		return page.WriteBool(slot, fieldName, value)
+	})
 }
 
-func (ts *TableScan) WriteValue(fieldName string, value any) {
-	if ts.layout.Schema().FieldType(fieldName) == Integer {
-		ts.WriteInt32(fieldName, value.(int32))
-	} else {
-		ts.WriteString(fieldName, value.(string))
+func (ts *TableScan) WriteTime(fieldName string, value time.Time) error {
+	return ts.writeField(fieldName, value, func(page *Page, slot int32) error {
+		return page.WriteTime(slot, fieldName, value)
+	})
+}
+
+func (ts *TableScan) WriteDecimal(fieldName string, value decimal.Decimal) error {
+	return ts.writeField(fieldName, value, func(page *Page, slot int32) error {
+		return page.WriteDecimal(slot, fieldName, value)
+	})
+}
+
+func (ts *TableScan) WriteBlob(fieldName string, value []byte) error {
+	return ts.writeField(fieldName, value, func(page *Page, slot int32) error {
+		return page.WriteBlob(slot, fieldName, value)
+	})
+}
+
+// WriteLargeValue writes the contents of the reader to the Text or Blob
+// field of the current record, streaming it to overflow blocks. The value
+// of an indexed field, or of any field if the scan has checks, is read in
+// full first, since the indexes and checks need it.
+func (ts *TableScan) WriteLargeValue(fieldName string, r io.Reader) error {
+	if len(ts.indexesOn(fieldName)) > 0 || len(ts.checks) > 0 {
+		value, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		if ts.layout.Schema().FieldType(fieldName) == Text {
+			return ts.WriteString(fieldName, string(value))
+		}
+		return ts.WriteBlob(fieldName, value)
 	}
+	return ts.write(func(page *Page, slot int32) error {
+		return page.WriteLargeValue(slot, fieldName, r)
+	})
 }
 
-func (ts *TableScan) Insert() {
-	slot, _ := ts.recordPage.InsertAfter(ts.currentSlot)
-	ts.currentSlot = slot
+// WriteValue writes the value to the field of the current record. A nil
+// value sets the field to NULL.
+func (ts *TableScan) WriteValue(fieldName string, value any) error {
+	if value == nil {
+		return ts.SetNull(fieldName)
+	}
+	return ts.writeField(fieldName, value, func(page *Page, slot int32) error {
+		return page.writeValue(slot, fieldName, value)
+	})
+}
 
-	for ts.currentSlot < 0 {
-		lastBlock, _ := ts.atLastBlock()
-		if lastBlock {
-			ts.moveToNewBlock()
+// SetNull sets the field of the current record to NULL. It fails with
+// ErrNotNullable if the field cannot hold NULL.
+func (ts *TableScan) SetNull(fieldName string) error {
+	return ts.writeField(fieldName, nil, func(page *Page, slot int32) error {
+		return page.SetNull(slot, fieldName)
+	})
+}
+
+// Insert inserts a new record into an empty slot of the current block, or
+// of a later block. Blocks the free-space map marks as full are skipped,
+// and a block found to have no empty slot is marked as full. The fields
+// with a default value are set to it, without evaluating the checks of the
+// scan.
+func (ts *TableScan) Insert() error {
+	if err := ts.insert(); err != nil {
+		return err
+	}
+	return ts.writeDefaults()
+}
+
+// writeDefaults writes the default values of the fields to the current
+// record.
+func (ts *TableScan) writeDefaults() error {
+	schema := ts.layout.Schema()
+	for _, fieldName := range schema.Fields() {
+		value := schema.DefaultValue(fieldName)
+		if value == nil {
+			continue
+		}
+		if err := ts.WriteValue(fieldName, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// insert inserts a new record whose fields are all NULL, or zero if they
+// cannot hold NULL.
+func (ts *TableScan) insert() error {
+	ts.releaseVersion()
+
+	if ts.recordPage == nil {
+		// The first block of the table is only appended by the first insert.
+		if err := ts.moveToStart(); err != nil {
+			return err
+		}
+		if ts.recordPage == nil {
+			if err := ts.moveToNewBlock(); err != nil {
+				return err
+			}
+		}
+	}
+	for {
+		start := ts.currentSlot
+		slot, skipped, err := ts.recordPage.insertAfter(start)
+		if err != nil {
+			return err
+		}
+		ts.currentSlot = slot
+		if slot >= 0 {
+			return nil
+		}
+		// Slots before the current record may still be empty.
+		if start >= 0 {
+			continue
+		}
+
+		// A block is only full if none of its slots was skipped for being
+		// locked: those are freed again if their transaction rolls back,
+		// or was deleting their record.
+		blockNum := ts.recordPage.Block().Number()
+		if !skipped {
+			if err := ts.fsm.setFull(blockNum, true); err != nil {
+				return err
+			}
+		}
+		size, err := ts.size()
+		if err != nil {
+			return err
+		}
+		next, err := ts.fsm.next(blockNum+1, size)
+		if err != nil {
+			return err
+		}
+		if next < size {
+			err = ts.moveToBlock(next)
 		} else {
-			ts.moveToBlock(ts.recordPage.Block().Number() + 1)
+			err = ts.moveToNewBlock()
+		}
+		if err != nil {
+			return err
 		}
-		ts.currentSlot, _ = ts.recordPage.InsertAfter(ts.currentSlot)
 	}
 }
 
-func (ts *TableScan) Delete() {
-	ts.recordPage.Delete(ts.currentSlot)
+// Delete deletes the current record. In a multi-version table, it fails
+// with transaction.ErrWriteConflict if a concurrent transaction has already
+// updated or deleted the record. The referenced keys of the scan are told
+// first, and the entries of the record are deleted from the indexes the
+// scan keeps up to date.
+func (ts *TableScan) Delete() error {
+	page, slot := ts.current()
+	if err := ts.deleting(page, slot); err != nil {
+		return err
+	}
+	rid := ts.GetRID()
+	values, err := ts.indexedValues(page, slot)
+	if err != nil {
+		return err
+	}
+	if err := ts.delete(page, slot); err != nil {
+		return err
+	}
+	for i, fi := range ts.indexes {
+		if err := replaceEntry(fi.index, values[i], nil, rid); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (ts *TableScan) MoveToRID(rid *RID) error {
-	ts.Close()
+	ts.unpin()
 	block := file.NewBlock(ts.filename, rid.blockNum)
 	recordPage, err := NewPage(ts.tx, block, ts.layout)
 	if err != nil {
@@ -145,14 +427,502 @@ func (ts *TableScan) MoveToRID(rid *RID) error {
 }
 
 func (ts *TableScan) GetRID() *RID {
+	page, slot := ts.current()
 	return &RID{
-		blockNum: ts.recordPage.Block().Number(),
-		slot:     ts.currentSlot,
+		blockNum: page.Block().Number(),
+		slot:     slot,
+	}
+}
+
+// PruneDeadVersions garbage collects the record versions of a multi-version
+// table that were deleted by transactions every running and future
+// transaction sees as committed. It returns the number of slots reclaimed.
+func (ts *TableScan) PruneDeadVersions() (int32, error) {
+	if !ts.layout.IsVersioned() {
+		return 0, nil
+	}
+
+	size, err := ts.size()
+	if err != nil {
+		return 0, err
+	}
+
+	var pruned int32
+	for blockNum := range size {
+		if err := ts.moveToBlock(blockNum); err != nil {
+			return pruned, err
+		}
+		n, err := ts.recordPage.Prune()
+		if err != nil {
+			return pruned, err
+		}
+		if n > 0 {
+			if err := ts.fsm.setFull(blockNum, false); err != nil {
+				return pruned, err
+			}
+		}
+		pruned += n
+	}
+
+	ts.BeforeFirst()
+	return pruned, nil
+}
+
+// Vacuum compacts the table, moving the records of its last blocks to empty
+// slots of its first ones, and cuts the blocks left empty off the file once
+// the transaction commits. The table is locked in X until then. The entries
+// of the records moved are moved in the indexes the scan keeps up to date,
+// and if moved is not nil, it is called with the old and new RID of each of
+// them. Vacuum returns the number of blocks cut off, and leaves the scan
+// before the first record.
+//
+// The records of a multi-version table are not moved, since snapshot
+// transactions read them without locks. Its dead versions are pruned
+// instead, and no blocks are cut off.
+func (ts *TableScan) Vacuum(moved func(from *RID, to *RID) error) (int32, error) {
+	if ts.layout.IsVersioned() {
+		_, err := ts.PruneDeadVersions()
+		return 0, err
+	}
+	if err := ts.tx.LockFile(ts.filename, transaction.X); err != nil {
+		return 0, err
+	}
+	ts.releaseVersion()
+
+	size, err := ts.size()
+	if err != nil || size == 0 {
+		return 0, err
+	}
+
+	// Records are moved from the last block to the first block with room,
+	// until the two meet.
+	var target int32
+	src := size - 1
+	for ; src > 0; src-- {
+		done, err := ts.vacuumBlock(src, &target, moved)
+		if err != nil {
+			return 0, err
+		}
+		if !done {
+			break
+		}
+	}
+
+	// Blocks that are cut off are no longer full, should the file grow back.
+	for blockNum := src; blockNum < size; blockNum++ {
+		if err := ts.fsm.setFull(blockNum, false); err != nil {
+			return 0, err
+		}
+	}
+	if err := ts.tx.Truncate(ts.filename, src+1); err != nil {
+		return 0, err
+	}
+	ts.BeforeFirst()
+	return size - src - 1, nil
+}
+
+// vacuumBlock moves the records of the source block to blocks from the
+// target block on, which it advances past full blocks, and reports whether
+// the source block was emptied.
+func (ts *TableScan) vacuumBlock(src int32, target *int32, moved func(from *RID, to *RID) error) (bool, error) {
+	if err := ts.moveToBlock(src); err != nil {
+		return false, err
+	}
+	for {
+		slot, err := ts.recordPage.NextAfter(-1)
+		if err != nil {
+			return false, err
+		}
+		if slot < 0 {
+			return true, nil
+		}
+		record, err := ts.recordPage.recordBytes(slot)
+		if err != nil {
+			return false, err
+		}
+		values, err := ts.indexedValues(ts.recordPage, slot)
+		if err != nil {
+			return false, err
+		}
+
+		var to *RID
+		for {
+			if *target, err = ts.fsm.next(*target, src); err != nil {
+				return false, err
+			}
+			if *target >= src {
+				return false, nil
+			}
+			page, err := NewPage(ts.tx, file.NewBlock(ts.filename, *target), ts.layout)
+			if err != nil {
+				return false, err
+			}
+			newSlot, err := page.insertBytes(record)
+			ts.tx.Unpin(page.Block())
+			if err != nil {
+				return false, err
+			}
+			if newSlot >= 0 {
+				to = &RID{blockNum: *target, slot: newSlot}
+				break
+			}
+			if err := ts.fsm.setFull(*target, true); err != nil {
+				return false, err
+			}
+			(*target)++
+		}
+
+		if err := ts.recordPage.remove(slot); err != nil {
+			return false, err
+		}
+		from := &RID{blockNum: src, slot: slot}
+		for i, fi := range ts.indexes {
+			if err := moveEntry(fi.index, values[i], from, to); err != nil {
+				return false, err
+			}
+		}
+		if moved != nil {
+			if err := moved(from, to); err != nil {
+				return false, err
+			}
+		}
+	}
+}
+
+// Rewrite stores the records of the table with the specified layout, which
+// the scan uses from then on, so that fields can be added to or removed
+// from the table. For each record, fill is called with a scan at a new
+// record of the new layout, whose fields are all NULL, and a scan at the
+// record in the old layout, and writes the fields of the new record. The
+// table is locked in X until the transaction commits, and the RIDs of its
+// records change. Rewrite leaves the scan before the first record.
+//
+// The records are copied to a scratch table, and copied back once the
+// blocks of the table are formatted for the new layout. The formatting is
+// logged, so that the old records are back if the transaction rolls back.
+// Snapshot transactions read a multi-version table without locks, so it
+// must not be rewritten while one of them may still read it.
+func (ts *TableScan) Rewrite(layout *Layout, fill func(dst *TableScan, src *TableScan) error) error {
+	if err := ts.tx.LockFile(ts.filename, transaction.X); err != nil {
+		return err
+	}
+	ts.releaseVersion()
+
+	scratchName := scratchTable(strings.TrimSuffix(ts.filename, ".tbl"))
+	scratch, err := NewTableScan(ts.tx, scratchName, layout)
+	if err != nil {
+		return err
+	}
+	defer scratch.Close()
+	// The scratch table is only left with records by a crash after a
+	// rewrite committed, and before its files were emptied.
+	if err := scratch.format(layout, false); err != nil {
+		return err
+	}
+
+	err = ts.forEach(func() error {
+		if err := scratch.insert(); err != nil {
+			return err
+		}
+		if err := fill(scratch, ts); err != nil {
+			return err
+		}
+		// Deleting the record frees its overflow blocks.
+		return ts.Delete()
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := ts.format(layout, true); err != nil {
+		return err
+	}
+	copyBack := func() error {
+		if err := ts.insert(); err != nil {
+			return err
+		}
+		for _, fieldName := range layout.Schema().Fields() {
+			value, err := scratch.ReadValue(fieldName)
+			if err != nil {
+				return err
+			}
+			if value == nil {
+				continue
+			}
+			if err := ts.WriteValue(fieldName, value); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := scratch.forEach(copyBack); err != nil {
+		return err
+	}
+
+	for _, filename := range tableFiles(scratchName) {
+		if err := ts.tx.Truncate(filename, 0); err != nil {
+			return err
+		}
+	}
+	ts.BeforeFirst()
+	return nil
+}
+
+// forEach calls f with the scan at each record of the table in turn. Unlike
+// a loop over Next, it stops at the first error and returns it.
+func (ts *TableScan) forEach(f func() error) error {
+	size, err := ts.size()
+	if err != nil {
+		return err
+	}
+	for blockNum := range size {
+		if err := ts.moveToBlock(blockNum); err != nil {
+			return err
+		}
+		for {
+			slot, err := ts.recordPage.NextAfter(ts.currentSlot)
+			if err != nil {
+				return err
+			}
+			if slot < 0 {
+				break
+			}
+			ts.currentSlot = slot
+			if err := f(); err != nil {
+				return err
+			}
+			ts.releaseVersion()
+		}
+	}
+	return nil
+}
+
+// format formats every block of the table for records of the specified
+// layout, which the scan uses from then on, and marks the blocks as not
+// full. The writes are logged if log is true.
+func (ts *TableScan) format(layout *Layout, log bool) error {
+	ts.releaseVersion()
+	ts.layout = layout
+	size, err := ts.size()
+	if err != nil {
+		return err
 	}
+	for blockNum := range size {
+		if err := ts.moveToBlock(blockNum); err != nil {
+			return err
+		}
+		if err := ts.recordPage.format(log); err != nil {
+			return err
+		}
+		if err := ts.fsm.setFull(blockNum, false); err != nil {
+			return err
+		}
+	}
+	ts.BeforeFirst()
+	return nil
+}
+
+// current returns the page and slot holding the current version of the
+// current record.
+func (ts *TableScan) current() (*Page, int32) {
+	if ts.versionPage != nil {
+		return ts.versionPage, ts.versionSlot
+	}
+	return ts.recordPage, ts.currentSlot
+}
+
+// writableVersion returns the page and slot that writes to the current
+// record should go to. Records of an ordinary table, and versions the
+// transaction created itself, are updated in place. Otherwise the current
+// version is marked as deleted, and a copy stamped with the transaction is
+// written to a free slot, so that other snapshots keep seeing the old
+// version.
+func (ts *TableScan) writableVersion() (*Page, int32, error) {
+	page, slot := ts.current()
+	if !ts.layout.IsVersioned() || page == ts.versionPage {
+		return page, slot, nil
+	}
+
+	xmin, _, err := page.Version(slot)
+	if err != nil {
+		return nil, 0, err
+	}
+	if xmin == ts.tx.TxNum() {
+		return page, slot, nil
+	}
+
+	return ts.moveCurrent(page.Block().Number(), 0)
+}
+
+// write applies a write to the current record. A record of a slotted table
+// that has outgrown its block is moved to another one first.
+func (ts *TableScan) write(write func(page *Page, slot int32) error) error {
+	page, slot, err := ts.writableVersion()
+	if err != nil {
+		return err
+	}
+	err = write(page, slot)
+	if !errors.Is(err, errPageFull) {
+		return err
+	}
+
+	page, _ = ts.current()
+	page, slot, err = ts.moveCurrent(page.Block().Number()+1, ts.layout.maxRecordSize())
+	if err != nil {
+		return err
+	}
+	return write(page, slot)
+}
+
+// moveCurrent copies the current record to a free slot, searching from the
+// specified block onwards, and deletes it from its old slot. The copy
+// becomes the current record, kept apart from the position of the scan as a
+// new version is. In a slotted table, the new block must have room for
+// reserve bytes.
+func (ts *TableScan) moveCurrent(blockNum int32, reserve int32) (*Page, int32, error) {
+	page, slot := ts.current()
+	values := make(map[string]any)
+	for _, fieldName := range ts.layout.Schema().Fields() {
+		val, err := ts.ReadValue(fieldName)
+		if err != nil {
+			return nil, 0, err
+		}
+		values[fieldName] = val
+	}
+
+	if err := ts.delete(page, slot); err != nil {
+		return nil, 0, err
+	}
+
+	newPage, newSlot, err := ts.insertVersion(blockNum, reserve)
+	if err != nil {
+		return nil, 0, err
+	}
+	for fieldName, val := range values {
+		// The fields of the copy start out as NULL.
+		if val == nil {
+			continue
+		}
+		if err := newPage.writeValue(newSlot, fieldName, val); err != nil {
+			ts.tx.Unpin(newPage.Block())
+			return nil, 0, err
+		}
+	}
+
+	ts.releaseVersion()
+	ts.versionPage = newPage
+	ts.versionSlot = newSlot
+	ts.created[RID{blockNum: newPage.Block().Number(), slot: newSlot}] = struct{}{}
+	return newPage, newSlot, nil
+}
+
+// delete deletes the record in the slot of the page, and marks the block as
+// not full. The slot of a deleted version is only freed once it is pruned.
+func (ts *TableScan) delete(page *Page, slot int32) error {
+	if err := page.Delete(slot); err != nil {
+		return err
+	}
+	if ts.layout.IsVersioned() {
+		return nil
+	}
+	return ts.fsm.setFull(page.Block().Number(), false)
+}
+
+// insertVersion claims a free slot for a new record version, searching from
+// the specified block onwards without moving the scan. The returned page is
+// pinned until releaseVersion is called. In a slotted table, only blocks
+// with room for reserve bytes of records besides the new one are used.
+func (ts *TableScan) insertVersion(blockNum int32, reserve int32) (*Page, int32, error) {
+	for {
+		size, err := ts.size()
+		if err != nil {
+			return nil, 0, err
+		}
+		if blockNum, err = ts.fsm.next(blockNum, size); err != nil {
+			return nil, 0, err
+		}
+
+		var page *Page
+		appended := blockNum >= size
+		if !appended {
+			page, err = NewPage(ts.tx, file.NewBlock(ts.filename, blockNum), ts.layout)
+			if err != nil {
+				return nil, 0, err
+			}
+		} else {
+			block, err := ts.tx.Append(ts.filename)
+			if err != nil {
+				return nil, 0, err
+			}
+			page, err = NewPage(ts.tx, block, ts.layout)
+			if err != nil {
+				return nil, 0, err
+			}
+			if err := page.Format(); err != nil {
+				ts.tx.Unpin(block)
+				return nil, 0, err
+			}
+		}
+
+		var slot int32
+		skipped := false
+		if ts.layout.IsSlotted() {
+			slot, err = page.insertSlotted(-1, reserve)
+		} else {
+			slot, skipped, err = page.insertAfter(-1)
+		}
+		if err != nil {
+			ts.tx.Unpin(page.Block())
+			return nil, 0, err
+		}
+		if slot >= 0 {
+			return page, slot, nil
+		}
+
+		ts.tx.Unpin(page.Block())
+		// Not even an empty block has room for the record.
+		if appended {
+			return nil, 0, errPageFull
+		}
+		// A block without room for the reserve may still have empty slots,
+		// as may one whose empty slots were locked.
+		if reserve == 0 && !skipped {
+			if err := ts.fsm.setFull(blockNum, true); err != nil {
+				return nil, 0, err
+			}
+		}
+		blockNum++
+	}
+}
+
+// releaseVersion forgets the new version of the current record, if any.
+func (ts *TableScan) releaseVersion() {
+	if ts.versionPage != nil {
+		ts.tx.Unpin(ts.versionPage.Block())
+		ts.versionPage = nil
+	}
+}
+
+// moveToStart moves the scan before the first record of the table. If the
+// table has no blocks, the scan is left without a current block, rather than
+// appending one, so that the table can be scanned by a read-only
+// transaction.
+func (ts *TableScan) moveToStart() error {
+	size, err := ts.size()
+	if err != nil {
+		return err
+	}
+	if size > 0 {
+		return ts.moveToBlock(0)
+	}
+	ts.unpin()
+	ts.recordPage = nil
+	ts.currentSlot = -1
+	return nil
 }
 
 func (ts *TableScan) moveToBlock(blockNum int32) error {
-	ts.Close()
+	ts.unpin()
 	block := file.NewBlock(ts.filename, blockNum)
 	recordPage, err := NewPage(ts.tx, block, ts.layout)
 	if err != nil {
@@ -164,7 +934,7 @@ func (ts *TableScan) moveToBlock(blockNum int32) error {
 }
 
 func (ts *TableScan) moveToNewBlock() error {
-	ts.Close()
+	ts.unpin()
 	block, err := ts.tx.Append(ts.filename)
 	if err != nil {
 		return err
@@ -185,18 +955,30 @@ func (ts *TableScan) moveToNewBlock() error {
 }
 
 func (ts *TableScan) atLastBlock() (bool, error) {
-	size, err := ts.tx.Size(ts.filename)
+	size, err := ts.size()
 	if err != nil {
 		return false, err
 	}
 	return ts.recordPage.Block().Number() == size-1, nil
 }
 
+// size returns the number of blocks in the table file.
+func (ts *TableScan) size() (int32, error) {
+	if ts.layout.IsVersioned() {
+		return ts.tx.SnapshotSize(ts.filename)
+	}
+	return ts.tx.Size(ts.filename)
+}
+
 type RID struct {
 	blockNum int32
 	slot     int32
 }
 
+func NewRID(blockNum int32, slot int32) *RID {
+	return &RID{blockNum: blockNum, slot: slot}
+}
+
 func (r *RID) BlockNumber() int32 {
 	return r.blockNum
 }
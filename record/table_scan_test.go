@@ -1,32 +1,39 @@
 package record
 
 import (
+	"errors"
 	"fmt"
+	"io"
+	"maps"
 	"math/rand/v2"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
 	"simpledb/buffer"
+	"simpledb/decimal"
 	"simpledb/file"
 	"simpledb/log"
 	"simpledb/transaction"
 )
 
 func TestTableScan(t *testing.T) {
-	directory := t.TempDir()
+	fileManager := file.NewMemoryStorage(400)
 
-	fileManager, err := file.NewManager(directory, 400)
+	logManager, err := log.NewManager(fileManager, "testlogfile")
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	logManager, err := log.NewManager(fileManager, "testlogfile")
+	bufferManager := buffer.NewManager(fileManager, logManager, 8)
+
+	transactionManager, err := transaction.NewManager(fileManager, logManager, bufferManager)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	bufferManager := buffer.NewManager(fileManager, logManager, 8)
-
-	tx, err := transaction.NewTransaction(fileManager, logManager, bufferManager)
+	tx, err := transactionManager.NewTransaction()
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -86,3 +93,1988 @@ func TestTableScan(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestTableScan_EmptyTable(t *testing.T) {
+	fm := file.NewMemoryStorage(400)
+	lm, err := log.NewManager(fm, "testlogfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bm := buffer.NewManager(fm, lm, 8)
+	tm, err := transaction.NewManager(fm, lm, bm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	schema := NewSchema()
+	schema.AddIntField("A")
+	layout := NewLayout(schema)
+
+	// A read-only transaction can scan a table that has no blocks yet.
+	reader, err := tm.NewTransaction(transaction.ReadOnly())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts, err := NewTableScan(reader, "T", layout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ts.Next() {
+		t.Error("Next() = true on an empty table")
+	}
+	ts.BeforeFirst()
+	if ts.Next() {
+		t.Error("Next() = true after BeforeFirst on an empty table")
+	}
+	if err := ts.Insert(); !errors.Is(err, transaction.ErrReadOnlyTransaction) {
+		t.Errorf("Insert: got error %v, want %v", err, transaction.ErrReadOnlyTransaction)
+	}
+	ts.Close()
+	if err := reader.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if size, err := fm.Size("T.tbl"); err != nil || size != 0 {
+		t.Errorf("table size = %d, %v, want 0", size, err)
+	}
+
+	// The first insert appends the first block.
+	tx, err := tm.NewTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts, err = NewTableScan(tx, "T", layout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ts.Next() {
+		t.Error("Next() = true on an empty table")
+	}
+	if err := ts.Insert(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ts.WriteInt32("A", 7); err != nil {
+		t.Fatal(err)
+	}
+	ts.BeforeFirst()
+	var values []int32
+	for ts.Next() {
+		a, err := ts.ReadInt32("A")
+		if err != nil {
+			t.Fatal(err)
+		}
+		values = append(values, a)
+	}
+	ts.Close()
+	if want := []int32{7}; !slices.Equal(values, want) {
+		t.Errorf("got %v, want %v", values, want)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newVersionedTable(t *testing.T, tm *transaction.Manager, values ...int32) *Layout {
+	t.Helper()
+
+	schema := NewSchema()
+	schema.AddIntField("A")
+	schema.AddStringField("B", 9)
+	layout := NewVersionedLayout(schema)
+
+	tx, err := tm.NewTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts, err := NewTableScan(tx, "V", layout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range values {
+		if err := ts.Insert(); err != nil {
+			t.Fatal(err)
+		}
+		if err := ts.WriteInt32("A", n); err != nil {
+			t.Fatal(err)
+		}
+		if err := ts.WriteString("B", fmt.Sprintf("rec%d", n)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ts.Close()
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	return layout
+}
+
+func readAll(t *testing.T, tx *transaction.Transaction, layout *Layout) []int32 {
+	t.Helper()
+
+	ts, err := NewTableScan(tx, "V", layout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ts.Close()
+
+	var values []int32
+	for ts.Next() {
+		a, err := ts.ReadInt32("A")
+		if err != nil {
+			t.Fatal(err)
+		}
+		values = append(values, a)
+	}
+	slices.Sort(values)
+	return values
+}
+
+func TestTableScan_SnapshotIsolation(t *testing.T) {
+	fm := file.NewMemoryStorage(400)
+	lm, err := log.NewManager(fm, "testlogfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bm := buffer.NewManager(fm, lm, 8)
+	tm, err := transaction.NewManager(fm, lm, bm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	layout := newVersionedTable(t, tm, 1, 2, 3)
+
+	reader, err := tm.NewTransaction(transaction.WithIsolationLevel(transaction.SnapshotIsolation))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := readAll(t, reader, layout), []int32{1, 2, 3}; !slices.Equal(got, want) {
+		t.Fatalf("reader before update: got %v, want %v", got, want)
+	}
+
+	// The writer must not be blocked by the reader, which holds no locks.
+	writer, err := tm.NewTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts, err := NewTableScan(writer, "V", layout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ts.Next() {
+		a, err := ts.ReadInt32("A")
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch a {
+		case 1:
+			if err := ts.WriteInt32("A", a+10); err != nil {
+				t.Fatal(err)
+			}
+		case 2:
+			if err := ts.Delete(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := ts.Insert(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ts.WriteInt32("A", 4); err != nil {
+		t.Fatal(err)
+	}
+	ts.Close()
+
+	if got, want := readAll(t, writer, layout), []int32{3, 4, 11}; !slices.Equal(got, want) {
+		t.Errorf("writer after update: got %v, want %v", got, want)
+	}
+	if err := writer.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The reader keeps seeing the database as of its start.
+	if got, want := readAll(t, reader, layout), []int32{1, 2, 3}; !slices.Equal(got, want) {
+		t.Errorf("reader after concurrent commit: got %v, want %v", got, want)
+	}
+
+	// While the reader is running, the old versions must be kept.
+	gc, err := tm.NewTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts, err = NewTableScan(gc, "V", layout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pruned, err := ts.PruneDeadVersions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts.Close()
+	if pruned != 0 {
+		t.Errorf("pruned %d versions while a snapshot needs them, want 0", pruned)
+	}
+	if err := gc.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := reader.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Once no snapshot needs them, the updated and deleted versions are dead.
+	gc, err = tm.NewTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts, err = NewTableScan(gc, "V", layout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pruned, err = ts.PruneDeadVersions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts.Close()
+	if pruned != 2 {
+		t.Errorf("pruned %d versions, want 2", pruned)
+	}
+	if got, want := readAll(t, gc, layout), []int32{3, 4, 11}; !slices.Equal(got, want) {
+		t.Errorf("after garbage collection: got %v, want %v", got, want)
+	}
+	if err := gc.Commit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTableScan_WriteConflict(t *testing.T) {
+	fm := file.NewMemoryStorage(400)
+	lm, err := log.NewManager(fm, "testlogfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bm := buffer.NewManager(fm, lm, 8)
+	tm, err := transaction.NewManager(fm, lm, bm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	layout := newVersionedTable(t, tm, 1)
+
+	update := func(tx *transaction.Transaction) error {
+		ts, err := NewTableScan(tx, "V", layout)
+		if err != nil {
+			return err
+		}
+		defer ts.Close()
+		if !ts.Next() {
+			t.Fatal("no record to update")
+		}
+		return ts.WriteInt32("A", int32(tx.TxNum()))
+	}
+
+	tx1, err := tm.NewTransaction(transaction.WithIsolationLevel(transaction.SnapshotIsolation))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx2, err := tm.NewTransaction(transaction.WithIsolationLevel(transaction.SnapshotIsolation))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := update(tx2); err != nil {
+		t.Fatalf("tx2: failed to update: %v", err)
+	}
+	if err := tx2.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	// tx2 committed first, so tx1's update of the same record must fail.
+	if err := update(tx1); !errors.Is(err, transaction.ErrWriteConflict) {
+		t.Errorf("tx1: got error %v, want %v", err, transaction.ErrWriteConflict)
+	}
+	if err := tx1.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+
+	tx3, err := tm.NewTransaction(transaction.WithIsolationLevel(transaction.SnapshotIsolation))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := readAll(t, tx3, layout), []int32{int32(tx2.TxNum())}; !slices.Equal(got, want) {
+		t.Errorf("after conflict: got %v, want %v", got, want)
+	}
+	if err := tx3.Commit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTableScan_RecordLocks(t *testing.T) {
+	fm := file.NewMemoryStorage(400)
+	lm, err := log.NewManager(fm, "testlogfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bm := buffer.NewManager(fm, lm, 8)
+	tm, err := transaction.NewManager(fm, lm, bm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	schema := NewSchema()
+	schema.AddIntField("A")
+	layout := NewLayout(schema)
+
+	tx, err := tm.NewTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts, err := NewTableScan(tx, "T", layout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rids []*RID
+	for n := range int32(2) {
+		if err := ts.Insert(); err != nil {
+			t.Fatal(err)
+		}
+		if err := ts.WriteInt32("A", n); err != nil {
+			t.Fatal(err)
+		}
+		rids = append(rids, ts.GetRID())
+	}
+	ts.Close()
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if rids[0].BlockNumber() != rids[1].BlockNumber() {
+		t.Fatal("records were not inserted into the same block")
+	}
+
+	update := func(tx *transaction.Transaction, rid *RID, value int32) error {
+		ts, err := NewTableScan(tx, "T", layout)
+		if err != nil {
+			return err
+		}
+		defer ts.Close()
+		if err := ts.MoveToRID(rid); err != nil {
+			return err
+		}
+		return ts.WriteInt32("A", value)
+	}
+
+	tx1, err := tm.NewTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx2, err := tm.NewTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := update(tx1, rids[0], 10); err != nil {
+		t.Fatal(err)
+	}
+
+	// tx2 updates another record of the same block while tx1 is running.
+	done := make(chan error, 1)
+	go func() {
+		done <- update(tx2, rids[1], 11)
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("update of a different record in the same block was blocked")
+	}
+
+	// Rolling back tx2 must not undo tx1's change.
+	if err := tx2.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx1.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	tx3, err := tm.NewTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts, err = NewTableScan(tx3, "T", layout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var values []int32
+	for ts.Next() {
+		a, err := ts.ReadInt32("A")
+		if err != nil {
+			t.Fatal(err)
+		}
+		values = append(values, a)
+	}
+	ts.Close()
+	if want := []int32{10, 1}; !slices.Equal(values, want) {
+		t.Errorf("got %v, want %v", values, want)
+	}
+	if err := tx3.Commit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTableScan_ConcurrentInserts(t *testing.T) {
+	testCases := []struct {
+		name  string
+		level transaction.IsolationLevel
+	}{
+		{"ReadUncommitted", transaction.ReadUncommitted},
+		{"ReadCommitted", transaction.ReadCommitted},
+		{"RepeatableRead", transaction.RepeatableRead},
+		{"Serializable", transaction.Serializable},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fm := file.NewMemoryStorage(400)
+			lm, err := log.NewManager(fm, "testlogfile")
+			if err != nil {
+				t.Fatal(err)
+			}
+			bm := buffer.NewManager(fm, lm, 8)
+			tm, err := transaction.NewManager(fm, lm, bm)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			schema := NewSchema()
+			schema.AddIntField("A")
+			layout := NewLayout(schema)
+
+			tx, err := tm.NewTransaction()
+			if err != nil {
+				t.Fatal(err)
+			}
+			ts, err := NewTableScan(tx, "V", layout)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := ts.Insert(); err != nil {
+				t.Fatal(err)
+			}
+			if err := ts.WriteInt32("A", 0); err != nil {
+				t.Fatal(err)
+			}
+			ts.Close()
+			if err := tx.Commit(); err != nil {
+				t.Fatal(err)
+			}
+
+			insert := func(tx *transaction.Transaction, value int32) (*RID, error) {
+				ts, err := NewTableScan(tx, "V", layout)
+				if err != nil {
+					return nil, err
+				}
+				defer ts.Close()
+				if err := ts.Insert(); err != nil {
+					return nil, err
+				}
+				return ts.GetRID(), ts.WriteInt32("A", value)
+			}
+
+			tx1, err := tm.NewTransaction(transaction.WithIsolationLevel(tc.level))
+			if err != nil {
+				t.Fatal(err)
+			}
+			tx2, err := tm.NewTransaction(transaction.WithIsolationLevel(tc.level))
+			if err != nil {
+				t.Fatal(err)
+			}
+			rid1, err := insert(tx1, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// tx2 must claim another slot while tx1 holds the one it filled,
+			// whatever shared locks the isolation level keeps.
+			type result struct {
+				rid *RID
+				err error
+			}
+			done := make(chan result, 1)
+			go func() {
+				rid, err := insert(tx2, 2)
+				done <- result{rid, err}
+			}()
+			select {
+			case r := <-done:
+				if r.err != nil {
+					t.Fatal(r.err)
+				}
+				if r.rid.Equals(rid1) {
+					t.Fatalf("both transactions claimed slot %v", rid1)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("insert was blocked by a concurrent insert")
+			}
+			if err := tx1.Commit(); err != nil {
+				t.Fatal(err)
+			}
+			if err := tx2.Commit(); err != nil {
+				t.Fatal(err)
+			}
+
+			tx3, err := tm.NewTransaction()
+			if err != nil {
+				t.Fatal(err)
+			}
+			values := readAll(t, tx3, layout)
+			if want := []int32{0, 1, 2}; !slices.Equal(values, want) {
+				t.Errorf("got %v, want %v", values, want)
+			}
+			if err := tx3.Commit(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestTableScan_Null(t *testing.T) {
+	fm := file.NewMemoryStorage(400)
+	lm, err := log.NewManager(fm, "testlogfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bm := buffer.NewManager(fm, lm, 8)
+	tm, err := transaction.NewManager(fm, lm, bm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	schema := NewSchema()
+	schema.AddIntField("A")
+	schema.AddField("B", Integer, 0, true)
+	schema.AddField("C", Varchar, 9, true)
+
+	checkNulls := func(ts *TableScan, want map[string]bool) {
+		t.Helper()
+		for fieldName, wantNull := range want {
+			null, err := ts.IsNull(fieldName)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if null != wantNull {
+				t.Errorf("IsNull(%q): got %v, want %v", fieldName, null, wantNull)
+			}
+			val, err := ts.ReadValue(fieldName)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (val == nil) != wantNull {
+				t.Errorf("ReadValue(%q): got %v, want NULL: %v", fieldName, val, wantNull)
+			}
+		}
+	}
+
+	for _, layout := range []*Layout{NewLayout(schema), NewVersionedLayout(schema)} {
+		tx, err := tm.NewTransaction()
+		if err != nil {
+			t.Fatal(err)
+		}
+		tableName := fmt.Sprintf("N%d", layout.Flags())
+		ts, err := NewTableScan(tx, tableName, layout)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The nullable fields of a new record are NULL until written.
+		if err := ts.Insert(); err != nil {
+			t.Fatal(err)
+		}
+		checkNulls(ts, map[string]bool{"A": false, "B": true, "C": true})
+
+		if err := ts.WriteInt32("B", 0); err != nil {
+			t.Fatal(err)
+		}
+		if err := ts.WriteString("C", ""); err != nil {
+			t.Fatal(err)
+		}
+		checkNulls(ts, map[string]bool{"B": false, "C": false})
+
+		if err := ts.SetNull("C"); err != nil {
+			t.Fatal(err)
+		}
+		if err := ts.SetNull("A"); !errors.Is(err, ErrNotNullable) {
+			t.Errorf("SetNull(%q): got error %v, want %v", "A", err, ErrNotNullable)
+		}
+		checkNulls(ts, map[string]bool{"A": false, "B": false, "C": true})
+
+		if err := ts.WriteValue("B", nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := ts.WriteInt32("A", 1); err != nil {
+			t.Fatal(err)
+		}
+		ts.Close()
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+
+		// Updating a record of another transaction in a multi-version
+		// table copies its nulls to the new version.
+		tx, err = tm.NewTransaction()
+		if err != nil {
+			t.Fatal(err)
+		}
+		ts, err = NewTableScan(tx, tableName, layout)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ts.Next() {
+			t.Fatal("no record")
+		}
+		if err := ts.WriteString("C", "c"); err != nil {
+			t.Fatal(err)
+		}
+		checkNulls(ts, map[string]bool{"A": false, "B": true, "C": false})
+		ts.Close()
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestTableScan_Types(t *testing.T) {
+	fm := file.NewMemoryStorage(400)
+	lm, err := log.NewManager(fm, "testlogfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bm := buffer.NewManager(fm, lm, 8)
+	tm, err := transaction.NewManager(fm, lm, bm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	schema := NewSchema()
+	schema.AddField("big", BigInt, 0, false)
+	schema.AddField("dbl", Double, 0, false)
+	schema.AddField("bool", Boolean, 0, false)
+	schema.AddField("date", Date, 0, false)
+	schema.AddField("ts", Timestamp, 0, false)
+	layout := NewLayout(schema)
+	if got, want := layout.SlotSize(), int32(4+8+8+4+8+8); got != want {
+		t.Errorf("slot size: got %d, want %d", got, want)
+	}
+
+	zone := time.FixedZone("X", -5*3600)
+	ts := time.Date(2024, 2, 29, 22, 30, 0, 123456789, zone)
+	want := map[string]any{
+		"big":  int64(1 << 40),
+		"dbl":  -2.5,
+		"bool": true,
+		// The date is the calendar date in the time's own location.
+		"date": time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC),
+		"ts":   ts.Truncate(time.Microsecond).UTC(),
+	}
+
+	tx, err := tm.NewTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	scan, err := NewTableScan(tx, "T", layout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := scan.Insert(); err != nil {
+		t.Fatal(err)
+	}
+	for _, err := range []error{
+		scan.WriteInt64("big", 1<<40),
+		scan.WriteFloat64("dbl", -2.5),
+		scan.WriteBool("bool", true),
+		scan.WriteTime("date", ts),
+		scan.WriteValue("ts", ts),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	scan.Close()
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err = tm.NewTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Commit()
+	scan, err = NewTableScan(tx, "T", layout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer scan.Close()
+	if !scan.Next() {
+		t.Fatal("no record")
+	}
+	for fieldName, wantVal := range want {
+		got, err := scan.ReadValue(fieldName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != wantVal {
+			t.Errorf("ReadValue(%q): got %v, want %v", fieldName, got, wantVal)
+		}
+	}
+	if got, err := scan.ReadFloat64("dbl"); err != nil || got != -2.5 {
+		t.Errorf("ReadFloat64: got %v, %v, want %v", got, err, -2.5)
+	}
+}
+
+func TestTableScan_Decimal(t *testing.T) {
+	fm := file.NewMemoryStorage(400)
+	lm, err := log.NewManager(fm, "testlogfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bm := buffer.NewManager(fm, lm, 8)
+	tm, err := transaction.NewManager(fm, lm, bm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	schema := NewSchema()
+	schema.AddDecimalField("amount", 12, 2, false)
+	layout := NewLayout(schema)
+
+	tx, err := tm.NewTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Commit()
+	ts, err := NewTableScan(tx, "T", layout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ts.Close()
+	if err := ts.Insert(); err != nil {
+		t.Fatal(err)
+	}
+
+	// More than int32 cents can hold, rounded to the scale of the field.
+	d, err := decimal.Parse("9999999999.995")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ts.WriteDecimal("amount", d); !errors.Is(err, ErrOutOfRange) {
+		t.Errorf("WriteDecimal(%s): got error %v, want %v", d, err, ErrOutOfRange)
+	}
+	d, err = decimal.Parse("42949672.945")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ts.WriteValue("amount", d); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ts.ReadDecimal("amount")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.String() != "42949672.95" {
+		t.Errorf("ReadDecimal: got %s, want %s", got, "42949672.95")
+	}
+}
+
+func TestTableScan_Slotted(t *testing.T) {
+	fm := file.NewMemoryStorage(400)
+	lm, err := log.NewManager(fm, "testlogfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bm := buffer.NewManager(fm, lm, 8)
+	tm, err := transaction.NewManager(fm, lm, bm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	schema := NewSchema()
+	schema.AddIntField("A")
+	schema.AddStringField("B", 300)
+	layout := NewSlottedLayout(schema)
+
+	// A record whose strings are short takes little of the block.
+	tx, err := tm.NewTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts, err := NewTableScan(tx, "T", layout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := make(map[int32]string)
+	for i := range int32(10) {
+		if err := ts.Insert(); err != nil {
+			t.Fatal(err)
+		}
+		if err := ts.WriteInt32("A", i); err != nil {
+			t.Fatal(err)
+		}
+		want[i] = fmt.Sprintf("rec%d", i)
+		if err := ts.WriteString("B", want[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if rid := ts.GetRID(); rid.BlockNumber() != 0 {
+		t.Errorf("last record in block %d, want 0", rid.BlockNumber())
+	}
+	if err := ts.WriteString("B", strings.Repeat("x", 301)); !errors.Is(err, ErrOutOfRange) {
+		t.Errorf("WriteString: got error %v, want %v", err, ErrOutOfRange)
+	}
+	ts.Close()
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(want map[int32]string) {
+		t.Helper()
+		tx, err := tm.NewTransaction()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer tx.Commit()
+		ts, err := NewTableScan(tx, "T", layout)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ts.Close()
+		got := make(map[int32]string)
+		for ts.Next() {
+			a, err := ts.ReadInt32("A")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got[a], err = ts.ReadString("B"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if !maps.Equal(got, want) {
+			t.Errorf("got records %v, want %v", got, want)
+		}
+	}
+	check(want)
+
+	// Growing the strings moves records that no longer fit to other blocks,
+	// and deleting and shrinking records frees space again.
+	tx, err = tm.NewTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts, err = NewTableScan(tx, "T", layout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	updated := maps.Clone(want)
+	for ts.Next() {
+		a, err := ts.ReadInt32("A")
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch {
+		case a%3 == 0:
+			if err := ts.Delete(); err != nil {
+				t.Fatal(err)
+			}
+			delete(updated, a)
+		case a%3 == 1:
+			updated[a] = strings.Repeat(fmt.Sprint(a), 150/len(fmt.Sprint(a)))
+			if err := ts.WriteString("B", updated[a]); err != nil {
+				t.Fatal(err)
+			}
+		default:
+			updated[a] = ""
+			if err := ts.WriteString("B", ""); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	ts.Close()
+	if size, err := tx.Size("T.tbl"); err != nil {
+		t.Fatal(err)
+	} else if size < 2 {
+		t.Errorf("table has %d blocks, want records moved to more blocks", size)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	check(want)
+
+	tx, err = tm.NewTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts, err = NewTableScan(tx, "T", layout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ts.Next() {
+		a, err := ts.ReadInt32("A")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := updated[a]; !ok {
+			if err := ts.Delete(); err != nil {
+				t.Fatal(err)
+			}
+		} else if err := ts.WriteString("B", updated[a]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ts.Close()
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	check(updated)
+}
+
+func TestTableScan_LargeValues(t *testing.T) {
+	fm := file.NewMemoryStorage(400)
+	lm, err := log.NewManager(fm, "testlogfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bm := buffer.NewManager(fm, lm, 8)
+	tm, err := transaction.NewManager(fm, lm, bm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	schema := NewSchema()
+	schema.AddField("doc", Text, 20, false)
+	schema.AddField("data", Blob, 20, true)
+	layout := NewLayout(schema)
+
+	short := "short"
+	long := strings.Repeat("0123456789", 150)
+	blob := make([]byte, 2000)
+	for i := range blob {
+		blob[i] = byte(i)
+	}
+
+	tx, err := tm.NewTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts, err := NewTableScan(tx, "T", layout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ts.Insert(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ts.WriteString("doc", short); err != nil {
+		t.Fatal(err)
+	}
+	if err := ts.Insert(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ts.WriteLargeValue("doc", strings.NewReader(long)); err != nil {
+		t.Fatal(err)
+	}
+	if err := ts.WriteValue("data", blob); err != nil {
+		t.Fatal(err)
+	}
+	ts.Close()
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	type row struct {
+		doc  string
+		data []byte
+	}
+	read := func(tx *transaction.Transaction) []row {
+		t.Helper()
+		ts, err := NewTableScan(tx, "T", layout)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ts.Close()
+		var rows []row
+		for ts.Next() {
+			r, err := ts.ReadLargeValue("doc")
+			if err != nil {
+				t.Fatal(err)
+			}
+			doc, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := ts.ReadValue("data")
+			if err != nil {
+				t.Fatal(err)
+			}
+			rows = append(rows, row{doc: string(doc)})
+			if data != nil {
+				rows[len(rows)-1].data = data.([]byte)
+			}
+		}
+		return rows
+	}
+	equal := func(a, b []row) bool {
+		return slices.EqualFunc(a, b, func(a, b row) bool {
+			return a.doc == b.doc && slices.Equal(a.data, b.data)
+		})
+	}
+
+	want := []row{{doc: short}, {doc: long, data: blob}}
+	tx, err = tm.NewTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := read(tx); !equal(got, want) {
+		t.Errorf("got %d rows, want the %d written", len(got), len(want))
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Overwriting and deleting long values frees their overflow blocks for
+	// later values, and rolling back restores the old values.
+	tx, err = tm.NewTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts, err = NewTableScan(tx, "T", layout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ts.Next() {
+		doc, err := ts.ReadString("doc")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if doc == short {
+			if err := ts.WriteString("doc", strings.ToUpper(long)); err != nil {
+				t.Fatal(err)
+			}
+			if err := ts.WriteBlob("data", blob[:10]); err != nil {
+				t.Fatal(err)
+			}
+		} else if err := ts.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ts.Close()
+	size, err := tx.Size("T.ovf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 3 {
+		ts, err = NewTableScan(tx, "T", layout)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ts.Next()
+		if err := ts.WriteString("doc", strings.ToLower(long)); err != nil {
+			t.Fatal(err)
+		}
+		ts.Close()
+	}
+	if got, err := tx.Size("T.ovf"); err != nil {
+		t.Fatal(err)
+	} else if got != size {
+		t.Errorf("overflow file has %d blocks, want freed blocks reused and %d", got, size)
+	}
+	if got := read(tx); !equal(got, []row{{doc: strings.ToLower(long), data: blob[:10]}}) {
+		t.Errorf("got rows %v, want the updated one", got)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err = tm.NewTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Commit()
+	if got := read(tx); !equal(got, want) {
+		t.Errorf("rollback did not restore the %d rows", len(want))
+	}
+}
+
+func TestTableScan_PruneLargeValues(t *testing.T) {
+	fm := file.NewMemoryStorage(400)
+	lm, err := log.NewManager(fm, "testlogfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bm := buffer.NewManager(fm, lm, 8)
+	tm, err := transaction.NewManager(fm, lm, bm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	schema := NewSchema()
+	schema.AddField("doc", Text, 20, false)
+	layout := NewVersionedLayout(schema)
+	long := strings.Repeat("0123456789", 150)
+
+	// write runs a transaction that writes the long value to the record,
+	// inserting it first if insert is true.
+	write := func(insert bool) {
+		t.Helper()
+		tx, err := tm.NewTransaction()
+		if err != nil {
+			t.Fatal(err)
+		}
+		ts, err := NewTableScan(tx, "T", layout)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if insert {
+			if err := ts.Insert(); err != nil {
+				t.Fatal(err)
+			}
+		} else if !ts.Next() {
+			t.Fatal("the record is gone")
+		}
+		if err := ts.WriteString("doc", long); err != nil {
+			t.Fatal(err)
+		}
+		ts.Close()
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	size := func() int32 {
+		t.Helper()
+		size, err := fm.Size("T.ovf")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return size
+	}
+
+	write(true)
+	write(false)
+	used := size()
+
+	gc, err := tm.NewTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts, err := NewTableScan(gc, "T", layout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pruned, err := ts.PruneDeadVersions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts.Close()
+	if err := gc.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if pruned != 1 {
+		t.Fatalf("pruned %d versions, want 1", pruned)
+	}
+
+	// The overflow blocks of the pruned version hold the new one.
+	write(false)
+	if got := size(); got != used {
+		t.Errorf("overflow file has %d blocks, want freed blocks reused and %d", got, used)
+	}
+}
+
+func TestTableScan_FreeSpaceMap(t *testing.T) {
+	fm := file.NewMemoryStorage(400)
+	lm, err := log.NewManager(fm, "testlogfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bm := buffer.NewManager(fm, lm, 8)
+	tm, err := transaction.NewManager(fm, lm, bm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	schema := NewSchema()
+	schema.AddIntField("A")
+	schema.AddStringField("B", 9)
+	layout := NewLayout(schema)
+	perBlock := 400 / layout.SlotSize()
+
+	tx, err := tm.NewTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts, err := NewTableScan(tx, "T", layout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 5 * perBlock {
+		if err := ts.Insert(); err != nil {
+			t.Fatal(err)
+		}
+		if err := ts.WriteInt32("A", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ts.Close()
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	// nextFree returns the first block an insert would try.
+	nextFree := func(tx *transaction.Transaction) int32 {
+		t.Helper()
+		size, err := tx.Size("T.tbl")
+		if err != nil {
+			t.Fatal(err)
+		}
+		next, err := newFreeSpaceMap(tx, "T.fsm").next(0, size)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return next
+	}
+
+	tx, err = tm.NewTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The first four blocks were found full by later inserts.
+	if got := nextFree(tx); got != 4 {
+		t.Errorf("first free block: got %d, want %d", got, 4)
+	}
+	ts, err = NewTableScan(tx, "T", layout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ts.Next() {
+		a, err := ts.ReadInt32("A")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if a == 2*perBlock+1 {
+			if err := ts.Delete(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if got := nextFree(tx); got != 2 {
+		t.Errorf("first free block after delete: got %d, want %d", got, 2)
+	}
+	ts.Close()
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err = tm.NewTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Commit()
+	if got := nextFree(tx); got != 4 {
+		t.Errorf("first free block after rollback: got %d, want %d", got, 4)
+	}
+	ts, err = NewTableScan(tx, "T", layout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ts.Close()
+	if err := ts.Insert(); err != nil {
+		t.Fatal(err)
+	}
+	if got := ts.GetRID().BlockNumber(); got != 5 {
+		t.Errorf("inserted into block %d, want new block %d", got, 5)
+	}
+}
+
+func TestTableScan_FreeSpaceMapLockedSlots(t *testing.T) {
+	fm := file.NewMemoryStorage(400)
+	lm, err := log.NewManager(fm, "testlogfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bm := buffer.NewManager(fm, lm, 8)
+	tm, err := transaction.NewManager(fm, lm, bm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	schema := NewSchema()
+	schema.AddIntField("A")
+	layout := NewLayout(schema)
+	perBlock := 400 / layout.SlotSize()
+
+	tx, err := tm.NewTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts, err := NewTableScan(tx, "T", layout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range perBlock {
+		if err := ts.Insert(); err != nil {
+			t.Fatal(err)
+		}
+		if err := ts.WriteInt32("A", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ts.Close()
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	// deleter frees a slot of the full block, which stays locked until it
+	// commits. It reads committed data only, so that it does not keep the
+	// end of the file from growing.
+	deleter, err := tm.NewTransaction(transaction.WithIsolationLevel(transaction.ReadCommitted))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts, err = NewTableScan(deleter, "T", layout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ts.Next() {
+		t.Fatal("no record to delete")
+	}
+	if err := ts.Delete(); err != nil {
+		t.Fatal(err)
+	}
+	ts.Close()
+
+	// inserter skips the locked slot, without marking the block as full.
+	inserter, err := tm.NewTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts, err = NewTableScan(inserter, "T", layout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ts.Insert(); err != nil {
+		t.Fatal(err)
+	}
+	if got := ts.GetRID().BlockNumber(); got != 1 {
+		t.Errorf("inserted into block %d, want new block %d", got, 1)
+	}
+	ts.Close()
+	size, err := inserter.Size("T.tbl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	next, err := newFreeSpaceMap(inserter, "T.fsm").next(0, size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next != 0 {
+		t.Errorf("first free block: got %d, want %d", next, 0)
+	}
+	if err := deleter.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := inserter.Commit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTableScan_Vacuum(t *testing.T) {
+	schema := NewSchema()
+	schema.AddIntField("A")
+	schema.AddField("B", Text, 9, false)
+
+	for name, layout := range map[string]*Layout{
+		"fixed":   NewLayout(schema),
+		"slotted": NewSlottedLayout(schema),
+	} {
+		t.Run(name, func(t *testing.T) {
+			fm := file.NewMemoryStorage(400)
+			lm, err := log.NewManager(fm, "testlogfile")
+			if err != nil {
+				t.Fatal(err)
+			}
+			bm := buffer.NewManager(fm, lm, 8)
+			tm, err := transaction.NewManager(fm, lm, bm)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Every third record survives, some with a value in overflow
+			// blocks, which must not be copied or freed by the move.
+			want := make(map[int32]string)
+			tx, err := tm.NewTransaction()
+			if err != nil {
+				t.Fatal(err)
+			}
+			ts, err := NewTableScan(tx, "T", layout)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := range int32(150) {
+				if err := ts.Insert(); err != nil {
+					t.Fatal(err)
+				}
+				if err := ts.WriteInt32("A", i); err != nil {
+					t.Fatal(err)
+				}
+				b := fmt.Sprint(i)
+				if i%10 == 0 {
+					b = strings.Repeat(b, 100)
+				}
+				if err := ts.WriteString("B", b); err != nil {
+					t.Fatal(err)
+				}
+				if i%3 == 0 {
+					want[i] = b
+				}
+			}
+			ts.BeforeFirst()
+			for ts.Next() {
+				a, err := ts.ReadInt32("A")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, ok := want[a]; !ok {
+					if err := ts.Delete(); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+			ts.Close()
+			if err := tx.Commit(); err != nil {
+				t.Fatal(err)
+			}
+			size, err := fm.Size("T.tbl")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			read := func(tx *transaction.Transaction) map[int32]string {
+				t.Helper()
+				ts, err := NewTableScan(tx, "T", layout)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer ts.Close()
+				got := make(map[int32]string)
+				for ts.Next() {
+					a, err := ts.ReadInt32("A")
+					if err != nil {
+						t.Fatal(err)
+					}
+					if got[a], err = ts.ReadString("B"); err != nil {
+						t.Fatal(err)
+					}
+				}
+				return got
+			}
+			vacuum := func(tx *transaction.Transaction) int32 {
+				t.Helper()
+				ts, err := NewTableScan(tx, "T", layout)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer ts.Close()
+				n, err := ts.Vacuum(func(from *RID, to *RID) error {
+					if to.BlockNumber() >= from.BlockNumber() {
+						t.Errorf("record moved from block %d to block %d", from.BlockNumber(), to.BlockNumber())
+					}
+					return nil
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+				return n
+			}
+
+			// Rolling back leaves the file as it was.
+			tx, err = tm.NewTransaction()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n := vacuum(tx); n == 0 {
+				t.Error("Vacuum cut off no blocks")
+			}
+			if err := tx.Rollback(); err != nil {
+				t.Fatal(err)
+			}
+			if got, err := fm.Size("T.tbl"); err != nil {
+				t.Fatal(err)
+			} else if got != size {
+				t.Errorf("file has %d blocks after rollback, want %d", got, size)
+			}
+
+			tx, err = tm.NewTransaction()
+			if err != nil {
+				t.Fatal(err)
+			}
+			n := vacuum(tx)
+			if err := tx.Commit(); err != nil {
+				t.Fatal(err)
+			}
+			if got, err := fm.Size("T.tbl"); err != nil {
+				t.Fatal(err)
+			} else if got != size-n {
+				t.Errorf("file has %d blocks after vacuum, want %d", got, size-n)
+			}
+
+			tx, err = tm.NewTransaction()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer tx.Commit()
+			if got := read(tx); !maps.Equal(got, want) {
+				t.Errorf("got records %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+// mapIndex is a unique index kept in memory.
+type mapIndex struct {
+	entries map[int32]RID
+	closed  bool
+}
+
+func (mi *mapIndex) Insert(value any, rid *RID) error {
+	if other, ok := mi.entries[value.(int32)]; ok && !other.Equals(rid) {
+		return &ConstraintViolationError{Constraint: "key"}
+	}
+	mi.entries[value.(int32)] = *rid
+	return nil
+}
+
+func (mi *mapIndex) Delete(value any, rid *RID) error {
+	if other, ok := mi.entries[value.(int32)]; ok && other.Equals(rid) {
+		delete(mi.entries, value.(int32))
+	}
+	return nil
+}
+
+func (mi *mapIndex) Close() {
+	mi.closed = true
+}
+
+func TestTableScan_Indexes(t *testing.T) {
+	fm := file.NewMemoryStorage(400)
+	lm, err := log.NewManager(fm, "testlogfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bm := buffer.NewManager(fm, lm, 8)
+	tm, err := transaction.NewManager(fm, lm, bm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	schema := NewSchema()
+	schema.AddIntField("A")
+	schema.AddStringField("B", 100)
+	layout := NewSlottedLayout(schema)
+
+	tx, err := tm.NewTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Commit()
+	ts, err := NewTableScan(tx, "T", layout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ts.Close()
+	index := &mapIndex{entries: make(map[int32]RID)}
+	ts.AddIndex("A", index)
+
+	// check verifies that the index has an entry for each record, pointing
+	// to it.
+	check := func(want int) {
+		t.Helper()
+		if len(index.entries) != want {
+			t.Errorf("invalid number of entries: got %d, want %d", len(index.entries), want)
+		}
+		for value, rid := range index.entries {
+			if err := ts.MoveToRID(&rid); err != nil {
+				t.Fatal(err)
+			}
+			if a, err := ts.ReadInt32("A"); err != nil || a != value {
+				t.Errorf("invalid record of entry %d: got %d (%v)", value, a, err)
+			}
+		}
+		ts.BeforeFirst()
+	}
+
+	for i := range int32(30) {
+		if err := ts.Insert(); err != nil {
+			t.Fatal(err)
+		}
+		if err := ts.WriteInt32("A", i); err != nil {
+			t.Fatal(err)
+		}
+		if err := ts.WriteString("B", "x"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	check(30)
+
+	// A rejected value leaves the record as it was.
+	ts.BeforeFirst()
+	for ts.Next() {
+		if a, _ := ts.ReadInt32("A"); a != 6 {
+			continue
+		}
+		var violation *ConstraintViolationError
+		if err := ts.WriteInt32("A", 5); !errors.As(err, &violation) || violation.Constraint != "key" {
+			t.Errorf("invalid error writing a duplicate: got %v, want a violation of key", err)
+		}
+		if a, _ := ts.ReadInt32("A"); a != 6 {
+			t.Errorf("invalid value after a rejected write: got %d, want %d", a, 6)
+		}
+		if err := ts.WriteInt32("A", 130); err != nil {
+			t.Fatal(err)
+		}
+	}
+	check(30)
+
+	// Records that outgrow their block move, and so do their entries.
+	ts.BeforeFirst()
+	for ts.Next() {
+		if err := ts.WriteString("B", strings.Repeat("y", 90)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	check(30)
+
+	ts.BeforeFirst()
+	for ts.Next() {
+		if a, _ := ts.ReadInt32("A"); a%2 == 0 {
+			if err := ts.Delete(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	check(15)
+
+	if _, err := ts.Vacuum(nil); err != nil {
+		t.Fatal(err)
+	}
+	check(15)
+
+	ts.Close()
+	if !index.closed {
+		t.Error("the index is not closed with the scan")
+	}
+}
+
+// funcCheck is a check made of a function.
+type funcCheck func(ts *TableScan) bool
+
+func (fc funcCheck) IsViolated(ts *TableScan) bool {
+	return fc(ts)
+}
+
+func TestTableScan_Checks(t *testing.T) {
+	fm := file.NewMemoryStorage(400)
+	lm, err := log.NewManager(fm, "testlogfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bm := buffer.NewManager(fm, lm, 8)
+	tm, err := transaction.NewManager(fm, lm, bm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	schema := NewSchema()
+	schema.AddIntField("lo")
+	schema.AddIntField("hi")
+	schema.AddField("note", Varchar, 10, true)
+	schema.SetDefault("lo", int32(1))
+	schema.SetDefault("hi", int32(10))
+	schema.SetDefault("note", "new")
+	layout := NewLayout(schema)
+
+	tx, err := tm.NewTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Commit()
+	ts, err := NewTableScan(tx, "T", layout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ts.Close()
+	ts.AddFieldCheck("positive", "lo", funcCheck(func(ts *TableScan) bool {
+		lo, _ := ts.ReadInt32("lo")
+		return lo <= 0
+	}))
+	ts.AddCheck("ordered", funcCheck(func(ts *TableScan) bool {
+		lo, _ := ts.ReadInt32("lo")
+		hi, _ := ts.ReadInt32("hi")
+		return lo > hi
+	}))
+
+	// readRecord returns the fields of the current record.
+	readRecord := func() (int32, int32, any) {
+		t.Helper()
+		lo, err := ts.ReadInt32("lo")
+		if err != nil {
+			t.Fatal(err)
+		}
+		hi, err := ts.ReadInt32("hi")
+		if err != nil {
+			t.Fatal(err)
+		}
+		note, err := ts.ReadValue("note")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return lo, hi, note
+	}
+	violates := func(err error, constraint string) bool {
+		var violation *ConstraintViolationError
+		return errors.As(err, &violation) && violation.Constraint == constraint
+	}
+
+	// A new record holds the default values.
+	if err := ts.Insert(); err != nil {
+		t.Fatal(err)
+	}
+	if lo, hi, note := readRecord(); lo != 1 || hi != 10 || note != "new" {
+		t.Errorf("invalid new record: got (%d, %d, %v), want (1, 10, new)", lo, hi, note)
+	}
+
+	// A write that violates a check on its field is undone.
+	if err := ts.WriteInt32("lo", 0); !violates(err, "positive") {
+		t.Errorf("invalid error writing a violating value: got %v, want a violation of positive", err)
+	}
+	if lo, _, _ := readRecord(); lo != 1 {
+		t.Errorf("invalid value after a rejected write: got %d, want %d", lo, 1)
+	}
+
+	// Checks on several fields are not evaluated on single writes, so that
+	// a record can be written field by field.
+	if err := ts.WriteInt32("lo", 20); err != nil {
+		t.Fatal(err)
+	}
+	if err := ts.WriteInt32("hi", 30); err != nil {
+		t.Fatal(err)
+	}
+	if err := ts.SetNull("note"); err != nil {
+		t.Fatal(err)
+	}
+	if lo, hi, note := readRecord(); lo != 20 || hi != 30 || note != nil {
+		t.Errorf("invalid record: got (%d, %d, %v), want (20, 30, <nil>)", lo, hi, note)
+	}
+
+	// Updating several fields checks the record once they are all written.
+	if err := ts.UpdateValues(map[string]any{"lo": int32(20), "hi": int32(30)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ts.UpdateValues(map[string]any{"hi": int32(15), "note": "x"}); !violates(err, "ordered") {
+		t.Errorf("invalid error updating to violating values: got %v, want a violation of ordered", err)
+	}
+	if lo, hi, note := readRecord(); lo != 20 || hi != 30 || note != nil {
+		t.Errorf("invalid record after a rejected update: got (%d, %d, %v), want (20, 30, <nil>)", lo, hi, note)
+	}
+	if err := ts.UpdateValues(map[string]any{"missing": int32(1)}); err != ErrFieldNotFound {
+		t.Errorf("invalid error updating a missing field: got %v, want %v", err, ErrFieldNotFound)
+	}
+
+	// A record inserted with violating values is deleted again.
+	if err := ts.InsertValues(map[string]any{"lo": int32(50), "hi": int32(40)}); !violates(err, "ordered") {
+		t.Errorf("invalid error inserting violating values: got %v, want a violation of ordered", err)
+	}
+	if err := ts.InsertValues(map[string]any{"lo": int32(50), "hi": int32(60)}); err != nil {
+		t.Fatal(err)
+	}
+	if lo, hi, note := readRecord(); lo != 50 || hi != 60 || note != "new" {
+		t.Errorf("invalid inserted record: got (%d, %d, %v), want (50, 60, new)", lo, hi, note)
+	}
+
+	ts.BeforeFirst()
+	var count int
+	for ts.Next() {
+		count++
+	}
+	if count != 2 {
+		t.Errorf("invalid number of records: got %d, want %d", count, 2)
+	}
+}
+
+func TestTableScan_InsertNotNullable(t *testing.T) {
+	fm := file.NewMemoryStorage(400)
+	lm, err := log.NewManager(fm, "testlogfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bm := buffer.NewManager(fm, lm, 8)
+	tm, err := transaction.NewManager(fm, lm, bm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	schema := NewSchema()
+	schema.AddIntField("A")
+	schema.AddStringField("B", 10)
+	schema.AddField("C", Integer, 0, true)
+	layout := NewLayout(schema)
+
+	tx, err := tm.NewTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Commit()
+	ts, err := NewTableScan(tx, "T", layout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ts.Close()
+
+	if err := ts.InsertValues(map[string]any{"A": int32(7), "B": "old", "C": int32(3)}); err != nil {
+		t.Fatal(err)
+	}
+	rid := ts.GetRID()
+	if err := ts.Delete(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The new record reuses the slot, but not the values of the deleted one.
+	ts.BeforeFirst()
+	if err := ts.Insert(); err != nil {
+		t.Fatal(err)
+	}
+	if !ts.GetRID().Equals(rid) {
+		t.Fatalf("the slot is not reused: got %v, want %v", ts.GetRID(), rid)
+	}
+	a, err := ts.ReadInt32("A")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ts.ReadString("B")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := ts.ReadValue("C")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a != 0 || b != "" || c != nil {
+		t.Errorf("invalid new record: got (%d, %q, %v), want (0, \"\", <nil>)", a, b, c)
+	}
+
+	if err := ts.InsertValues(map[string]any{"A": int32(1), "C": int32(1)}); err != ErrNotNullable {
+		t.Errorf("invalid error inserting without a non-nullable value: got %v, want %v", err, ErrNotNullable)
+	}
+	if err := ts.InsertValues(map[string]any{"A": int32(1), "B": "x"}); err != nil {
+		t.Fatal(err)
+	}
+
+	ts.BeforeFirst()
+	var count int
+	for ts.Next() {
+		count++
+	}
+	if count != 2 {
+		t.Errorf("invalid number of records: got %d, want %d", count, 2)
+	}
+}
+
+// keyLog records the changes a scan tells a referenced key of, and rejects
+// those to the value it protects.
+type keyLog struct {
+	changes   []string
+	protected int32
+}
+
+func (kl *keyLog) Deleting(value any) error {
+	if value == kl.protected {
+		return &ConstraintViolationError{Constraint: "protected"}
+	}
+	kl.changes = append(kl.changes, fmt.Sprintf("delete %v", value))
+	return nil
+}
+
+func (kl *keyLog) Updating(old any, value any) error {
+	if old == kl.protected {
+		return &ConstraintViolationError{Constraint: "protected"}
+	}
+	kl.changes = append(kl.changes, fmt.Sprintf("update %v to %v", old, value))
+	return nil
+}
+
+func TestTableScan_ReferencedKeys(t *testing.T) {
+	fm := file.NewMemoryStorage(400)
+	lm, err := log.NewManager(fm, "testlogfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bm := buffer.NewManager(fm, lm, 8)
+	tm, err := transaction.NewManager(fm, lm, bm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	schema := NewSchema()
+	schema.AddField("A", Integer, 0, true)
+	schema.AddIntField("B")
+	layout := NewLayout(schema)
+
+	tx, err := tm.NewTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Commit()
+	ts, err := NewTableScan(tx, "T", layout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ts.Close()
+	key := &keyLog{protected: 2}
+	ts.AddReferencedKey("A", key)
+
+	// A new record holds NULL, which no record can refer to.
+	for i := range int32(3) {
+		ts.Insert()
+		if err := ts.WriteInt32("A", i); err != nil {
+			t.Fatal(err)
+		}
+		ts.WriteInt32("B", i)
+	}
+	ts.BeforeFirst()
+	for ts.Next() {
+		a, _ := ts.ReadInt32("A")
+		switch a {
+		case 0:
+			if err := ts.WriteInt32("A", 10); err != nil {
+				t.Fatal(err)
+			}
+		case 1:
+			if err := ts.Delete(); err != nil {
+				t.Fatal(err)
+			}
+		case 2:
+			var violation *ConstraintViolationError
+			if err := ts.WriteInt32("A", 20); !errors.As(err, &violation) {
+				t.Errorf("invalid error updating a protected value: got %v, want a violation", err)
+			}
+			if err := ts.Delete(); !errors.As(err, &violation) {
+				t.Errorf("invalid error deleting a protected value: got %v, want a violation", err)
+			}
+			if a, _ := ts.ReadInt32("A"); a != 2 {
+				t.Errorf("invalid value of a protected record: got %d, want %d", a, 2)
+			}
+			// Other fields are not referenced.
+			if err := ts.WriteInt32("B", 20); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	want := []string{"update 0 to 10", "delete 1"}
+	if !slices.Equal(key.changes, want) {
+		t.Errorf("invalid changes: got %v, want %v", key.changes, want)
+	}
+}
@@ -1,31 +1,199 @@
 package server
 
 import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"time"
+
 	"simpledb/buffer"
 	"simpledb/file"
 	"simpledb/log"
 	"simpledb/transaction"
 )
 
+// LogFile is the name of the log file in the database directory.
+const LogFile = "simpledb.log"
+
+// shipInterval is how often the log is checked for records to ship to the
+// replicas.
+const shipInterval = 10 * time.Millisecond
+
 type SimpleDB struct {
-	fileManager   *file.Manager
-	logManager    *log.Manager
-	bufferManager *buffer.Manager
+	fileManager        file.Storage
+	logManager         *log.Manager
+	bufferManager      *buffer.Manager
+	transactionManager *transaction.Manager
+	replica            bool
 }
 
 func NewSimpleDB(dirName string, blockSize int32, buffSize int32) *SimpleDB {
 	fileManager, _ := file.NewManager(dirName, blockSize)
-	logManager, _ := log.NewManager(fileManager, "simpledb.log")
+	return NewSimpleDBWithStorage(fileManager, buffSize)
+}
+
+// NewMemorySimpleDB creates an ephemeral database, whose files are kept in
+// memory and lost when it is discarded.
+func NewMemorySimpleDB(blockSize int32, buffSize int32) *SimpleDB {
+	return NewSimpleDBWithStorage(file.NewMemoryStorage(blockSize), buffSize)
+}
+
+// NewSimpleDBWithStorage creates a database whose files are kept in the
+// given storage.
+func NewSimpleDBWithStorage(fileManager file.Storage, buffSize int32) *SimpleDB {
+	logManager, _ := log.NewManager(fileManager, LogFile)
 	bufferManager := buffer.NewManager(fileManager, logManager, buffSize)
+	transactionManager, _ := transaction.NewManager(fileManager, logManager, bufferManager)
 
 	return &SimpleDB{
-		fileManager:   fileManager,
-		logManager:    logManager,
-		bufferManager: bufferManager,
+		fileManager:        fileManager,
+		logManager:         logManager,
+		bufferManager:      bufferManager,
+		transactionManager: transactionManager,
 	}
 }
 
-func (s *SimpleDB) NewTx() *transaction.Transaction {
-	tx, _ := transaction.NewTransaction(s.fileManager, s.logManager, s.bufferManager)
+// NewReplica opens a replica in dirName, which was restored from a backup
+// of the primary with transaction.RestoreReplica. Its transactions are
+// read-only, and recovery runs before it is returned.
+func NewReplica(dirName string, blockSize int32, buffSize int32) *SimpleDB {
+	db := NewSimpleDB(dirName, blockSize, buffSize)
+	tx := db.NewTx()
+	tx.Recover()
+	tx.Commit()
+
+	db.replica = true
+	return db
+}
+
+// NewTx starts a transaction. On a replica, the transaction is read-only.
+func (s *SimpleDB) NewTx(opts ...transaction.Option) *transaction.Transaction {
+	if s.replica {
+		opts = append(opts, transaction.ReadOnly())
+	}
+	tx, _ := s.transactionManager.NewTransaction(opts...)
 	return tx
 }
+
+// ServeReplicas ships the log to the replicas that connect to the listener,
+// until accepting a connection fails. A replica first sends the LSN after
+// which it needs the log, as a big-endian int32.
+func (s *SimpleDB) ServeReplicas(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go s.shipLog(conn)
+	}
+}
+
+func (s *SimpleDB) shipLog(conn net.Conn) {
+	defer conn.Close()
+
+	var lsn int32
+	if err := binary.Read(conn, binary.BigEndian, &lsn); err != nil {
+		return
+	}
+	s.logManager.Ship(conn, lsn, shipInterval)
+}
+
+// Replicate applies the log shipped by the primary through the connection,
+// opened to the primary's ServeReplicas, until the connection fails.
+func (s *SimpleDB) Replicate(conn net.Conn) error {
+	replica, err := s.transactionManager.NewReplica()
+	if err != nil {
+		return err
+	}
+
+	if err := binary.Write(conn, binary.BigEndian, replica.ResumeLSN()); err != nil {
+		return err
+	}
+	return replica.Receive(conn)
+}
+
+// Backup copies the database to the directory, which should be empty,
+// while transactions keep running. The backup is restored with
+// transaction.Restore.
+func (s *SimpleDB) Backup(dirName string) error {
+	dst, err := file.NewManager(dirName, s.fileManager.BlockSize())
+	if err != nil {
+		return err
+	}
+	return s.transactionManager.Backup(dst)
+}
+
+// ServeBackups backs up the database for the clients that connect to the
+// listener, until accepting a connection fails. A backup must be taken by
+// the process running the database, which knows its running transactions
+// and the end of its log, rather than by another one opening its directory.
+func (s *SimpleDB) ServeBackups(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go s.serveBackup(conn)
+	}
+}
+
+func (s *SimpleDB) serveBackup(conn net.Conn) {
+	defer conn.Close()
+
+	dirName, err := readString(conn)
+	if err != nil {
+		return
+	}
+	var reply string
+	if err := s.Backup(dirName); err != nil {
+		reply = err.Error()
+	}
+	writeString(conn, reply)
+}
+
+// RequestBackup asks the database serving backups through the connection,
+// opened to its ServeBackups, to back itself up to the directory, which is
+// taken on the database's host, and waits for the backup to finish. The
+// directory is sent as its length, a big-endian int32, followed by its
+// bytes, and the database replies with the error of the backup in the same
+// way, or an empty string if it succeeded.
+func RequestBackup(conn net.Conn, dirName string) error {
+	if err := writeString(conn, dirName); err != nil {
+		return err
+	}
+	reply, err := readString(conn)
+	if err != nil {
+		return err
+	}
+	if reply != "" {
+		return errors.New(reply)
+	}
+	return nil
+}
+
+func readString(r io.Reader) (string, error) {
+	var n int32
+	if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+		return "", err
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+func writeString(w io.Writer, str string) error {
+	if err := binary.Write(w, binary.BigEndian, int32(len(str))); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, str)
+	return err
+}
+
+// TransactionManager returns the manager of the database's transactions,
+// through which prepared transactions are finished.
+func (s *SimpleDB) TransactionManager() *transaction.Manager {
+	return s.transactionManager
+}
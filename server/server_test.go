@@ -0,0 +1,154 @@
+package server
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+
+	"simpledb/file"
+	"simpledb/transaction"
+)
+
+func TestSimpleDB_ServeBackups(t *testing.T) {
+	dir := t.TempDir()
+	dbDir := filepath.Join(dir, "db")
+	fm, err := file.NewManager(dbDir, 400)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage := &readHookStorage{Storage: fm}
+	db := NewSimpleDBWithStorage(storage, 8)
+
+	write := func(t *testing.T, tx *transaction.Transaction, filename string, val int32) {
+		t.Helper()
+		block, err := tx.Append(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Pin(block); err != nil {
+			t.Fatal(err)
+		}
+		defer tx.Unpin(block)
+		if err := tx.WriteInt32(block, 0, val, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// tx1 starts before the backup is taken, and commits while it runs,
+	// once its file is copied. Its change is only in the copied log, before
+	// the commit record.
+	tx1 := db.NewTx()
+	write(t, tx1, "running", 1)
+	tx2 := db.NewTx()
+	write(t, tx2, "committed", 2)
+	if err := tx2.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	storage.afterRead = func(block *file.Block) {
+		if block.Filename() != "running" {
+			return
+		}
+		storage.afterRead = nil
+		if err := tx1.Commit(); err != nil {
+			t.Error(err)
+		}
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go db.ServeBackups(listener)
+
+	backupDir := filepath.Join(dir, "backup")
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := RequestBackup(conn, backupDir); err != nil {
+		t.Fatalf("failed to back up: %v", err)
+	}
+
+	read := func(t *testing.T, db *SimpleDB, filename string) int32 {
+		t.Helper()
+		tx := db.NewTx()
+		defer tx.Commit()
+		block := file.NewBlock(filename, 0)
+		if err := tx.Pin(block); err != nil {
+			t.Fatal(err)
+		}
+		defer tx.Unpin(block)
+		val, err := tx.ReadInt32(block, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return val
+	}
+	recoverDB := func(t *testing.T, dirName string) *SimpleDB {
+		t.Helper()
+		db := NewSimpleDB(dirName, 400, 8)
+		tx := db.NewTx()
+		if err := tx.Recover(); err != nil {
+			t.Fatalf("failed to recover: %v", err)
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		return db
+	}
+
+	restoredDir := filepath.Join(dir, "restored")
+	src, err := file.NewManager(backupDir, 400)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst, err := file.NewManager(restoredDir, 400)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := transaction.Restore(src, dst); err != nil {
+		t.Fatal(err)
+	}
+	restored := recoverDB(t, restoredDir)
+	if got := read(t, restored, "committed"); got != 2 {
+		t.Errorf("restored value committed before the backup: got %d, want 2", got)
+	}
+	if got := read(t, restored, "running"); got != 1 {
+		t.Errorf("restored value committed during the backup: got %d, want 1", got)
+	}
+
+	// The log of the database is intact after the backup.
+	restarted := recoverDB(t, dbDir)
+	for filename, want := range map[string]int32{"committed": 2, "running": 1} {
+		if got := read(t, restarted, filename); got != want {
+			t.Errorf("%s value after a restart: got %d, want %d", filename, got, want)
+		}
+	}
+
+	// A failed backup reports the error of the database.
+	conn, err = net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := RequestBackup(conn, filepath.Join(dbDir, LogFile)); err == nil {
+		t.Error("backing up to a file succeeded")
+	}
+}
+
+// readHookStorage calls afterRead after a block is read, if it is set.
+type readHookStorage struct {
+	file.Storage
+	afterRead func(block *file.Block)
+}
+
+func (s *readHookStorage) Read(block *file.Block, page *file.Page) error {
+	err := s.Storage.Read(block, page)
+	if s.afterRead != nil {
+		s.afterRead(block)
+	}
+	return err
+}
@@ -0,0 +1,324 @@
+package transaction
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"simpledb/file"
+	"simpledb/log"
+)
+
+// BackupLabel is the file that marks a storage as a backup. Recovery replays
+// the log of a storage that has it from the backup's checkpoint, and then
+// removes it.
+const BackupLabel = "backup_label"
+
+// Errors returned by Backup, Restore and RollForward.
+var (
+	ErrBlockSizeMismatch  = errors.New("transaction: storages have different block sizes")
+	ErrNotBackup          = errors.New("transaction: storage holds no complete backup")
+	ErrTargetBeforeBackup = errors.New("transaction: recovery target is before the end of the backup")
+)
+
+// backupLabel is the contents of the BackupLabel file.
+type backupLabel struct {
+	checkpoint int32 // LSN after which recovery replays the log
+	end        int32 // LSN up to which the log must be replayed
+	logFile    string
+}
+
+// Backup copies the database to dst, which should be empty, while
+// transactions keep running. The backup contains every transaction that
+// committed before Backup was called, and possibly some that committed
+// while it ran; it is made consistent by running recovery after Restore.
+//
+// The backup starts at a fuzzy checkpoint: the LSN before the start of the
+// oldest running transaction. The data files are copied first, and then the
+// log. Changes of transactions that committed before the checkpoint are
+// already in the data files, since commits flush them, and every later
+// change is in the copied log, since the log is written ahead of the data.
+// If Backup fails, dst must not be restored.
+func (m *Manager) Backup(dst file.Storage) error {
+	if dst.BlockSize() != m.fileManager.BlockSize() {
+		return ErrBlockSizeMismatch
+	}
+
+	label := &backupLabel{
+		checkpoint: m.checkpoint(),
+		logFile:    m.logManager.Filename(),
+	}
+
+	filenames, err := m.fileManager.List()
+	if err != nil {
+		return err
+	}
+	for _, filename := range filenames {
+		// Temporary tables do not outlive a restart, and so are not backed up.
+		if filename == label.logFile || filename == BackupLabel || strings.HasPrefix(filename, "temp") {
+			continue
+		}
+		if err := copyFile(dst, m.fileManager, filename); err != nil {
+			return err
+		}
+	}
+
+	// Every change in the copied data files is logged up to here.
+	label.end = m.logManager.LatestLSN()
+	if err := m.logManager.Flush(label.end); err != nil {
+		return err
+	}
+	if err := copyFile(dst, m.fileManager, label.logFile); err != nil {
+		return err
+	}
+
+	// The label is written last, so that an incomplete backup has none.
+	return writeBackupLabel(dst, label)
+}
+
+// checkpoint returns the LSN after which a backup replays the log: the LSN
+// before the start of the oldest running transaction, or the latest LSN.
+func (m *Manager) checkpoint() int32 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	checkpoint := m.logManager.LatestLSN()
+	for _, lsn := range m.logStarts {
+		checkpoint = min(checkpoint, lsn)
+	}
+	return checkpoint
+}
+
+// Restore replaces the files of dst with those of the backup. The restored
+// database must then be recovered, by calling Recover before any other
+// transaction runs on it.
+func Restore(backup file.Storage, dst file.Storage) error {
+	if dst.BlockSize() != backup.BlockSize() {
+		return ErrBlockSizeMismatch
+	}
+
+	label, err := readBackupLabel(backup)
+	if err != nil {
+		return err
+	}
+	if label == nil {
+		return ErrNotBackup
+	}
+
+	existing, err := dst.List()
+	if err != nil {
+		return err
+	}
+	for _, filename := range existing {
+		if err := dst.Remove(filename); err != nil {
+			return err
+		}
+	}
+
+	filenames, err := backup.List()
+	if err != nil {
+		return err
+	}
+	for _, filename := range filenames {
+		if err := copyFile(dst, backup, filename); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RecoveryTarget is the point up to which a restored backup is recovered.
+// The zero value recovers up to the end of the log.
+type RecoveryTarget struct {
+	// LSN, if not zero, is the LSN of the last log record to replay.
+	LSN int32
+	// Time, if not zero, excludes the transactions that committed after it.
+	Time time.Time
+}
+
+// RollForward prepares the backup restored in dst for point-in-time
+// recovery. The backup's log is replaced by the archived log, cut after the
+// target, so that Recover then replays the changes of the transactions that
+// committed up to the target, and rolls back the others. The archived log
+// must continue the backup's log, as does the log of the database the
+// backup was taken of.
+//
+// The target must not be before the end of the backup, since the data files
+// may contain changes made up to there. If RollForward fails, the backup
+// must be restored again.
+func RollForward(archive file.Storage, dst file.Storage, target RecoveryTarget) error {
+	label, err := readBackupLabel(dst)
+	if err != nil {
+		return err
+	}
+	if label == nil {
+		return ErrNotBackup
+	}
+
+	if err := copyFile(dst, archive, label.logFile); err != nil {
+		return err
+	}
+
+	lsn, err := targetLSN(dst, label.logFile, target)
+	if err != nil {
+		return err
+	}
+	if lsn < label.end {
+		return ErrTargetBeforeBackup
+	}
+	return log.Truncate(dst, label.logFile, lsn)
+}
+
+// targetLSN returns the LSN of the last log record to replay to reach the
+// target: the record before the first one past the target.
+func targetLSN(storage file.Storage, logFile string, target RecoveryTarget) (int32, error) {
+	iter, err := logIterator(storage, logFile)
+	if err != nil || iter == nil {
+		return 0, err
+	}
+
+	var lsn int32
+	for iter.HasNext() {
+		log, err := iter.Next()
+		if err != nil {
+			return 0, err
+		}
+		if lsn == 0 {
+			lsn = iter.LSN()
+		}
+
+		record, err := createLogRecord(log)
+		if err != nil {
+			return 0, err
+		}
+		// Commit times can be slightly out of order, so the whole log is
+		// searched for the first commit past the target.
+		if commit, ok := record.(*CommitRecord); ok && !target.Time.IsZero() && commit.Time().After(target.Time) {
+			lsn = min(lsn, iter.LSN()-1)
+		}
+	}
+
+	if target.LSN != 0 {
+		lsn = min(lsn, target.LSN)
+	}
+	return lsn, nil
+}
+
+// LoggedCommit is a commit found in the log, to choose a recovery target.
+type LoggedCommit struct {
+	LSN   int32
+	TxNum int64
+	Time  time.Time
+}
+
+// LoggedCommits returns the commits in the log file, most recent first.
+func LoggedCommits(storage file.Storage, logFile string) ([]LoggedCommit, error) {
+	iter, err := logIterator(storage, logFile)
+	if err != nil || iter == nil {
+		return nil, err
+	}
+
+	var commits []LoggedCommit
+	for iter.HasNext() {
+		log, err := iter.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		record, err := createLogRecord(log)
+		if err != nil {
+			return nil, err
+		}
+		if commit, ok := record.(*CommitRecord); ok {
+			commits = append(commits, LoggedCommit{
+				LSN:   iter.LSN(),
+				TxNum: commit.TxNumber(),
+				Time:  commit.Time(),
+			})
+		}
+	}
+	return commits, nil
+}
+
+// logIterator returns an iterator over a log file that is not in use by a
+// log manager, or nil if the file is empty.
+func logIterator(storage file.Storage, logFile string) (*log.Iterator, error) {
+	size, err := storage.Size(logFile)
+	if err != nil || size == 0 {
+		return nil, err
+	}
+	return log.NewIterator(storage, file.NewBlock(logFile, size-1))
+}
+
+// readBackupLabel reads the backup label of the storage, or returns nil if
+// it has none.
+func readBackupLabel(storage file.Storage) (*backupLabel, error) {
+	size, err := storage.Size(BackupLabel)
+	if err != nil {
+		return nil, err
+	}
+	if size == 0 {
+		// Getting the size may have created an empty file.
+		return nil, storage.Remove(BackupLabel)
+	}
+
+	page := file.NewPage(storage.BlockSize())
+	if err := storage.Read(file.NewBlock(BackupLabel, 0), page); err != nil {
+		return nil, err
+	}
+
+	label := &backupLabel{}
+	if label.checkpoint, err = page.ReadInt32At(0); err != nil {
+		return nil, err
+	}
+	if label.end, err = page.ReadInt32At(4); err != nil {
+		return nil, err
+	}
+	if label.logFile, err = page.ReadStringAt(8); err != nil {
+		return nil, err
+	}
+	return label, nil
+}
+
+func writeBackupLabel(storage file.Storage, label *backupLabel) error {
+	page := file.NewPage(storage.BlockSize())
+	if err := page.WriteInt32At(0, label.checkpoint); err != nil {
+		return err
+	}
+	if err := page.WriteInt32At(4, label.end); err != nil {
+		return err
+	}
+	if err := page.WriteStringAt(8, label.logFile); err != nil {
+		return err
+	}
+
+	if err := storage.Write(file.NewBlock(BackupLabel, 0), page); err != nil {
+		return err
+	}
+	return storage.Sync(BackupLabel)
+}
+
+// copyFile replaces the file in dst with a copy of the file in src, and
+// syncs the copy.
+func copyFile(dst file.Storage, src file.Storage, filename string) error {
+	size, err := src.Size(filename)
+	if err != nil {
+		return err
+	}
+
+	if err := dst.Remove(filename); err != nil {
+		return err
+	}
+
+	page := file.NewPage(src.BlockSize())
+	for blockNum := range size {
+		block := file.NewBlock(filename, blockNum)
+		if err := src.Read(block, page); err != nil {
+			return err
+		}
+		if err := dst.Write(block, page); err != nil {
+			return err
+		}
+	}
+	return dst.Sync(filename)
+}
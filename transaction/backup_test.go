@@ -0,0 +1,350 @@
+package transaction
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"simpledb/buffer"
+	"simpledb/file"
+	"simpledb/log"
+)
+
+// hookedStorage runs a function before the first read of a block, to change
+// the database while a backup copies it.
+type hookedStorage struct {
+	file.Storage
+	block *file.Block
+	hook  func()
+}
+
+func (s *hookedStorage) Read(block *file.Block, page *file.Page) error {
+	if s.hook != nil && block.Equals(s.block) {
+		hook := s.hook
+		s.hook = nil
+		hook()
+	}
+	return s.Storage.Read(block, page)
+}
+
+func openBackupTestManager(t *testing.T, storage file.Storage) *Manager {
+	t.Helper()
+	lm, err := log.NewManager(storage, "testlogfile")
+	if err != nil {
+		t.Fatalf("failed to create log manager: %v", err)
+	}
+	tm, err := NewManager(storage, lm, buffer.NewManager(storage, lm, 8))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tm
+}
+
+func newBackupTestTx(t *testing.T, tm *Manager) *Transaction {
+	t.Helper()
+	tx, err := tm.NewTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tx
+}
+
+func writeBackupTestInt(t *testing.T, tx *Transaction, block *file.Block, offset int32, val int32) {
+	t.Helper()
+	if err := tx.Pin(block); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.WriteInt32(block, offset, val, true); err != nil {
+		t.Fatal(err)
+	}
+	tx.Unpin(block)
+}
+
+func commitBackupTestTx(t *testing.T, tx *Transaction) {
+	t.Helper()
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// recoverBackupTestStorage recovers the restored backup in the storage, and
+// returns a manager for it.
+func recoverBackupTestStorage(t *testing.T, storage file.Storage) *Manager {
+	t.Helper()
+	tm := openBackupTestManager(t, storage)
+	recovery := newBackupTestTx(t, tm)
+	if err := recovery.Recover(); err != nil {
+		t.Fatalf("failed to recover: %v", err)
+	}
+	commitBackupTestTx(t, recovery)
+	return tm
+}
+
+type backupTestValue struct {
+	name   string
+	block  *file.Block
+	offset int32
+	want   int32
+}
+
+func checkBackupTestValues(t *testing.T, tm *Manager, values []backupTestValue) {
+	t.Helper()
+	tx := newBackupTestTx(t, tm)
+	for _, tc := range values {
+		if err := tx.Pin(tc.block); err != nil {
+			t.Fatal(err)
+		}
+		got, err := tx.ReadInt32(tc.block, tc.offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tc.want {
+			t.Errorf("%s: got %d at %v offset %d, want %d", tc.name, got, tc.block, tc.offset, tc.want)
+		}
+		tx.Unpin(tc.block)
+	}
+	commitBackupTestTx(t, tx)
+}
+
+func TestManager_Backup(t *testing.T) {
+	block0 := file.NewBlock("testfile", 0)
+	block1 := file.NewBlock("testfile", 1)
+	block2 := file.NewBlock("testfile", 2)
+
+	storage := &hookedStorage{Storage: file.NewMemoryStorage(400), block: block1}
+	tm := openBackupTestManager(t, storage)
+
+	tx1 := newBackupTestTx(t, tm)
+	writeBackupTestInt(t, tx1, block0, 0, 1)
+	writeBackupTestInt(t, tx1, block1, 0, 1)
+	writeBackupTestInt(t, tx1, block2, 0, 1)
+	commitBackupTestTx(t, tx1)
+
+	// tx2 is running when the backup starts, and commits during the copy.
+	tx2 := newBackupTestTx(t, tm)
+	writeBackupTestInt(t, tx2, block1, 4, 2)
+
+	// tx3 rolls back before the backup starts.
+	tx3 := newBackupTestTx(t, tm)
+	writeBackupTestInt(t, tx3, block2, 4, 3)
+	if err := tx3.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+
+	var tx5 *Transaction
+	storage.hook = func() {
+		// block0 is already copied when these transactions change it.
+		commitBackupTestTx(t, tx2)
+
+		tx4 := newBackupTestTx(t, tm)
+		writeBackupTestInt(t, tx4, block0, 4, 4)
+		// The appended block is left empty, so only its append is logged.
+		if _, err := tx4.Append("appended"); err != nil {
+			t.Fatal(err)
+		}
+		commitBackupTestTx(t, tx4)
+
+		tx5 = newBackupTestTx(t, tm)
+		writeBackupTestInt(t, tx5, block0, 8, 5)
+		if err := tm.bufferManager.FlushAll(tx5.TxNum()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	backup := file.NewMemoryStorage(400)
+	if err := tm.Backup(backup); err != nil {
+		t.Fatalf("failed to back up: %v", err)
+	}
+
+	// tx6 commits after the backup.
+	tx6 := newBackupTestTx(t, tm)
+	writeBackupTestInt(t, tx6, block2, 8, 6)
+	commitBackupTestTx(t, tx6)
+	commitBackupTestTx(t, tx5)
+
+	restored := file.NewMemoryStorage(400)
+	if err := Restore(file.NewMemoryStorage(400), restored); !errors.Is(err, ErrNotBackup) {
+		t.Errorf("got error %v restoring an empty storage, want %v", err, ErrNotBackup)
+	}
+	if err := Restore(backup, restored); err != nil {
+		t.Fatalf("failed to restore: %v", err)
+	}
+
+	tm = recoverBackupTestStorage(t, restored)
+
+	filenames, err := restored.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if slices.Contains(filenames, BackupLabel) {
+		t.Error("the backup label is left after recovery")
+	}
+	if size, err := restored.Size("appended"); err != nil || size != 1 {
+		t.Errorf("got %d blocks appended during the backup, error %v, want 1", size, err)
+	}
+
+	checkBackupTestValues(t, tm, []backupTestValue{
+		{"committed before the backup", block0, 0, 1},
+		{"committed before the backup", block2, 0, 1},
+		{"committed during the backup", block1, 4, 2},
+		{"rolled back before the backup", block2, 4, 0},
+		{"committed during the backup, after its block was copied", block0, 4, 4},
+		{"running at the end of the backup", block0, 8, 0},
+		{"committed after the backup", block2, 8, 0},
+	})
+}
+
+func TestRollForward(t *testing.T) {
+	block := file.NewBlock("testfile", 0)
+
+	storage := file.NewMemoryStorage(400)
+	tm := openBackupTestManager(t, storage)
+
+	tx1 := newBackupTestTx(t, tm)
+	writeBackupTestInt(t, tx1, block, 0, 1)
+	commitBackupTestTx(t, tx1)
+
+	backup := file.NewMemoryStorage(400)
+	if err := tm.Backup(backup); err != nil {
+		t.Fatalf("failed to back up: %v", err)
+	}
+
+	tx2 := newBackupTestTx(t, tm)
+	writeBackupTestInt(t, tx2, block, 4, 2)
+	commitBackupTestTx(t, tx2)
+	beforeMistake := time.Now()
+	// Make sure the mistake commits strictly after the target time.
+	time.Sleep(time.Millisecond)
+
+	// The mistake to recover from.
+	tx3 := newBackupTestTx(t, tm)
+	writeBackupTestInt(t, tx3, block, 0, 0)
+	commitBackupTestTx(t, tx3)
+
+	tx4 := newBackupTestTx(t, tm)
+	writeBackupTestInt(t, tx4, block, 8, 4)
+	commitBackupTestTx(t, tx4)
+
+	commits, err := LoggedCommits(storage, "testlogfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mistake LoggedCommit
+	for _, commit := range commits {
+		if commit.TxNum == tx3.TxNum() {
+			mistake = commit
+		}
+	}
+	if mistake.LSN == 0 {
+		t.Fatal("the commit of the mistake is not logged")
+	}
+
+	for _, tc := range []struct {
+		name   string
+		target RecoveryTarget
+		values []backupTestValue
+	}{
+		{
+			name:   "Up to a time",
+			target: RecoveryTarget{Time: beforeMistake},
+			values: []backupTestValue{
+				{"committed before the backup", block, 0, 1},
+				{"committed before the target", block, 4, 2},
+				{"committed after the target", block, 8, 0},
+			},
+		},
+		{
+			name:   "Up to an LSN",
+			target: RecoveryTarget{LSN: mistake.LSN - 1},
+			values: []backupTestValue{
+				{"committed before the backup", block, 0, 1},
+				{"committed before the target", block, 4, 2},
+				{"committed after the target", block, 8, 0},
+			},
+		},
+		{
+			name:   "Up to the end of the log",
+			target: RecoveryTarget{},
+			values: []backupTestValue{
+				{"changed by the mistake", block, 0, 0},
+				{"committed before the mistake", block, 4, 2},
+				{"committed after the mistake", block, 8, 4},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			restored := file.NewMemoryStorage(400)
+			if err := Restore(backup, restored); err != nil {
+				t.Fatalf("failed to restore: %v", err)
+			}
+			if err := RollForward(storage, restored, tc.target); err != nil {
+				t.Fatalf("failed to roll forward: %v", err)
+			}
+			checkBackupTestValues(t, recoverBackupTestStorage(t, restored), tc.values)
+		})
+	}
+
+	t.Run("Removed file", func(t *testing.T) {
+		storage := file.NewMemoryStorage(400)
+		tm := openBackupTestManager(t, storage)
+
+		tx1 := newBackupTestTx(t, tm)
+		for range 3 {
+			block, err := tx1.Append("removed")
+			if err != nil {
+				t.Fatal(err)
+			}
+			writeBackupTestInt(t, tx1, block, 0, 1)
+		}
+		commitBackupTestTx(t, tx1)
+
+		backup := file.NewMemoryStorage(400)
+		if err := tm.Backup(backup); err != nil {
+			t.Fatalf("failed to back up: %v", err)
+		}
+
+		tx2 := newBackupTestTx(t, tm)
+		if err := tx2.Remove("removed"); err != nil {
+			t.Fatal(err)
+		}
+		commitBackupTestTx(t, tx2)
+
+		// The file is made again, with a single block.
+		tx3 := newBackupTestTx(t, tm)
+		block, err := tx3.Append("removed")
+		if err != nil {
+			t.Fatal(err)
+		}
+		writeBackupTestInt(t, tx3, block, 4, 3)
+		commitBackupTestTx(t, tx3)
+
+		restored := file.NewMemoryStorage(400)
+		if err := Restore(backup, restored); err != nil {
+			t.Fatalf("failed to restore: %v", err)
+		}
+		if err := RollForward(storage, restored, RecoveryTarget{}); err != nil {
+			t.Fatalf("failed to roll forward: %v", err)
+		}
+		tm = recoverBackupTestStorage(t, restored)
+
+		if size, err := restored.Size("removed"); err != nil || size != 1 {
+			t.Errorf("got %d blocks in the made again file, error %v, want 1", size, err)
+		}
+		checkBackupTestValues(t, tm, []backupTestValue{
+			{"committed before the file was removed", block, 0, 0},
+			{"committed after the file was made again", block, 4, 3},
+		})
+	})
+
+	t.Run("Not before the end of the backup", func(t *testing.T) {
+		restored := file.NewMemoryStorage(400)
+		if err := Restore(backup, restored); err != nil {
+			t.Fatalf("failed to restore: %v", err)
+		}
+		err := RollForward(storage, restored, RecoveryTarget{LSN: 1})
+		if !errors.Is(err, ErrTargetBeforeBackup) {
+			t.Errorf("got error %v, want %v", err, ErrTargetBeforeBackup)
+		}
+	})
+}
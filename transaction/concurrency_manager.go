@@ -2,60 +2,267 @@ package transaction
 
 import "simpledb/file"
 
+// endOfFile is the number of the dummy block whose lock stands for the end
+// of a file. Reading the size of a file takes a shared lock on it, and
+// appending a block takes an exclusive lock on it.
+const endOfFile = -1
+
+// defaultEscalationThreshold is the number of block and record locks a
+// transaction may hold on one file, unless WithEscalationThreshold sets
+// another. Beyond it, they are escalated to a single lock on the file.
+const defaultEscalationThreshold = 64
+
+// ConcurrencyManager acquires the locks of a transaction, following the
+// multi-granularity protocol: before a resource is locked, its ancestors are
+// locked in the corresponding intention mode, from the database down.
 type ConcurrencyManager struct {
-	lockTable *LockTable
-	locks     map[*file.Block]string
+	lockTable      *LockTable
+	isolationLevel IsolationLevel
+	txNum          int64
+	locks          map[Resource]LockMode
+	fineLocks      map[string]int // number of block and record locks held per file
+	// escalationThreshold is the number of block and record locks on a
+	// file beyond which they are escalated, or 0 if they never are.
+	escalationThreshold int
 }
 
-func NewConcurrencyManager(lockTable *LockTable) *ConcurrencyManager {
+func NewConcurrencyManager(lockTable *LockTable, isolationLevel IsolationLevel, txNum int64) *ConcurrencyManager {
 	return &ConcurrencyManager{
-		lockTable: lockTable,
-		locks:     make(map[*file.Block]string),
+		lockTable:           lockTable,
+		isolationLevel:      isolationLevel,
+		txNum:               txNum,
+		locks:               make(map[Resource]LockMode),
+		fineLocks:           make(map[string]int),
+		escalationThreshold: defaultEscalationThreshold,
 	}
 }
 
+// SLock obtains a shared lock on the block before it is read, unless the
+// isolation level does not require one. The caller must call ReadDone once
+// the read is complete.
 func (cm *ConcurrencyManager) SLock(block *file.Block) error {
-	if _, exit := cm.locks[block]; exit {
+	if cm.isolationLevel == ReadUncommitted {
 		return nil
 	}
+	return cm.lockFine(BlockResource(block), S)
+}
 
-	err := cm.lockTable.SLock(block)
-	if err != nil {
-		return err
+// SLockRecord obtains a shared lock on the record in the specified slot
+// before it is read, unless the isolation level does not require one. The
+// caller must call RecordReadDone once the read is complete.
+func (cm *ConcurrencyManager) SLockRecord(block *file.Block, slot int32) error {
+	if cm.isolationLevel == ReadUncommitted {
+		return nil
 	}
+	return cm.lockFine(RecordResource(block, slot), S)
+}
 
-	cm.locks[block] = "S"
-	return nil
+// ReadDone is called once a read protected by SLock is complete. Shared
+// locks that the isolation level does not retain are released here.
+func (cm *ConcurrencyManager) ReadDone(block *file.Block) {
+	cm.readDone(BlockResource(block))
 }
 
+// RecordReadDone is called once a read protected by SLockRecord is
+// complete.
+func (cm *ConcurrencyManager) RecordReadDone(block *file.Block, slot int32) {
+	cm.readDone(RecordResource(block, slot))
+}
+
+// XLock obtains an exclusive lock on the block before it is written.
+// Exclusive locks are held until the transaction finishes, whatever the
+// isolation level.
 func (cm *ConcurrencyManager) XLock(block *file.Block) error {
-	if cm.hasXLock(block) {
+	return cm.lockFine(BlockResource(block), X)
+}
+
+// XLockRecord obtains an exclusive lock on the record in the specified slot
+// before it is written.
+func (cm *ConcurrencyManager) XLockRecord(block *file.Block, slot int32) error {
+	return cm.lockFine(RecordResource(block, slot), X)
+}
+
+// TryXLockRecord obtains an exclusive lock on the record in the specified
+// slot if it can be granted without waiting, and reports whether it was.
+// The intention locks above the record are waited for as usual.
+func (cm *ConcurrencyManager) TryXLockRecord(block *file.Block, slot int32) (bool, error) {
+	resource := RecordResource(block, slot)
+	if cm.covers(resource, X) {
+		return true, nil
+	}
+
+	parent, _ := resource.Parent()
+	if err := cm.Lock(parent, IX); err != nil {
+		return false, err
+	}
+	if !cm.lockTable.TryLock(cm.txNum, resource, X) {
+		return false, nil
+	}
+
+	cm.granted(resource, X)
+	return true, cm.escalateIfNeeded(resource.filename)
+}
+
+// Lock locks the resource in the specified mode, first taking intention
+// locks on its ancestors. It does nothing if a lock held on the resource or
+// on one of its ancestors already grants the mode.
+func (cm *ConcurrencyManager) Lock(resource Resource, mode LockMode) error {
+	if cm.covers(resource, mode) {
 		return nil
 	}
 
-	// Transaction having an xlock on a block also has an implied slock on it.
-	err := cm.SLock(block)
-	if err != nil {
-		return err
+	if parent, ok := resource.Parent(); ok {
+		intention := IS
+		if mode == IX || mode == SIX || mode == X {
+			intention = IX
+		}
+		if err := cm.Lock(parent, intention); err != nil {
+			return err
+		}
 	}
 
-	err = cm.lockTable.XLock(block)
-	if err != nil {
+	if err := cm.lockTable.Lock(cm.txNum, resource, mode); err != nil {
 		return err
 	}
 
-	cm.locks[block] = "X"
+	cm.granted(resource, mode)
 	return nil
 }
 
 func (cm *ConcurrencyManager) Release() {
-	for block := range cm.locks {
-		cm.lockTable.Unlock(block)
+	for resource := range cm.locks {
+		cm.lockTable.Unlock(cm.txNum, resource)
 	}
 	clear(cm.locks)
+	clear(cm.fineLocks)
+}
+
+// granted records a lock granted by the lock table.
+func (cm *ConcurrencyManager) granted(resource Resource, mode LockMode) {
+	held := cm.locks[resource]
+	cm.locks[resource] = combine(held, mode)
+	if !cm.counts(resource, held) && cm.counts(resource, cm.locks[resource]) {
+		cm.fineLocks[resource.filename]++
+	}
+}
+
+// counts reports whether a lock held in the mode on the resource counts
+// towards the escalation threshold of its file. Only shared and exclusive
+// locks on blocks and records count: intention locks on blocks merely
+// accompany record locks, and shared locks released right after the read
+// they protect are never many at a time.
+func (cm *ConcurrencyManager) counts(resource Resource, mode LockMode) bool {
+	if resource.Level() < BlockLevel {
+		return false
+	}
+	switch mode {
+	case S:
+		return !cm.releasedAfterRead(resource)
+	case SIX, X:
+		return true
+	default:
+		return false
+	}
 }
 
-func (cm *ConcurrencyManager) hasXLock(block *file.Block) bool {
-	lock, exit := cm.locks[block]
-	return exit && lock == "X"
+// lockFine locks a block or a record, and escalates the transaction's locks
+// on the file once there are too many of them.
+func (cm *ConcurrencyManager) lockFine(resource Resource, mode LockMode) error {
+	if err := cm.Lock(resource, mode); err != nil {
+		return err
+	}
+	return cm.escalateIfNeeded(resource.filename)
+}
+
+// escalateIfNeeded replaces the transaction's block and record locks on the
+// file by a single lock on the file, once more than escalationThreshold of
+// them count towards it. The file is locked in X if any of them grants
+// writing, and in S otherwise.
+//
+// Escalation is only attempted if the file lock can be granted without
+// waiting. Otherwise the fine-grained locks are kept, and escalation is
+// tried again on the next lock: two transactions waiting for each other to
+// escalate would deadlock.
+func (cm *ConcurrencyManager) escalateIfNeeded(filename string) error {
+	if cm.escalationThreshold <= 0 || cm.fineLocks[filename] <= cm.escalationThreshold {
+		return nil
+	}
+
+	mode := S
+	for resource, held := range cm.locks {
+		if resource.Level() >= BlockLevel && resource.filename == filename && held != S && held != IS {
+			mode = X
+			break
+		}
+	}
+
+	fileLock := FileResource(filename)
+	if !cm.covers(fileLock, mode) {
+		intention := IS
+		if mode == X {
+			intention = IX
+		}
+		if err := cm.Lock(DatabaseResource(), intention); err != nil {
+			return err
+		}
+		if !cm.lockTable.TryLock(cm.txNum, fileLock, mode) {
+			return nil
+		}
+		cm.granted(fileLock, mode)
+	}
+
+	for resource := range cm.locks {
+		if resource.Level() >= BlockLevel && resource.filename == filename {
+			cm.unlock(resource)
+		}
+	}
+	return nil
+}
+
+// readDone releases a shared lock that the isolation level does not retain
+// after the read it protects.
+func (cm *ConcurrencyManager) readDone(resource Resource) {
+	if cm.locks[resource] == S && cm.releasedAfterRead(resource) {
+		cm.unlock(resource)
+	}
+}
+
+// releasedAfterRead reports whether the isolation level releases a shared
+// lock on the resource once the read it protects is done.
+func (cm *ConcurrencyManager) releasedAfterRead(resource Resource) bool {
+	switch cm.isolationLevel {
+	case ReadCommitted:
+		return true
+	case RepeatableRead:
+		return resource.Level() == BlockLevel && resource.block == endOfFile
+	default:
+		return false
+	}
+}
+
+// covers reports whether the transaction already holds a lock that grants
+// the requested mode on the resource, either on the resource itself or on
+// one of its ancestors.
+func (cm *ConcurrencyManager) covers(resource Resource, mode LockMode) bool {
+	if combine(cm.locks[resource], mode) == cm.locks[resource] {
+		return true
+	}
+	for {
+		parent, ok := resource.Parent()
+		if !ok {
+			return false
+		}
+		if implies(cm.locks[parent], mode) {
+			return true
+		}
+		resource = parent
+	}
+}
+
+func (cm *ConcurrencyManager) unlock(resource Resource) {
+	if cm.counts(resource, cm.locks[resource]) {
+		cm.fineLocks[resource.filename]--
+	}
+	cm.lockTable.Unlock(cm.txNum, resource)
+	delete(cm.locks, resource)
 }
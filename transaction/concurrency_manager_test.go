@@ -2,6 +2,7 @@ package transaction
 
 import (
 	"fmt"
+	"maps"
 	"testing"
 	"time"
 
@@ -13,29 +14,33 @@ import (
 )
 
 func TestConcurrencyManager(t *testing.T) {
-	dir := t.TempDir()
+	fm := file.NewMemoryStorage(400)
 
-	fm, err := file.NewManager(dir, 400)
+	lm, err := log.NewManager(fm, "testlogfile")
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	lm, err := log.NewManager(fm, "testlogfile")
+	bm := buffer.NewManager(fm, lm, 8)
+
+	tm, err := NewManager(fm, lm, bm)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	bm := buffer.NewManager(fm, lm, 8)
-
+	// The clients are started in order, so that their lock requests
+	// interleave as A, B, C and none of them deadlocks.
 	var eg errgroup.Group
 	eg.Go(func() error {
-		return clientA(t, fm, lm, bm)
+		return clientA(t, tm)
 	})
+	time.Sleep(100 * time.Millisecond)
 	eg.Go(func() error {
-		return clientB(t, fm, lm, bm)
+		return clientB(t, tm)
 	})
+	time.Sleep(100 * time.Millisecond)
 	eg.Go(func() error {
-		return clientC(t, fm, lm, bm)
+		return clientC(t, tm)
 	})
 
 	if err := eg.Wait(); err != nil {
@@ -43,10 +48,10 @@ func TestConcurrencyManager(t *testing.T) {
 	}
 }
 
-func clientA(t *testing.T, fm *file.Manager, lm *log.Manager, bm *buffer.Manager) error {
+func clientA(t *testing.T, tm *Manager) error {
 	t.Helper()
 
-	tx, err := NewTransaction(fm, lm, bm)
+	tx, err := tm.NewTransaction()
 	if err != nil {
 		return fmt.Errorf("clientA: failed to create transaction: %w", err)
 	}
@@ -78,10 +83,10 @@ func clientA(t *testing.T, fm *file.Manager, lm *log.Manager, bm *buffer.Manager
 	return nil
 }
 
-func clientB(t *testing.T, fm *file.Manager, lm *log.Manager, bm *buffer.Manager) error {
+func clientB(t *testing.T, tm *Manager) error {
 	t.Helper()
 
-	tx, err := NewTransaction(fm, lm, bm)
+	tx, err := tm.NewTransaction()
 	if err != nil {
 		return fmt.Errorf("clientB: failed to create transaction: %w", err)
 	}
@@ -113,10 +118,10 @@ func clientB(t *testing.T, fm *file.Manager, lm *log.Manager, bm *buffer.Manager
 	return nil
 }
 
-func clientC(t *testing.T, fm *file.Manager, lm *log.Manager, bm *buffer.Manager) error {
+func clientC(t *testing.T, tm *Manager) error {
 	t.Helper()
 
-	tx, err := NewTransaction(fm, lm, bm)
+	tx, err := tm.NewTransaction()
 	if err != nil {
 		return fmt.Errorf("clientC: failed to create transaction: %w", err)
 	}
@@ -147,3 +152,449 @@ func clientC(t *testing.T, fm *file.Manager, lm *log.Manager, bm *buffer.Manager
 	}
 	return nil
 }
+
+func TestIsolationLevels(t *testing.T) {
+	setup := func(t *testing.T) *Manager {
+		t.Helper()
+		fm := file.NewMemoryStorage(400)
+		lm, err := log.NewManager(fm, "testlogfile")
+		if err != nil {
+			t.Fatal(err)
+		}
+		tm, err := NewManager(fm, lm, buffer.NewManager(fm, lm, 8))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tm
+	}
+
+	newTx := func(t *testing.T, tm *Manager, level IsolationLevel) *Transaction {
+		t.Helper()
+		tx, err := tm.NewTransaction(WithIsolationLevel(level))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tx
+	}
+
+	read := func(t *testing.T, tx *Transaction, block *file.Block) int32 {
+		t.Helper()
+		if err := tx.Pin(block); err != nil {
+			t.Fatal(err)
+		}
+		defer tx.Unpin(block)
+		val, err := tx.ReadInt32(block, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return val
+	}
+
+	// inBackground runs f in a goroutine and reports whether it is still
+	// blocked shortly afterwards. The returned channel receives f's result.
+	inBackground := func(f func() error) (bool, <-chan error) {
+		done := make(chan error, 1)
+		go func() {
+			done <- f()
+		}()
+		select {
+		case err := <-done:
+			done <- err
+			return false, done
+		case <-time.After(200 * time.Millisecond):
+			return true, done
+		}
+	}
+
+	write := func(tx *Transaction, block *file.Block, val int32) error {
+		if err := tx.Pin(block); err != nil {
+			return err
+		}
+		defer tx.Unpin(block)
+		return tx.WriteInt32(block, 0, val, true)
+	}
+
+	t.Run("ReadUncommitted permits dirty reads", func(t *testing.T) {
+		tm := setup(t)
+		block := file.NewBlock("testfile", 0)
+
+		writer := newTx(t, tm, Serializable)
+		if err := write(writer, block, 5); err != nil {
+			t.Fatal(err)
+		}
+
+		reader := newTx(t, tm, ReadUncommitted)
+		if got := read(t, reader, block); got != 5 {
+			t.Errorf("got %d, want the uncommitted value 5", got)
+		}
+
+		if err := writer.Rollback(); err != nil {
+			t.Fatal(err)
+		}
+		if got := read(t, reader, block); got != 0 {
+			t.Errorf("got %d after rollback, want 0", got)
+		}
+		if err := reader.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("ReadCommitted permits non-repeatable reads", func(t *testing.T) {
+		tm := setup(t)
+		block := file.NewBlock("testfile", 0)
+
+		writer := newTx(t, tm, Serializable)
+		if err := write(writer, block, 5); err != nil {
+			t.Fatal(err)
+		}
+
+		// The uncommitted value cannot be read.
+		reader := newTx(t, tm, ReadCommitted)
+		blocked, done := inBackground(func() error {
+			if got := read(t, reader, block); got != 7 {
+				return fmt.Errorf("got %d, want the committed value 7", got)
+			}
+			return nil
+		})
+		if !blocked {
+			t.Fatalf("read of an uncommitted value was not blocked: %v", <-done)
+		}
+		if err := write(writer, block, 7); err != nil {
+			t.Fatal(err)
+		}
+		if err := writer.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+
+		// The reader holds no lock, so another writer can change the value.
+		writer = newTx(t, tm, Serializable)
+		if err := write(writer, block, 9); err != nil {
+			t.Fatal(err)
+		}
+		if err := writer.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		if got := read(t, reader, block); got != 9 {
+			t.Errorf("got %d on second read, want 9", got)
+		}
+		if err := reader.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("RepeatableRead permits phantoms", func(t *testing.T) {
+		tm := setup(t)
+		block := file.NewBlock("testfile", 0)
+
+		reader := newTx(t, tm, RepeatableRead)
+		read(t, reader, block)
+
+		// The block read is locked until the reader commits.
+		writer := newTx(t, tm, Serializable)
+		blocked, done := inBackground(func() error {
+			return write(writer, block, 5)
+		})
+		if !blocked {
+			t.Fatalf("write of a block read under RepeatableRead was not blocked: %v", <-done)
+		}
+
+		// But the end of the file is not.
+		appender := newTx(t, tm, Serializable)
+		size, err := reader.Size("testfile")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := appender.Append("testfile"); err != nil {
+			t.Fatal(err)
+		}
+		if err := appender.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		newSize, err := reader.Size("testfile")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if newSize != size+1 {
+			t.Errorf("got size %d, want the phantom block to make it %d", newSize, size+1)
+		}
+
+		if err := reader.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+		if err := writer.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("Serializable prevents phantoms", func(t *testing.T) {
+		tm := setup(t)
+
+		reader := newTx(t, tm, Serializable)
+		size, err := reader.Size("testfile")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		appender := newTx(t, tm, Serializable)
+		blocked, done := inBackground(func() error {
+			_, err := appender.Append("testfile")
+			return err
+		})
+		if !blocked {
+			t.Fatalf("append to a file whose size was read was not blocked: %v", <-done)
+		}
+
+		newSize, err := reader.Size("testfile")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if newSize != size {
+			t.Errorf("got size %d, want %d", newSize, size)
+		}
+		if err := reader.Commit(); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+		if err := appender.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestConcurrencyManager_Hierarchy(t *testing.T) {
+	t.Run("Intention locks conflict with file locks", func(t *testing.T) {
+		lt := NewLockTable()
+		reader := NewConcurrencyManager(lt, Serializable, 1)
+		writer := NewConcurrencyManager(lt, Serializable, 2)
+
+		if err := reader.SLock(file.NewBlock("testfile", 3)); err != nil {
+			t.Fatal(err)
+		}
+
+		wantLocks := map[Resource]LockMode{
+			DatabaseResource():                          IS,
+			FileResource("testfile"):                    IS,
+			BlockResource(file.NewBlock("testfile", 3)): S,
+		}
+		if !maps.Equal(reader.locks, wantLocks) {
+			t.Errorf("got locks %v, want %v", reader.locks, wantLocks)
+		}
+
+		// A shared lock on the whole file is compatible with the reader.
+		if err := writer.Lock(FileResource("testfile"), S); err != nil {
+			t.Fatal(err)
+		}
+
+		// An exclusive lock on it has to wait for the reader to finish.
+		done := make(chan error, 1)
+		go func() {
+			done <- writer.Lock(FileResource("testfile"), X)
+		}()
+		select {
+		case err := <-done:
+			t.Fatalf("exclusive file lock was granted over a reader: %v", err)
+		case <-time.After(100 * time.Millisecond):
+		}
+
+		reader.Release()
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+
+		// Holding the file exclusively covers its blocks.
+		if err := writer.XLock(file.NewBlock("testfile", 3)); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := writer.locks[BlockResource(file.NewBlock("testfile", 3))]; ok {
+			t.Error("block lock was taken although the file is locked exclusively")
+		}
+		writer.Release()
+	})
+
+	t.Run("Records of a block are locked independently", func(t *testing.T) {
+		lt := NewLockTable()
+		cm1 := NewConcurrencyManager(lt, Serializable, 1)
+		cm2 := NewConcurrencyManager(lt, Serializable, 2)
+		block := file.NewBlock("testfile", 0)
+
+		if err := cm1.XLockRecord(block, 0); err != nil {
+			t.Fatal(err)
+		}
+		wantLocks := map[Resource]LockMode{
+			DatabaseResource():       IX,
+			FileResource("testfile"): IX,
+			BlockResource(block):     IX,
+			RecordResource(block, 0): X,
+		}
+		if !maps.Equal(cm1.locks, wantLocks) {
+			t.Errorf("got locks %v, want %v", cm1.locks, wantLocks)
+		}
+
+		if ok, err := cm2.TryXLockRecord(block, 1); err != nil || !ok {
+			t.Errorf("another record of the block: got (%v, %v), want (true, nil)", ok, err)
+		}
+		if ok, err := cm2.TryXLockRecord(block, 0); err != nil || ok {
+			t.Errorf("record locked by another transaction: got (%v, %v), want (false, nil)", ok, err)
+		}
+
+		// A lock on the whole block conflicts with the record locks.
+		done := make(chan error, 1)
+		go func() {
+			done <- cm2.SLock(block)
+		}()
+		select {
+		case err := <-done:
+			t.Fatalf("block lock was granted over a record lock: %v", err)
+		case <-time.After(100 * time.Millisecond):
+		}
+
+		cm1.Release()
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+		cm2.Release()
+	})
+
+	t.Run("Block locks are escalated to a file lock", func(t *testing.T) {
+		lt := NewLockTable()
+		cm := NewConcurrencyManager(lt, Serializable, 1)
+
+		for i := range int32(defaultEscalationThreshold) {
+			if err := cm.SLock(file.NewBlock("testfile", i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := cm.XLock(file.NewBlock("testfile", 0)); err != nil {
+			t.Fatal(err)
+		}
+		if len(cm.locks) != defaultEscalationThreshold+2 {
+			t.Fatalf("got %d locks before escalation, want %d", len(cm.locks), defaultEscalationThreshold+2)
+		}
+
+		if err := cm.SLock(file.NewBlock("testfile", defaultEscalationThreshold)); err != nil {
+			t.Fatal(err)
+		}
+
+		wantLocks := map[Resource]LockMode{
+			DatabaseResource():       IX,
+			FileResource("testfile"): X,
+		}
+		if !maps.Equal(cm.locks, wantLocks) {
+			t.Errorf("got locks %v after escalation, want %v", cm.locks, wantLocks)
+		}
+		cm.Release()
+	})
+
+	t.Run("Intention locks on blocks do not count towards escalation", func(t *testing.T) {
+		lt := NewLockTable()
+		cm := NewConcurrencyManager(lt, Serializable, 1)
+		cm.escalationThreshold = 4
+
+		// Each record lock comes with an intention lock on its block.
+		for i := range int32(4) {
+			if err := cm.XLockRecord(file.NewBlock("testfile", i), 0); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if mode := cm.locks[FileResource("testfile")]; mode != IX {
+			t.Errorf("file locked in %v after 4 record locks, want %v", mode, IX)
+		}
+
+		if err := cm.XLockRecord(file.NewBlock("testfile", 4), 0); err != nil {
+			t.Fatal(err)
+		}
+		if mode := cm.locks[FileResource("testfile")]; mode != X {
+			t.Errorf("file locked in %v after 5 record locks, want %v", mode, X)
+		}
+		cm.Release()
+	})
+
+	t.Run("Read locks released after the read are not escalated", func(t *testing.T) {
+		lt := NewLockTable()
+		reader := NewConcurrencyManager(lt, ReadCommitted, 1)
+		writer := NewConcurrencyManager(lt, Serializable, 2)
+		reader.escalationThreshold = 4
+
+		for i := range int32(10) {
+			block := file.NewBlock("testfile", i)
+			if err := reader.SLock(block); err != nil {
+				t.Fatal(err)
+			}
+			reader.ReadDone(block)
+		}
+		if mode := reader.locks[FileResource("testfile")]; mode != IS {
+			t.Errorf("file locked in %v after reads, want %v", mode, IS)
+		}
+
+		// A writer is not blocked by the reads, which are done.
+		if ok, err := writer.TryXLockRecord(file.NewBlock("testfile", 0), 0); err != nil || !ok {
+			t.Errorf("TryXLockRecord: got (%v, %v), want (true, nil)", ok, err)
+		}
+		reader.Release()
+		writer.Release()
+	})
+
+	t.Run("Escalation does not wait for other transactions", func(t *testing.T) {
+		lt := NewLockTable()
+		cm1 := NewConcurrencyManager(lt, Serializable, 1)
+		cm2 := NewConcurrencyManager(lt, Serializable, 2)
+		cm1.escalationThreshold = 2
+		cm2.escalationThreshold = 2
+
+		// Both transactions go past the threshold while the other holds an
+		// intention lock on the file, so neither can escalate.
+		done := make(chan error, 1)
+		go func() {
+			for i := range int32(4) {
+				for j, cm := range []*ConcurrencyManager{cm1, cm2} {
+					if err := cm.XLockRecord(file.NewBlock("testfile", int32(j)), i); err != nil {
+						done <- err
+						return
+					}
+				}
+			}
+			done <- nil
+		}()
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("escalation waited for another transaction")
+		}
+
+		for _, cm := range []*ConcurrencyManager{cm1, cm2} {
+			if mode := cm.locks[FileResource("testfile")]; mode == X {
+				t.Errorf("transaction %d escalated over an intention lock", cm.txNum)
+			}
+		}
+		cm1.Release()
+		cm2.Release()
+	})
+
+	t.Run("Escalation can be disabled", func(t *testing.T) {
+		lt := NewLockTable()
+		cm := NewConcurrencyManager(lt, Serializable, 1)
+		cm.escalationThreshold = 0
+
+		for i := range int32(defaultEscalationThreshold + 1) {
+			if err := cm.SLock(file.NewBlock("testfile", i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if mode := cm.locks[FileResource("testfile")]; mode != IS {
+			t.Errorf("file locked in %v, want %v", mode, IS)
+		}
+		cm.Release()
+	})
+}
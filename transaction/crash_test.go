@@ -0,0 +1,213 @@
+package transaction
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"testing"
+
+	"simpledb/buffer"
+	"simpledb/file"
+	"simpledb/log"
+)
+
+// TestRecovery_Crash runs random transactional workloads on a storage that
+// crashes at a random write, and checks that recovery keeps the changes of
+// the committed transactions and undoes all others.
+func TestRecovery_Crash(t *testing.T) {
+	for seed := range uint64(30) {
+		t.Run(fmt.Sprintf("seed %d", seed), func(t *testing.T) {
+			runCrashWorkload(t, seed)
+		})
+	}
+}
+
+type crashCell struct {
+	block  int32
+	offset int32
+}
+
+func runCrashWorkload(t *testing.T, seed uint64) {
+	const (
+		filename  = "testfile"
+		logFile   = "testlogfile"
+		numBlocks = 6
+		numCells  = 8 // int32 cells per block
+	)
+
+	disk := file.NewMemoryStorage(400)
+	storage := file.NewFaultyStorage(disk, seed)
+	// Log blocks are not checksummed, so a torn log block could not be told
+	// apart from a valid one. Only data blocks are torn.
+	storage.TearWrites(func(block *file.Block) bool {
+		return block.Filename() != logFile
+	})
+
+	open := func(t *testing.T) *Manager {
+		t.Helper()
+		lm, err := log.NewManager(storage, logFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Few buffers, so that uncommitted changes are written to disk
+		// when buffers are replaced.
+		tm, err := NewManager(storage, lm, buffer.NewManager(storage, lm, 3))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tm
+	}
+
+	rng := rand.New(rand.NewPCG(seed, 0))
+	committed := make(map[crashCell]int32)
+
+	tm := open(t)
+	storage.FailNthWrite(1 + rng.IntN(150))
+
+	// runTx runs a random transaction, and reports whether it committed.
+	runTx := func() (bool, error) {
+		tx, err := tm.NewTransaction()
+		if err != nil {
+			return false, err
+		}
+
+		changes := make(map[crashCell]int32)
+		for range 1 + rng.IntN(6) {
+			cell := crashCell{block: rng.Int32N(numBlocks), offset: 4 * rng.Int32N(numCells)}
+			val := rng.Int32()
+			block := file.NewBlock(filename, cell.block)
+			if err := tx.Pin(block); err != nil {
+				return false, err
+			}
+			err := tx.WriteInt32(block, cell.offset, val, true)
+			tx.Unpin(block)
+			if err != nil {
+				return false, err
+			}
+			changes[cell] = val
+		}
+
+		if rng.IntN(4) == 0 {
+			return false, tx.Rollback()
+		}
+		if err := tx.Commit(); err != nil {
+			return false, err
+		}
+		for cell, val := range changes {
+			committed[cell] = val
+		}
+		return true, nil
+	}
+
+	for !storage.Crashed() {
+		if _, err := runTx(); err != nil && !storage.Crashed() {
+			t.Fatalf("transaction failed before the crash: %v", err)
+		}
+	}
+
+	storage.Restart()
+	tm = open(t)
+	recovery, err := tm.NewTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := recovery.Recover(); err != nil {
+		t.Fatalf("failed to recover: %v", err)
+	}
+	if err := recovery.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err := tm.NewTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for blockNum := range int32(numBlocks) {
+		block := file.NewBlock(filename, blockNum)
+		if err := tx.Pin(block); err != nil {
+			t.Fatal(err)
+		}
+		for offset := int32(0); offset < 4*numCells; offset += 4 {
+			val, err := tx.ReadInt32(block, offset)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := committed[crashCell{blockNum, offset}]; val != want {
+				t.Errorf("block %d offset %d: got %d, want %d", blockNum, offset, val, want)
+			}
+		}
+		tx.Unpin(block)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// removeCrashStorage crashes when a file is removed, as if the process died
+// after a commit was logged and before the files it removed were deleted.
+type removeCrashStorage struct {
+	*file.FaultyStorage
+	crash bool
+}
+
+func (s *removeCrashStorage) Remove(filename string) error {
+	if s.crash {
+		s.Crash()
+		return file.ErrCrashed
+	}
+	return s.FaultyStorage.Remove(filename)
+}
+
+func TestRecovery_CrashBeforeRemove(t *testing.T) {
+	storage := &removeCrashStorage{FaultyStorage: file.NewFaultyStorage(file.NewMemoryStorage(400), 0)}
+	tm := openBackupTestManager(t, storage)
+
+	tx1 := newBackupTestTx(t, tm)
+	for _, filename := range []string{"removed", "made again"} {
+		block, err := tx1.Append(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		writeBackupTestInt(t, tx1, block, 0, 1)
+	}
+	commitBackupTestTx(t, tx1)
+
+	// "made again" is removed, and then made again by a later transaction.
+	tx2 := newBackupTestTx(t, tm)
+	if err := tx2.Remove("made again"); err != nil {
+		t.Fatal(err)
+	}
+	commitBackupTestTx(t, tx2)
+
+	tx3 := newBackupTestTx(t, tm)
+	block, err := tx3.Append("made again")
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeBackupTestInt(t, tx3, block, 0, 3)
+	commitBackupTestTx(t, tx3)
+
+	tx4 := newBackupTestTx(t, tm)
+	if err := tx4.Remove("removed"); err != nil {
+		t.Fatal(err)
+	}
+	storage.crash = true
+	if err := tx4.Commit(); !errors.Is(err, file.ErrCrashed) {
+		t.Fatalf("got error %v committing, want %v", err, file.ErrCrashed)
+	}
+
+	storage.crash = false
+	storage.Restart()
+	if files, _ := storage.List(); !slices.Contains(files, "removed") {
+		t.Fatalf("the crash did not leave the removed file behind: got %v", files)
+	}
+	tm = recoverBackupTestStorage(t, storage)
+
+	if files, _ := storage.List(); slices.Contains(files, "removed") {
+		t.Errorf("recovery left the removed file behind: got %v", files)
+	}
+	checkBackupTestValues(t, tm, []backupTestValue{
+		{"written to the made again file", file.NewBlock("made again", 0), 0, 3},
+	})
+}
@@ -2,21 +2,176 @@ package transaction
 
 import (
 	"errors"
+	"fmt"
 	"sync"
 	"time"
 
 	"simpledb/file"
 )
 
+// LockMode is the mode in which a resource is locked.
+//
+// Intention modes (IS, IX) are taken on the ancestors of a resource before
+// it is locked in shared (S) or exclusive (X) mode, so that a lock on a
+// coarser resource conflicts with the locks held on the resources under it.
+// SIX is the combination of S and IX: it reads the whole resource while
+// exclusively locking some of its descendants.
+type LockMode int32
+
+const (
+	IS LockMode = iota + 1
+	IX
+	S
+	SIX
+	X
+)
+
+func (m LockMode) String() string {
+	switch m {
+	case IS:
+		return "IS"
+	case IX:
+		return "IX"
+	case S:
+		return "S"
+	case SIX:
+		return "SIX"
+	case X:
+		return "X"
+	default:
+		return fmt.Sprintf("LockMode(%d)", int32(m))
+	}
+}
+
+// compatible[held][requested] reports whether a lock can be granted in the
+// requested mode while another transaction holds it in the held mode.
+var compatible = [X + 1][X + 1]bool{
+	IS:  {IS: true, IX: true, S: true, SIX: true},
+	IX:  {IS: true, IX: true},
+	S:   {IS: true, S: true},
+	SIX: {IS: true},
+}
+
+// combine returns the weakest mode that is at least as strong as both
+// modes. It is the mode a transaction holds after requesting m2 on a
+// resource that it already holds in m1.
+func combine(m1 LockMode, m2 LockMode) LockMode {
+	switch {
+	case m1 == m2:
+		return m1
+	case m1 == 0 || m1 == IS:
+		return m2
+	case m2 == 0 || m2 == IS:
+		return m1
+	case m1 == X || m2 == X:
+		return X
+	default:
+		// The remaining pairs are IX, S and SIX, any two of which combine
+		// into SIX.
+		return SIX
+	}
+}
+
+// implies reports whether holding a resource in mode held grants mode
+// requested on each of its descendants.
+func implies(held LockMode, requested LockMode) bool {
+	switch held {
+	case X:
+		return true
+	case S, SIX:
+		return requested == S || requested == IS
+	default:
+		return false
+	}
+}
+
+// LockLevel is the granularity of a lockable resource.
+type LockLevel int32
+
+const (
+	DatabaseLevel LockLevel = iota
+	FileLevel
+	BlockLevel
+	RecordLevel
+)
+
+// Resource identifies a node of the lock hierarchy: the database, a file
+// (the table stored in it), a block of a file, or a record slot of a block.
+type Resource struct {
+	level    LockLevel
+	filename string
+	block    int32
+	slot     int32
+}
+
+// DatabaseResource returns the root of the lock hierarchy.
+func DatabaseResource() Resource {
+	return Resource{level: DatabaseLevel}
+}
+
+// FileResource returns the resource standing for a whole file.
+func FileResource(filename string) Resource {
+	return Resource{level: FileLevel, filename: filename}
+}
+
+// BlockResource returns the resource standing for a block.
+func BlockResource(block *file.Block) Resource {
+	return Resource{level: BlockLevel, filename: block.Filename(), block: block.Number()}
+}
+
+// RecordResource returns the resource standing for the record in the
+// specified slot of a block.
+func RecordResource(block *file.Block, slot int32) Resource {
+	return Resource{level: RecordLevel, filename: block.Filename(), block: block.Number(), slot: slot}
+}
+
+// Level returns the granularity of the resource.
+func (r Resource) Level() LockLevel {
+	return r.level
+}
+
+// Parent returns the resource directly above r in the hierarchy. It returns
+// false for the database, which has no parent.
+func (r Resource) Parent() (Resource, bool) {
+	switch r.level {
+	case FileLevel:
+		return DatabaseResource(), true
+	case BlockLevel:
+		return FileResource(r.filename), true
+	case RecordLevel:
+		return Resource{level: BlockLevel, filename: r.filename, block: r.block}, true
+	default:
+		return Resource{}, false
+	}
+}
+
+func (r Resource) String() string {
+	switch r.level {
+	case DatabaseLevel:
+		return "database"
+	case FileLevel:
+		return fmt.Sprintf("file %s", r.filename)
+	case BlockLevel:
+		return fmt.Sprintf("block %s:%d", r.filename, r.block)
+	default:
+		return fmt.Sprintf("record %s:%d:%d", r.filename, r.block, r.slot)
+	}
+}
+
+// LockTable keeps track of the locks held by transactions on the resources
+// of the lock hierarchy. It does not take intention locks on ancestors by
+// itself; that is the responsibility of the concurrency manager.
 type LockTable struct {
-	mu    sync.Mutex
-	locks map[*file.Block]int32
-	cond  *sync.Cond // used to wait for a block to become available.
+	mu      sync.Mutex
+	locks   map[Resource]map[int64]LockMode // resource -> transaction -> mode
+	cond    *sync.Cond                      // used to wait for a resource to become available.
+	maxWait time.Duration                   // how long to wait for a lock, maxWaitTime but in tests
 }
 
 func NewLockTable() *LockTable {
 	lt := &LockTable{
-		locks: make(map[*file.Block]int32),
+		locks:   make(map[Resource]map[int64]LockMode),
+		maxWait: maxWaitTime,
 	}
 	lt.cond = sync.NewCond(&lt.mu)
 	return lt
@@ -28,110 +183,99 @@ const maxWaitTime = 10 * time.Second
 // ErrLockAbort is returned when a lock request times out.
 var ErrLockTimeout = errors.New("lock request aborted due to timeout")
 
-// SLock grants a shared (read) lock on the specified block.
+// Lock grants the transaction a lock on the resource in the requested mode.
+// If the transaction already holds the resource, its lock is upgraded to
+// the combination of the held and requested modes.
 // It will wait for at most maxWait for the lock.
-func (lt *LockTable) SLock(block *file.Block) error {
+func (lt *LockTable) Lock(txNum int64, resource Resource, mode LockMode) error {
 	lt.mu.Lock()
+	defer lt.mu.Unlock()
 
-	if !lt.hasXLock(block) {
-		lt.locks[block]++
-		lt.mu.Unlock()
-		return nil
+	mode = combine(lt.locks[resource][txNum], mode)
+	if err := lt.waitWhile(func() bool { return lt.conflicts(txNum, resource, mode) }); err != nil {
+		return err
 	}
 
-	done := make(chan struct{})
-	go func() {
-		lt.cond.Wait()
-		close(done)
-	}()
+	lt.grant(txNum, resource, mode)
+	return nil
+}
 
-	timer := time.NewTimer(maxWaitTime)
-	defer timer.Stop()
+// TryLock is like Lock, but instead of waiting for a conflicting lock to be
+// released, it reports false right away.
+func (lt *LockTable) TryLock(txNum int64, resource Resource, mode LockMode) bool {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
 
-	for {
-		select {
-		case <-timer.C:
-			return ErrLockTimeout
-		case <-done:
-			if lt.hasXLock(block) {
-				continue
-			}
-
-			lt.locks[block]++
-			lt.mu.Unlock()
-			return nil
-		}
+	mode = combine(lt.locks[resource][txNum], mode)
+	if lt.conflicts(txNum, resource, mode) {
+		return false
 	}
+
+	lt.grant(txNum, resource, mode)
+	return true
 }
 
-// XLock grants an exclusive (write) lock on the specified block.
-// It will wait for at most maxWait for the lock.
-func (lt *LockTable) XLock(block *file.Block) error {
+// Unlock releases the transaction's lock on the resource, and notifies
+// other goroutines that may be waiting for a lock.
+func (lt *LockTable) Unlock(txNum int64, resource Resource) {
 	lt.mu.Lock()
+	defer lt.mu.Unlock()
 
-	// Concurrency manager always obtains an slock on the block before requesting the
-	// xlock, and so a value higher than 1 indicates that some other transaction also has a
-	// lock on this block.
-	if !lt.hasOtherSLocks(block) {
-		lt.locks[block] = -1
-		lt.mu.Unlock()
+	holders := lt.locks[resource]
+	delete(holders, txNum)
+	if len(holders) == 0 {
+		delete(lt.locks, resource)
+	}
+	lt.cond.Broadcast()
+}
+
+// waitWhile waits on the condition variable for as long as blocked reports
+// true, giving up with ErrLockTimeout after the maximum wait.
+// This method must be called with the mutex lock already held.
+func (lt *LockTable) waitWhile(blocked func() bool) error {
+	if !blocked() {
 		return nil
 	}
 
-	done := make(chan struct{})
-	go func() {
-		lt.cond.Wait()
-		close(done)
-	}()
+	deadline := time.Now().Add(lt.maxWait)
 
-	timer := time.NewTimer(maxWaitTime)
+	// Wake up the waiters when the deadline passes, so that they can notice
+	// the timeout even if no lock is ever released.
+	timer := time.AfterFunc(lt.maxWait, func() {
+		lt.mu.Lock()
+		defer lt.mu.Unlock()
+		lt.cond.Broadcast()
+	})
 	defer timer.Stop()
 
-	for {
-		select {
-		case <-timer.C:
+	for blocked() {
+		if !time.Now().Before(deadline) {
 			return ErrLockTimeout
-		case <-done:
-			if lt.hasOtherSLocks(block) {
-				continue
-			}
-
-			lt.locks[block] = -1
-			lt.mu.Unlock()
-			return nil
 		}
+		lt.cond.Wait()
 	}
+	return nil
 }
 
-// Unlock releases a lock on the specified block.
-// If this is the last lock on the block, it notifies other goroutines
-// that may be waiting for a lock.
-func (lt *LockTable) Unlock(block *file.Block) {
-	lt.mu.Lock()
-	defer lt.mu.Unlock()
-
-	if lt.getLockValue(block) > 1 {
-		// There are other shared locks, so just decrement the count.
-		lt.locks[block]--
-	} else {
-		// This is the last shared lock or an exclusive lock.
-		delete(lt.locks, block)
-		lt.cond.Broadcast()
+// grant records that the transaction holds the resource in the mode.
+// This method must be called with the mutex lock already held.
+func (lt *LockTable) grant(txNum int64, resource Resource, mode LockMode) {
+	holders, ok := lt.locks[resource]
+	if !ok {
+		holders = make(map[int64]LockMode)
+		lt.locks[resource] = holders
 	}
+	holders[txNum] = mode
 }
 
-// hasXlock checks if the block has an exclusive lock.
-func (lt *LockTable) hasXLock(block *file.Block) bool {
-	return lt.locks[block] < 0
-}
-
-// hasOtherSLocks checks if the block has more than one shared lock.
-func (lt *LockTable) hasOtherSLocks(block *file.Block) bool {
-	return lt.locks[block] > 1
-}
-
-// getLockVal returns the lock value for a block.
-// 0 means no lock, >0 means shared lock count, <0 means exclusive lock.
-func (lt *LockTable) getLockValue(block *file.Block) int32 {
-	return lt.locks[block]
+// conflicts checks if another transaction holds the resource in a mode
+// incompatible with the requested one.
+// This method must be called with the mutex lock already held.
+func (lt *LockTable) conflicts(txNum int64, resource Resource, mode LockMode) bool {
+	for holder, held := range lt.locks[resource] {
+		if holder != txNum && !compatible[held][mode] {
+			return true
+		}
+	}
+	return false
 }
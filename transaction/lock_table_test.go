@@ -0,0 +1,80 @@
+package transaction
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCombine(t *testing.T) {
+	testCases := []struct {
+		held, requested, want LockMode
+	}{
+		{0, S, S},
+		{IS, IX, IX},
+		{IS, S, S},
+		{IX, S, SIX},
+		{S, IX, SIX},
+		{SIX, IX, SIX},
+		{SIX, S, SIX},
+		{S, X, X},
+		{X, IS, X},
+	}
+
+	for _, tc := range testCases {
+		if got := combine(tc.held, tc.requested); got != tc.want {
+			t.Errorf("combine(%v, %v) = %v, want %v", tc.held, tc.requested, got, tc.want)
+		}
+	}
+}
+
+func TestLockTable(t *testing.T) {
+	resource := FileResource("testfile")
+
+	testCases := []struct {
+		held, requested LockMode
+		wantGranted     bool
+	}{
+		{IS, IS, true},
+		{IS, X, false},
+		{IX, IX, true},
+		{IX, S, false},
+		{S, S, true},
+		{S, IX, false},
+		{SIX, IS, true},
+		{SIX, IX, false},
+		{X, IS, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.held.String()+"/"+tc.requested.String(), func(t *testing.T) {
+			lt := NewLockTable()
+			if err := lt.Lock(1, resource, tc.held); err != nil {
+				t.Fatal(err)
+			}
+
+			done := make(chan error, 1)
+			go func() {
+				done <- lt.Lock(2, resource, tc.requested)
+			}()
+
+			select {
+			case err := <-done:
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !tc.wantGranted {
+					t.Errorf("%v was granted while %v is held", tc.requested, tc.held)
+				}
+			case <-time.After(100 * time.Millisecond):
+				if tc.wantGranted {
+					t.Errorf("%v was not granted while %v is held", tc.requested, tc.held)
+				}
+				// Releasing the held lock must let the waiter through.
+				lt.Unlock(1, resource)
+				if err := <-done; err != nil {
+					t.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,240 @@
+package transaction
+
+import (
+	"errors"
+	"slices"
+	"sync"
+
+	"simpledb/buffer"
+	"simpledb/file"
+	"simpledb/log"
+)
+
+// Manager creates the transactions of a database instance. The transactions
+// it creates share its lock table and version table, so that they are
+// isolated from one another but not from transactions of other instances.
+type Manager struct {
+	fileManager   file.Storage
+	logManager    *log.Manager
+	bufferManager *buffer.Manager
+	lockTable     *LockTable
+	versions      *versionTable
+	// replica is set for a replica, whose record versions carry the
+	// transaction numbers of its primary.
+	replica bool
+
+	mu        sync.Mutex
+	prepared  map[string]*Transaction // prepared transactions by global id
+	logStarts map[int64]int32         // LSN before the start of each running writer
+}
+
+// Errors returned by the two-phase commit API.
+var (
+	ErrInvalidGlobalID   = errors.New("transaction: global transaction id must not be empty")
+	ErrDuplicateGlobalID = errors.New("transaction: global transaction id is already prepared")
+	ErrUnknownGlobalID   = errors.New("transaction: no prepared transaction with this global id")
+)
+
+// NewManager creates the transaction manager of a database instance.
+// Transaction numbers continue after the highest one found in the log, so
+// that the numbers of transactions run before a restart are never reused.
+func NewManager(fileManager file.Storage, logManager *log.Manager, bufferManager *buffer.Manager) (*Manager, error) {
+	lastTxNum, err := lastLoggedTxNum(logManager)
+	if err != nil {
+		return nil, err
+	}
+	replica, err := isReplica(fileManager)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Manager{
+		fileManager:   fileManager,
+		logManager:    logManager,
+		bufferManager: bufferManager,
+		lockTable:     NewLockTable(),
+		versions:      newVersionTable(lastTxNum),
+		replica:       replica,
+		prepared:      make(map[string]*Transaction),
+		logStarts:     make(map[int64]int32),
+	}, nil
+}
+
+// InDoubt returns the global ids of the prepared transactions that are
+// waiting for the coordinator's decision, in sorted order. After a restart,
+// they are only known once Recover has run.
+func (m *Manager) InDoubt() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	gids := make([]string, 0, len(m.prepared))
+	for gid := range m.prepared {
+		gids = append(gids, gid)
+	}
+	slices.Sort(gids)
+	return gids
+}
+
+// CommitPrepared commits the transaction prepared under the global id.
+func (m *Manager) CommitPrepared(gid string) error {
+	return m.finishPrepared(gid, (*Transaction).commit)
+}
+
+// RollbackPrepared rolls back the transaction prepared under the global id.
+func (m *Manager) RollbackPrepared(gid string) error {
+	return m.finishPrepared(gid, (*Transaction).rollback)
+}
+
+func (m *Manager) finishPrepared(gid string, finish func(*Transaction) error) error {
+	m.mu.Lock()
+	tx, ok := m.prepared[gid]
+	m.mu.Unlock()
+	if !ok || tx.gid == "" {
+		return ErrUnknownGlobalID
+	}
+
+	if err := finish(tx); err != nil {
+		return err
+	}
+	m.forgetPrepared(gid)
+	return nil
+}
+
+// reservePrepared registers the transaction under the global id, which must
+// not be in use.
+func (m *Manager) reservePrepared(gid string, tx *Transaction) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.prepared[gid]; ok {
+		return ErrDuplicateGlobalID
+	}
+	m.prepared[gid] = tx
+	return nil
+}
+
+func (m *Manager) forgetPrepared(gid string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.prepared, gid)
+}
+
+// resumePrepared recreates a transaction that recovery found in doubt. It
+// takes exclusive locks on the blocks the transaction modified, since which
+// records of them it locked before the restart is not logged, and on the
+// files it removed, which are deleted if it commits.
+func (m *Manager) resumePrepared(inDoubt *inDoubtTx) error {
+	tx := &Transaction{
+		txNum:         inDoubt.txNum,
+		gid:           inDoubt.gid,
+		fileManager:   m.fileManager,
+		logManager:    m.logManager,
+		bufferManager: m.bufferManager,
+		manager:       m,
+	}
+	tx.recoveryManager = &RecoveryManager{
+		logManager:    m.logManager,
+		bufferManager: m.bufferManager,
+		tx:            tx,
+		txNum:         tx.txNum,
+	}
+	tx.concurrencyManager = NewConcurrencyManager(m.lockTable, Serializable, tx.txNum)
+	tx.bufferList = NewBufferList(m.bufferManager)
+
+	if err := m.reservePrepared(inDoubt.gid, tx); err != nil {
+		// The transaction is still running in this instance.
+		if errors.Is(err, ErrDuplicateGlobalID) {
+			return nil
+		}
+		return err
+	}
+
+	// Where the transaction started in the log is not known, so a backup
+	// taken while it is in doubt replays the whole log.
+	m.startLogging(tx.txNum, 0)
+	m.versions.resume(tx.txNum)
+	for _, block := range inDoubt.blocks {
+		if err := tx.concurrencyManager.XLock(block); err != nil {
+			return err
+		}
+	}
+	for _, filename := range inDoubt.removes {
+		if err := tx.LockFile(filename, X); err != nil {
+			return err
+		}
+		if tx.removes == nil {
+			tx.removes = make(map[string]struct{})
+		}
+		tx.removes[filename] = struct{}{}
+	}
+	return nil
+}
+
+// startLogging registers a transaction that writes log records, and an LSN
+// before its first record. Its start record must not be written before it
+// is registered.
+func (m *Manager) startLogging(txNum int64, lsn int32) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.logStarts[txNum] = lsn
+}
+
+func (m *Manager) stopLogging(txNum int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.logStarts, txNum)
+}
+
+// writeCheckpoint logs a checkpoint if the transaction is the only one
+// writing to the log, and returns its LSN, or 0 if others are. Transactions
+// that start later are only logged after the checkpoint.
+func (m *Manager) writeCheckpoint(txNum int64) (int32, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for running := range m.logStarts {
+		if running != txNum {
+			return 0, nil
+		}
+	}
+	return WriteCheckpointRecordToLog(m.logManager, m.versions.last())
+}
+
+// lastLoggedTxNum returns the highest transaction number in the log, or 0
+// if the log is empty. Read-only transactions write no log records, but
+// since they leave no trace in the database either, reusing their numbers
+// is harmless.
+func lastLoggedTxNum(logManager *log.Manager) (int64, error) {
+	iter, err := logManager.Iterator()
+	if err != nil {
+		return 0, err
+	}
+
+	var lastTxNum int64
+	for iter.HasNext() {
+		log, err := iter.Next()
+		if err != nil {
+			return 0, err
+		}
+
+		record, err := createLogRecord(log)
+		if err != nil {
+			return 0, err
+		}
+
+		// Transactions may log out of order, so the log is scanned back to
+		// the most recent checkpoint rather than to the most recent start
+		// record. The transactions logged before the checkpoint have numbers
+		// up to the one it holds.
+		if checkpoint, ok := record.(*CheckpointRecord); ok {
+			return max(lastTxNum, checkpoint.LastTxNum()), nil
+		}
+		if record != nil && record.TxNumber() > lastTxNum {
+			lastTxNum = record.TxNumber()
+		}
+	}
+	return lastTxNum, nil
+}
@@ -0,0 +1,154 @@
+package transaction
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"simpledb/buffer"
+	"simpledb/file"
+	"simpledb/log"
+)
+
+func TestManager_TxNumAfterRestart(t *testing.T) {
+	fm := file.NewMemoryStorage(400)
+
+	open := func(t *testing.T) *Manager {
+		t.Helper()
+		lm, err := log.NewManager(fm, "testlogfile")
+		if err != nil {
+			t.Fatal(err)
+		}
+		tm, err := NewManager(fm, lm, buffer.NewManager(fm, lm, 8))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tm
+	}
+
+	tm := open(t)
+	var last int64
+	for range 3 {
+		tx, err := tm.NewTransaction()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tx.TxNum() <= last {
+			t.Fatalf("got transaction number %d after %d", tx.TxNum(), last)
+		}
+		last = tx.TxNum()
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A manager opened on the same log continues the numbering.
+	tm = open(t)
+	tx, err := tm.NewTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := tx.TxNum(), last+1; got != want {
+		t.Errorf("got transaction number %d after restart, want %d", got, want)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Another instance has its own numbering and lock table.
+	other, err := open(t).NewTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.concurrencyManager.lockTable == tx.concurrencyManager.lockTable {
+		t.Error("two database instances share a lock table")
+	}
+	if err := other.Commit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestManager_TxNumAfterCheckpoint(t *testing.T) {
+	fm := file.NewMemoryStorage(400)
+
+	open := func(t *testing.T) *Manager {
+		t.Helper()
+		lm, err := log.NewManager(fm, "testlogfile")
+		if err != nil {
+			t.Fatal(err)
+		}
+		tm, err := NewManager(fm, lm, buffer.NewManager(fm, lm, 8))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tm
+	}
+
+	// Enough transactions to fill a few log blocks.
+	tm := open(t)
+	for range 20 {
+		tx, err := tm.NewTransaction()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Recovery logs a checkpoint, as no other transaction is running.
+	recovery, err := tm.NewTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := recovery.Recover(); err != nil {
+		t.Fatal(err)
+	}
+	if err := recovery.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if size, err := fm.Size("testlogfile"); err != nil || size < 2 {
+		t.Fatalf("got log size %d, error %v, want more than one block", size, err)
+	}
+
+	// The log before the checkpoint is not read again: the numbering
+	// continues after the recovery transaction even though the first log
+	// block is unreadable.
+	if err := fm.Write(file.NewBlock("testlogfile", 0), file.NewPage(400)); err != nil {
+		t.Fatal(err)
+	}
+	tx, err := open(t).NewTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := tx.TxNum(), recovery.TxNum()+1; got != want {
+		t.Errorf("got transaction number %d after restart, want %d", got, want)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestManager_Primary(t *testing.T) {
+	fm, err := file.NewManager(t.TempDir(), 400)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lm, err := log.NewManager(fm, "testlogfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tm, err := NewManager(fm, lm, buffer.NewManager(fm, lm, 8))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Finding out whether the database is a replica leaves no replica
+	// state behind, which backups would copy.
+	if _, err := tm.NewReplica(); !errors.Is(err, ErrNotReplica) {
+		t.Errorf("got error %v starting a replica on a primary, want %v", err, ErrNotReplica)
+	}
+	if filenames, err := fm.List(); err != nil || slices.Contains(filenames, ReplicaState) {
+		t.Errorf("got files %v, error %v, want no %s", filenames, err, ReplicaState)
+	}
+}
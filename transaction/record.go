@@ -1,6 +1,8 @@
 package transaction
 
 import (
+	"time"
+
 	"simpledb/file"
 	"simpledb/log"
 )
@@ -14,12 +16,29 @@ const (
 	Rollback
 	SetInt
 	SetString
+	SetInt64
+	Prepare
+	SetBytes
+	AppendBlock
+	RemoveFile
 )
 
+// Record is a log record. Update records carry both the old and the new
+// value, so that their change can be undone by recovery and redone when a
+// backup is restored.
 type Record interface {
 	Operator() RecordType
-	TxNumber() int32
+	TxNumber() int64
 	Undo(tx *Transaction) error
+	Redo(tx *Transaction) error
+}
+
+// update is a record of a change, which a replica applies again.
+type update interface {
+	Record
+	// replay writes the new value through the transaction, which locks
+	// and logs it.
+	replay(tx *Transaction) error
 }
 
 func createLogRecord(log []byte) (record Record, err error) {
@@ -32,7 +51,7 @@ func createLogRecord(log []byte) (record Record, err error) {
 
 	switch RecordType(op) {
 	case Checkpoint:
-		return NewCheckpointRecord()
+		return NewCheckpointRecord(p)
 	case Start:
 		return NewStartRecord(p)
 	case Commit:
@@ -43,47 +62,83 @@ func createLogRecord(log []byte) (record Record, err error) {
 		return NewSetIntRecord(p)
 	case SetString:
 		return NewSetStringRecord(p)
+	case SetInt64:
+		return NewSetInt64Record(p)
+	case Prepare:
+		return NewPrepareRecord(p)
+	case SetBytes:
+		return NewSetBytesRecord(p)
+	case AppendBlock:
+		return NewAppendBlockRecord(p)
+	case RemoveFile:
+		return NewRemoveFileRecord(p)
 	default:
 		return
 	}
 }
 
-type CheckpointRecord struct{}
+// CheckpointRecord marks a point of the log before which every transaction
+// finished, so that recovery does not read further back. It holds the last
+// transaction number allocated, which the numbering continues after on a
+// restart.
+type CheckpointRecord struct {
+	lastTxNum int64
+}
 
-func NewCheckpointRecord() (*CheckpointRecord, error) {
-	return &CheckpointRecord{}, nil
+func NewCheckpointRecord(page *file.Page) (*CheckpointRecord, error) {
+	lastTxNum, err := page.ReadInt64At(4)
+	if err != nil {
+		return nil, err
+	}
+	return &CheckpointRecord{lastTxNum: lastTxNum}, nil
 }
 
 func (r *CheckpointRecord) Operator() RecordType {
 	return Checkpoint
 }
 
-func (r *CheckpointRecord) TxNumber() int32 {
+func (r *CheckpointRecord) TxNumber() int64 {
 	return -1
 }
 
+// LastTxNum returns the last transaction number allocated when the
+// checkpoint was written.
+func (r *CheckpointRecord) LastTxNum() int64 {
+	return r.lastTxNum
+}
+
 func (r *CheckpointRecord) Undo(tx *Transaction) error {
 	// Do nothing because a checkpoint record contains no undo information.
 	return nil
 }
 
-func WriteCheckpointRecordToLog(logManager *log.Manager) (int32, error) {
-	p := file.NewPage(4)
+func (r *CheckpointRecord) Redo(tx *Transaction) error {
+	// Do nothing because a checkpoint record contains no redo information.
+	return nil
+}
+
+func WriteCheckpointRecordToLog(logManager *log.Manager, lastTxNum int64) (int32, error) {
+	p := file.NewPage(4 + 8)
 
 	err := p.WriteInt32At(0, int32(Checkpoint))
 	if err != nil {
 		return 0, err
 	}
 
+	err = p.WriteInt64At(4, lastTxNum)
+	if err != nil {
+		return 0, err
+	}
+
 	return logManager.Append(p.Buf())
 }
 
 type StartRecord struct {
-	txNum int32
+	txNum int64
 }
 
 func NewStartRecord(page *file.Page) (*StartRecord, error) {
-	txNum, err := page.ReadInt32At(4)
+	txNum, err := page.ReadInt64At(4)
 	if err != nil {
 		return nil, err
 	}
@@ -94,7 +149,7 @@ func (r *StartRecord) Operator() RecordType {
 	return Start
 }
 
-func (r *StartRecord) TxNumber() int32 {
+func (r *StartRecord) TxNumber() int64 {
 	return r.txNum
 }
 
@@ -103,15 +158,20 @@ func (r *StartRecord) Undo(tx *Transaction) error {
 	return nil
 }
 
-func WriteStartRecordToLog(logManager *log.Manager, txNum int32) (int32, error) {
-	p := file.NewPage(2 * 4)
+func (r *StartRecord) Redo(tx *Transaction) error {
+	// Do nothing because a start record contains no redo information.
+	return nil
+}
+
+func WriteStartRecordToLog(logManager *log.Manager, txNum int64) (int32, error) {
+	p := file.NewPage(4 + 8)
 
 	err := p.WriteInt32At(0, int32(Start))
 	if err != nil {
 		return 0, err
 	}
 
-	err = p.WriteInt32At(4, txNum)
+	err = p.WriteInt64At(4, txNum)
 	if err != nil {
 		return 0, err
 	}
@@ -119,40 +179,62 @@ func WriteStartRecordToLog(logManager *log.Manager, txNum int32) (int32, error)
 	return logManager.Append(p.Buf())
 }
 
+// CommitRecord marks a transaction as committed, at the time it was written,
+// which is a target for point-in-time recovery.
 type CommitRecord struct {
-	txNum int32
+	txNum int64
+	time  int64 // nanoseconds since the Unix epoch
 }
 
 func NewCommitRecord(page *file.Page) (*CommitRecord, error) {
-	txNum, err := page.ReadInt32At(4)
+	txNum, err := page.ReadInt64At(4)
 	if err != nil {
 		return nil, err
 	}
-	return &CommitRecord{txNum: txNum}, nil
+	commitTime, err := page.ReadInt64At(12)
+	if err != nil {
+		return nil, err
+	}
+	return &CommitRecord{txNum: txNum, time: commitTime}, nil
 }
 
 func (r *CommitRecord) Operator() RecordType {
 	return Commit
 }
 
-func (r *CommitRecord) TxNumber() int32 {
+func (r *CommitRecord) TxNumber() int64 {
 	return r.txNum
 }
 
+// Time returns the time the transaction committed.
+func (r *CommitRecord) Time() time.Time {
+	return time.Unix(0, r.time)
+}
+
 func (r *CommitRecord) Undo(tx *Transaction) error {
 	// Do nothing because a commit record contains no undo information.
 	return nil
 }
 
-func WriteCommitRecordToLog(logManager *log.Manager, txNum int32) (int32, error) {
-	p := file.NewPage(2 * 4)
+func (r *CommitRecord) Redo(tx *Transaction) error {
+	// Do nothing because a commit record contains no redo information.
+	return nil
+}
+
+func WriteCommitRecordToLog(logManager *log.Manager, txNum int64) (int32, error) {
+	p := file.NewPage(4 + 8 + 8)
 
 	err := p.WriteInt32At(0, int32(Commit))
 	if err != nil {
 		return 0, err
 	}
 
-	err = p.WriteInt32At(4, txNum)
+	err = p.WriteInt64At(4, txNum)
+	if err != nil {
+		return 0, err
+	}
+
+	err = p.WriteInt64At(12, time.Now().UnixNano())
 	if err != nil {
 		return 0, err
 	}
@@ -161,11 +243,11 @@ func WriteCommitRecordToLog(logManager *log.Manager, txNum int32) (int32, error)
 }
 
 type RollbackRecord struct {
-	txNum int32
+	txNum int64
 }
 
 func NewRollbackRecord(page *file.Page) (*RollbackRecord, error) {
-	txNum, err := page.ReadInt32At(4)
+	txNum, err := page.ReadInt64At(4)
 	if err != nil {
 		return nil, err
 	}
@@ -176,7 +258,7 @@ func (r *RollbackRecord) Operator() RecordType {
 	return Rollback
 }
 
-func (r *RollbackRecord) TxNumber() int32 {
+func (r *RollbackRecord) TxNumber() int64 {
 	return r.txNum
 }
 
@@ -185,15 +267,84 @@ func (r *RollbackRecord) Undo(tx *Transaction) error {
 	return nil
 }
 
-func WriteRollbackRecordToLog(logManager *log.Manager, txNum int32) (int32, error) {
-	p := file.NewPage(2 * 4)
+func (r *RollbackRecord) Redo(tx *Transaction) error {
+	// Do nothing because a rollback record contains no redo information.
+	return nil
+}
+
+func WriteRollbackRecordToLog(logManager *log.Manager, txNum int64) (int32, error) {
+	p := file.NewPage(4 + 8)
 
 	err := p.WriteInt32At(0, int32(Rollback))
 	if err != nil {
 		return 0, err
 	}
 
-	err = p.WriteInt32At(4, txNum)
+	err = p.WriteInt64At(4, txNum)
+	if err != nil {
+		return 0, err
+	}
+
+	return logManager.Append(p.Buf())
+}
+
+// PrepareRecord marks a transaction as prepared under a global transaction
+// id. A prepared transaction is neither undone nor finished by recovery.
+type PrepareRecord struct {
+	txNum int64
+	gid   string
+}
+
+func NewPrepareRecord(page *file.Page) (*PrepareRecord, error) {
+	txNum, err := page.ReadInt64At(4)
+	if err != nil {
+		return nil, err
+	}
+	gid, err := page.ReadStringAt(12)
+	if err != nil {
+		return nil, err
+	}
+	return &PrepareRecord{txNum: txNum, gid: gid}, nil
+}
+
+func (r *PrepareRecord) Operator() RecordType {
+	return Prepare
+}
+
+func (r *PrepareRecord) TxNumber() int64 {
+	return r.txNum
+}
+
+// GlobalID returns the global transaction id the transaction was prepared
+// under.
+func (r *PrepareRecord) GlobalID() string {
+	return r.gid
+}
+
+func (r *PrepareRecord) Undo(tx *Transaction) error {
+	// Do nothing because a prepare record contains no undo information.
+	return nil
+}
+
+func (r *PrepareRecord) Redo(tx *Transaction) error {
+	// Do nothing because a prepare record contains no redo information.
+	return nil
+}
+
+func WritePrepareRecordToLog(logManager *log.Manager, txNum int64, gid string) (int32, error) {
+	p := file.NewPage(4 + 8 + 4 + int32(len(gid)))
+
+	err := p.WriteInt32At(0, int32(Prepare))
+	if err != nil {
+		return 0, err
+	}
+
+	err = p.WriteInt64At(4, txNum)
+	if err != nil {
+		return 0, err
+	}
+
+	err = p.WriteStringAt(12, gid)
 	if err != nil {
 		return 0, err
 	}
@@ -202,36 +353,42 @@ func WriteRollbackRecordToLog(logManager *log.Manager, txNum int32) (int32, erro
 }
 
 type SetIntRecord struct {
-	txNum  int32
+	txNum  int64
 	offset int32
-	val    int32
+	val    int32 // old value
+	newVal int32
 	block  *file.Block
 }
 
 func NewSetIntRecord(page *file.Page) (*SetIntRecord, error) {
-	txNum, err := page.ReadInt32At(4)
+	txNum, err := page.ReadInt64At(4)
 	if err != nil {
 		return nil, err
 	}
 
-	filename, err := page.ReadStringAt(8)
+	filename, err := page.ReadStringAt(12)
 	if err != nil {
 		return nil, err
 	}
 
-	blockNum, err := page.ReadInt32At(8 + page.MaxLength(filename))
+	blockNum, err := page.ReadInt32At(12 + page.MaxLength(filename))
 	if err != nil {
 		return nil, err
 	}
 
 	block := file.NewBlock(filename, blockNum)
 
-	offset, err := page.ReadInt32At(8 + page.MaxLength(filename) + 4)
+	offset, err := page.ReadInt32At(12 + page.MaxLength(filename) + 4)
 	if err != nil {
 		return nil, err
 	}
 
-	val, err := page.ReadInt32At(8 + page.MaxLength(filename) + 4 + 4)
+	val, err := page.ReadInt32At(12 + page.MaxLength(filename) + 4 + 4)
+	if err != nil {
+		return nil, err
+	}
+
+	newVal, err := page.ReadInt32At(12 + page.MaxLength(filename) + 4 + 4 + 4)
 	if err != nil {
 		return nil, err
 	}
@@ -240,6 +397,7 @@ func NewSetIntRecord(page *file.Page) (*SetIntRecord, error) {
 		txNum:  txNum,
 		offset: offset,
 		val:    val,
+		newVal: newVal,
 		block:  block,
 	}, nil
 }
@@ -248,7 +406,7 @@ func (r *SetIntRecord) Operator() RecordType {
 	return SetInt
 }
 
-func (r *SetIntRecord) TxNumber() int32 {
+func (r *SetIntRecord) TxNumber() int64 {
 	return r.txNum
 }
 
@@ -257,7 +415,25 @@ func (r *SetIntRecord) Undo(tx *Transaction) error {
 		return err
 	}
 
-	if err := tx.WriteInt32(r.block, r.offset, r.val, false); err != nil {
+	// The transaction holds locks on whatever it changed, and during recovery
+	// no other transaction is running, so the old value is restored without
+	// locking. Locking the whole block could wait for transactions that
+	// hold other records of it.
+	if err := tx.writeInt32(r.block, r.offset, r.val, false); err != nil {
+		return err
+	}
+
+	tx.Unpin(r.block)
+	return nil
+}
+
+// Redo writes the new value again, without logging it, as undo does.
+func (r *SetIntRecord) Redo(tx *Transaction) error {
+	if err := tx.Pin(r.block); err != nil {
+		return err
+	}
+
+	if err := tx.writeInt32(r.block, r.offset, r.newVal, false); err != nil {
 		return err
 	}
 
@@ -265,55 +441,73 @@ func (r *SetIntRecord) Undo(tx *Transaction) error {
 	return nil
 }
 
-func WriteSetIntRecotrdToLog(logManager *log.Manager, txNum int32, block *file.Block, offset int32, val int32) (int32, error) {
+func (r *SetIntRecord) replay(tx *Transaction) error {
+	if err := tx.Pin(r.block); err != nil {
+		return err
+	}
+	defer tx.Unpin(r.block)
+
+	return tx.WriteInt32(r.block, r.offset, r.newVal, true)
+}
+
+func WriteSetIntRecotrdToLog(logManager *log.Manager, txNum int64, block *file.Block, offset int32, val int32, newVal int32) (int32, error) {
 	tpos := int32(4)
-	fpos := tpos + 4
+	fpos := tpos + 8
 	bpos := fpos + 4 + int32(len(block.Filename()))
 	opos := bpos + 4
 	vpos := opos + 4
 
-	p := file.NewPage(vpos + 4)
+	npos := vpos + 4
+
+	p := file.NewPage(npos + 4)
 	p.WriteInt32At(0, int32(SetInt))
-	p.WriteInt32At(tpos, txNum)
+	p.WriteInt64At(tpos, txNum)
 	p.WriteStringAt(fpos, block.Filename())
 	p.WriteInt32At(bpos, block.Number())
 	p.WriteInt32At(opos, offset)
 	p.WriteInt32At(vpos, val)
+	p.WriteInt32At(npos, newVal)
 
 	return logManager.Append(p.Buf())
 }
 
 type SetStringRecord struct {
-	txNum  int32
+	txNum  int64
 	offset int32
-	val    string
+	val    string // old value
+	newVal string
 	block  *file.Block
 }
 
 func NewSetStringRecord(page *file.Page) (*SetStringRecord, error) {
-	txNum, err := page.ReadInt32At(4)
+	txNum, err := page.ReadInt64At(4)
 	if err != nil {
 		return nil, err
 	}
 
-	filename, err := page.ReadStringAt(8)
+	filename, err := page.ReadStringAt(12)
 	if err != nil {
 		return nil, err
 	}
 
-	blockNum, err := page.ReadInt32At(8 + page.MaxLength(filename))
+	blockNum, err := page.ReadInt32At(12 + page.MaxLength(filename))
 	if err != nil {
 		return nil, err
 	}
 
 	block := file.NewBlock(filename, blockNum)
 
-	offset, err := page.ReadInt32At(8 + page.MaxLength(filename) + 4)
+	offset, err := page.ReadInt32At(12 + page.MaxLength(filename) + 4)
+	if err != nil {
+		return nil, err
+	}
+
+	val, err := page.ReadStringAt(12 + page.MaxLength(filename) + 4 + 4)
 	if err != nil {
 		return nil, err
 	}
 
-	val, err := page.ReadStringAt(8 + page.MaxLength(filename) + 4 + 4)
+	newVal, err := page.ReadStringAt(12 + page.MaxLength(filename) + 4 + 4 + page.MaxLength(val))
 	if err != nil {
 		return nil, err
 	}
@@ -322,6 +516,7 @@ func NewSetStringRecord(page *file.Page) (*SetStringRecord, error) {
 		txNum:  txNum,
 		offset: offset,
 		val:    val,
+		newVal: newVal,
 		block:  block,
 	}, nil
 }
@@ -330,7 +525,7 @@ func (r *SetStringRecord) Operator() RecordType {
 	return SetString
 }
 
-func (r *SetStringRecord) TxNumber() int32 {
+func (r *SetStringRecord) TxNumber() int64 {
 	return r.txNum
 }
 
@@ -339,7 +534,7 @@ func (r *SetStringRecord) Undo(tx *Transaction) error {
 		return err
 	}
 
-	if err := tx.WriteString(r.block, r.offset, r.val, false); err != nil {
+	if err := tx.writeString(r.block, r.offset, r.val, false); err != nil {
 		return err
 	}
 
@@ -347,20 +542,435 @@ func (r *SetStringRecord) Undo(tx *Transaction) error {
 	return nil
 }
 
-func WriteSetStringRecordToLog(logManager *log.Manager, txNum int32, block *file.Block, offset int32, val string) (int32, error) {
+// Redo writes the new value again, without logging it, as undo does.
+func (r *SetStringRecord) Redo(tx *Transaction) error {
+	if err := tx.Pin(r.block); err != nil {
+		return err
+	}
+
+	if err := tx.writeString(r.block, r.offset, r.newVal, false); err != nil {
+		return err
+	}
+
+	tx.Unpin(r.block)
+	return nil
+}
+
+func (r *SetStringRecord) replay(tx *Transaction) error {
+	if err := tx.Pin(r.block); err != nil {
+		return err
+	}
+	defer tx.Unpin(r.block)
+
+	return tx.WriteString(r.block, r.offset, r.newVal, true)
+}
+
+func WriteSetStringRecordToLog(logManager *log.Manager, txNum int64, block *file.Block, offset int32, val string, newVal string) (int32, error) {
 	tpos := int32(4)
-	fpos := tpos + 4
+	fpos := tpos + 8
 	bpos := fpos + 4 + int32(len(block.Filename()))
 	opos := bpos + 4
 	vpos := opos + 4
 
-	p := file.NewPage(vpos + 4 + int32(len(val)))
+	npos := vpos + 4 + int32(len(val))
+
+	p := file.NewPage(npos + 4 + int32(len(newVal)))
 	p.WriteInt32At(0, int32(SetString))
-	p.WriteInt32At(tpos, txNum)
+	p.WriteInt64At(tpos, txNum)
 	p.WriteStringAt(fpos, block.Filename())
 	p.WriteInt32At(bpos, block.Number())
 	p.WriteInt32At(opos, offset)
 	p.WriteStringAt(vpos, val)
+	p.WriteStringAt(npos, newVal)
+
+	return logManager.Append(p.Buf())
+}
+
+type SetInt64Record struct {
+	txNum  int64
+	offset int32
+	val    int64 // old value
+	newVal int64
+	block  *file.Block
+}
+
+func NewSetInt64Record(page *file.Page) (*SetInt64Record, error) {
+	txNum, err := page.ReadInt64At(4)
+	if err != nil {
+		return nil, err
+	}
+
+	filename, err := page.ReadStringAt(12)
+	if err != nil {
+		return nil, err
+	}
+
+	blockNum, err := page.ReadInt32At(12 + page.MaxLength(filename))
+	if err != nil {
+		return nil, err
+	}
+
+	block := file.NewBlock(filename, blockNum)
+
+	offset, err := page.ReadInt32At(12 + page.MaxLength(filename) + 4)
+	if err != nil {
+		return nil, err
+	}
+
+	val, err := page.ReadInt64At(12 + page.MaxLength(filename) + 4 + 4)
+	if err != nil {
+		return nil, err
+	}
+
+	newVal, err := page.ReadInt64At(12 + page.MaxLength(filename) + 4 + 4 + 8)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SetInt64Record{
+		txNum:  txNum,
+		offset: offset,
+		val:    val,
+		newVal: newVal,
+		block:  block,
+	}, nil
+}
+
+func (r *SetInt64Record) Operator() RecordType {
+	return SetInt64
+}
+
+func (r *SetInt64Record) TxNumber() int64 {
+	return r.txNum
+}
+
+func (r *SetInt64Record) Undo(tx *Transaction) error {
+	if err := tx.Pin(r.block); err != nil {
+		return err
+	}
+
+	if err := tx.writeInt64(r.block, r.offset, r.val, false); err != nil {
+		return err
+	}
+
+	tx.Unpin(r.block)
+	return nil
+}
+
+// Redo writes the new value again, without logging it, as undo does.
+func (r *SetInt64Record) Redo(tx *Transaction) error {
+	if err := tx.Pin(r.block); err != nil {
+		return err
+	}
+
+	if err := tx.writeInt64(r.block, r.offset, r.newVal, false); err != nil {
+		return err
+	}
+
+	tx.Unpin(r.block)
+	return nil
+}
+
+func (r *SetInt64Record) replay(tx *Transaction) error {
+	if err := tx.Pin(r.block); err != nil {
+		return err
+	}
+	defer tx.Unpin(r.block)
+
+	return tx.WriteInt64(r.block, r.offset, r.newVal, true)
+}
+
+func WriteSetInt64RecordToLog(logManager *log.Manager, txNum int64, block *file.Block, offset int32, val int64, newVal int64) (int32, error) {
+	tpos := int32(4)
+	fpos := tpos + 8
+	bpos := fpos + 4 + int32(len(block.Filename()))
+	opos := bpos + 4
+	vpos := opos + 4
+
+	npos := vpos + 8
+
+	p := file.NewPage(npos + 8)
+	p.WriteInt32At(0, int32(SetInt64))
+	p.WriteInt64At(tpos, txNum)
+	p.WriteStringAt(fpos, block.Filename())
+	p.WriteInt32At(bpos, block.Number())
+	p.WriteInt32At(opos, offset)
+	p.WriteInt64At(vpos, val)
+	p.WriteInt64At(npos, newVal)
+
+	return logManager.Append(p.Buf())
+}
+
+// SetBytesRecord is a change to a run of bytes of a block, such as a
+// variable-length record. It carries the exact bytes that were overwritten,
+// so that undo restores the block byte for byte.
+type SetBytesRecord struct {
+	txNum  int64
+	offset int32
+	val    []byte // old value
+	newVal []byte
+	block  *file.Block
+}
+
+func NewSetBytesRecord(page *file.Page) (*SetBytesRecord, error) {
+	txNum, err := page.ReadInt64At(4)
+	if err != nil {
+		return nil, err
+	}
+
+	filename, err := page.ReadStringAt(12)
+	if err != nil {
+		return nil, err
+	}
+
+	blockNum, err := page.ReadInt32At(12 + page.MaxLength(filename))
+	if err != nil {
+		return nil, err
+	}
+
+	block := file.NewBlock(filename, blockNum)
+
+	offset, err := page.ReadInt32At(12 + page.MaxLength(filename) + 4)
+	if err != nil {
+		return nil, err
+	}
+
+	val, err := page.ReadBytesAt(12 + page.MaxLength(filename) + 4 + 4)
+	if err != nil {
+		return nil, err
+	}
+
+	newVal, err := page.ReadBytesAt(12 + page.MaxLength(filename) + 4 + 4 + 4 + int32(len(val)))
+	if err != nil {
+		return nil, err
+	}
+
+	return &SetBytesRecord{
+		txNum:  txNum,
+		offset: offset,
+		val:    val,
+		newVal: newVal,
+		block:  block,
+	}, nil
+}
+
+func (r *SetBytesRecord) Operator() RecordType {
+	return SetBytes
+}
+
+func (r *SetBytesRecord) TxNumber() int64 {
+	return r.txNum
+}
+
+func (r *SetBytesRecord) Undo(tx *Transaction) error {
+	if err := tx.Pin(r.block); err != nil {
+		return err
+	}
+
+	if err := tx.writeBytes(r.block, r.offset, r.val, false); err != nil {
+		return err
+	}
+
+	tx.Unpin(r.block)
+	return nil
+}
+
+// Redo writes the new value again, without logging it, as undo does.
+func (r *SetBytesRecord) Redo(tx *Transaction) error {
+	if err := tx.Pin(r.block); err != nil {
+		return err
+	}
+
+	if err := tx.writeBytes(r.block, r.offset, r.newVal, false); err != nil {
+		return err
+	}
+
+	tx.Unpin(r.block)
+	return nil
+}
+
+func (r *SetBytesRecord) replay(tx *Transaction) error {
+	if err := tx.Pin(r.block); err != nil {
+		return err
+	}
+	defer tx.Unpin(r.block)
+
+	return tx.WriteBytes(r.block, r.offset, r.newVal, true)
+}
+
+func WriteSetBytesRecordToLog(logManager *log.Manager, txNum int64, block *file.Block, offset int32, val []byte, newVal []byte) (int32, error) {
+	tpos := int32(4)
+	fpos := tpos + 8
+	bpos := fpos + 4 + int32(len(block.Filename()))
+	opos := bpos + 4
+	vpos := opos + 4
+
+	npos := vpos + 4 + int32(len(val))
+
+	p := file.NewPage(npos + 4 + int32(len(newVal)))
+	p.WriteInt32At(0, int32(SetBytes))
+	p.WriteInt64At(tpos, txNum)
+	p.WriteStringAt(fpos, block.Filename())
+	p.WriteInt32At(bpos, block.Number())
+	p.WriteInt32At(opos, offset)
+	p.WriteBytesAt(vpos, val)
+	p.WriteBytesAt(npos, newVal)
+
+	return logManager.Append(p.Buf())
+}
+
+// maxSetBytes returns the largest number of bytes of the block that a
+// single SetBytes record can change, so that the record, which holds both
+// the old and the new bytes, fits in a log block.
+func maxSetBytes(logBlockSize int32, block *file.Block) int32 {
+	// The record header, the two length prefixes, and the length prefix and
+	// header the log manager adds.
+	overhead := int32(4+8+4+len(block.Filename())+4+4) + 2*4 + 4 + 12
+	return (logBlockSize - overhead) / 2
+}
+
+// AppendBlockRecord marks a block as appended to its file. The block is
+// empty when it is appended, and what is written to it afterwards is logged,
+// so redo appends it again if the file is shorter, and empties it, in case
+// the file held it before being cut or removed.
+type AppendBlockRecord struct {
+	txNum int64
+	block *file.Block
+}
+
+func NewAppendBlockRecord(page *file.Page) (*AppendBlockRecord, error) {
+	txNum, err := page.ReadInt64At(4)
+	if err != nil {
+		return nil, err
+	}
+
+	filename, err := page.ReadStringAt(12)
+	if err != nil {
+		return nil, err
+	}
+
+	blockNum, err := page.ReadInt32At(12 + page.MaxLength(filename))
+	if err != nil {
+		return nil, err
+	}
+
+	return &AppendBlockRecord{txNum: txNum, block: file.NewBlock(filename, blockNum)}, nil
+}
+
+func (r *AppendBlockRecord) Operator() RecordType {
+	return AppendBlock
+}
+
+func (r *AppendBlockRecord) TxNumber() int64 {
+	return r.txNum
+}
+
+func (r *AppendBlockRecord) Undo(tx *Transaction) error {
+	// Do nothing because an appended block is left in the file, as when
+	// the transaction rolls back.
+	return nil
+}
+
+func (r *AppendBlockRecord) Redo(tx *Transaction) error {
+	if err := tx.extend(r.block); err != nil {
+		return err
+	}
+	if err := tx.Pin(r.block); err != nil {
+		return err
+	}
+
+	if err := tx.writeBytes(r.block, 0, make([]byte, tx.BlockSize()), false); err != nil {
+		return err
+	}
+
+	tx.Unpin(r.block)
+	return nil
+}
+
+// replay appends blocks to the file until it holds the block. The changes of
+// a replica are applied by commit rather than in log order, so the block is
+// not emptied, as another transaction may already have written to it.
+func (r *AppendBlockRecord) replay(tx *Transaction) error {
+	for {
+		size, err := tx.Size(r.block.Filename())
+		if err != nil {
+			return err
+		}
+		if size > r.block.Number() {
+			return nil
+		}
+		if _, err := tx.Append(r.block.Filename()); err != nil {
+			return err
+		}
+	}
+}
+
+func WriteAppendBlockRecordToLog(logManager *log.Manager, txNum int64, block *file.Block) (int32, error) {
+	tpos := int32(4)
+	fpos := tpos + 8
+	bpos := fpos + 4 + int32(len(block.Filename()))
+
+	p := file.NewPage(bpos + 4)
+	p.WriteInt32At(0, int32(AppendBlock))
+	p.WriteInt64At(tpos, txNum)
+	p.WriteStringAt(fpos, block.Filename())
+	p.WriteInt32At(bpos, block.Number())
+
+	return logManager.Append(p.Buf())
+}
+
+// RemoveFileRecord marks a file as removed by the transaction. The file is
+// only deleted once the transaction commits, so redo deletes it when it
+// reaches the commit record of the transaction.
+type RemoveFileRecord struct {
+	txNum    int64
+	filename string
+}
+
+func NewRemoveFileRecord(page *file.Page) (*RemoveFileRecord, error) {
+	txNum, err := page.ReadInt64At(4)
+	if err != nil {
+		return nil, err
+	}
+
+	filename, err := page.ReadStringAt(12)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RemoveFileRecord{txNum: txNum, filename: filename}, nil
+}
+
+func (r *RemoveFileRecord) Operator() RecordType {
+	return RemoveFile
+}
+
+func (r *RemoveFileRecord) TxNumber() int64 {
+	return r.txNum
+}
+
+func (r *RemoveFileRecord) Undo(tx *Transaction) error {
+	// Do nothing because the file is only deleted once the transaction
+	// commits.
+	return nil
+}
+
+func (r *RemoveFileRecord) Redo(tx *Transaction) error {
+	return tx.removeFile(r.filename)
+}
+
+func (r *RemoveFileRecord) replay(tx *Transaction) error {
+	return tx.Remove(r.filename)
+}
+
+func WriteRemoveFileRecordToLog(logManager *log.Manager, txNum int64, filename string) (int32, error) {
+	tpos := int32(4)
+	fpos := tpos + 8
+
+	p := file.NewPage(fpos + 4 + int32(len(filename)))
+	p.WriteInt32At(0, int32(RemoveFile))
+	p.WriteInt64At(tpos, txNum)
+	p.WriteStringAt(fpos, filename)
 
 	return logManager.Append(p.Buf())
 }
@@ -1,7 +1,11 @@
 package transaction
 
 import (
+	"maps"
+	"slices"
+
 	"simpledb/buffer"
+	"simpledb/file"
 	"simpledb/log"
 )
 
@@ -9,10 +13,10 @@ type RecoveryManager struct {
 	logManager    *log.Manager
 	bufferManager *buffer.Manager
 	tx            *Transaction
-	txNum         int32
+	txNum         int64
 }
 
-func NewRecoveryManager(logManager *log.Manager, bufferManager *buffer.Manager, tx *Transaction, txNum int32) (*RecoveryManager, error) {
+func NewRecoveryManager(logManager *log.Manager, bufferManager *buffer.Manager, tx *Transaction, txNum int64) (*RecoveryManager, error) {
 	_, err := WriteStartRecordToLog(logManager, txNum)
 	if err != nil {
 		return nil, err
@@ -40,6 +44,22 @@ func (m *RecoveryManager) Commit() error {
 	return m.logManager.Flush(lsn)
 }
 
+// Prepare flushes the transaction's changes and writes a durable prepare
+// record for it under the global transaction id.
+func (m *RecoveryManager) Prepare(gid string) error {
+	err := m.bufferManager.FlushAll(m.txNum)
+	if err != nil {
+		return err
+	}
+
+	lsn, err := WritePrepareRecordToLog(m.logManager, m.txNum, gid)
+	if err != nil {
+		return err
+	}
+
+	return m.logManager.Flush(lsn)
+}
+
 func (m *RecoveryManager) Rollback() error {
 	err := m.doRollback()
 	if err != nil {
@@ -59,23 +79,67 @@ func (m *RecoveryManager) Rollback() error {
 	return m.logManager.Flush(lsn)
 }
 
-func (m *RecoveryManager) Recover() error {
-	err := m.doRecover()
+// Recover undoes the changes of the transactions that did not finish, except
+// for prepared ones, which are left in doubt and returned so that they can
+// be resumed. The transactions it undoes are logged as rolled back, so that
+// later recoveries do not undo them again, and if none is left in doubt and
+// no other transaction is running, a checkpoint is logged.
+//
+// In a restored backup, the changes in the log are first redone, since the
+// data files were copied while transactions were changing them.
+func (m *RecoveryManager) Recover() ([]*inDoubtTx, error) {
+	label, err := readBackupLabel(m.tx.fileManager)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if label != nil {
+		if err := m.doRedo(label.checkpoint); err != nil {
+			return nil, err
+		}
+	}
+
+	inDoubt, undone, err := m.doRecover()
+	if err != nil {
+		return nil, err
 	}
 
 	err = m.bufferManager.FlushAll(m.txNum)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	for _, txNum := range undone {
+		if _, err := WriteRollbackRecordToLog(m.logManager, txNum); err != nil {
+			return nil, err
+		}
 	}
 
 	lsn, err := WriteRollbackRecordToLog(m.logManager, m.txNum)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return m.logManager.Flush(lsn)
+	// Unless transactions are left in doubt, every transaction in the log
+	// has finished now, so later recoveries can stop here.
+	if len(inDoubt) == 0 {
+		checkpoint, err := m.tx.manager.writeCheckpoint(m.txNum)
+		if err != nil {
+			return nil, err
+		}
+		lsn = max(lsn, checkpoint)
+	}
+
+	if err := m.logManager.Flush(lsn); err != nil {
+		return nil, err
+	}
+
+	// The backup is consistent now, so the label is no longer needed.
+	if label != nil {
+		if err := m.tx.fileManager.Remove(BackupLabel); err != nil {
+			return nil, err
+		}
+	}
+	return inDoubt, nil
 }
 
 func (m *RecoveryManager) SetInt(buf *buffer.Buffer, offset int32, newVal int32) (int32, error) {
@@ -84,7 +148,7 @@ func (m *RecoveryManager) SetInt(buf *buffer.Buffer, offset int32, newVal int32)
 		return 0, err
 	}
 
-	return WriteSetIntRecotrdToLog(m.logManager, m.txNum, buf.Block(), offset, oldVal)
+	return WriteSetIntRecotrdToLog(m.logManager, m.txNum, buf.Block(), offset, oldVal, newVal)
 }
 
 func (m *RecoveryManager) SetString(buf *buffer.Buffer, offset int32, newVal string) (int32, error) {
@@ -93,7 +157,35 @@ func (m *RecoveryManager) SetString(buf *buffer.Buffer, offset int32, newVal str
 		return 0, err
 	}
 
-	return WriteSetStringRecordToLog(m.logManager, m.txNum, buf.Block(), offset, oldVal)
+	return WriteSetStringRecordToLog(m.logManager, m.txNum, buf.Block(), offset, oldVal, newVal)
+}
+
+func (m *RecoveryManager) SetInt64(buf *buffer.Buffer, offset int32, newVal int64) (int32, error) {
+	oldVal, err := buf.Contents().ReadInt64At(offset)
+	if err != nil {
+		return 0, err
+	}
+
+	return WriteSetInt64RecordToLog(m.logManager, m.txNum, buf.Block(), offset, oldVal, newVal)
+}
+
+func (m *RecoveryManager) SetBytes(buf *buffer.Buffer, offset int32, newVal []byte) (int32, error) {
+	oldVal, err := buf.Contents().ReadRawAt(offset, int32(len(newVal)))
+	if err != nil {
+		return 0, err
+	}
+
+	return WriteSetBytesRecordToLog(m.logManager, m.txNum, buf.Block(), offset, oldVal, newVal)
+}
+
+// Append logs that the block was appended to its file.
+func (m *RecoveryManager) Append(block *file.Block) (int32, error) {
+	return WriteAppendBlockRecordToLog(m.logManager, m.txNum, block)
+}
+
+// Remove logs that the file is to be deleted when the transaction commits.
+func (m *RecoveryManager) Remove(filename string) (int32, error) {
+	return WriteRemoveFileRecordToLog(m.logManager, m.txNum, filename)
 }
 
 func (m *RecoveryManager) doRollback() error {
@@ -117,43 +209,185 @@ func (m *RecoveryManager) doRollback() error {
 			if record.Operator() == Start {
 				return nil
 			}
-			record.Undo(m.tx)
+			if err := record.Undo(m.tx); err != nil {
+				return err
+			}
 		}
 	}
 
 	return nil
 }
 
-func (m *RecoveryManager) doRecover() error {
-	finishedTxs := make(map[int32]bool)
+// inDoubtTx is a transaction that recovery found prepared but not finished.
+type inDoubtTx struct {
+	txNum   int64
+	gid     string
+	blocks  []*file.Block // blocks the transaction modified
+	removes []string      // files the transaction removed
+}
+
+// doRecover undoes the unfinished transactions. It returns the prepared
+// transactions, and the numbers of the transactions it undid.
+//
+// A crash after a commit may leave the files the transaction removed
+// behind, so they are deleted again, unless a later transaction appended to
+// them, which it could only do once they were deleted.
+func (m *RecoveryManager) doRecover() ([]*inDoubtTx, []int64, error) {
+	finishedTxs := make(map[int64]bool)
+	committedTxs := make(map[int64]bool)
+	preparedTxs := make(map[int64]*inDoubtTx)
+	undoneTxs := make(map[int64]bool)
+	appended := make(map[string]bool)
+	var removed []string
+	var inDoubt []*inDoubtTx
+
 	iter, err := m.logManager.Iterator()
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
 	for iter.HasNext() {
 		log, err := iter.Next()
 		if err != nil {
-			return err
+			return nil, nil, err
 		}
 
 		record, err := createLogRecord(log)
 		if err != nil {
-			return err
+			return nil, nil, err
 		}
 
 		if record.Operator() == Checkpoint {
-			return nil
+			break
+		}
+
+		// The log is read backwards, so the appends seen so far come after
+		// the record.
+		if r, ok := record.(*AppendBlockRecord); ok {
+			appended[r.block.Filename()] = true
 		}
 
-		if record.Operator() == Commit || record.Operator() == Rollback {
-			finishedTxs[record.TxNumber()] = true
-		} else if !finishedTxs[record.TxNumber()] {
+		txNum := record.TxNumber()
+		switch {
+		case record.Operator() == Commit:
+			finishedTxs[txNum] = true
+			committedTxs[txNum] = true
+		case record.Operator() == Rollback:
+			finishedTxs[txNum] = true
+		case record.Operator() == RemoveFile && committedTxs[txNum]:
+			if filename := record.(*RemoveFileRecord).filename; !appended[filename] {
+				removed = append(removed, filename)
+			}
+		case finishedTxs[txNum]:
+		case record.Operator() == Prepare:
+			// The log is read backwards, so the prepare record of a
+			// transaction comes before the changes it made.
+			prepared := &inDoubtTx{txNum: txNum, gid: record.(*PrepareRecord).GlobalID()}
+			preparedTxs[txNum] = prepared
+			inDoubt = append(inDoubt, prepared)
+		case preparedTxs[txNum] != nil:
+			if block, ok := modifiedBlock(record); ok {
+				preparedTxs[txNum].blocks = append(preparedTxs[txNum].blocks, block)
+			}
+			if r, ok := record.(*RemoveFileRecord); ok {
+				preparedTxs[txNum].removes = append(preparedTxs[txNum].removes, r.filename)
+			}
+		default:
 			if err := record.Undo(m.tx); err != nil {
-				return err
+				return nil, nil, err
+			}
+			if txNum != m.txNum {
+				undoneTxs[txNum] = true
 			}
 		}
 	}
 
+	for _, filename := range removed {
+		if err := m.tx.removeFile(filename); err != nil {
+			return nil, nil, err
+		}
+	}
+	return inDoubt, slices.Sorted(maps.Keys(undoneTxs)), nil
+}
+
+// doRedo replays the log of a restored backup after the checkpoint LSN. The
+// data files of the backup can be older than the log, so every change is
+// written again in log order. When the rollback record of a transaction is
+// reached, its changes are undone again, as its rollback did. The changes of
+// the transactions that did not finish are left for doRecover to undo. The
+// files a transaction removed are deleted when its commit record is reached,
+// as they were by its commit.
+func (m *RecoveryManager) doRedo(checkpoint int32) error {
+	iter, err := m.logManager.Iterator()
+	if err != nil {
+		return err
+	}
+
+	// The iterator reads the log backwards.
+	var records []Record
+	for iter.HasNext() {
+		log, err := iter.Next()
+		if err != nil {
+			return err
+		}
+
+		if iter.LSN() <= checkpoint {
+			break
+		}
+
+		record, err := createLogRecord(log)
+		if err != nil {
+			return err
+		}
+		records = append(records, record)
+	}
+	slices.Reverse(records)
+
+	changes := make(map[int64][]Record)
+	for _, record := range records {
+		txNum := record.TxNumber()
+		switch record.Operator() {
+		case Commit:
+			for _, change := range changes[txNum] {
+				if change.Operator() != RemoveFile {
+					continue
+				}
+				if err := change.Redo(m.tx); err != nil {
+					return err
+				}
+			}
+			delete(changes, txNum)
+		case Rollback:
+			for _, change := range slices.Backward(changes[txNum]) {
+				if err := change.Undo(m.tx); err != nil {
+					return err
+				}
+			}
+			delete(changes, txNum)
+		case SetInt, SetString, SetInt64, SetBytes, AppendBlock:
+			if err := record.Redo(m.tx); err != nil {
+				return err
+			}
+			changes[txNum] = append(changes[txNum], record)
+		case RemoveFile:
+			changes[txNum] = append(changes[txNum], record)
+		}
+	}
 	return nil
 }
+
+// modifiedBlock returns the block changed by an update record.
+func modifiedBlock(record Record) (*file.Block, bool) {
+	switch r := record.(type) {
+	case *SetIntRecord:
+		return r.block, true
+	case *SetStringRecord:
+		return r.block, true
+	case *SetInt64Record:
+		return r.block, true
+	case *SetBytesRecord:
+		return r.block, true
+	default:
+		return nil, false
+	}
+}
@@ -0,0 +1,254 @@
+package transaction
+
+import (
+	"errors"
+	"io"
+	"slices"
+	"sync"
+
+	"simpledb/file"
+	"simpledb/log"
+)
+
+// ReplicaState is the file where a replica keeps how far it has applied the
+// log of its primary.
+const ReplicaState = "replica_state"
+
+// ErrNotReplica is returned by NewReplica for a database that was not
+// restored with RestoreReplica.
+var ErrNotReplica = errors.New("transaction: database is not a replica")
+
+// ErrReplicaStalled is returned by Receive when the changes of a primary
+// transaction cannot be applied, because transactions of the replica keep
+// holding locks on the data they change. Once they finish, Receive can be
+// called again, with the log shipped from ResumeLSN.
+var ErrReplicaStalled = errors.New("transaction: replica cannot apply changes while its transactions hold locks")
+
+// maxApplyAttempts is how many times the changes of a primary transaction
+// are applied before Receive gives up, each attempt waiting for the locks
+// of the replica's transactions until it times out.
+const maxApplyAttempts = 6
+
+// Replica applies the log of a primary database, shipped by the primary's
+// log.Manager.Ship, to a copy of the database, which can serve read-only
+// transactions meanwhile.
+//
+// The changes of a primary transaction are applied when its commit record
+// is received, all together in a transaction of the replica, so that
+// transactions on the replica only see committed data. That transaction
+// also records the LSN of the commit, and the LSN after which the log must
+// be shipped again after a restart, so that the transactions still running
+// on the primary are then received from their start.
+//
+// The versions in multi-version tables carry the transaction numbers of the
+// primary, so transactions on the replica take every version as committed,
+// which the versions they can read are. They must not use SnapshotIsolation,
+// since a snapshot takes no locks and could see a commit partly applied.
+type Replica struct {
+	manager *Manager
+
+	mu      sync.Mutex
+	applied int32 // LSN of the last commit applied
+	resume  int32 // LSN after which the log must be shipped
+
+	pending map[int64][]Record // changes of running primary transactions
+	starts  map[int64]int32    // LSN before each running primary transaction
+}
+
+// RestoreReplica restores the backup of a primary database in dst, to make a
+// replica of it. As after Restore, the replica must then be recovered. The
+// transactions that committed in the backup's log are already applied; the
+// replica receives the log from the backup's checkpoint, to get all the
+// changes of the transactions that were running at its end.
+func RestoreReplica(backup file.Storage, dst file.Storage) error {
+	if err := Restore(backup, dst); err != nil {
+		return err
+	}
+
+	label, err := readBackupLabel(dst)
+	if err != nil {
+		return err
+	}
+	logManager, err := log.NewManager(dst, label.logFile)
+	if err != nil {
+		return err
+	}
+
+	page := file.NewPage(dst.BlockSize())
+	if err := page.WriteInt32At(0, logManager.LatestLSN()); err != nil {
+		return err
+	}
+	if err := page.WriteInt32At(4, label.checkpoint); err != nil {
+		return err
+	}
+	if err := dst.Write(replicaStateBlock(), page); err != nil {
+		return err
+	}
+	return dst.Sync(ReplicaState)
+}
+
+// NewReplica starts applying the primary's log to the database of the
+// manager, which must be a recovered replica.
+func (m *Manager) NewReplica() (*Replica, error) {
+	if !m.replica {
+		return nil, ErrNotReplica
+	}
+
+	page := file.NewPage(m.fileManager.BlockSize())
+	if err := m.fileManager.Read(replicaStateBlock(), page); err != nil {
+		return nil, err
+	}
+	applied, err := page.ReadInt32At(0)
+	if err != nil {
+		return nil, err
+	}
+	resume, err := page.ReadInt32At(4)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Replica{
+		manager: m,
+		applied: applied,
+		resume:  resume,
+	}, nil
+}
+
+// AppliedLSN returns the LSN of the commit record of the last primary
+// transaction applied.
+func (r *Replica) AppliedLSN() int32 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.applied
+}
+
+// ResumeLSN returns the LSN after which the primary must ship its log.
+func (r *Replica) ResumeLSN() int32 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.resume
+}
+
+// Receive applies the log records read from rd, which the primary ships
+// from ResumeLSN on, until reading or applying them fails.
+func (r *Replica) Receive(rd io.Reader) error {
+	r.pending = make(map[int64][]Record)
+	r.starts = make(map[int64]int32)
+
+	for {
+		lsn, log, err := log.Receive(rd)
+		if err != nil {
+			return err
+		}
+		if err := r.apply(lsn, log); err != nil {
+			return err
+		}
+	}
+}
+
+func (r *Replica) apply(lsn int32, log []byte) error {
+	record, err := createLogRecord(log)
+	if err != nil || record == nil {
+		return err
+	}
+
+	txNum := record.TxNumber()
+	switch record.Operator() {
+	case Start:
+		r.starts[txNum] = lsn - 1
+	case SetInt, SetString, SetInt64, SetBytes, AppendBlock, RemoveFile:
+		r.pending[txNum] = append(r.pending[txNum], record)
+	case Rollback:
+		delete(r.pending, txNum)
+		delete(r.starts, txNum)
+	case Commit:
+		changes := r.pending[txNum]
+		delete(r.pending, txNum)
+		delete(r.starts, txNum)
+
+		// Commits up to the applied LSN are received again after a
+		// restart, but their changes are already in the database.
+		if lsn <= r.AppliedLSN() {
+			return nil
+		}
+
+		resume := lsn
+		for _, start := range r.starts {
+			resume = min(resume, start)
+		}
+
+		// The changes wait for the transactions of the replica that read
+		// them; after a timeout, they are applied again.
+		for range maxApplyAttempts {
+			err := r.commit(changes, lsn, resume)
+			if !errors.Is(err, ErrLockTimeout) {
+				return err
+			}
+		}
+		return ErrReplicaStalled
+	}
+	return nil
+}
+
+// commit applies the changes of a primary transaction that committed at the
+// LSN, in a transaction of the replica.
+func (r *Replica) commit(changes []Record, lsn int32, resume int32) error {
+	tx, err := r.manager.NewTransaction()
+	if err != nil {
+		return err
+	}
+
+	if err := r.write(tx, changes, lsn, resume); err != nil {
+		return errors.Join(err, tx.Rollback())
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.applied = lsn
+	r.resume = resume
+	return nil
+}
+
+func (r *Replica) write(tx *Transaction, changes []Record, lsn int32, resume int32) error {
+	for _, change := range changes {
+		if err := change.(update).replay(tx); err != nil {
+			return err
+		}
+	}
+
+	block := replicaStateBlock()
+	if err := tx.Pin(block); err != nil {
+		return err
+	}
+	defer tx.Unpin(block)
+
+	if err := tx.WriteInt32(block, 0, lsn, true); err != nil {
+		return err
+	}
+	return tx.WriteInt32(block, 4, resume, true)
+}
+
+// isReplica reports whether the storage holds the state of a replica. The
+// state file is looked up before it is sized, since sizing a file creates
+// it.
+func isReplica(storage file.Storage) (bool, error) {
+	filenames, err := storage.List()
+	if err != nil {
+		return false, err
+	}
+	if _, found := slices.BinarySearch(filenames, ReplicaState); !found {
+		return false, nil
+	}
+	size, err := storage.Size(ReplicaState)
+	return size > 0, err
+}
+
+func replicaStateBlock() *file.Block {
+	return file.NewBlock(ReplicaState, 0)
+}
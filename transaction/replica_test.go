@@ -0,0 +1,261 @@
+package transaction
+
+import (
+	"errors"
+	"io"
+	"net"
+	"slices"
+	"testing"
+	"time"
+
+	"simpledb/file"
+)
+
+func TestReplica(t *testing.T) {
+	block := file.NewBlock("testfile", 0)
+	block1 := file.NewBlock("testfile", 1)
+
+	primaryStorage := file.NewMemoryStorage(400)
+	primary := openBackupTestManager(t, primaryStorage)
+	if _, err := primary.NewReplica(); !errors.Is(err, ErrNotReplica) {
+		t.Errorf("got error %v starting a replica on the primary, want %v", err, ErrNotReplica)
+	}
+
+	tx1 := newBackupTestTx(t, primary)
+	writeBackupTestInt(t, tx1, block, 0, 1)
+	commitBackupTestTx(t, tx1)
+
+	// tx2 is running at the end of the backup, and commits later.
+	tx2 := newBackupTestTx(t, primary)
+	writeBackupTestInt(t, tx2, block, 4, 2)
+
+	backup := file.NewMemoryStorage(400)
+	if err := primary.Backup(backup); err != nil {
+		t.Fatalf("failed to back up: %v", err)
+	}
+	if filenames, err := backup.List(); err != nil || slices.Contains(filenames, ReplicaState) {
+		t.Errorf("got files %v, error %v in the backup of the primary, want no %s", filenames, err, ReplicaState)
+	}
+
+	replicaStorage := file.NewMemoryStorage(400)
+	if err := RestoreReplica(backup, replicaStorage); err != nil {
+		t.Fatalf("failed to restore the replica: %v", err)
+	}
+
+	// connect starts a replica on the storage and ships the primary's log to
+	// it. It returns the replica, and a function that disconnects it.
+	connect := func(t *testing.T) (*Manager, *Replica, func()) {
+		t.Helper()
+		tm := recoverBackupTestStorage(t, replicaStorage)
+		replica, err := tm.NewReplica()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		primaryConn, replicaConn := net.Pipe()
+		go primary.logManager.Ship(primaryConn, replica.ResumeLSN(), time.Millisecond)
+
+		received := make(chan error)
+		go func() {
+			received <- replica.Receive(replicaConn)
+		}()
+
+		return tm, replica, func() {
+			replicaConn.Close()
+			primaryConn.Close()
+			if err := <-received; !errors.Is(err, io.ErrClosedPipe) {
+				t.Errorf("receiving failed: %v", err)
+			}
+		}
+	}
+
+	// catchUp waits for the replica to apply the primary's last commit.
+	catchUp := func(t *testing.T, replica *Replica) {
+		t.Helper()
+		commits, err := LoggedCommits(primaryStorage, "testlogfile")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for deadline := time.Now().Add(5 * time.Second); replica.AppliedLSN() < commits[0].LSN; {
+			if time.Now().After(deadline) {
+				t.Fatalf("the replica applied LSN %d, want %d", replica.AppliedLSN(), commits[0].LSN)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	tm, replica, disconnect := connect(t)
+
+	commitBackupTestTx(t, tx2)
+
+	tx3 := newBackupTestTx(t, primary)
+	writeBackupTestInt(t, tx3, block, 8, 3)
+	if err := tx3.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+
+	// tx4 is running when the replica restarts, and commits afterwards.
+	tx4 := newBackupTestTx(t, primary)
+	writeBackupTestInt(t, tx4, block1, 0, 4)
+
+	tx5 := newBackupTestTx(t, primary)
+	writeBackupTestInt(t, tx5, block, 16, 5)
+	if _, err := tx5.Append("appended"); err != nil {
+		t.Fatal(err)
+	}
+	commitBackupTestTx(t, tx5)
+
+	catchUp(t, replica)
+	checkBackupTestValues(t, tm, []backupTestValue{
+		{"committed before the backup", block, 0, 1},
+		{"committed after the backup", block, 4, 2},
+		{"rolled back", block, 8, 0},
+		{"not committed yet", block1, 0, 0},
+		{"committed after the replica started", block, 16, 5},
+	})
+	if size, err := replicaStorage.Size("appended"); err != nil || size != 1 {
+		t.Errorf("got %d blocks appended after the replica started, error %v, want 1", size, err)
+	}
+	disconnect()
+
+	// The replica resumes where it stopped after a restart.
+	writeBackupTestInt(t, tx4, block1, 4, 4)
+	commitBackupTestTx(t, tx4)
+
+	tm, replica, disconnect = connect(t)
+	defer disconnect()
+
+	catchUp(t, replica)
+	checkBackupTestValues(t, tm, []backupTestValue{
+		{"committed before the restart", block, 16, 5},
+		{"started before the restart", block1, 0, 4},
+		{"started before the restart", block1, 4, 4},
+	})
+
+	// The file is removed and made again on the replica too.
+	tx6 := newBackupTestTx(t, primary)
+	if err := tx6.Remove("testfile"); err != nil {
+		t.Fatal(err)
+	}
+	commitBackupTestTx(t, tx6)
+
+	tx7 := newBackupTestTx(t, primary)
+	appended, err := tx7.Append("testfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeBackupTestInt(t, tx7, appended, 0, 7)
+	commitBackupTestTx(t, tx7)
+
+	catchUp(t, replica)
+	if size, err := replicaStorage.Size("testfile"); err != nil || size != 1 {
+		t.Errorf("got %d blocks in the made again file, error %v, want 1", size, err)
+	}
+	checkBackupTestValues(t, tm, []backupTestValue{
+		{"written to the made again file", block, 0, 7},
+		{"committed before the file was removed", block, 4, 0},
+	})
+
+	// Versions carry the numbers of primary transactions, which may be
+	// those of transactions running on the replica.
+	reader := newBackupTestTx(t, tm)
+	running := newBackupTestTx(t, tm)
+	if !reader.IsVisible(running.TxNum(), 0) {
+		t.Error("a version created by a primary transaction is not visible")
+	}
+	if reader.IsVisible(1, running.TxNum()) {
+		t.Error("a version deleted by a primary transaction is visible")
+	}
+	for _, tx := range []*Transaction{reader, running} {
+		if err := tx.Rollback(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestReplica_Stalled(t *testing.T) {
+	block := file.NewBlock("testfile", 0)
+
+	primaryStorage := file.NewMemoryStorage(400)
+	primary := openBackupTestManager(t, primaryStorage)
+	tx1 := newBackupTestTx(t, primary)
+	writeBackupTestInt(t, tx1, block, 0, 1)
+	commitBackupTestTx(t, tx1)
+
+	backup := file.NewMemoryStorage(400)
+	if err := primary.Backup(backup); err != nil {
+		t.Fatalf("failed to back up: %v", err)
+	}
+	replicaStorage := file.NewMemoryStorage(400)
+	if err := RestoreReplica(backup, replicaStorage); err != nil {
+		t.Fatalf("failed to restore the replica: %v", err)
+	}
+	tm := recoverBackupTestStorage(t, replicaStorage)
+	tm.lockTable.maxWait = time.Millisecond
+	replica, err := tm.NewReplica()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// receive ships the primary's log to the replica. It returns the error
+	// the replica stops with, and a function that disconnects it.
+	receive := func() (<-chan error, func()) {
+		primaryConn, replicaConn := net.Pipe()
+		go primary.logManager.Ship(primaryConn, replica.ResumeLSN(), time.Millisecond)
+		received := make(chan error, 1)
+		go func() {
+			received <- replica.Receive(replicaConn)
+		}()
+		return received, func() {
+			replicaConn.Close()
+			primaryConn.Close()
+		}
+	}
+
+	// The reader keeps its lock on the block until it commits.
+	reader := newBackupTestTx(t, tm)
+	if err := reader.Pin(block); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reader.ReadInt32(block, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	tx2 := newBackupTestTx(t, primary)
+	writeBackupTestInt(t, tx2, block, 0, 2)
+	commitBackupTestTx(t, tx2)
+
+	received, disconnect := receive()
+	select {
+	case err := <-received:
+		if !errors.Is(err, ErrReplicaStalled) {
+			t.Fatalf("got error %v receiving while a reader holds the block, want %v", err, ErrReplicaStalled)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the replica kept waiting for the reader")
+	}
+	disconnect()
+	commitBackupTestTx(t, reader)
+
+	// Once the reader is done, the replica receives the change again.
+	received, disconnect = receive()
+	defer func() {
+		disconnect()
+		if err := <-received; !errors.Is(err, io.ErrClosedPipe) {
+			t.Errorf("receiving failed: %v", err)
+		}
+	}()
+	commits, err := LoggedCommits(primaryStorage, "testlogfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); replica.AppliedLSN() < commits[0].LSN; {
+		if time.Now().After(deadline) {
+			t.Fatalf("the replica applied LSN %d, want %d", replica.AppliedLSN(), commits[0].LSN)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	checkBackupTestValues(t, tm, []backupTestValue{
+		{"committed while a reader held the block", block, 0, 2},
+	})
+}
@@ -0,0 +1,138 @@
+package transaction
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrWriteConflict is returned when a snapshot transaction tries to modify a
+// record version that another transaction has already replaced or deleted
+// since the snapshot was taken. Under first-committer-wins the later writer
+// must roll back.
+var ErrWriteConflict = errors.New("transaction: write-write conflict with a concurrent transaction")
+
+// Snapshot is the set of transactions whose effects are visible to a
+// snapshot transaction. It is fixed when the transaction starts: every
+// transaction that had committed by then is visible, and every transaction
+// that was still running, or that started later, is not.
+type Snapshot struct {
+	txNum  int64
+	high   int64              // first transaction number not yet allocated
+	active map[int64]struct{} // transactions running when the snapshot was taken
+}
+
+// Sees reports whether the effects of the specified transaction are part of
+// the snapshot. A transaction always sees its own changes.
+func (s *Snapshot) Sees(txNum int64) bool {
+	if txNum == s.txNum {
+		return true
+	}
+	if txNum >= s.high {
+		return false
+	}
+	_, running := s.active[txNum]
+	return !running
+}
+
+// versionTable allocates transaction numbers, and tracks the running
+// transactions and the snapshots they hold, which is what multi-version
+// visibility and garbage collection are decided from.
+type versionTable struct {
+	mu        sync.Mutex
+	lastTxNum int64 // last transaction number allocated
+	active    map[int64]struct{}
+	snapshots map[int64]*Snapshot
+}
+
+// newVersionTable creates a version table whose transaction numbers start
+// after lastTxNum.
+func newVersionTable(lastTxNum int64) *versionTable {
+	return &versionTable{
+		lastTxNum: lastTxNum,
+		active:    make(map[int64]struct{}),
+		snapshots: make(map[int64]*Snapshot),
+	}
+}
+
+// begin allocates a new transaction number and registers the transaction as
+// running. Allocation and registration happen atomically, so that a snapshot
+// never sees a transaction number without knowing whether it has finished.
+// If takeSnapshot is true, a snapshot is taken for the new transaction.
+func (vt *versionTable) begin(takeSnapshot bool) (int64, *Snapshot) {
+	vt.mu.Lock()
+	defer vt.mu.Unlock()
+
+	vt.lastTxNum++
+	txNum := vt.lastTxNum
+	vt.active[txNum] = struct{}{}
+
+	if !takeSnapshot {
+		return txNum, nil
+	}
+
+	snapshot := &Snapshot{
+		txNum:  txNum,
+		high:   txNum + 1,
+		active: make(map[int64]struct{}, len(vt.active)),
+	}
+	for t := range vt.active {
+		if t != txNum {
+			snapshot.active[t] = struct{}{}
+		}
+	}
+	vt.snapshots[txNum] = snapshot
+	return txNum, snapshot
+}
+
+// last returns the last transaction number allocated.
+func (vt *versionTable) last() int64 {
+	vt.mu.Lock()
+	defer vt.mu.Unlock()
+
+	return vt.lastTxNum
+}
+
+// resume registers a transaction started before a restart as running again.
+func (vt *versionTable) resume(txNum int64) {
+	vt.mu.Lock()
+	defer vt.mu.Unlock()
+
+	vt.active[txNum] = struct{}{}
+}
+
+// end marks the transaction as finished. It must only be called once the
+// transaction's commit or rollback is durable.
+func (vt *versionTable) end(txNum int64) {
+	vt.mu.Lock()
+	defer vt.mu.Unlock()
+
+	delete(vt.active, txNum)
+	delete(vt.snapshots, txNum)
+}
+
+// isActive reports whether the transaction is still running.
+func (vt *versionTable) isActive(txNum int64) bool {
+	vt.mu.Lock()
+	defer vt.mu.Unlock()
+
+	_, ok := vt.active[txNum]
+	return ok
+}
+
+// isObsolete reports whether a version deleted by the specified transaction
+// is invisible to every running and future transaction, so that its slot can
+// be reclaimed.
+func (vt *versionTable) isObsolete(deleter int64) bool {
+	vt.mu.Lock()
+	defer vt.mu.Unlock()
+
+	if _, ok := vt.active[deleter]; ok {
+		return false
+	}
+	for _, snapshot := range vt.snapshots {
+		if !snapshot.Sees(deleter) {
+			return false
+		}
+	}
+	return true
+}
@@ -1,47 +1,153 @@
 package transaction
 
 import (
+	"errors"
 	"sync"
-	"sync/atomic"
 
 	"simpledb/buffer"
 	"simpledb/file"
 	"simpledb/log"
 )
 
-var transactionNumber atomic.Int32
+// IsolationLevel determines how a transaction is isolated from concurrent
+// transactions.
+type IsolationLevel int32
+
+// All isolation levels hold exclusive locks until the transaction finishes.
+// They differ in how long shared locks are held.
+const (
+	// Serializable transactions follow strict two-phase locking: shared locks
+	// are taken on every record and block read, including the empty slots a
+	// scan goes past and the end-of-file marker of a file whose size is read,
+	// and all locks are held until commit.
+	Serializable IsolationLevel = iota
+	// SnapshotIsolation transactions read multi-version tables as of the
+	// moment they started, without taking shared locks. Writers still take
+	// exclusive locks, and conflicting writes are detected with
+	// first-committer-wins. Other tables are read as under Serializable.
+	SnapshotIsolation
+	// ReadUncommitted transactions take no shared locks, and so can read
+	// changes of transactions that have not committed (dirty reads).
+	ReadUncommitted
+	// ReadCommitted transactions release a shared lock as soon as the read
+	// it protects is done. A value read twice can change in between
+	// (non-repeatable reads).
+	ReadCommitted
+	// RepeatableRead transactions hold shared locks on records until commit,
+	// but not on end-of-file markers, so records appended by other
+	// transactions can appear between two scans (phantoms).
+	RepeatableRead
+)
+
+// Option configures a transaction created by Manager.NewTransaction.
+type Option func(*Transaction)
+
+// WithIsolationLevel sets the isolation level of the transaction.
+func WithIsolationLevel(level IsolationLevel) Option {
+	return func(tx *Transaction) {
+		tx.isolationLevel = level
+	}
+}
+
+// WithEscalationThreshold sets the number of block and record locks the
+// transaction may hold on one file before they are escalated to a single
+// lock on the file. A threshold of zero or less disables escalation.
+func WithEscalationThreshold(n int) Option {
+	return func(tx *Transaction) {
+		tx.escalationThreshold = n
+	}
+}
+
+// ReadOnly makes the transaction read-only. A read-only transaction writes
+// no log records and flushes no buffers when it finishes, and it takes no
+// exclusive locks. Whether it holds shared locks still depends on its
+// isolation level: under SnapshotIsolation it reads multi-version tables
+// without any locks, and under ReadCommitted it releases them after each read.
+func ReadOnly() Option {
+	return func(tx *Transaction) {
+		tx.readOnly = true
+	}
+}
+
+// ErrReadOnlyTransaction is returned when a read-only transaction attempts
+// to modify the database.
+var ErrReadOnlyTransaction = errors.New("transaction: cannot write in a read-only transaction")
+
+// ErrTransactionPrepared is returned when a prepared transaction attempts to
+// modify the database, or to finish other than through the manager's
+// CommitPrepared or RollbackPrepared.
+var ErrTransactionPrepared = errors.New("transaction: transaction is prepared")
+
+// ErrFileRemoved is returned when a transaction appends to a file it
+// removed. The file keeps its blocks until the transaction commits, so it
+// cannot be made again before then.
+var ErrFileRemoved = errors.New("transaction: file is removed by the transaction")
 
 type Transaction struct {
-	mu                 sync.Mutex
-	txNum              int32
-	nextTxNum          int32
-	fileManager        *file.Manager
-	logManager         *log.Manager
-	bufferManager      *buffer.Manager
-	recoveryManager    *RecoveryManager
-	concurrencyManager *ConcurrencyManager
-	bufferList         *BufferList
-}
-
-func NewTransaction(fileManager *file.Manager, logManager *log.Manager, bufferManager *buffer.Manager) (*Transaction, error) {
+	mu                  sync.Mutex
+	txNum               int64
+	isolationLevel      IsolationLevel
+	escalationThreshold int
+	readOnly            bool
+	gid                 string // global transaction id, once prepared
+	snapshot            *Snapshot
+	fileManager         file.Storage
+	logManager          *log.Manager
+	bufferManager       *buffer.Manager
+	manager             *Manager
+	recoveryManager     *RecoveryManager
+	concurrencyManager  *ConcurrencyManager
+	bufferList          *BufferList
+
+	// truncates holds the sizes the files are cut to at commit.
+	truncates map[string]int32
+	// removes holds the files deleted at commit.
+	removes map[string]struct{}
+
+	beforeCommit []func() error
+	onCommit     []func()
+	onRollback   []func()
+}
+
+// NewTransaction starts a transaction on the database instance. Its number
+// is allocated from the manager, and its locks are taken in the manager's
+// lock table.
+func (m *Manager) NewTransaction(opts ...Option) (*Transaction, error) {
 	tx := &Transaction{
-		fileManager:   fileManager,
-		logManager:    logManager,
-		bufferManager: bufferManager,
+		escalationThreshold: defaultEscalationThreshold,
+		fileManager:         m.fileManager,
+		logManager:          m.logManager,
+		bufferManager:       m.bufferManager,
+		manager:             m,
+	}
+	for _, opt := range opts {
+		opt(tx)
 	}
 
-	txNum := transactionNumber.Add(1)
-	recoveryManager, err := NewRecoveryManager(logManager, bufferManager, tx, txNum)
-	if err != nil {
-		return nil, err
+	txNum, snapshot := tx.manager.versions.begin(tx.isolationLevel == SnapshotIsolation)
+
+	// A read-only transaction has nothing to recover, so it needs no
+	// recovery manager and its start is not logged.
+	var recoveryManager *RecoveryManager
+	if !tx.readOnly {
+		m.startLogging(txNum, m.logManager.LatestLSN())
+
+		var err error
+		recoveryManager, err = NewRecoveryManager(m.logManager, m.bufferManager, tx, txNum)
+		if err != nil {
+			m.stopLogging(txNum)
+			tx.manager.versions.end(txNum)
+			return nil, err
+		}
 	}
 
-	lockTable := NewLockTable()
-	concurrencyManager := NewConcurrencyManager(lockTable)
+	concurrencyManager := NewConcurrencyManager(m.lockTable, tx.isolationLevel, txNum)
+	concurrencyManager.escalationThreshold = tx.escalationThreshold
 
-	bufferList := NewBufferList(bufferManager)
+	bufferList := NewBufferList(m.bufferManager)
 
 	tx.txNum = txNum
+	tx.snapshot = snapshot
 	tx.recoveryManager = recoveryManager
 	tx.concurrencyManager = concurrencyManager
 	tx.bufferList = bufferList
@@ -49,35 +155,190 @@ func NewTransaction(fileManager *file.Manager, logManager *log.Manager, bufferMa
 	return tx, nil
 }
 
+// Commit commits the transaction. The BeforeCommit hooks are run first, and
+// if one of them fails, the transaction is rolled back instead and the hook's
+// error is returned.
 func (tx *Transaction) Commit() error {
-	if err := tx.recoveryManager.Commit(); err != nil {
+	if tx.gid != "" {
+		return ErrTransactionPrepared
+	}
+	if err := tx.runBeforeCommit(); err != nil {
+		return errors.Join(err, tx.rollback())
+	}
+	return tx.commit()
+}
+
+func (tx *Transaction) Rollback() error {
+	if tx.gid != "" {
+		return ErrTransactionPrepared
+	}
+	return tx.rollback()
+}
+
+// Prepare is the first phase of a two-phase commit coordinated outside the
+// database. It makes the transaction's changes durable together with a
+// prepare record carrying the global transaction id gid, after which the
+// transaction can no longer fail to commit. The transaction keeps its locks,
+// even across a restart, until the coordinator finishes it with the
+// manager's CommitPrepared or RollbackPrepared.
+func (tx *Transaction) Prepare(gid string) error {
+	if err := tx.checkWritable(); err != nil {
 		return err
 	}
+	if gid == "" {
+		return ErrInvalidGlobalID
+	}
 
-	tx.concurrencyManager.Release()
+	// Once prepared, the transaction must be able to commit, so it is
+	// validated now rather than when the coordinator commits it.
+	if err := tx.runBeforeCommit(); err != nil {
+		return err
+	}
+
+	if err := tx.manager.reservePrepared(gid, tx); err != nil {
+		return err
+	}
+	if err := tx.recoveryManager.Prepare(gid); err != nil {
+		tx.manager.forgetPrepared(gid)
+		return err
+	}
+
+	tx.gid = gid
+	return nil
+}
+
+// BeforeCommit registers a hook that validates the transaction before it
+// commits, or before it is prepared. Hooks run in the order they were
+// registered, and the first error aborts the commit. A hook can still read
+// and write through the transaction.
+func (tx *Transaction) BeforeCommit(hook func() error) {
+	tx.beforeCommit = append(tx.beforeCommit, hook)
+}
+
+// OnCommit registers a hook to run once the transaction has committed and
+// released its locks. Hooks run in the order they were registered.
+func (tx *Transaction) OnCommit(hook func()) {
+	tx.onCommit = append(tx.onCommit, hook)
+}
+
+// OnRollback registers a hook to run once the transaction has rolled back
+// and released its locks, including when a BeforeCommit hook aborted its
+// commit. Hooks run in the order they were registered.
+func (tx *Transaction) OnRollback(hook func()) {
+	tx.onRollback = append(tx.onRollback, hook)
+}
+
+func (tx *Transaction) runBeforeCommit() error {
+	for _, hook := range tx.beforeCommit {
+		if err := hook(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (tx *Transaction) commit() error {
+	if !tx.readOnly {
+		if err := tx.recoveryManager.Commit(); err != nil {
+			return err
+		}
+		tx.manager.stopLogging(tx.txNum)
+	}
+
+	tx.manager.versions.end(tx.txNum)
 	tx.bufferList.UnpinAll()
+	// The files are cut and removed while they are still locked, so that no
+	// other transaction can have appended to them.
+	err := tx.truncateFiles()
+	if err == nil {
+		err = tx.removeFiles()
+	}
+	tx.concurrencyManager.Release()
+
+	for _, hook := range tx.onCommit {
+		hook()
+	}
+	return err
+}
+
+// truncateFiles cuts the files the transaction truncated. The blocks cut
+// off are discarded from the buffer pool first, so that flushing them
+// cannot extend the files again.
+func (tx *Transaction) truncateFiles() error {
+	for filename, size := range tx.truncates {
+		current, err := tx.fileManager.Size(filename)
+		if err != nil {
+			return err
+		}
+		if current <= size {
+			continue
+		}
+		if err := tx.bufferManager.Discard(filename, size); err != nil {
+			return err
+		}
+		if err := tx.fileManager.Truncate(filename, size); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-func (tx *Transaction) Rollback() error {
-	if err := tx.recoveryManager.Recover(); err != nil {
+// removeFiles deletes the files the transaction removed.
+func (tx *Transaction) removeFiles() error {
+	for filename := range tx.removes {
+		if err := tx.removeFile(filename); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeFile deletes the file, discarding its blocks from the buffer pool
+// first.
+func (tx *Transaction) removeFile(filename string) error {
+	if err := tx.bufferManager.Discard(filename, 0); err != nil {
 		return err
 	}
+	return tx.fileManager.Remove(filename)
+}
+
+func (tx *Transaction) rollback() error {
+	if !tx.readOnly {
+		if err := tx.recoveryManager.Rollback(); err != nil {
+			return err
+		}
+		tx.manager.stopLogging(tx.txNum)
+	}
 
+	tx.manager.versions.end(tx.txNum)
 	tx.concurrencyManager.Release()
 	tx.bufferList.UnpinAll()
+
+	for _, hook := range tx.onRollback {
+		hook()
+	}
 	return nil
 }
 
 func (tx *Transaction) Recover() error {
+	if err := tx.checkWritable(); err != nil {
+		return err
+	}
+
 	if err := tx.bufferManager.FlushAll(tx.txNum); err != nil {
 		return err
 	}
 
-	if err := tx.recoveryManager.Recover(); err != nil {
+	inDoubt, err := tx.recoveryManager.Recover()
+	if err != nil {
 		return err
 	}
 
+	for _, prepared := range inDoubt {
+		if err := tx.manager.resumePrepared(prepared); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -94,9 +355,9 @@ func (tx *Transaction) ReadInt32(block *file.Block, offset int32) (int32, error)
 	if err != nil {
 		return 0, err
 	}
+	defer tx.concurrencyManager.ReadDone(block)
 
-	buf := tx.bufferList.GetBuffer(block)
-	return buf.Contents().ReadInt32At(offset)
+	return tx.readInt32(block, offset)
 }
 
 func (tx *Transaction) ReadString(block *file.Block, offset int32) (string, error) {
@@ -104,17 +365,231 @@ func (tx *Transaction) ReadString(block *file.Block, offset int32) (string, erro
 	if err != nil {
 		return "", err
 	}
+	defer tx.concurrencyManager.ReadDone(block)
+
+	return tx.readString(block, offset)
+}
+
+// ReadInt64 is the int64 counterpart of ReadInt32.
+func (tx *Transaction) ReadInt64(block *file.Block, offset int32) (int64, error) {
+	err := tx.concurrencyManager.SLock(block)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.concurrencyManager.ReadDone(block)
+
+	return tx.readInt64(block, offset)
+}
+
+// ReadBytes reads the n bytes of the block at the offset.
+func (tx *Transaction) ReadBytes(block *file.Block, offset int32, n int32) ([]byte, error) {
+	err := tx.concurrencyManager.SLock(block)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.concurrencyManager.ReadDone(block)
 
 	buf := tx.bufferList.GetBuffer(block)
-	return buf.Contents().ReadStringAt(offset)
+	buf.Latch()
+	defer buf.Unlatch()
+	return buf.Contents().ReadRawAt(offset, n)
+}
+
+// ReadRecordInt32 reads an int32 that belongs to the record in the specified
+// slot of the block. Only the record is locked, so that other transactions
+// can concurrently access the other records of the block.
+func (tx *Transaction) ReadRecordInt32(block *file.Block, slot int32, offset int32) (int32, error) {
+	err := tx.concurrencyManager.SLockRecord(block, slot)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.concurrencyManager.RecordReadDone(block, slot)
+
+	return tx.readInt32(block, offset)
+}
+
+// ReadRecordInt64 is the int64 counterpart of ReadRecordInt32.
+func (tx *Transaction) ReadRecordInt64(block *file.Block, slot int32, offset int32) (int64, error) {
+	err := tx.concurrencyManager.SLockRecord(block, slot)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.concurrencyManager.RecordReadDone(block, slot)
+
+	return tx.readInt64(block, offset)
+}
+
+// ReadRecordString is the string counterpart of ReadRecordInt32.
+func (tx *Transaction) ReadRecordString(block *file.Block, slot int32, offset int32) (string, error) {
+	err := tx.concurrencyManager.SLockRecord(block, slot)
+	if err != nil {
+		return "", err
+	}
+	defer tx.concurrencyManager.RecordReadDone(block, slot)
+
+	return tx.readString(block, offset)
+}
+
+// SnapshotReadInt32 reads an int32 from a record of a multi-version table.
+// A snapshot transaction reads it without taking a shared lock, since which
+// versions it sees is decided by its snapshot rather than by locking. Other
+// transactions fall back to ReadRecordInt32.
+func (tx *Transaction) SnapshotReadInt32(block *file.Block, slot int32, offset int32) (int32, error) {
+	if tx.snapshot == nil {
+		return tx.ReadRecordInt32(block, slot, offset)
+	}
+	return tx.readInt32(block, offset)
+}
+
+// SnapshotReadInt64 is the int64 counterpart of SnapshotReadInt32.
+func (tx *Transaction) SnapshotReadInt64(block *file.Block, slot int32, offset int32) (int64, error) {
+	if tx.snapshot == nil {
+		return tx.ReadRecordInt64(block, slot, offset)
+	}
+	return tx.readInt64(block, offset)
+}
+
+// SnapshotReadString is the string counterpart of SnapshotReadInt32.
+func (tx *Transaction) SnapshotReadString(block *file.Block, slot int32, offset int32) (string, error) {
+	if tx.snapshot == nil {
+		return tx.ReadRecordString(block, slot, offset)
+	}
+	return tx.readString(block, offset)
+}
+
+// PeekInt32 reads an int32 without taking any lock. The value may be changed
+// by another transaction as soon as it is returned, so it is only a hint that
+// the caller must check again once it holds the appropriate lock.
+func (tx *Transaction) PeekInt32(block *file.Block, offset int32) (int32, error) {
+	return tx.readInt32(block, offset)
+}
+
+// PeekInt64 is the int64 counterpart of PeekInt32.
+func (tx *Transaction) PeekInt64(block *file.Block, offset int32) (int64, error) {
+	return tx.readInt64(block, offset)
 }
 
 func (tx *Transaction) WriteInt32(block *file.Block, offset int32, val int32, log bool) error {
+	if err := tx.checkWritable(); err != nil {
+		return err
+	}
+
+	if err := tx.concurrencyManager.XLock(block); err != nil {
+		return err
+	}
+	return tx.writeInt32(block, offset, val, log)
+}
+
+func (tx *Transaction) WriteInt64(block *file.Block, offset int32, val int64, log bool) error {
+	if err := tx.checkWritable(); err != nil {
+		return err
+	}
+
 	if err := tx.concurrencyManager.XLock(block); err != nil {
 		return err
 	}
+	return tx.writeInt64(block, offset, val, log)
+}
+
+func (tx *Transaction) WriteString(block *file.Block, offset int32, val string, log bool) error {
+	if err := tx.checkWritable(); err != nil {
+		return err
+	}
+
+	if err := tx.concurrencyManager.XLock(block); err != nil {
+		return err
+	}
+	return tx.writeString(block, offset, val, log)
+}
+
+// WriteBytes overwrites the bytes of the block at the offset with val. The
+// bytes overwritten are logged exactly, so that undo restores them even if
+// they were not a value of their own.
+func (tx *Transaction) WriteBytes(block *file.Block, offset int32, val []byte, log bool) error {
+	if err := tx.checkWritable(); err != nil {
+		return err
+	}
+
+	if err := tx.concurrencyManager.XLock(block); err != nil {
+		return err
+	}
+	return tx.writeBytes(block, offset, val, log)
+}
+
+// WriteRecordInt32 writes an int32 that belongs to the record in the
+// specified slot of the block, under an exclusive lock on the record only.
+func (tx *Transaction) WriteRecordInt32(block *file.Block, slot int32, offset int32, val int32, log bool) error {
+	if err := tx.XLockRecord(block, slot); err != nil {
+		return err
+	}
+	return tx.writeInt32(block, offset, val, log)
+}
+
+// WriteRecordInt64 is the int64 counterpart of WriteRecordInt32.
+func (tx *Transaction) WriteRecordInt64(block *file.Block, slot int32, offset int32, val int64, log bool) error {
+	if err := tx.XLockRecord(block, slot); err != nil {
+		return err
+	}
+	return tx.writeInt64(block, offset, val, log)
+}
+
+// WriteRecordString is the string counterpart of WriteRecordInt32.
+func (tx *Transaction) WriteRecordString(block *file.Block, slot int32, offset int32, val string, log bool) error {
+	if err := tx.XLockRecord(block, slot); err != nil {
+		return err
+	}
+	return tx.writeString(block, offset, val, log)
+}
+
+// XLockRecord locks the record in the specified slot of the block
+// exclusively, until the transaction finishes.
+func (tx *Transaction) XLockRecord(block *file.Block, slot int32) error {
+	if err := tx.checkWritable(); err != nil {
+		return err
+	}
+	return tx.concurrencyManager.XLockRecord(block, slot)
+}
+
+// TryXLockRecord is like XLockRecord, but reports false instead of waiting
+// if another transaction holds a lock on the record.
+func (tx *Transaction) TryXLockRecord(block *file.Block, slot int32) (bool, error) {
+	if err := tx.checkWritable(); err != nil {
+		return false, err
+	}
+	return tx.concurrencyManager.TryXLockRecord(block, slot)
+}
+
+// readInt32 reads an int32 from a pinned block under the buffer latch. The
+// caller is responsible for locking.
+func (tx *Transaction) readInt32(block *file.Block, offset int32) (int32, error) {
+	buf := tx.bufferList.GetBuffer(block)
+	buf.Latch()
+	defer buf.Unlatch()
+	return buf.Contents().ReadInt32At(offset)
+}
+
+func (tx *Transaction) readInt64(block *file.Block, offset int32) (int64, error) {
+	buf := tx.bufferList.GetBuffer(block)
+	buf.Latch()
+	defer buf.Unlatch()
+	return buf.Contents().ReadInt64At(offset)
+}
 
+func (tx *Transaction) readString(block *file.Block, offset int32) (string, error) {
 	buf := tx.bufferList.GetBuffer(block)
+	buf.Latch()
+	defer buf.Unlatch()
+	return buf.Contents().ReadStringAt(offset)
+}
+
+// writeInt32 writes an int32 to a pinned block under the buffer latch,
+// logging the old value first if log is true. The caller is responsible for
+// locking.
+func (tx *Transaction) writeInt32(block *file.Block, offset int32, val int32, log bool) error {
+	buf := tx.bufferList.GetBuffer(block)
+	buf.Latch()
+	defer buf.Unlatch()
+
 	lsn := int32(-1)
 	if log {
 		var err error
@@ -132,12 +607,33 @@ func (tx *Transaction) WriteInt32(block *file.Block, offset int32, val int32, lo
 	return nil
 }
 
-func (tx *Transaction) WriteString(block *file.Block, offset int32, val string, log bool) error {
-	if err := tx.concurrencyManager.XLock(block); err != nil {
+func (tx *Transaction) writeInt64(block *file.Block, offset int32, val int64, log bool) error {
+	buf := tx.bufferList.GetBuffer(block)
+	buf.Latch()
+	defer buf.Unlatch()
+
+	lsn := int32(-1)
+	if log {
+		var err error
+		lsn, err = tx.recoveryManager.SetInt64(buf, offset, val)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := buf.Contents().WriteInt64At(offset, val); err != nil {
 		return err
 	}
 
+	buf.SetModified(tx.txNum, lsn)
+	return nil
+}
+
+func (tx *Transaction) writeString(block *file.Block, offset int32, val string, log bool) error {
 	buf := tx.bufferList.GetBuffer(block)
+	buf.Latch()
+	defer buf.Unlatch()
+
 	lsn := int32(-1)
 	if log {
 		var err error
@@ -155,20 +651,240 @@ func (tx *Transaction) WriteString(block *file.Block, offset int32, val string,
 	return nil
 }
 
+// writeBytes is the byte counterpart of writeInt32. Runs of bytes too long
+// for one log record are logged in pieces.
+func (tx *Transaction) writeBytes(block *file.Block, offset int32, val []byte, log bool) error {
+	buf := tx.bufferList.GetBuffer(block)
+	buf.Latch()
+	defer buf.Unlatch()
+
+	lsn := int32(-1)
+	if log {
+		chunk := maxSetBytes(tx.fileManager.BlockSize(), block)
+		for start := 0; start < len(val); start += int(chunk) {
+			end := min(start+int(chunk), len(val))
+			var err error
+			lsn, err = tx.recoveryManager.SetBytes(buf, offset+int32(start), val[start:end])
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := buf.Contents().WriteRawAt(offset, val); err != nil {
+		return err
+	}
+
+	buf.SetModified(tx.txNum, lsn)
+	return nil
+}
+
 func (tx *Transaction) Size(filename string) (int32, error) {
-	dummyBlock := file.NewBlock(filename, -1)
+	dummyBlock := file.NewBlock(filename, endOfFile)
 	if err := tx.concurrencyManager.SLock(dummyBlock); err != nil {
 		return 0, err
 	}
+	defer tx.concurrencyManager.ReadDone(dummyBlock)
+	return tx.fileManager.Size(filename)
+}
+
+// PeekSize returns the number of blocks of the file without locking its
+// end, so that it does not wait for transactions appending to the file.
+// Like the values read by PeekInt32, it is only a hint.
+func (tx *Transaction) PeekSize(filename string) (int32, error) {
+	return tx.fileManager.Size(filename)
+}
+
+// SnapshotSize returns the number of blocks of a multi-version table.
+// A snapshot transaction does not lock the end of the file, since records
+// inserted by concurrent transactions are invisible to it anyway. Other
+// transactions fall back to Size.
+func (tx *Transaction) SnapshotSize(filename string) (int32, error) {
+	if tx.snapshot == nil {
+		return tx.Size(filename)
+	}
 	return tx.fileManager.Size(filename)
 }
 
+// LockFile locks a whole file in the specified mode, which is held until
+// the transaction finishes. Locking a table with S or X spares the
+// transaction from locking each of its blocks, and SIX lets it read the
+// whole table while updating some of its blocks.
+func (tx *Transaction) LockFile(filename string, mode LockMode) error {
+	return tx.lock(FileResource(filename), mode)
+}
+
+// LockBlock locks a block in the specified mode, which is held until the
+// transaction finishes. Locking a block in X before reading a value spares
+// the transaction from upgrading its lock when it then writes the value.
+func (tx *Transaction) LockBlock(block *file.Block, mode LockMode) error {
+	return tx.lock(BlockResource(block), mode)
+}
+
+// LockDatabase locks the whole database in the specified mode, which is
+// held until the transaction finishes.
+func (tx *Transaction) LockDatabase(mode LockMode) error {
+	return tx.lock(DatabaseResource(), mode)
+}
+
+func (tx *Transaction) lock(resource Resource, mode LockMode) error {
+	if tx.readOnly && mode != IS && mode != S {
+		return ErrReadOnlyTransaction
+	}
+	return tx.concurrencyManager.Lock(resource, mode)
+}
+
+// Truncate cuts the file to the specified number of blocks once the
+// transaction commits, and locks the file in X until then. Since cutting
+// the file cannot be undone, nothing happens if the transaction rolls back,
+// and after a crash the file may keep its blocks, so they must not hold
+// anything the database still needs, such as used records. Blocks the
+// transaction appends afterwards are kept.
+func (tx *Transaction) Truncate(filename string, size int32) error {
+	if err := tx.checkWritable(); err != nil {
+		return err
+	}
+	if err := tx.LockFile(filename, X); err != nil {
+		return err
+	}
+	if tx.truncates == nil {
+		tx.truncates = make(map[string]int32)
+	}
+	if current, ok := tx.truncates[filename]; !ok || size < current {
+		tx.truncates[filename] = size
+	}
+	return nil
+}
+
+// Remove deletes the file once the transaction commits, and locks the file
+// in X until then. As with Truncate, nothing happens if the transaction
+// rolls back. The removal is logged, so that recovery deletes the file if
+// a crash left it behind, and restoring a backup and a replica remove it
+// too. The transaction cannot append to the file afterwards.
+func (tx *Transaction) Remove(filename string) error {
+	if err := tx.checkWritable(); err != nil {
+		return err
+	}
+	if err := tx.LockFile(filename, X); err != nil {
+		return err
+	}
+	if _, ok := tx.removes[filename]; ok {
+		return nil
+	}
+	if _, err := tx.recoveryManager.Remove(filename); err != nil {
+		return err
+	}
+	if tx.removes == nil {
+		tx.removes = make(map[string]struct{})
+	}
+	tx.removes[filename] = struct{}{}
+	return nil
+}
+
+// Removing reports whether the transaction removed the file, which is then
+// deleted when it commits.
+func (tx *Transaction) Removing(filename string) bool {
+	_, ok := tx.removes[filename]
+	return ok
+}
+
+// Append adds an empty block to the end of the file. The new block is
+// logged, so that restoring a backup empties it again. It fails with
+// ErrFileRemoved if the transaction removed the file.
 func (tx *Transaction) Append(filename string) (*file.Block, error) {
-	dummyBlock := file.NewBlock(filename, -1)
+	if err := tx.checkWritable(); err != nil {
+		return nil, err
+	}
+	if tx.Removing(filename) {
+		return nil, ErrFileRemoved
+	}
+
+	dummyBlock := file.NewBlock(filename, endOfFile)
 	if err := tx.concurrencyManager.XLock(dummyBlock); err != nil {
 		return nil, err
 	}
-	return tx.fileManager.Append(filename)
+	block, err := tx.fileManager.Append(filename)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.recoveryManager.Append(block); err != nil {
+		return nil, err
+	}
+	if size, ok := tx.truncates[filename]; ok && block.Number() >= size {
+		tx.truncates[filename] = block.Number() + 1
+	}
+	return block, nil
+}
+
+// extend appends empty blocks to the file until it holds the block, without
+// locking or logging them, for recovery.
+func (tx *Transaction) extend(block *file.Block) error {
+	for {
+		size, err := tx.fileManager.Size(block.Filename())
+		if err != nil {
+			return err
+		}
+		if size > block.Number() {
+			return nil
+		}
+		if _, err := tx.fileManager.Append(block.Filename()); err != nil {
+			return err
+		}
+	}
+}
+
+// TxNum returns the transaction number.
+func (tx *Transaction) TxNum() int64 {
+	return tx.txNum
+}
+
+// checkWritable returns the error a write by the transaction fails with, if
+// any.
+func (tx *Transaction) checkWritable() error {
+	if tx.readOnly {
+		return ErrReadOnlyTransaction
+	}
+	if tx.gid != "" {
+		return ErrTransactionPrepared
+	}
+	return nil
+}
+
+// IsReadOnly reports whether the transaction is read-only.
+func (tx *Transaction) IsReadOnly() bool {
+	return tx.readOnly
+}
+
+// IsolationLevel returns the isolation level of the transaction.
+func (tx *Transaction) IsolationLevel() IsolationLevel {
+	return tx.isolationLevel
+}
+
+// IsVisible reports whether a record version created by xmin and deleted by
+// xmax (0 if it has not been deleted) is visible to the transaction.
+// A snapshot transaction decides using its snapshot. Any other transaction
+// sees the latest version: its locks guarantee that versions written by
+// other transactions have been committed.
+func (tx *Transaction) IsVisible(xmin int64, xmax int64) bool {
+	return tx.sees(xmin) && (xmax == 0 || !tx.sees(xmax))
+}
+
+// IsObsolete reports whether a record version deleted by xmax can no longer
+// be seen by any transaction, so that it can be garbage collected.
+func (tx *Transaction) IsObsolete(xmax int64) bool {
+	return xmax != 0 && tx.manager.versions.isObsolete(xmax)
+}
+
+func (tx *Transaction) sees(txNum int64) bool {
+	// A replica only applies the changes of committed primary
+	// transactions, whose numbers mean nothing to its own transactions.
+	if tx.manager.replica {
+		return true
+	}
+	if tx.snapshot != nil {
+		return tx.snapshot.Sees(txNum)
+	}
+	return txNum == tx.txNum || !tx.manager.versions.isActive(txNum)
 }
 
 func (tx *Transaction) BlockSize() int32 {
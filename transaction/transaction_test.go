@@ -1,7 +1,10 @@
 package transaction
 
 import (
+	"errors"
+	"slices"
 	"testing"
+	"time"
 
 	"simpledb/buffer"
 	"simpledb/file"
@@ -9,12 +12,7 @@ import (
 )
 
 func TestTransaction(t *testing.T) {
-	dir := t.TempDir()
-
-	fm, err := file.NewManager(dir, 400)
-	if err != nil {
-		t.Fatalf("failed to create file manager: %v", err)
-	}
+	fm := file.NewMemoryStorage(400)
 
 	lm, err := log.NewManager(fm, "testlogfile")
 	if err != nil {
@@ -23,7 +21,12 @@ func TestTransaction(t *testing.T) {
 
 	bm := buffer.NewManager(fm, lm, 8)
 
-	tx1, err := NewTransaction(fm, lm, bm)
+	tm, err := NewManager(fm, lm, bm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tx1, err := tm.NewTransaction()
 	if err != nil {
 		t.Fatalf("tx1: failed to create transaction: %v", err)
 	}
@@ -46,7 +49,7 @@ func TestTransaction(t *testing.T) {
 		t.Fatalf("tx1: failed to commit: %v", err)
 	}
 
-	tx2, err := NewTransaction(fm, lm, bm)
+	tx2, err := tm.NewTransaction()
 	if err != nil {
 		t.Fatalf("tx2: failed to create transaction: %v", err)
 	}
@@ -83,7 +86,7 @@ func TestTransaction(t *testing.T) {
 		t.Fatalf("tx2: failed to commit: %v", err)
 	}
 
-	tx3, err := NewTransaction(fm, lm, bm)
+	tx3, err := tm.NewTransaction()
 	if err != nil {
 		t.Fatalf("tx3: failed to create transaction: %v", err)
 	}
@@ -113,7 +116,7 @@ func TestTransaction(t *testing.T) {
 		t.Fatalf("tx3: failed to rollback: %v", err)
 	}
 
-	tx4, err := NewTransaction(fm, lm, bm)
+	tx4, err := tm.NewTransaction()
 	if err != nil {
 		t.Fatalf("tx4: failed to create transaction: %v", err)
 	}
@@ -143,3 +146,555 @@ func TestTransaction(t *testing.T) {
 		t.Fatalf("tx4: failed to commit: %v", err)
 	}
 }
+
+func TestTransaction_ReadOnly(t *testing.T) {
+	fm := file.NewMemoryStorage(400)
+
+	lm, err := log.NewManager(fm, "testlogfile")
+	if err != nil {
+		t.Fatalf("failed to create log manager: %v", err)
+	}
+
+	bm := buffer.NewManager(fm, lm, 8)
+
+	tm, err := NewManager(fm, lm, bm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	countLogRecords := func() int {
+		iter, err := lm.Iterator()
+		if err != nil {
+			t.Fatalf("failed to create log iterator: %v", err)
+		}
+		n := 0
+		for iter.HasNext() {
+			if _, err := iter.Next(); err != nil {
+				t.Fatalf("failed to read log record: %v", err)
+			}
+			n++
+		}
+		return n
+	}
+
+	block := file.NewBlock("testfile", 1)
+
+	tx1, err := tm.NewTransaction()
+	if err != nil {
+		t.Fatalf("tx1: failed to create transaction: %v", err)
+	}
+	if err := tx1.Pin(block); err != nil {
+		t.Fatalf("tx1: failed to pin block: %v", err)
+	}
+	if err := tx1.WriteInt32(block, 80, 1, true); err != nil {
+		t.Fatalf("tx1: failed to write int32: %v", err)
+	}
+	if err := tx1.Commit(); err != nil {
+		t.Fatalf("tx1: failed to commit: %v", err)
+	}
+
+	before := countLogRecords()
+
+	tx2, err := tm.NewTransaction(ReadOnly())
+	if err != nil {
+		t.Fatalf("tx2: failed to create transaction: %v", err)
+	}
+	if err := tx2.Pin(block); err != nil {
+		t.Fatalf("tx2: failed to pin block: %v", err)
+	}
+
+	intVal, err := tx2.ReadInt32(block, 80)
+	if err != nil {
+		t.Fatalf("tx2: failed to read int32: %v", err)
+	}
+	if intVal != 1 {
+		t.Errorf("Expected intVal to be 1, got %d", intVal)
+	}
+
+	if err := tx2.WriteInt32(block, 80, 2, true); !errors.Is(err, ErrReadOnlyTransaction) {
+		t.Errorf("WriteInt32: got error %v, want %v", err, ErrReadOnlyTransaction)
+	}
+	if err := tx2.WriteString(block, 40, "two", true); !errors.Is(err, ErrReadOnlyTransaction) {
+		t.Errorf("WriteString: got error %v, want %v", err, ErrReadOnlyTransaction)
+	}
+	if _, err := tx2.Append("testfile"); !errors.Is(err, ErrReadOnlyTransaction) {
+		t.Errorf("Append: got error %v, want %v", err, ErrReadOnlyTransaction)
+	}
+
+	if err := tx2.Commit(); err != nil {
+		t.Fatalf("tx2: failed to commit: %v", err)
+	}
+
+	if after := countLogRecords(); after != before {
+		t.Errorf("read-only transaction wrote %d log records, want 0", after-before)
+	}
+}
+
+func TestTransaction_TwoPhaseCommit(t *testing.T) {
+	fm := file.NewMemoryStorage(400)
+
+	open := func(t *testing.T) *Manager {
+		t.Helper()
+		lm, err := log.NewManager(fm, "testlogfile")
+		if err != nil {
+			t.Fatalf("failed to create log manager: %v", err)
+		}
+		tm, err := NewManager(fm, lm, buffer.NewManager(fm, lm, 8))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tm
+	}
+
+	block1 := file.NewBlock("testfile", 1)
+	block2 := file.NewBlock("testfile", 2)
+
+	write := func(t *testing.T, tx *Transaction, block *file.Block, val int32) {
+		t.Helper()
+		if err := tx.Pin(block); err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.WriteInt32(block, 0, val, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// read reads in the background, since it waits for the locks of the
+	// prepared transactions.
+	read := func(tm *Manager, block *file.Block) <-chan int32 {
+		values := make(chan int32, 1)
+		go func() {
+			tx, err := tm.NewTransaction()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer tx.Commit()
+			if err := tx.Pin(block); err != nil {
+				t.Error(err)
+				return
+			}
+			val, err := tx.ReadInt32(block, 0)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			values <- val
+		}()
+		return values
+	}
+
+	tm := open(t)
+	tx, err := tm.NewTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	write(t, tx, block1, 1)
+	write(t, tx, block2, 1)
+	if _, err := tx.Append("removed"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	tx1, err := tm.NewTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	write(t, tx1, block1, 10)
+	if err := tx1.Remove("removed"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx1.Prepare("g1"); err != nil {
+		t.Fatalf("tx1: failed to prepare: %v", err)
+	}
+	if err := tx1.Commit(); !errors.Is(err, ErrTransactionPrepared) {
+		t.Errorf("Commit of a prepared transaction: got error %v, want %v", err, ErrTransactionPrepared)
+	}
+	if err := tx1.WriteInt32(block1, 0, 11, true); !errors.Is(err, ErrTransactionPrepared) {
+		t.Errorf("WriteInt32 of a prepared transaction: got error %v, want %v", err, ErrTransactionPrepared)
+	}
+
+	tx2, err := tm.NewTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx2.Prepare("g1"); !errors.Is(err, ErrDuplicateGlobalID) {
+		t.Errorf("Prepare with a global id in use: got error %v, want %v", err, ErrDuplicateGlobalID)
+	}
+	write(t, tx2, block2, 20)
+	if err := tx2.Prepare("g2"); err != nil {
+		t.Fatalf("tx2: failed to prepare: %v", err)
+	}
+
+	// Restart without finishing the prepared transactions. Recovery must keep
+	// their changes and their locks, and find them again after another
+	// restart.
+	for range 2 {
+		tm = open(t)
+		recovery, err := tm.NewTransaction()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := recovery.Recover(); err != nil {
+			t.Fatalf("failed to recover: %v", err)
+		}
+		if err := recovery.Commit(); err != nil {
+			t.Fatal(err)
+		}
+
+		if got, want := tm.InDoubt(), []string{"g1", "g2"}; !slices.Equal(got, want) {
+			t.Fatalf("got in-doubt transactions %v, want %v", got, want)
+		}
+	}
+
+	values := read(tm, block1)
+	select {
+	case val := <-values:
+		t.Fatalf("read %d while the prepared transaction holds the block", val)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if err := tm.CommitPrepared("g1"); err != nil {
+		t.Fatalf("failed to commit g1: %v", err)
+	}
+	if val := <-values; val != 10 {
+		t.Errorf("after committing g1: got %d, want 10", val)
+	}
+	if files, _ := fm.List(); slices.Contains(files, "removed") {
+		t.Errorf("file removed by g1 not deleted: got %v", files)
+	}
+
+	if err := tm.RollbackPrepared("g2"); err != nil {
+		t.Fatalf("failed to roll back g2: %v", err)
+	}
+	if val := <-read(tm, block2); val != 1 {
+		t.Errorf("after rolling back g2: got %d, want 1", val)
+	}
+
+	if got := tm.InDoubt(); len(got) != 0 {
+		t.Errorf("got in-doubt transactions %v after finishing them, want none", got)
+	}
+	if err := tm.CommitPrepared("g1"); !errors.Is(err, ErrUnknownGlobalID) {
+		t.Errorf("CommitPrepared of a finished transaction: got error %v, want %v", err, ErrUnknownGlobalID)
+	}
+}
+
+func TestTransaction_Hooks(t *testing.T) {
+	fm := file.NewMemoryStorage(400)
+
+	lm, err := log.NewManager(fm, "testlogfile")
+	if err != nil {
+		t.Fatalf("failed to create log manager: %v", err)
+	}
+
+	bm := buffer.NewManager(fm, lm, 8)
+
+	tm, err := NewManager(fm, lm, bm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block := file.NewBlock("testfile", 1)
+	var calls []string
+	newTx := func(t *testing.T, val int32, validation error) *Transaction {
+		t.Helper()
+		tx, err := tm.NewTransaction()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Pin(block); err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.WriteInt32(block, 80, val, true); err != nil {
+			t.Fatal(err)
+		}
+
+		tx.BeforeCommit(func() error {
+			calls = append(calls, "validate 1")
+			return nil
+		})
+		tx.BeforeCommit(func() error {
+			calls = append(calls, "validate 2")
+			return validation
+		})
+		tx.OnCommit(func() { calls = append(calls, "commit 1") })
+		tx.OnCommit(func() { calls = append(calls, "commit 2") })
+		tx.OnRollback(func() { calls = append(calls, "rollback") })
+		return tx
+	}
+
+	tx1 := newTx(t, 1, nil)
+	if err := tx1.Commit(); err != nil {
+		t.Fatalf("tx1: failed to commit: %v", err)
+	}
+	if want := []string{"validate 1", "validate 2", "commit 1", "commit 2"}; !slices.Equal(calls, want) {
+		t.Errorf("commit: got calls %v, want %v", calls, want)
+	}
+
+	calls = nil
+	tx2 := newTx(t, 2, nil)
+	if err := tx2.Rollback(); err != nil {
+		t.Fatalf("tx2: failed to roll back: %v", err)
+	}
+	if want := []string{"rollback"}; !slices.Equal(calls, want) {
+		t.Errorf("rollback: got calls %v, want %v", calls, want)
+	}
+
+	// A failed validation rolls the transaction back.
+	calls = nil
+	errInvalid := errors.New("invalid")
+	tx3 := newTx(t, 3, errInvalid)
+	if err := tx3.Commit(); !errors.Is(err, errInvalid) {
+		t.Fatalf("tx3: got error %v, want %v", err, errInvalid)
+	}
+	if want := []string{"validate 1", "validate 2", "rollback"}; !slices.Equal(calls, want) {
+		t.Errorf("aborted commit: got calls %v, want %v", calls, want)
+	}
+
+	tx4, err := tm.NewTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx4.Pin(block); err != nil {
+		t.Fatal(err)
+	}
+	if val, err := tx4.ReadInt32(block, 80); err != nil || val != 1 {
+		t.Errorf("after aborted commit: got (%d, %v), want (1, nil)", val, err)
+	}
+	if err := tx4.Commit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTransaction_WriteBytes(t *testing.T) {
+	fm := file.NewMemoryStorage(400)
+
+	lm, err := log.NewManager(fm, "testlogfile")
+	if err != nil {
+		t.Fatalf("failed to create log manager: %v", err)
+	}
+
+	bm := buffer.NewManager(fm, lm, 8)
+
+	tm, err := NewManager(fm, lm, bm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block := file.NewBlock("testfile", 1)
+	before := make([]byte, 400)
+	for i := range before {
+		before[i] = byte(i)
+	}
+
+	tx1, err := tm.NewTransaction()
+	if err != nil {
+		t.Fatalf("tx1: failed to create transaction: %v", err)
+	}
+	if err := tx1.Pin(block); err != nil {
+		t.Fatalf("tx1: failed to pin block: %v", err)
+	}
+	if err := tx1.WriteBytes(block, 0, before, false); err != nil {
+		t.Fatalf("tx1: failed to write bytes: %v", err)
+	}
+	if err := tx1.Commit(); err != nil {
+		t.Fatalf("tx1: failed to commit: %v", err)
+	}
+
+	// A write of the whole block does not fit in one log record.
+	tx2, err := tm.NewTransaction()
+	if err != nil {
+		t.Fatalf("tx2: failed to create transaction: %v", err)
+	}
+	if err := tx2.Pin(block); err != nil {
+		t.Fatalf("tx2: failed to pin block: %v", err)
+	}
+	if err := tx2.WriteBytes(block, 0, make([]byte, 400), true); err != nil {
+		t.Fatalf("tx2: failed to write bytes: %v", err)
+	}
+	got, err := tx2.ReadBytes(block, 10, 4)
+	if err != nil {
+		t.Fatalf("tx2: failed to read bytes: %v", err)
+	}
+	if !slices.Equal(got, make([]byte, 4)) {
+		t.Errorf("ReadBytes: got %v, want zeroes", got)
+	}
+	if err := tx2.Rollback(); err != nil {
+		t.Fatalf("tx2: failed to roll back: %v", err)
+	}
+
+	tx3, err := tm.NewTransaction()
+	if err != nil {
+		t.Fatalf("tx3: failed to create transaction: %v", err)
+	}
+	if err := tx3.Pin(block); err != nil {
+		t.Fatalf("tx3: failed to pin block: %v", err)
+	}
+	got, err = tx3.ReadBytes(block, 0, 400)
+	if err != nil {
+		t.Fatalf("tx3: failed to read bytes: %v", err)
+	}
+	if !slices.Equal(got, before) {
+		t.Errorf("rollback did not restore the bytes: got %v, want %v", got, before)
+	}
+	if err := tx3.Commit(); err != nil {
+		t.Fatalf("tx3: failed to commit: %v", err)
+	}
+}
+
+func TestTransaction_Truncate(t *testing.T) {
+	fm := file.NewMemoryStorage(400)
+
+	lm, err := log.NewManager(fm, "testlogfile")
+	if err != nil {
+		t.Fatalf("failed to create log manager: %v", err)
+	}
+
+	bm := buffer.NewManager(fm, lm, 8)
+
+	tm, err := NewManager(fm, lm, bm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tx1, err := tm.NewTransaction()
+	if err != nil {
+		t.Fatalf("tx1: failed to create transaction: %v", err)
+	}
+	for range 3 {
+		if _, err := tx1.Append("testfile"); err != nil {
+			t.Fatalf("tx1: failed to append block: %v", err)
+		}
+	}
+	if err := tx1.Commit(); err != nil {
+		t.Fatalf("tx1: failed to commit: %v", err)
+	}
+
+	// A block appended after the truncation is kept, along with the
+	// blocks before it.
+	tx2, err := tm.NewTransaction()
+	if err != nil {
+		t.Fatalf("tx2: failed to create transaction: %v", err)
+	}
+	if err := tx2.Truncate("testfile", 1); err != nil {
+		t.Fatalf("tx2: failed to truncate file: %v", err)
+	}
+	block, err := tx2.Append("testfile")
+	if err != nil {
+		t.Fatalf("tx2: failed to append block: %v", err)
+	}
+	if err := tx2.Pin(block); err != nil {
+		t.Fatalf("tx2: failed to pin block: %v", err)
+	}
+	if err := tx2.WriteInt32(block, 0, 42, true); err != nil {
+		t.Fatalf("tx2: failed to write: %v", err)
+	}
+	if err := tx2.Commit(); err != nil {
+		t.Fatalf("tx2: failed to commit: %v", err)
+	}
+	if size, _ := fm.Size("testfile"); size != 4 {
+		t.Errorf("invalid size after commit: got %d, want %d", size, 4)
+	}
+
+	tx3, err := tm.NewTransaction()
+	if err != nil {
+		t.Fatalf("tx3: failed to create transaction: %v", err)
+	}
+	if err := tx3.Pin(block); err != nil {
+		t.Fatalf("tx3: failed to pin block: %v", err)
+	}
+	if val, err := tx3.ReadInt32(block, 0); err != nil || val != 42 {
+		t.Errorf("tx3: invalid value: got %d (%v), want %d", val, err, 42)
+	}
+	if err := tx3.Truncate("testfile", 1); err != nil {
+		t.Fatalf("tx3: failed to truncate file: %v", err)
+	}
+	if err := tx3.Commit(); err != nil {
+		t.Fatalf("tx3: failed to commit: %v", err)
+	}
+	if size, _ := fm.Size("testfile"); size != 1 {
+		t.Errorf("invalid size after truncation: got %d, want %d", size, 1)
+	}
+}
+
+func TestTransaction_Remove(t *testing.T) {
+	fm := file.NewMemoryStorage(400)
+
+	lm, err := log.NewManager(fm, "testlogfile")
+	if err != nil {
+		t.Fatalf("failed to create log manager: %v", err)
+	}
+
+	bm := buffer.NewManager(fm, lm, 8)
+
+	tm, err := NewManager(fm, lm, bm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tx1, err := tm.NewTransaction()
+	if err != nil {
+		t.Fatalf("tx1: failed to create transaction: %v", err)
+	}
+	block, err := tx1.Append("testfile")
+	if err != nil {
+		t.Fatalf("tx1: failed to append block: %v", err)
+	}
+	if err := tx1.Pin(block); err != nil {
+		t.Fatalf("tx1: failed to pin block: %v", err)
+	}
+	if err := tx1.WriteInt32(block, 0, 42, true); err != nil {
+		t.Fatalf("tx1: failed to write: %v", err)
+	}
+	if err := tx1.Commit(); err != nil {
+		t.Fatalf("tx1: failed to commit: %v", err)
+	}
+
+	// A removal that is rolled back leaves the file as it was.
+	tx2, err := tm.NewTransaction()
+	if err != nil {
+		t.Fatalf("tx2: failed to create transaction: %v", err)
+	}
+	if err := tx2.Remove("testfile"); err != nil {
+		t.Fatalf("tx2: failed to remove file: %v", err)
+	}
+	if err := tx2.Rollback(); err != nil {
+		t.Fatalf("tx2: failed to roll back: %v", err)
+	}
+	if size, _ := fm.Size("testfile"); size != 1 {
+		t.Errorf("invalid size after rollback: got %d, want %d", size, 1)
+	}
+
+	tx3, err := tm.NewTransaction()
+	if err != nil {
+		t.Fatalf("tx3: failed to create transaction: %v", err)
+	}
+	if err := tx3.Pin(block); err != nil {
+		t.Fatalf("tx3: failed to pin block: %v", err)
+	}
+	if val, err := tx3.ReadInt32(block, 0); err != nil || val != 42 {
+		t.Errorf("tx3: invalid value: got %d (%v), want %d", val, err, 42)
+	}
+	if err := tx3.Remove("testfile"); err != nil {
+		t.Fatalf("tx3: failed to remove file: %v", err)
+	}
+	// The file is still there until the transaction commits.
+	if size, _ := fm.Size("testfile"); size != 1 {
+		t.Errorf("invalid size before commit: got %d, want %d", size, 1)
+	}
+	if _, err := tx3.Append("testfile"); !errors.Is(err, ErrFileRemoved) {
+		t.Errorf("tx3: invalid error appending to a removed file: got %v, want %v", err, ErrFileRemoved)
+	}
+	if err := tx3.Commit(); err != nil {
+		t.Fatalf("tx3: failed to commit: %v", err)
+	}
+	files, err := fm.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if slices.Contains(files, "testfile") {
+		t.Errorf("file not removed: got %v", files)
+	}
+}